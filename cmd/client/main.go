@@ -6,6 +6,7 @@ package main
 
 import (
 	"context"
+	"log"
 
 	"github.com/MaximkaSha/gophkeeper/internal/client"
 	"github.com/MaximkaSha/gophkeeper/internal/ui"
@@ -20,7 +21,10 @@ var (
 
 func main() {
 	ctx := context.Background()
-	client := client.NewClient(BuildVersion, BuildTime)
+	client, err := client.NewClient(BuildVersion, BuildTime)
+	if err != nil {
+		log.Fatal(err)
+	}
 
 	ui.UI(ctx, *client)
 
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/MaximkaSha/gophkeeper/internal/config"
+	"github.com/MaximkaSha/gophkeeper/internal/jwtkeys"
+)
+
+const keysUsage = `usage: server keys <command>
+  rotate [-keep N] [-activation D]  generate new signing key, keep N newest keys (default 2),
+                                   key signs after D (default 2m) when all servers reloaded it
+  retire <kid>                     remove key, tokens signed by it are rejected
+  list                             show keys, newest first`
+
+// keysCommand manages JWT keys in JWTKeysDir.
+// Running server reloads keys every minute, restart is not needed.
+func keysCommand(args []string) {
+	dir := config.NewServerConfig().JWTKeysDir
+	if len(args) == 0 {
+		log.Fatal(keysUsage)
+	}
+	switch args[0] {
+	case "rotate":
+		flags := flag.NewFlagSet("rotate", flag.ExitOnError)
+		keep := flags.Int("keep", jwtkeys.DefaultKeep, "number of keys to keep")
+		activation := flags.Duration("activation", jwtkeys.DefaultActivation, "delay before new key signs tokens")
+		flags.Parse(args[1:])
+		key, retired, err := jwtkeys.Rotate(dir, *keep, *activation)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println("new signing key:", key.ID)
+		if !key.NotBefore.IsZero() {
+			fmt.Println("signs from:", key.NotBefore.Format(time.RFC3339))
+		}
+		for _, kid := range retired {
+			fmt.Println("retired:", kid)
+		}
+	case "retire":
+		if len(args) != 2 {
+			log.Fatal(keysUsage)
+		}
+		err := jwtkeys.Retire(dir, args[1])
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println("retired:", args[1])
+	case "list":
+		keys, err := jwtkeys.List(dir)
+		if err != nil {
+			log.Fatal(err)
+		}
+		now := time.Now()
+		current := jwtkeys.Current(keys, now)
+		for _, key := range keys {
+			state := "verify"
+			switch {
+			case current != nil && key.ID == current.ID:
+				state = "sign"
+			case key.Private != nil && now.Before(key.NotBefore):
+				state = "pending, signs from " + key.NotBefore.Format(time.RFC3339)
+			}
+			fmt.Println(key.ID, state)
+		}
+	default:
+		log.Fatal(keysUsage)
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/MaximkaSha/gophkeeper/internal/config"
+	"github.com/MaximkaSha/gophkeeper/internal/storage"
+)
+
+const lockoutsUsage = `usage: server lockouts <command>
+  list         show locked accounts ("account:<email>") and addresses ("ip:<address>")
+  clear <key>  unlock key and reset its failed logins counter`
+
+// lockoutsCommand shows and clears login lockouts in server database.
+func lockoutsCommand(args []string) {
+	if len(args) == 0 {
+		log.Fatal(lockoutsUsage)
+	}
+	db := storage.NewStorager(config.NewServerConfig().DSN, nil)
+	switch args[0] {
+	case "list":
+		now := time.Now()
+		lockouts, err := db.ListLockouts(context.Background(), now)
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, lockout := range lockouts {
+			fmt.Printf("%s failures: %d, locked for %s\n", lockout.Key, lockout.Failures, lockout.LockedUntil.Sub(now).Round(time.Second))
+		}
+	case "clear":
+		if len(args) != 2 {
+			log.Fatal(lockoutsUsage)
+		}
+		err := db.ClearLoginAttempts(context.Background(), args[1])
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println("cleared:", args[1])
+	default:
+		log.Fatal(lockoutsUsage)
+	}
+}
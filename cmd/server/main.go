@@ -1,12 +1,17 @@
 // Package server is backend for the client.
 // It implements gRPC endpoints and DB handling.
 // Configured via json file.
+//
+// JWT keys are managed with "server keys rotate|retire|list".
+// Login lockouts are managed with "server lockouts list|clear".
+// Database schema is migrated on start or with "server migrate up|down|status".
 package main
 
 import (
 	"fmt"
 	"log"
 	"net"
+	"os"
 
 	"github.com/MaximkaSha/gophkeeper/internal/authserver"
 	pb "github.com/MaximkaSha/gophkeeper/internal/proto"
@@ -18,6 +23,18 @@ import (
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "keys" {
+		keysCommand(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "lockouts" {
+		lockoutsCommand(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		migrateCommand(os.Args[2:])
+		return
+	}
 	Server := server.NewGophkeeperServer()
 	Auth := authserver.NewAuthGophkeeperServer()
 	listen, err := net.Listen("tcp", Server.Config.Addr)
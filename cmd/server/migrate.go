@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/MaximkaSha/gophkeeper/internal/config"
+	"github.com/MaximkaSha/gophkeeper/internal/storage"
+)
+
+const migrateUsage = `usage: server migrate <command>
+  up      apply pending migrations (server does it on start too)
+  down    roll back latest applied migration
+  status  show applied and pending migrations`
+
+// migrateCommand manages schema migrations of server database.
+func migrateCommand(args []string) {
+	if len(args) != 1 {
+		log.Fatal(migrateUsage)
+	}
+	db, err := storage.Open(config.NewServerConfig().DSN)
+	if err != nil {
+		log.Fatal(err)
+	}
+	ctx := context.Background()
+	switch args[0] {
+	case "up":
+		applied, err := db.MigrateUp(ctx)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println("applied migrations:", applied)
+	case "down":
+		m, ok, err := db.MigrateDown(ctx)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if !ok {
+			fmt.Println("no applied migrations")
+			return
+		}
+		fmt.Printf("rolled back: %04d %s\n", m.Version, m.Name)
+	case "status":
+		statuses, err := db.Migrations(ctx)
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, m := range statuses {
+			state := "pending"
+			if m.Applied() {
+				state = "applied " + m.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Printf("%04d %s %s\n", m.Version, m.Name, state)
+		}
+	default:
+		log.Fatal(migrateUsage)
+	}
+}
@@ -3,10 +3,18 @@ package authserver
 
 import (
 	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"errors"
+	"log"
 	"time"
 
+	"github.com/MaximkaSha/gophkeeper/internal/blobstore"
 	"github.com/MaximkaSha/gophkeeper/internal/config"
+	"github.com/MaximkaSha/gophkeeper/internal/crypto"
+	"github.com/MaximkaSha/gophkeeper/internal/jwtkeys"
 	"github.com/MaximkaSha/gophkeeper/internal/models"
+	"github.com/MaximkaSha/gophkeeper/internal/passhash"
 	pb "github.com/MaximkaSha/gophkeeper/internal/proto"
 	"github.com/MaximkaSha/gophkeeper/internal/storage"
 	"github.com/dgrijalva/jwt-go"
@@ -15,33 +23,84 @@ import (
 	"google.golang.org/grpc/status"
 )
 
-// AuthGophkeeperServer - main structure which keeps JWT key, configuration, DB interface.
+// AuthGophkeeperServer - main structure which keeps JWT keys, configuration, DB interface.
 type AuthGophkeeperServer struct {
-	jwtKey []byte
+	keys   *jwtkeys.KeySet
+	hasher passhash.Hasher
 	config *config.ServerConfig
 	DB     models.Storager
+	// now returns current time of authenticator codes, time.Now if nil.
+	now func() time.Time
 	pb.UnimplementedAuthGophkeeperServer
 }
 
-// SetJWTKey - sets JWT key
-func (a *AuthGophkeeperServer) SetJWTKey(key string) {
-	a.jwtKey = []byte(key)
+// SetKeySet - sets JWT signing and verification keys.
+func (a *AuthGophkeeperServer) SetKeySet(keys *jwtkeys.KeySet) {
+	a.keys = keys
+}
+
+// SetHasher - sets hasher of new passwords.
+func (a *AuthGophkeeperServer) SetHasher(hasher passhash.Hasher) {
+	a.hasher = hasher
+}
+
+// clock returns current time of authenticator codes.
+func (a AuthGophkeeperServer) clock() time.Time {
+	if a.now == nil {
+		return time.Now()
+	}
+	return a.now()
 }
 
 // NewAuthGophkeeperServer - constructor for AuthGophkeeperServer object.
 // Read the config file and return new AuthGophkeeperServer with configured parametrs.
+// JWT keys are loaded from JWTKeysDir, first key is generated if directory is empty.
+// Accounts which deletion grace period is over and stale refresh tokens are purged every minute.
 func NewAuthGophkeeperServer() AuthGophkeeperServer {
 	config := config.NewServerConfig()
-	return AuthGophkeeperServer{
-		jwtKey: []byte(config.JWTSecret), // do I need to add random for each server start ?
-		DB:     storage.NewStorage(config.DSN),
+	argon := passhash.DefaultArgon2id
+	argon.Time, argon.Memory, argon.Threads = config.Argon2Time, config.Argon2Memory, config.Argon2Threads
+	hasher, err := passhash.New(config.PasswordHash, config.BcryptCost, argon)
+	if err != nil {
+		log.Fatalf("password hash %q: %s", config.PasswordHash, err.Error())
+	}
+	keys, err := jwtkeys.Load(config.JWTKeysDir)
+	if err != nil {
+		log.Fatalf("loading JWT keys error: %s", err.Error())
+	}
+	if _, err := keys.Signer(); err != nil {
+		key, _, err := jwtkeys.Rotate(config.JWTKeysDir, jwtkeys.DefaultKeep, jwtkeys.DefaultActivation)
+		if err != nil {
+			log.Fatalf("generating JWT key error: %s", err.Error())
+		}
+		log.Printf("JWT signing key %s generated", key.ID)
+		err = keys.Reload()
+		if err != nil {
+			log.Fatalf("loading JWT keys error: %s", err.Error())
+		}
+	}
+	go keys.ReloadEvery(jwtkeys.ReloadInterval)
+	// purged accounts release their file blobs
+	blobs, err := blobstore.New(config.BlobStore)
+	if err != nil {
+		log.Fatalf("blob store error: %s", err.Error())
+	}
+	server := AuthGophkeeperServer{
+		keys:   keys,
+		hasher: hasher,
+		DB:     storage.NewStorager(config.DSN, blobs),
 		config: config,
 	}
+	go server.PurgeEvery(time.Minute)
+	go server.PurgeRefreshTokensEvery(time.Minute)
+	return server
 }
 
 // Claims - struct of JWT claim.
 type Claims struct {
 	Email string `json:"email"`
+	// Login session of token.
+	SessionID string `json:"sid"`
 	jwt.StandardClaims
 }
 
@@ -52,126 +111,223 @@ func (a AuthGophkeeperServer) UserRegister(ctx context.Context, in *pb.UserRegis
 	var response pb.UserRegisterResponse
 	user := models.User{}
 	user.FromProto(in.User)
-	err := a.DB.AddUser(user)
+	if len(user.Password) == 0 || len(user.Secret) == 0 || len(user.Salt) == 0 {
+		return &response, status.Errorf(codes.InvalidArgument, "password, wrapped secret and salt required")
+	}
+	var err error
+	user.Password, err = a.passwordHasher().Hash(user.Password)
 	if err != nil {
-		return &response, status.Errorf(codes.Aborted, err.Error())
+		return &response, status.Errorf(codes.Unknown, "Password hashing error")
+	}
+	err = a.DB.AddUser(ctx, user)
+	if err != nil {
+		return &response, models.StatusError(err)
 	}
 	return &response, nil
 }
 
-// JWTClain function returns new token and expirastion time for given user credentials.
-// New token signed by JWTKey from config.
-// Token ttl 1 minute.
-func (a AuthGophkeeperServer) JWTClain(creds models.User) (string, int64, error) {
+// UserSalt implements endpoint which returns user's KDF salt.
+// Client needs salt to derive auth key before login.
+// Empty salt means user was registered before zero-knowledge keys and logs in with password.
+// Unknown email gets fake salt, so response doesn't tell whether account exists.
+func (a AuthGophkeeperServer) UserSalt(ctx context.Context, in *pb.UserSaltRequest) (*pb.UserSaltResponse, error) {
+	var response pb.UserSaltResponse
+	user, err := a.DB.GetUser(ctx, models.User{Email: in.Email})
+	if errors.Is(err, models.ErrNotFound) {
+		response.Salt = a.fakeSalt(in.Email)
+		return &response, nil
+	}
+	if err != nil {
+		return &response, models.StatusError(err)
+	}
+	response.Salt = user.Salt
+	return &response, nil
+}
+
+// fakeSaltKey - key of fake salts if SaltKey is not configured.
+var fakeSaltKey = func() []byte {
+	key, err := crypto.GenKey()
+	if err != nil {
+		log.Fatalf("generating salt key error: %s", err.Error())
+	}
+	return key
+}()
+
+// fakeSalt returns salt of unknown email, it is the same on every request.
+func (a AuthGophkeeperServer) fakeSalt(email string) []byte {
+	key := fakeSaltKey
+	if a.config != nil && a.config.SaltKey != "" {
+		key = []byte(a.config.SaltKey)
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(email))
+	return mac.Sum(nil)[:crypto.SaltSize]
+}
 
-	expirationTime := time.Now().Add(1 * time.Minute)
+// JWTClain function returns new access token and expirastion time for given user credentials and session.
+// New token signed by the newest ES256 key, its id is in "kid" header.
+// Token ttl is AccessTokenTTL from config, 1 minute by default.
+func (a AuthGophkeeperServer) JWTClain(creds models.User, sessionID string) (string, int64, error) {
+
+	expirationTime := time.Now().Add(a.accessTokenTTL())
 	claims := &Claims{
-		Email: creds.Email,
+		Email:     creds.Email,
+		SessionID: sessionID,
 		StandardClaims: jwt.StandardClaims{
 			ExpiresAt: expirationTime.Unix(),
 		},
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	tokenString, err := token.SignedString(a.jwtKey)
+	tokenString, err := a.keys.Sign(claims)
 	return tokenString, expirationTime.Unix(), err
 }
 
 // UserLogin function implements user login endpoint.
 // If user creds ok returns JWT token which must be used to access privite API.
+// If user enrolled authenticator, MFA token is returned instead, see UserLoginMFA.
 func (a AuthGophkeeperServer) UserLogin(ctx context.Context, in *pb.UserLoginRequest) (*pb.UserLoginResponse, error) {
 	var response pb.UserLoginResponse
 	user := models.User{}
 	user.FromProto(in.User)
+	// Locked account or address is rejected before password is checked.
+	keys := clientLoginKeys(ctx, user.Email)
+	err := a.checkLockout(ctx, keys)
+	if err != nil {
+		return &response, err
+	}
 	userHash := models.User{}
-	userHash, err := a.DB.GetUser(user)
+	userHash, err = a.DB.GetUser(ctx, user)
+	// unknown email is not told from wrong password
+	if errors.Is(err, models.ErrNotFound) {
+		a.loginFailed(keys)
+		return &response, status.Errorf(codes.Unauthenticated, "wrong password")
+	}
 	if err != nil {
-		return &response, status.Errorf(codes.Aborted, err.Error())
+		return &response, models.StatusError(err)
 	}
-	if !user.CheckPasswordHash(userHash.Password) {
+	if !a.checkPassword(ctx, user.Password, userHash) {
+		a.loginFailed(keys)
 		return &response, status.Errorf(codes.Unauthenticated, "wrong password")
 	}
-	tokenString, expiresAt, err := a.JWTClain(userHash)
+	enrollment, err := a.DB.GetTOTP(ctx, userHash.Email)
 	if err != nil {
-		return &response, status.Errorf(codes.Unknown, "JWT Generating Error")
+		return &response, models.StatusError(err)
 	}
-	token := pb.Token{
-		Email:   userHash.Email,
-		Token:   tokenString,
-		Expires: expiresAt,
+	// Enrolled user gets MFA token only, session starts after UserLoginMFA.
+	// Failures counter is reset when code is checked.
+	if enrollment.Confirmed {
+		response.MfaToken, err = a.mfaToken(userHash, in.Device, in.BuildVersion)
+		if err != nil {
+			return &response, status.Errorf(codes.Unknown, "JWT Generating Error")
+		}
+		response.MfaRequired = true
+		response.User = &pb.User{Email: userHash.Email}
+		return &response, nil
+	}
+	a.loginSucceeded(ctx, userHash.Email)
+	token, err := a.newSession(ctx, userHash, in.Device, in.BuildVersion)
+	if err != nil {
+		return &response, err
 	}
 	response.User = userHash.ToProto()
-	response.Token = &token
+	response.Token = token
+	response.DeleteAfter = deleteAfter(userHash.DeleteAfter)
 	return &response, nil
 }
 
+// protectedMethods - methods of auth service which need valid access token.
+var protectedMethods = map[string]bool{
+	"/" + pb.AuthGophkeeper_ServiceDesc.ServiceName + "/Logout":                true,
+	"/" + pb.AuthGophkeeper_ServiceDesc.ServiceName + "/ListSessions":          true,
+	"/" + pb.AuthGophkeeper_ServiceDesc.ServiceName + "/TerminateSession":      true,
+	"/" + pb.AuthGophkeeper_ServiceDesc.ServiceName + "/EnrollTOTP":            true,
+	"/" + pb.AuthGophkeeper_ServiceDesc.ServiceName + "/ConfirmTOTP":           true,
+	"/" + pb.AuthGophkeeper_ServiceDesc.ServiceName + "/DisableTOTP":           true,
+	"/" + pb.AuthGophkeeper_ServiceDesc.ServiceName + "/ChangePassword":        true,
+	"/" + pb.AuthGophkeeper_ServiceDesc.ServiceName + "/DeleteAccount":         true,
+	"/" + pb.AuthGophkeeper_ServiceDesc.ServiceName + "/CancelAccountDeletion": true,
+}
+
 // AuthFuncOverride overrides auth  middleware call, to keep Register and Login endpoints public.
+// Methods which work with current session are authenticated by AuthFunc.
 func (a AuthGophkeeperServer) AuthFuncOverride(ctx context.Context, fullMethodName string) (context.Context, error) {
+	if protectedMethods[fullMethodName] {
+		return a.AuthFunc(ctx)
+	}
 	return ctx, nil
 }
 
 // parseToken function checks sing and ttl of given token.
-func (a AuthGophkeeperServer) parseToken(token string) error {
+// Any key of key set is accepted, so tokens stay valid during key rotation.
+// Returns token claims if token is valid.
+func (a AuthGophkeeperServer) parseToken(token string) (*Claims, error) {
 	claims := &Claims{}
-	tkn, err := jwt.ParseWithClaims(token, claims, func(token *jwt.Token) (interface{}, error) {
-		return a.jwtKey, nil
-	})
-	if err != nil {
-		if err == jwt.ErrSignatureInvalid {
-			return status.Error(codes.Unauthenticated, "wrong token")
-		}
+	tkn, err := jwt.ParseWithClaims(token, claims, a.keys.Keyfunc)
+	// MFA token proves password only, it can't be used as access token.
+	if err != nil || !tkn.Valid || claims.Audience == mfaAudience {
+		return nil, status.Error(codes.Unauthenticated, "wrong token")
 	}
-	if !tkn.Valid {
-		return status.Error(codes.Unauthenticated, "wrong token")
-	}
-
-	return nil
-}
-
-// Refresh endpoint implementation.
-// This endpoint use to refresh user token.
-func (a AuthGophkeeperServer) Refresh(ctx context.Context, in *pb.RefreshRequest) (*pb.RefreshResponse, error) {
-	var response pb.RefreshResponse
-	claims := &Claims{}
-	tkn, err := jwt.ParseWithClaims(in.Token.Token, claims, func(token *jwt.Token) (interface{}, error) {
-		return a.jwtKey, nil
-	})
-	if err != nil {
-		if err == jwt.ErrSignatureInvalid {
-			return &response, status.Errorf(codes.Unauthenticated, "wrong password")
-		}
-		return &response, status.Errorf(codes.Unknown, err.Error())
-	}
-	if !tkn.Valid {
-		return &response, status.Errorf(codes.Unauthenticated, "wrong password")
-	}
-	if time.Until(time.Unix(claims.ExpiresAt, 0)) > 30*time.Second {
-		return &response, status.Errorf(codes.FailedPrecondition, "too early to refresh")
-	}
-
-	expirationTime := time.Now().Add(1 * time.Minute)
-	claims.ExpiresAt = expirationTime.Unix()
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	tokenResp := in.Token
-	tokenResp.Token, err = token.SignedString(a.jwtKey)
-	if err != nil {
-		return &response, status.Errorf(codes.Unknown, "Internal error")
-	}
-	response.Token = tokenResp
-	return &response, nil
+	return claims, nil
 }
 
 // AuthFunc is used by a middleware to authenticate requests.
+// Email of authenticated user is placed to context, see EmailFromContext.
 func (a AuthGophkeeperServer) AuthFunc(ctx context.Context) (context.Context, error) {
 	token, err := grpc_auth.AuthFromMD(ctx, "bearer")
 	if err != nil {
 		return nil, err
 	}
 
-	err = a.parseToken(token)
+	claims, err := a.parseToken(token)
 	if err != nil {
 		return nil, status.Errorf(codes.Unauthenticated, "invalid auth token: %v", err)
 	}
 
-	return ctx, nil
+	return context.WithValue(ctx, principalKey{}, claims), nil
+}
+
+// principalKey - context key of authenticated user's claims.
+type principalKey struct{}
+
+// NewContextWithEmail returns new context which carries authenticated user's email.
+func NewContextWithEmail(ctx context.Context, email string) context.Context {
+	return context.WithValue(ctx, principalKey{}, &Claims{Email: email})
+}
+
+// NewContextWithSession returns new context which carries authenticated user's email,
+// session id and expiration time of access token.
+func NewContextWithSession(ctx context.Context, email string, sessionID string, expires time.Time) context.Context {
+	return context.WithValue(ctx, principalKey{}, &Claims{
+		Email:          email,
+		SessionID:      sessionID,
+		StandardClaims: jwt.StandardClaims{ExpiresAt: expires.Unix()},
+	})
+}
+
+// EmailFromContext returns email of authenticated user.
+// Returns false if request was not authenticated.
+func EmailFromContext(ctx context.Context) (string, bool) {
+	claims, ok := ctx.Value(principalKey{}).(*Claims)
+	if !ok || claims.Email == "" {
+		return "", false
+	}
+	return claims.Email, true
+}
+
+// SessionFromContext returns session id of authenticated request.
+func SessionFromContext(ctx context.Context) (string, bool) {
+	claims, ok := ctx.Value(principalKey{}).(*Claims)
+	if !ok || claims.SessionID == "" {
+		return "", false
+	}
+	return claims.SessionID, true
+}
+
+// ExpiresFromContext returns expiration time of access token of authenticated request.
+func ExpiresFromContext(ctx context.Context) (time.Time, bool) {
+	claims, ok := ctx.Value(principalKey{}).(*Claims)
+	if !ok || claims.ExpiresAt == 0 {
+		return time.Time{}, false
+	}
+	return time.Unix(claims.ExpiresAt, 0), true
 }
@@ -8,15 +8,37 @@ import (
 	"testing"
 	"time"
 
+	"github.com/MaximkaSha/gophkeeper/internal/crypto"
+	"github.com/MaximkaSha/gophkeeper/internal/jwtkeys"
 	"github.com/MaximkaSha/gophkeeper/internal/mockdb"
 	"github.com/MaximkaSha/gophkeeper/internal/models"
+	"github.com/MaximkaSha/gophkeeper/internal/passhash"
 	pb "github.com/MaximkaSha/gophkeeper/internal/proto"
 	"github.com/golang/mock/gomock"
 	"github.com/stretchr/testify/require"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
 )
 
+// testKeySet returns key set with one new signing key.
+func testKeySet(t *testing.T) *jwtkeys.KeySet {
+	keys := jwtkeys.NewKeySet()
+	key, err := jwtkeys.GenerateKey()
+	require.NoError(t, err)
+	keys.Add(key)
+	return keys
+}
+
+// allowLogins expects lockout checks in tests which are not about lockout.
+func allowLogins(store *mockdb.MockStorager) {
+	store.EXPECT().GetLoginAttempt(gomock.Any(), gomock.Any()).Return(models.LoginAttempt{}, nil).AnyTimes()
+	store.EXPECT().AddLoginFailure(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(1, nil).AnyTimes()
+	store.EXPECT().ClearLoginAttempts(gomock.Any(), gomock.Any()).Return(nil).AnyTimes()
+}
+
 func TestAuthGophkeeperServer_UserRegister(t *testing.T) {
 	tests := []struct {
 		name string
@@ -34,9 +56,15 @@ func TestAuthGophkeeperServer_UserRegister(t *testing.T) {
 				Email:    "test@test.com",
 				Password: "11111",
 				Secret:   []byte("secret"),
+				Salt:     []byte("salt"),
 			}
 			store := mockdb.NewMockStorager(ctrl)
-			store.EXPECT().AddUser(gomock.Eq(data))
+			// server stores hash of password, never password itself
+			var stored models.User
+			store.EXPECT().AddUser(gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, u models.User) error {
+				stored = u
+				return nil
+			})
 			Server := AuthGophkeeperServer{
 				DB: store,
 			}
@@ -59,7 +87,19 @@ func TestAuthGophkeeperServer_UserRegister(t *testing.T) {
 			})
 
 			require.NoError(t, err)
-			store.EXPECT().AddUser(gomock.Eq(data)).Return(errors.New("no data"))
+			require.NotEqual(t, data.Password, stored.Password)
+			ok, err := passhash.Verify(data.Password, stored.Password)
+			require.NoError(t, err)
+			require.True(t, ok)
+			require.Equal(t, data.Secret, stored.Secret)
+			require.Equal(t, data.Salt, stored.Salt)
+			store.EXPECT().AddUser(gomock.Any(), gomock.Any()).Return(errors.New("no data"))
+			_, err = c.UserRegister(context.Background(), &pb.UserRegisterRequest{
+				User: data.ToProto(),
+			})
+			require.Error(t, err)
+			// plain secret without salt is not accepted
+			data.Salt = nil
 			_, err = c.UserRegister(context.Background(), &pb.UserRegisterRequest{
 				User: data.ToProto(),
 			})
@@ -68,6 +108,38 @@ func TestAuthGophkeeperServer_UserRegister(t *testing.T) {
 	}
 }
 
+func TestAuthGophkeeperServer_UserSalt(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	store := mockdb.NewMockStorager(ctrl)
+	a := AuthGophkeeperServer{
+		DB: store,
+	}
+	store.EXPECT().GetUser(gomock.Any(), gomock.Eq(models.User{Email: "test@test.com"})).Return(models.User{
+		Email:    "test@test.com",
+		Password: "hash",
+		Secret:   []byte("wrapped"),
+		Salt:     []byte("salt"),
+	}, nil)
+	resp, err := a.UserSalt(context.Background(), &pb.UserSaltRequest{Email: "test@test.com"})
+	require.NoError(t, err)
+	require.Equal(t, []byte("salt"), resp.Salt)
+	// unknown email gets the same fake salt every time
+	store.EXPECT().GetUser(gomock.Any(), gomock.Any()).Return(models.User{}, models.ErrNotFound).Times(3)
+	resp, err = a.UserSalt(context.Background(), &pb.UserSaltRequest{Email: "none@test.com"})
+	require.NoError(t, err)
+	require.Len(t, resp.Salt, crypto.SaltSize)
+	again, err := a.UserSalt(context.Background(), &pb.UserSaltRequest{Email: "none@test.com"})
+	require.NoError(t, err)
+	require.Equal(t, resp.Salt, again.Salt)
+	other, err := a.UserSalt(context.Background(), &pb.UserSaltRequest{Email: "other@test.com"})
+	require.NoError(t, err)
+	require.NotEqual(t, resp.Salt, other.Salt)
+	store.EXPECT().GetUser(gomock.Any(), gomock.Any()).Return(models.User{}, errors.New("connection refused"))
+	_, err = a.UserSalt(context.Background(), &pb.UserSaltRequest{Email: "none@test.com"})
+	require.Error(t, err)
+}
+
 func TestAuthGophkeeperServer_UserLogin(t *testing.T) {
 	tests := []struct {
 		name string
@@ -93,9 +165,29 @@ func TestAuthGophkeeperServer_UserLogin(t *testing.T) {
 			}
 			dataHash.HashPassword()
 			store := mockdb.NewMockStorager(ctrl)
-			store.EXPECT().GetUser(gomock.Eq(data)).Return(dataHash, nil)
+			store.EXPECT().GetUser(gomock.Any(), gomock.Eq(data)).Return(dataHash, nil)
+			var session models.Session
+			store.EXPECT().AddSession(gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, s models.Session) error {
+				session = s
+				return nil
+			})
+			store.EXPECT().AddRefreshToken(gomock.Any(), gomock.Any())
+			store.EXPECT().GetTOTP(gomock.Any(), gomock.Eq(data.Email)).Return(models.TOTP{}, nil)
+			// bcrypt hash is upgraded to Argon2id on successful login
+			var upgraded models.User
+			store.EXPECT().UpdateUser(gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, u models.User) error {
+				upgraded = u
+				return nil
+			})
+			// account and address are checked on every login, failures are counted for both
+			store.EXPECT().GetLoginAttempt(gomock.Any(), gomock.Eq("account:test@test.com")).Return(models.LoginAttempt{}, nil).Times(3)
+			store.EXPECT().GetLoginAttempt(gomock.Any(), gomock.Eq("ip:127.0.0.1")).Return(models.LoginAttempt{}, nil).Times(3)
+			store.EXPECT().ClearLoginAttempts(gomock.Any(), gomock.Eq("account:test@test.com")).Return(nil)
+			store.EXPECT().AddLoginFailure(gomock.Any(), gomock.Eq("account:test@test.com"), gomock.Any(), gomock.Any()).Return(1, nil).Times(2)
+			store.EXPECT().AddLoginFailure(gomock.Any(), gomock.Eq("ip:127.0.0.1"), gomock.Any(), gomock.Any()).Return(1, nil).Times(2)
 			Server := AuthGophkeeperServer{
-				DB: store,
+				DB:   store,
+				keys: testKeySet(t),
 			}
 
 			s := grpc.NewServer()
@@ -111,18 +203,28 @@ func TestAuthGophkeeperServer_UserLogin(t *testing.T) {
 				log.Fatal(err)
 			}
 			c := pb.NewAuthGophkeeperClient(conn)
-			_, err = c.UserLogin(context.Background(), &pb.UserLoginRequest{
-				User: data.ToProto(),
+			resp, err := c.UserLogin(context.Background(), &pb.UserLoginRequest{
+				User:         data.ToProto(),
+				Device:       "laptop",
+				BuildVersion: "v1.0.0",
 			})
 
 			require.NoError(t, err)
-			store.EXPECT().GetUser(gomock.Eq(data)).Return(data, errors.New("no data"))
+			require.NotEmpty(t, resp.Token.Token)
+			require.NotEmpty(t, resp.Token.RefreshToken)
+			require.Equal(t, session.ID, resp.Token.SessionId)
+			require.Equal(t, "laptop", session.Device)
+			require.Equal(t, "v1.0.0", session.BuildVersion)
+			require.Equal(t, "127.0.0.1", session.IP)
+			require.False(t, passhash.DefaultArgon2id.NeedsRehash(upgraded.Password))
+			require.Equal(t, dataHash.Secret, upgraded.Secret)
+			store.EXPECT().GetUser(gomock.Any(), gomock.Eq(data)).Return(data, models.ErrNotFound)
 			_, err = c.UserLogin(context.Background(), &pb.UserLoginRequest{
 				User: data.ToProto(),
 			})
 			require.Error(t, err)
-			//wrong pwd
-			store.EXPECT().GetUser(gomock.Eq(data)).Return(data, nil)
+			// plaintext stored by old client is not accepted as hash
+			store.EXPECT().GetUser(gomock.Any(), gomock.Eq(data)).Return(data, nil)
 			_, err = c.UserLogin(context.Background(), &pb.UserLoginRequest{
 				User: data.ToProto(),
 			})
@@ -144,7 +246,7 @@ func TestAuthGophkeeperServer_JWTClain(t *testing.T) {
 		{
 			name: "Test 1",
 			a: AuthGophkeeperServer{
-				jwtKey: []byte("jwt_key"),
+				keys: testKeySet(t),
 			},
 			args: args{
 				models.User{
@@ -158,7 +260,7 @@ func TestAuthGophkeeperServer_JWTClain(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got1, got2, err := tt.a.JWTClain(tt.args.creds)
+			got1, got2, err := tt.a.JWTClain(tt.args.creds, "session")
 			if (err != nil) != tt.wantErr {
 				t.Errorf("AuthGophkeeperServer.JWTClain() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -167,7 +269,7 @@ func TestAuthGophkeeperServer_JWTClain(t *testing.T) {
 			duration := time.Second * 2
 			time.Sleep(duration)
 
-			got3, got4, err := tt.a.JWTClain(tt.args.creds)
+			got3, got4, err := tt.a.JWTClain(tt.args.creds, "session")
 			if (err != nil) != tt.wantErr {
 				t.Errorf("AuthGophkeeperServer.JWTClain() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -192,7 +294,7 @@ func TestAuthGophkeeperServer_parseToken(t *testing.T) {
 		{
 			name: "Test 1",
 			a: AuthGophkeeperServer{
-				jwtKey: []byte("secret"),
+				keys: testKeySet(t),
 			},
 			args: args{
 				token: "",
@@ -207,61 +309,271 @@ func TestAuthGophkeeperServer_parseToken(t *testing.T) {
 				Password: "1",
 				Secret:   []byte("s"),
 			}
-			tt.args.token, _, _ = tt.a.JWTClain(user)
-			if err := tt.a.parseToken(tt.args.token); (err != nil) != tt.wantErr {
+			tt.args.token, _, _ = tt.a.JWTClain(user, "session")
+			claims, err := tt.a.parseToken(tt.args.token)
+			if (err != nil) != tt.wantErr {
 				t.Errorf("AuthGophkeeperServer.parseToken() error = %v, wantErr %v", err, tt.wantErr)
 			}
-			tt.a.jwtKey = []byte("new_secret")
-			err := tt.a.parseToken(tt.args.token)
+			require.Equal(t, user.Email, claims.Email)
+			_, err = tt.a.parseToken("not a token")
+			require.Error(t, err)
+			tt.a.keys = testKeySet(t)
+			_, err = tt.a.parseToken(tt.args.token)
 			require.Error(t, err)
 		})
 	}
 }
 
 func TestAuthGophkeeperServer_Refresh(t *testing.T) {
-	type args struct {
-		ctx context.Context
-		in  *pb.RefreshRequest
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	store := mockdb.NewMockStorager(ctrl)
+	a := AuthGophkeeperServer{
+		keys: testKeySet(t),
+		DB:   store,
+	}
+	ctx := context.Background()
+	session := models.Session{
+		ID:        "session",
+		User:      "test@test.com",
+		CreatedAt: time.Now(),
+		LastSeen:  time.Now(),
+		ExpiresAt: time.Now().Add(time.Hour),
+	}
+	store.EXPECT().AddSession(gomock.Any(), gomock.Any()).Return(nil)
+	store.EXPECT().AddRefreshToken(gomock.Any(), gomock.Any()).Return(nil)
+	token, err := a.newSession(ctx, models.User{Email: session.User}, "laptop", "v1.0.0")
+	require.NoError(t, err)
+	hash := hashRefreshToken(token.RefreshToken)
+
+	// rotation
+	store.EXPECT().GetRefreshToken(gomock.Any(), gomock.Eq(hash)).Return(models.RefreshToken{Hash: hash, SessionID: session.ID}, nil)
+	store.EXPECT().GetSession(gomock.Any(), gomock.Eq(session.ID)).Return(session, nil)
+	store.EXPECT().UseRefreshToken(gomock.Any(), gomock.Eq(hash)).Return(true, nil)
+	store.EXPECT().TouchSession(gomock.Any(), gomock.Eq(session.ID), gomock.Any(), gomock.Any()).Return(nil)
+	store.EXPECT().AddRefreshToken(gomock.Any(), gomock.Any()).Return(nil)
+	resp, err := a.Refresh(ctx, &pb.RefreshRequest{Token: token})
+	require.NoError(t, err)
+	require.NotEqual(t, token.RefreshToken, resp.Token.RefreshToken)
+	claims, err := a.parseToken(resp.Token.Token)
+	require.NoError(t, err)
+	require.Equal(t, session.ID, claims.SessionID)
+
+	// reuse of rotated token kills session
+	store.EXPECT().GetRefreshToken(gomock.Any(), gomock.Eq(hash)).Return(models.RefreshToken{Hash: hash, SessionID: session.ID, Used: true}, nil)
+	store.EXPECT().GetSession(gomock.Any(), gomock.Eq(session.ID)).Return(session, nil)
+	store.EXPECT().RevokeSession(gomock.Any(), gomock.Eq(session.ID)).Return(nil)
+	_, err = a.Refresh(ctx, &pb.RefreshRequest{Token: token})
+	require.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	// concurrent use of the same token
+	store.EXPECT().GetRefreshToken(gomock.Any(), gomock.Eq(hash)).Return(models.RefreshToken{Hash: hash, SessionID: session.ID}, nil)
+	store.EXPECT().GetSession(gomock.Any(), gomock.Eq(session.ID)).Return(session, nil)
+	store.EXPECT().UseRefreshToken(gomock.Any(), gomock.Eq(hash)).Return(false, nil)
+	store.EXPECT().RevokeSession(gomock.Any(), gomock.Eq(session.ID)).Return(nil)
+	_, err = a.Refresh(ctx, &pb.RefreshRequest{Token: token})
+	require.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	// revoked session
+	session.Revoked = true
+	store.EXPECT().GetRefreshToken(gomock.Any(), gomock.Eq(hash)).Return(models.RefreshToken{Hash: hash, SessionID: session.ID}, nil)
+	store.EXPECT().GetSession(gomock.Any(), gomock.Eq(session.ID)).Return(session, nil)
+	_, err = a.Refresh(ctx, &pb.RefreshRequest{Token: token})
+	require.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	// unknown token
+	store.EXPECT().GetRefreshToken(gomock.Any(), gomock.Any()).Return(models.RefreshToken{}, models.ErrNotFound)
+	_, err = a.Refresh(ctx, &pb.RefreshRequest{Token: &pb.Token{RefreshToken: "wrong"}})
+	require.Equal(t, codes.Unauthenticated, status.Code(err))
+}
+
+func TestAuthGophkeeperServer_PurgeRefreshTokens(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	store := mockdb.NewMockStorager(ctrl)
+	a := AuthGophkeeperServer{
+		keys: testKeySet(t),
+		DB:   store,
+	}
+	now := time.Now()
+	store.EXPECT().PurgeRefreshTokens(gomock.Any(), gomock.Eq(now), gomock.Eq(now.Add(-usedRefreshTokenTTL))).Return(2, nil)
+	purged, err := a.PurgeRefreshTokens(context.Background(), now)
+	require.NoError(t, err)
+	require.Equal(t, 2, purged)
+}
+
+func TestAuthGophkeeperServer_Logout(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	store := mockdb.NewMockStorager(ctrl)
+	a := AuthGophkeeperServer{
+		keys: testKeySet(t),
+		DB:   store,
+	}
+	token, _, err := a.JWTClain(models.User{Email: "test@test.com"}, "session")
+	require.NoError(t, err)
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", "bearer "+token))
+	_, err = a.AuthFuncOverride(ctx, "/authgophkeeper.AuthGophkeeper/UserLogin")
+	require.NoError(t, err)
+	_, err = a.AuthFuncOverride(context.Background(), "/authgophkeeper.AuthGophkeeper/Logout")
+	require.Error(t, err)
+	ctx, err = a.AuthFuncOverride(ctx, "/authgophkeeper.AuthGophkeeper/Logout")
+	require.NoError(t, err)
+	store.EXPECT().RevokeSession(gomock.Any(), gomock.Eq("session")).Return(nil)
+	_, err = a.Logout(ctx, &pb.LogoutRequest{})
+	require.NoError(t, err)
+	_, err = a.Logout(context.Background(), &pb.LogoutRequest{})
+	require.Equal(t, codes.Unauthenticated, status.Code(err))
+}
+
+func TestAuthGophkeeperServer_RevokeSession(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	store := mockdb.NewMockStorager(ctrl)
+	a := AuthGophkeeperServer{
+		keys: testKeySet(t),
+		DB:   store,
 	}
+	session := models.Session{
+		ID:        "session",
+		User:      "test@test.com",
+		ExpiresAt: time.Now().Add(time.Hour),
+	}
+	hash := hashRefreshToken("refresh")
+	store.EXPECT().GetRefreshToken(gomock.Any(), gomock.Eq(hash)).Return(models.RefreshToken{Hash: hash, SessionID: session.ID}, nil)
+	store.EXPECT().GetSession(gomock.Any(), gomock.Eq(session.ID)).Return(session, nil)
+	store.EXPECT().RevokeSession(gomock.Any(), gomock.Eq(session.ID)).Return(nil)
+	_, err := a.RevokeSession(context.Background(), &pb.RevokeSessionRequest{RefreshToken: "refresh"})
+	require.NoError(t, err)
+	session.ExpiresAt = time.Now().Add(-time.Hour)
+	store.EXPECT().GetRefreshToken(gomock.Any(), gomock.Eq(hash)).Return(models.RefreshToken{Hash: hash, SessionID: session.ID}, nil)
+	store.EXPECT().GetSession(gomock.Any(), gomock.Eq(session.ID)).Return(session, nil)
+	_, err = a.RevokeSession(context.Background(), &pb.RevokeSessionRequest{RefreshToken: "refresh"})
+	require.Equal(t, codes.Unauthenticated, status.Code(err))
+}
+
+func TestAuthGophkeeperServer_ListSessions(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	store := mockdb.NewMockStorager(ctrl)
+	a := AuthGophkeeperServer{
+		keys: testKeySet(t),
+		DB:   store,
+	}
+	now := time.Now()
+	sessions := []models.Session{
+		{
+			ID:           "current",
+			User:         "test@test.com",
+			Device:       "laptop",
+			BuildVersion: "v1.0.0",
+			IP:           "127.0.0.1",
+			CreatedAt:    now,
+			LastSeen:     now,
+		},
+		{
+			ID:        "other",
+			User:      "test@test.com",
+			Device:    "phone",
+			CreatedAt: now.Add(-time.Hour),
+			LastSeen:  now.Add(-time.Minute),
+		},
+	}
+	_, err := a.ListSessions(context.Background(), &pb.ListSessionsRequest{})
+	require.Equal(t, codes.Unauthenticated, status.Code(err))
+	token, _, err := a.JWTClain(models.User{Email: "test@test.com"}, "current")
+	require.NoError(t, err)
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", "bearer "+token))
+	ctx, err = a.AuthFuncOverride(ctx, "/authgophkeeper.AuthGophkeeper/ListSessions")
+	require.NoError(t, err)
+	store.EXPECT().ListSessions(gomock.Any(), gomock.Eq("test@test.com")).Return(sessions, nil)
+	resp, err := a.ListSessions(ctx, &pb.ListSessionsRequest{})
+	require.NoError(t, err)
+	require.Len(t, resp.Sessions, 2)
+	require.Equal(t, &pb.Session{
+		Id:           "current",
+		Device:       "laptop",
+		BuildVersion: "v1.0.0",
+		Ip:           "127.0.0.1",
+		CreatedAt:    now.Unix(),
+		LastSeen:     now.Unix(),
+		Current:      true,
+	}, resp.Sessions[0])
+	require.False(t, resp.Sessions[1].Current)
+	store.EXPECT().ListSessions(gomock.Any(), gomock.Eq("test@test.com")).Return(nil, errors.New("no data"))
+	_, err = a.ListSessions(ctx, &pb.ListSessionsRequest{})
+	require.Error(t, err)
+}
+
+func TestAuthGophkeeperServer_TerminateSession(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	store := mockdb.NewMockStorager(ctrl)
+	a := AuthGophkeeperServer{
+		DB: store,
+	}
+	ctx := NewContextWithEmail(context.Background(), "test@test.com")
 	tests := []struct {
 		name    string
-		a       AuthGophkeeperServer
-		args    args
-		want    *pb.RefreshResponse
-		wantErr bool
+		session models.Session
+		err     error
+		revoke  bool
+		code    codes.Code
 	}{
 		{
-			name: "Test 1",
-			a: AuthGophkeeperServer{
-				jwtKey: []byte("secret"),
-			},
-			args: args{
-				ctx: context.Background(),
-				in: &pb.RefreshRequest{
-					Token: &pb.Token{
-						Email:   "test@test",
-						Token:   "",
-						Expires: time.Now().Unix(),
-					},
-				},
-			},
-			wantErr: false,
+			name:    "own session",
+			session: models.Session{ID: "session", User: "test@test.com"},
+			revoke:  true,
+			code:    codes.OK,
+		},
+		{
+			name:    "other user's session",
+			session: models.Session{ID: "session", User: "other@test.com"},
+			code:    codes.NotFound,
+		},
+		{
+			name: "no session",
+			err:  models.ErrNotFound,
+			code: codes.NotFound,
 		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			user := models.User{
-				Email:    "t",
-				Password: "1",
-				Secret:   []byte("s"),
+			store.EXPECT().GetSession(gomock.Any(), gomock.Eq("session")).Return(tt.session, tt.err)
+			if tt.revoke {
+				store.EXPECT().RevokeSession(gomock.Any(), gomock.Eq("session")).Return(nil)
 			}
-			tt.args.in.Token.Token, tt.args.in.Token.Expires, _ = tt.a.JWTClain(user)
-			_, err := tt.a.Refresh(tt.args.ctx, tt.args.in)
-			require.Error(t, err)
-			time.Sleep(time.Second * 35)
-			resp, err := tt.a.Refresh(tt.args.ctx, tt.args.in)
-			require.NoError(t, err)
-			require.Equal(t, resp.Token.Token, tt.args.in.Token.Token)
+			_, err := a.TerminateSession(ctx, &pb.TerminateSessionRequest{SessionId: "session"})
+			require.Equal(t, tt.code, status.Code(err))
 		})
 	}
+	_, err := a.TerminateSession(context.Background(), &pb.TerminateSessionRequest{SessionId: "session"})
+	require.Equal(t, codes.Unauthenticated, status.Code(err))
+}
+
+func TestAuthGophkeeperServer_AuthFunc(t *testing.T) {
+	a := AuthGophkeeperServer{
+		keys: testKeySet(t),
+	}
+	token, _, err := a.JWTClain(models.User{Email: "test@test.com"}, "session")
+	require.NoError(t, err)
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", "bearer "+token))
+	ctx, err = a.AuthFunc(ctx)
+	require.NoError(t, err)
+	email, ok := EmailFromContext(ctx)
+	require.True(t, ok)
+	require.Equal(t, "test@test.com", email)
+	sessionID, ok := SessionFromContext(ctx)
+	require.True(t, ok)
+	require.Equal(t, "session", sessionID)
+	expires, ok := ExpiresFromContext(ctx)
+	require.True(t, ok)
+	require.WithinDuration(t, time.Now().Add(defaultAccessTokenTTL), expires, time.Minute)
+	_, ok = EmailFromContext(context.Background())
+	require.False(t, ok)
+	_, ok = ExpiresFromContext(NewContextWithEmail(context.Background(), "test@test.com"))
+	require.False(t, ok)
+	ctx = metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", "bearer wrong"))
+	_, err = a.AuthFunc(ctx)
+	require.Equal(t, codes.Unauthenticated, status.Code(err))
 }
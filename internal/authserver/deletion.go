@@ -0,0 +1,122 @@
+package authserver
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/MaximkaSha/gophkeeper/internal/models"
+	pb "github.com/MaximkaSha/gophkeeper/internal/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// defaultAccountDeletionGrace - time to undo account deletion, used if config doesn't set it.
+const defaultAccountDeletionGrace = 7 * 24 * time.Hour
+
+// accountDeletionGrace returns time between deletion request and purge of account.
+func (a AuthGophkeeperServer) accountDeletionGrace() time.Duration {
+	if a.config == nil || a.config.AccountDeletionGrace == 0 {
+		return defaultAccountDeletionGrace
+	}
+	return a.config.AccountDeletionGrace
+}
+
+// deleteAfter returns unix time of scheduled deletion for login response, 0 if not scheduled.
+func deleteAfter(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.Unix()
+}
+
+// DeleteAccount endpoint schedules deletion of authenticated user.
+// Password and, if enrolled, authenticator or recovery code must be passed.
+// Account stays usable till purge, so deletion can be undone by CancelAccountDeletion.
+func (a AuthGophkeeperServer) DeleteAccount(ctx context.Context, in *pb.DeleteAccountRequest) (*pb.DeleteAccountResponse, error) {
+	var response pb.DeleteAccountResponse
+	email, ok := EmailFromContext(ctx)
+	if !ok {
+		return &response, status.Errorf(codes.Unauthenticated, "no session")
+	}
+	user, err := a.verifyCurrentPassword(ctx, email, in.Password)
+	if err != nil {
+		return &response, err
+	}
+	enrollment, err := a.DB.GetTOTP(ctx, email)
+	if err != nil {
+		return &response, models.StatusError(err)
+	}
+	if enrollment.Confirmed {
+		ok, err = a.checkCode(ctx, enrollment, in.Code)
+		if err != nil {
+			return &response, models.StatusError(err)
+		}
+		if !ok {
+			a.loginFailed(clientLoginKeys(ctx, email))
+			return &response, status.Errorf(codes.PermissionDenied, "wrong code")
+		}
+	}
+	if !user.DeleteAfter.IsZero() {
+		response.DeleteAfter = user.DeleteAfter.Unix()
+		return &response, nil
+	}
+	at := time.Now().Add(a.accountDeletionGrace())
+	err = a.DB.ScheduleUserDeletion(ctx, email, at)
+	if err != nil {
+		return &response, models.StatusError(err)
+	}
+	log.Printf("account %s scheduled for deletion at %s", email, at.Format(time.RFC3339))
+	response.DeleteAfter = at.Unix()
+	return &response, nil
+}
+
+// CancelAccountDeletion endpoint undoes deletion of authenticated user during grace period.
+func (a AuthGophkeeperServer) CancelAccountDeletion(ctx context.Context, in *pb.CancelAccountDeletionRequest) (*pb.CancelAccountDeletionResponse, error) {
+	var response pb.CancelAccountDeletionResponse
+	email, ok := EmailFromContext(ctx)
+	if !ok {
+		return &response, status.Errorf(codes.Unauthenticated, "no session")
+	}
+	ok, err := a.DB.CancelUserDeletion(ctx, email)
+	if err != nil {
+		return &response, models.StatusError(err)
+	}
+	if !ok {
+		return &response, status.Errorf(codes.FailedPrecondition, "account deletion is not scheduled")
+	}
+	return &response, nil
+}
+
+// PurgeDeletedAccounts removes accounts which grace period is over, with all their data.
+// Returns number of purged accounts.
+func (a AuthGophkeeperServer) PurgeDeletedAccounts(ctx context.Context, now time.Time) (int, error) {
+	emails, err := a.DB.ListDueDeletions(ctx, now)
+	if err != nil {
+		return 0, err
+	}
+	purged := 0
+	for _, email := range emails {
+		ok, err := a.DB.PurgeUser(ctx, email, now)
+		if err != nil {
+			return purged, err
+		}
+		if !ok {
+			continue
+		}
+		purged++
+		log.Printf("account %s purged", email)
+		// Failed logins counter is not account data, error doesn't matter.
+		_ = a.DB.ClearLoginAttempts(ctx, accountKeyPrefix+email)
+	}
+	return purged, nil
+}
+
+// PurgeEvery purges deleted accounts with given interval, errors are logged.
+func (a AuthGophkeeperServer) PurgeEvery(interval time.Duration) {
+	for range time.Tick(interval) {
+		if _, err := a.PurgeDeletedAccounts(context.Background(), time.Now()); err != nil {
+			log.Println("accounts purge error: ", err)
+		}
+	}
+}
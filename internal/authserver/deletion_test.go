@@ -0,0 +1,186 @@
+package authserver
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/MaximkaSha/gophkeeper/internal/mockdb"
+	"github.com/MaximkaSha/gophkeeper/internal/models"
+	"github.com/MaximkaSha/gophkeeper/internal/passhash"
+	pb "github.com/MaximkaSha/gophkeeper/internal/proto"
+	"github.com/golang/mock/gomock"
+	"github.com/pquerna/otp/totp"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestAuthGophkeeperServer_DeleteAccount(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	store := mockdb.NewMockStorager(ctrl)
+	allowLogins(store)
+	now := time.Now()
+	a := AuthGophkeeperServer{
+		keys: testKeySet(t),
+		DB:   store,
+		now:  func() time.Time { return now },
+	}
+	hash, err := passhash.Bcrypt{Cost: 4}.Hash("11111")
+	require.NoError(t, err)
+	user := models.User{
+		Email:    "test@test.com",
+		Password: hash,
+	}
+	key, err := totp.Generate(totp.GenerateOpts{Issuer: totpIssuer, AccountName: user.Email})
+	require.NoError(t, err)
+	enrollment := models.TOTP{User: user.Email, Secret: key.Secret(), Confirmed: true}
+	code, err := totp.GenerateCode(key.Secret(), now)
+	require.NoError(t, err)
+	scheduled := user
+	scheduled.DeleteAfter = time.Now().Add(time.Hour)
+	ctx := NewContextWithEmail(context.Background(), user.Email)
+
+	tests := []struct {
+		name    string
+		ctx     context.Context
+		req     *pb.DeleteAccountRequest
+		prepare func()
+		code    codes.Code
+	}{
+		{
+			name: "no session",
+			ctx:  context.Background(),
+			req:  &pb.DeleteAccountRequest{Password: "11111"},
+			code: codes.Unauthenticated,
+		},
+		{
+			name: "wrong password",
+			ctx:  ctx,
+			req:  &pb.DeleteAccountRequest{Password: "22222"},
+			prepare: func() {
+				store.EXPECT().GetUser(gomock.Any(), gomock.Eq(models.User{Email: user.Email})).Return(user, nil)
+			},
+			code: codes.PermissionDenied,
+		},
+		{
+			name: "authenticator code required",
+			ctx:  ctx,
+			req:  &pb.DeleteAccountRequest{Password: "11111"},
+			prepare: func() {
+				store.EXPECT().GetUser(gomock.Any(), gomock.Eq(models.User{Email: user.Email})).Return(user, nil)
+				store.EXPECT().GetTOTP(gomock.Any(), gomock.Eq(user.Email)).Return(enrollment, nil)
+				store.EXPECT().UseRecoveryCode(gomock.Any(), gomock.Eq(user.Email), gomock.Any()).Return(false, nil)
+			},
+			code: codes.PermissionDenied,
+		},
+		{
+			name: "scheduled",
+			ctx:  ctx,
+			req:  &pb.DeleteAccountRequest{Password: "11111", Code: code},
+			prepare: func() {
+				store.EXPECT().GetUser(gomock.Any(), gomock.Eq(models.User{Email: user.Email})).Return(user, nil)
+				store.EXPECT().GetTOTP(gomock.Any(), gomock.Eq(user.Email)).Return(enrollment, nil)
+				store.EXPECT().UseTOTPStep(gomock.Any(), gomock.Eq(user.Email), gomock.Eq(now.Unix()/totpPeriod)).Return(true, nil)
+				store.EXPECT().ScheduleUserDeletion(gomock.Any(), gomock.Eq(user.Email), gomock.Any()).DoAndReturn(func(_ context.Context, email string, at time.Time) error {
+					require.WithinDuration(t, time.Now().Add(defaultAccountDeletionGrace), at, time.Minute)
+					return nil
+				})
+			},
+			code: codes.OK,
+		},
+		{
+			name: "already scheduled",
+			ctx:  ctx,
+			req:  &pb.DeleteAccountRequest{Password: "11111"},
+			prepare: func() {
+				store.EXPECT().GetUser(gomock.Any(), gomock.Eq(models.User{Email: user.Email})).Return(scheduled, nil)
+				store.EXPECT().GetTOTP(gomock.Any(), gomock.Eq(user.Email)).Return(models.TOTP{}, nil)
+			},
+			code: codes.OK,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.prepare != nil {
+				tt.prepare()
+			}
+			resp, err := a.DeleteAccount(tt.ctx, tt.req)
+			require.Equal(t, tt.code, status.Code(err))
+			if err == nil {
+				require.NotZero(t, resp.DeleteAfter)
+			}
+		})
+	}
+}
+
+func TestAuthGophkeeperServer_CancelAccountDeletion(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	store := mockdb.NewMockStorager(ctrl)
+	a := AuthGophkeeperServer{
+		keys: testKeySet(t),
+		DB:   store,
+	}
+	ctx := NewContextWithEmail(context.Background(), "test@test.com")
+	store.EXPECT().CancelUserDeletion(gomock.Any(), gomock.Eq("test@test.com")).Return(true, nil)
+	_, err := a.CancelAccountDeletion(ctx, &pb.CancelAccountDeletionRequest{})
+	require.NoError(t, err)
+	store.EXPECT().CancelUserDeletion(gomock.Any(), gomock.Eq("test@test.com")).Return(false, nil)
+	_, err = a.CancelAccountDeletion(ctx, &pb.CancelAccountDeletionRequest{})
+	require.Equal(t, codes.FailedPrecondition, status.Code(err))
+	_, err = a.CancelAccountDeletion(context.Background(), &pb.CancelAccountDeletionRequest{})
+	require.Equal(t, codes.Unauthenticated, status.Code(err))
+}
+
+func TestAuthGophkeeperServer_PurgeDeletedAccounts(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	store := mockdb.NewMockStorager(ctrl)
+	a := AuthGophkeeperServer{
+		keys: testKeySet(t),
+		DB:   store,
+	}
+	now := time.Now()
+	store.EXPECT().ListDueDeletions(gomock.Any(), gomock.Eq(now)).Return([]string{"a@test.com", "b@test.com"}, nil)
+	store.EXPECT().PurgeUser(gomock.Any(), gomock.Eq("a@test.com"), gomock.Eq(now)).Return(true, nil)
+	store.EXPECT().ClearLoginAttempts(gomock.Any(), gomock.Eq("account:a@test.com")).Return(nil)
+	// deletion of b was canceled after listing
+	store.EXPECT().PurgeUser(gomock.Any(), gomock.Eq("b@test.com"), gomock.Eq(now)).Return(false, nil)
+	purged, err := a.PurgeDeletedAccounts(context.Background(), now)
+	require.NoError(t, err)
+	require.Equal(t, 1, purged)
+
+	store.EXPECT().ListDueDeletions(gomock.Any(), gomock.Eq(now)).Return(nil, errors.New("no"))
+	_, err = a.PurgeDeletedAccounts(context.Background(), now)
+	require.Error(t, err)
+}
+
+func TestAuthGophkeeperServer_UserLoginDeleteAfter(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	store := mockdb.NewMockStorager(ctrl)
+	allowLogins(store)
+	a := AuthGophkeeperServer{
+		keys:   testKeySet(t),
+		hasher: passhash.Bcrypt{Cost: 4},
+		DB:     store,
+	}
+	hash, err := passhash.Bcrypt{Cost: 4}.Hash("11111")
+	require.NoError(t, err)
+	user := models.User{
+		Email:       "test@test.com",
+		Password:    hash,
+		DeleteAfter: time.Now().Add(time.Hour).Truncate(time.Second),
+	}
+	// user can log in during grace period to undo deletion
+	store.EXPECT().GetUser(gomock.Any(), gomock.Any()).Return(user, nil)
+	store.EXPECT().GetTOTP(gomock.Any(), gomock.Eq(user.Email)).Return(models.TOTP{}, nil)
+	store.EXPECT().AddSession(gomock.Any(), gomock.Any()).Return(nil)
+	store.EXPECT().AddRefreshToken(gomock.Any(), gomock.Any()).Return(nil)
+	resp, err := a.UserLogin(context.Background(), &pb.UserLoginRequest{User: &pb.User{Email: user.Email, Password: "11111"}})
+	require.NoError(t, err)
+	require.Equal(t, user.DeleteAfter.Unix(), resp.DeleteAfter)
+}
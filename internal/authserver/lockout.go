@@ -0,0 +1,150 @@
+package authserver
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/MaximkaSha/gophkeeper/internal/models"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+// Key prefixes of failed logins counters.
+const (
+	accountKeyPrefix = "account:"
+	ipKeyPrefix      = "ip:"
+)
+
+// lockoutPolicy - when and for how long key is locked.
+// Key is locked for base after threshold failures in a row,
+// every next failure doubles lockout till max.
+type lockoutPolicy struct {
+	threshold int
+	base      time.Duration
+	max       time.Duration
+	// window - counter starts again if last failure was earlier.
+	window time.Duration
+}
+
+var (
+	// accountLockout protects single account from password guessing.
+	accountLockout = lockoutPolicy{
+		threshold: 5,
+		base:      30 * time.Second,
+		max:       time.Hour,
+		window:    24 * time.Hour,
+	}
+	// ipLockout protects all accounts from guessing from one address.
+	// Threshold is higher, many users can be behind one NAT.
+	ipLockout = lockoutPolicy{
+		threshold: 20,
+		base:      30 * time.Second,
+		max:       time.Hour,
+		window:    time.Hour,
+	}
+)
+
+// lockDuration returns lockout after given number of failures, zero if key is not locked.
+func (p lockoutPolicy) lockDuration(failures int) time.Duration {
+	if failures < p.threshold {
+		return 0
+	}
+	d := p.base
+	for i := p.threshold; i < failures && d < p.max; i++ {
+		d *= 2
+	}
+	if d > p.max {
+		d = p.max
+	}
+	return d
+}
+
+// lockoutTimeout - time to count failed attempt after request is done.
+const lockoutTimeout = 5 * time.Second
+
+// lockoutKey - counter key and its policy.
+type lockoutKey struct {
+	key    string
+	policy lockoutPolicy
+}
+
+// loginKeys returns counters of login attempt: account and client address.
+// Empty values are skipped.
+func loginKeys(email string, ip string) []lockoutKey {
+	keys := []lockoutKey{}
+	if email != "" {
+		keys = append(keys, lockoutKey{key: accountKeyPrefix + email, policy: accountLockout})
+	}
+	if ip != "" {
+		keys = append(keys, lockoutKey{key: ipKeyPrefix + ip, policy: ipLockout})
+	}
+	return keys
+}
+
+// checkLockout returns ResourceExhausted error with RetryInfo detail if any key is locked.
+func (a AuthGophkeeperServer) checkLockout(ctx context.Context, keys []lockoutKey) error {
+	now := time.Now()
+	var wait time.Duration
+	for _, k := range keys {
+		attempt, err := a.DB.GetLoginAttempt(ctx, k.key)
+		if err != nil {
+			return models.StatusError(err)
+		}
+		if d := attempt.LockedUntil.Sub(now); d > wait {
+			wait = d
+		}
+	}
+	if wait <= 0 {
+		return nil
+	}
+	return lockedError(wait)
+}
+
+// lockedError returns ResourceExhausted error, client should retry after given delay.
+func lockedError(wait time.Duration) error {
+	wait = wait.Round(time.Second)
+	st, err := status.New(codes.ResourceExhausted, "too many failed attempts, try again in "+wait.String()).
+		WithDetails(&errdetails.RetryInfo{RetryDelay: durationpb.New(wait)})
+	if err != nil {
+		return status.Errorf(codes.ResourceExhausted, "too many failed attempts, try again in %s", wait)
+	}
+	return st.Err()
+}
+
+// loginFailed counts failed attempt for every key and locks keys which reached threshold.
+// Storage errors are logged by storage, caller returns its own error.
+// Attempt is counted even if client canceled request, so guessing can't dodge the counter.
+func (a AuthGophkeeperServer) loginFailed(keys []lockoutKey) {
+	ctx, cancel := context.WithTimeout(context.Background(), lockoutTimeout)
+	defer cancel()
+	now := time.Now()
+	for _, k := range keys {
+		failures, err := a.DB.AddLoginFailure(ctx, k.key, now, now.Add(-k.policy.window))
+		if err != nil {
+			continue
+		}
+		d := k.policy.lockDuration(failures)
+		if d == 0 {
+			continue
+		}
+		log.Printf("login locked for %s after %d failures, %s", k.key, failures, d)
+		_ = a.DB.LockLogin(ctx, k.key, now.Add(d))
+	}
+}
+
+// loginSucceeded resets failures counter of account.
+// Address counter is kept, it is shared by all accounts behind it.
+func (a AuthGophkeeperServer) loginSucceeded(ctx context.Context, email string) {
+	err := a.DB.ClearLoginAttempts(ctx, accountKeyPrefix+email)
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+// clientLoginKeys returns login counters of request context.
+func clientLoginKeys(ctx context.Context, email string) []lockoutKey {
+	return loginKeys(email, peerIP(ctx))
+}
@@ -0,0 +1,132 @@
+package authserver
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/MaximkaSha/gophkeeper/internal/mockdb"
+	"github.com/MaximkaSha/gophkeeper/internal/models"
+	pb "github.com/MaximkaSha/gophkeeper/internal/proto"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+func TestLockoutPolicy_lockDuration(t *testing.T) {
+	tests := []struct {
+		name     string
+		failures int
+		want     time.Duration
+	}{
+		{
+			name:     "below threshold",
+			failures: 4,
+			want:     0,
+		},
+		{
+			name:     "threshold",
+			failures: 5,
+			want:     30 * time.Second,
+		},
+		{
+			name:     "backoff",
+			failures: 7,
+			want:     2 * time.Minute,
+		},
+		{
+			name:     "max",
+			failures: 100,
+			want:     time.Hour,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.want, accountLockout.lockDuration(tt.failures))
+		})
+	}
+}
+
+func TestAuthGophkeeperServer_UserLoginLockout(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	store := mockdb.NewMockStorager(ctrl)
+	a := AuthGophkeeperServer{
+		keys: testKeySet(t),
+		DB:   store,
+	}
+	user := models.User{
+		Email:    "test@test.com",
+		Password: "11111",
+	}
+	ctx := peer.NewContext(context.Background(), &peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 5000}})
+
+	// threshold failure locks account, address is only counted
+	store.EXPECT().GetLoginAttempt(gomock.Any(), gomock.Eq("account:test@test.com")).Return(models.LoginAttempt{}, nil)
+	store.EXPECT().GetLoginAttempt(gomock.Any(), gomock.Eq("ip:10.0.0.1")).Return(models.LoginAttempt{}, nil)
+	store.EXPECT().GetUser(gomock.Any(), gomock.Eq(user)).Return(models.User{}, models.ErrNotFound)
+	store.EXPECT().AddLoginFailure(gomock.Any(), gomock.Eq("account:test@test.com"), gomock.Any(), gomock.Any()).Return(accountLockout.threshold, nil)
+	store.EXPECT().AddLoginFailure(gomock.Any(), gomock.Eq("ip:10.0.0.1"), gomock.Any(), gomock.Any()).Return(accountLockout.threshold, nil)
+	var lockedUntil time.Time
+	store.EXPECT().LockLogin(gomock.Any(), gomock.Eq("account:test@test.com"), gomock.Any()).DoAndReturn(func(_ context.Context, key string, until time.Time) error {
+		lockedUntil = until
+		return nil
+	})
+	_, err := a.UserLogin(ctx, &pb.UserLoginRequest{User: user.ToProto()})
+	require.Equal(t, codes.Unauthenticated, status.Code(err))
+	require.WithinDuration(t, time.Now().Add(accountLockout.base), lockedUntil, time.Second)
+
+	// locked account is rejected before password check
+	store.EXPECT().GetLoginAttempt(gomock.Any(), gomock.Eq("account:test@test.com")).Return(models.LoginAttempt{LockedUntil: lockedUntil}, nil)
+	store.EXPECT().GetLoginAttempt(gomock.Any(), gomock.Eq("ip:10.0.0.1")).Return(models.LoginAttempt{}, nil)
+	_, err = a.UserLogin(ctx, &pb.UserLoginRequest{User: user.ToProto()})
+	require.Equal(t, codes.ResourceExhausted, status.Code(err))
+	details := status.Convert(err).Details()
+	require.Len(t, details, 1)
+	retry, ok := details[0].(*errdetails.RetryInfo)
+	require.True(t, ok)
+	require.Equal(t, accountLockout.base, retry.RetryDelay.AsDuration())
+
+	// expired lockout
+	store.EXPECT().GetLoginAttempt(gomock.Any(), gomock.Eq("account:test@test.com")).Return(models.LoginAttempt{LockedUntil: time.Now().Add(-time.Second)}, nil)
+	store.EXPECT().GetLoginAttempt(gomock.Any(), gomock.Eq("ip:10.0.0.1")).Return(models.LoginAttempt{}, nil)
+	store.EXPECT().GetUser(gomock.Any(), gomock.Eq(user)).Return(models.User{}, models.ErrNotFound)
+	store.EXPECT().AddLoginFailure(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(1, nil).Times(2)
+	_, err = a.UserLogin(ctx, &pb.UserLoginRequest{User: user.ToProto()})
+	require.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	// storage outage is not a failed attempt
+	store.EXPECT().GetLoginAttempt(gomock.Any(), gomock.Any()).Return(models.LoginAttempt{}, nil).Times(2)
+	store.EXPECT().GetUser(gomock.Any(), gomock.Eq(user)).Return(models.User{}, errors.New("connection refused"))
+	_, err = a.UserLogin(ctx, &pb.UserLoginRequest{User: user.ToProto()})
+	require.Equal(t, codes.Internal, status.Code(err))
+	require.Equal(t, "Internal error", status.Convert(err).Message())
+}
+
+func TestAuthGophkeeperServer_RefreshLockout(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	store := mockdb.NewMockStorager(ctrl)
+	a := AuthGophkeeperServer{
+		keys: testKeySet(t),
+		DB:   store,
+	}
+	ctx := peer.NewContext(context.Background(), &peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 5000}})
+
+	// guessed tokens are counted against address
+	store.EXPECT().GetLoginAttempt(gomock.Any(), gomock.Eq("ip:10.0.0.1")).Return(models.LoginAttempt{}, nil)
+	store.EXPECT().GetRefreshToken(gomock.Any(), gomock.Any()).Return(models.RefreshToken{}, models.ErrNotFound)
+	store.EXPECT().AddLoginFailure(gomock.Any(), gomock.Eq("ip:10.0.0.1"), gomock.Any(), gomock.Any()).Return(ipLockout.threshold+1, nil)
+	store.EXPECT().LockLogin(gomock.Any(), gomock.Eq("ip:10.0.0.1"), gomock.Any()).Return(nil)
+	_, err := a.Refresh(ctx, &pb.RefreshRequest{Token: &pb.Token{RefreshToken: "wrong"}})
+	require.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	store.EXPECT().GetLoginAttempt(gomock.Any(), gomock.Eq("ip:10.0.0.1")).Return(models.LoginAttempt{LockedUntil: time.Now().Add(time.Minute)}, nil)
+	_, err = a.Refresh(ctx, &pb.RefreshRequest{Token: &pb.Token{RefreshToken: "wrong"}})
+	require.Equal(t, codes.ResourceExhausted, status.Code(err))
+}
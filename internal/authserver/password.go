@@ -0,0 +1,102 @@
+package authserver
+
+import (
+	"context"
+	"log"
+
+	"github.com/MaximkaSha/gophkeeper/internal/models"
+	"github.com/MaximkaSha/gophkeeper/internal/passhash"
+	pb "github.com/MaximkaSha/gophkeeper/internal/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// passwordHasher returns hasher of new passwords, Argon2id by default.
+func (a AuthGophkeeperServer) passwordHasher() passhash.Hasher {
+	if a.hasher == nil {
+		return passhash.DefaultArgon2id
+	}
+	return a.hasher
+}
+
+// checkPassword verifies password against stored hash of user.
+// Hash made by old algorithm or parameters is replaced by current one,
+// failed upgrade doesn't fail login.
+func (a AuthGophkeeperServer) checkPassword(ctx context.Context, password string, user models.User) bool {
+	ok, err := passhash.Verify(password, user.Password)
+	if err != nil {
+		log.Printf("password of %s not verified: %s", user.Email, err)
+		return false
+	}
+	if !ok {
+		return false
+	}
+	hasher := a.passwordHasher()
+	if hasher.NeedsRehash(user.Password) {
+		user.Password, err = hasher.Hash(password)
+		if err == nil {
+			err = a.DB.UpdateUser(ctx, user)
+		}
+		if err != nil {
+			log.Println("password rehash error: ", err)
+		}
+	}
+	return true
+}
+
+// verifyCurrentPassword checks password of signed in user before sensitive changes.
+// Guessing is limited like login, hash is not upgraded here.
+func (a AuthGophkeeperServer) verifyCurrentPassword(ctx context.Context, email string, password string) (models.User, error) {
+	keys := clientLoginKeys(ctx, email)
+	err := a.checkLockout(ctx, keys)
+	if err != nil {
+		return models.User{}, err
+	}
+	user, err := a.DB.GetUser(ctx, models.User{Email: email})
+	if err != nil {
+		return models.User{}, models.StatusError(err)
+	}
+	ok, err := passhash.Verify(password, user.Password)
+	if err != nil || !ok {
+		a.loginFailed(keys)
+		return models.User{}, status.Errorf(codes.PermissionDenied, "wrong password")
+	}
+	return user, nil
+}
+
+// ChangePassword endpoint replaces master password of authenticated user.
+// Current password must be passed. New password, salt and vault key wrapped
+// by new password come from client, new password is hashed here.
+// Vault key itself is not changed, so stored data is readable without re-encryption.
+// All sessions except current one are revoked.
+func (a AuthGophkeeperServer) ChangePassword(ctx context.Context, in *pb.ChangePasswordRequest) (*pb.ChangePasswordResponse, error) {
+	var response pb.ChangePasswordResponse
+	email, ok := EmailFromContext(ctx)
+	if !ok {
+		return &response, status.Errorf(codes.Unauthenticated, "no session")
+	}
+	sessionID, _ := SessionFromContext(ctx)
+	user := models.User{}
+	user.FromProto(in.GetUser())
+	if len(user.Password) == 0 || len(user.Secret) == 0 || len(user.Salt) == 0 {
+		return &response, status.Errorf(codes.InvalidArgument, "password, wrapped secret and salt required")
+	}
+	_, err := a.verifyCurrentPassword(ctx, email, in.OldPassword)
+	if err != nil {
+		return &response, err
+	}
+	user.Email = email
+	user.Password, err = a.passwordHasher().Hash(user.Password)
+	if err != nil {
+		return &response, status.Errorf(codes.Unknown, "Password hashing error")
+	}
+	err = a.DB.UpdateUser(ctx, user)
+	if err != nil {
+		return &response, models.StatusError(err)
+	}
+	err = a.DB.RevokeUserSessions(ctx, email, sessionID)
+	if err != nil {
+		return &response, models.StatusError(err)
+	}
+	return &response, nil
+}
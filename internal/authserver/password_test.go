@@ -0,0 +1,106 @@
+package authserver
+
+import (
+	"context"
+	"testing"
+
+	"github.com/MaximkaSha/gophkeeper/internal/mockdb"
+	"github.com/MaximkaSha/gophkeeper/internal/models"
+	"github.com/MaximkaSha/gophkeeper/internal/passhash"
+	pb "github.com/MaximkaSha/gophkeeper/internal/proto"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestAuthGophkeeperServer_ChangePassword(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	store := mockdb.NewMockStorager(ctrl)
+	a := AuthGophkeeperServer{
+		keys: testKeySet(t),
+		DB:   store,
+	}
+	current := models.User{
+		Email:    "test@test.com",
+		Password: "11111",
+		Secret:   []byte("wrapped"),
+		Salt:     []byte("salt"),
+	}
+	current.HashPassword()
+	changed := models.User{
+		Email:    "test@test.com",
+		Password: "new auth key",
+		Secret:   []byte("rewrapped"),
+		Salt:     []byte("newsalt"),
+	}
+	token, _, err := a.JWTClain(models.User{Email: current.Email}, "session")
+	require.NoError(t, err)
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", "bearer "+token))
+	ctx, err = a.AuthFuncOverride(ctx, "/authgophkeeper.AuthGophkeeper/ChangePassword")
+	require.NoError(t, err)
+	_, err = a.AuthFuncOverride(context.Background(), "/authgophkeeper.AuthGophkeeper/ChangePassword")
+	require.Error(t, err)
+
+	tests := []struct {
+		name    string
+		ctx     context.Context
+		req     *pb.ChangePasswordRequest
+		prepare func()
+		code    codes.Code
+	}{
+		{
+			name: "no session",
+			ctx:  context.Background(),
+			req:  &pb.ChangePasswordRequest{OldPassword: "11111", User: changed.ToProto()},
+			code: codes.Unauthenticated,
+		},
+		{
+			name: "no wrapped secret",
+			ctx:  ctx,
+			req:  &pb.ChangePasswordRequest{OldPassword: "11111", User: &pb.User{Password: changed.Password, Salt: changed.Salt}},
+			code: codes.InvalidArgument,
+		},
+		{
+			name: "wrong password",
+			ctx:  ctx,
+			req:  &pb.ChangePasswordRequest{OldPassword: "22222", User: changed.ToProto()},
+			prepare: func() {
+				store.EXPECT().GetLoginAttempt(gomock.Any(), gomock.Eq("account:test@test.com")).Return(models.LoginAttempt{}, nil)
+				store.EXPECT().GetUser(gomock.Any(), gomock.Eq(models.User{Email: current.Email})).Return(current, nil)
+				store.EXPECT().AddLoginFailure(gomock.Any(), gomock.Eq("account:test@test.com"), gomock.Any(), gomock.Any()).Return(1, nil)
+			},
+			code: codes.PermissionDenied,
+		},
+		{
+			name: "changed, other sessions revoked",
+			ctx:  ctx,
+			req:  &pb.ChangePasswordRequest{OldPassword: "11111", User: changed.ToProto()},
+			prepare: func() {
+				store.EXPECT().GetLoginAttempt(gomock.Any(), gomock.Eq("account:test@test.com")).Return(models.LoginAttempt{}, nil)
+				store.EXPECT().GetUser(gomock.Any(), gomock.Eq(models.User{Email: current.Email})).Return(current, nil)
+				store.EXPECT().UpdateUser(gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, u models.User) error {
+					ok, err := passhash.Verify(changed.Password, u.Password)
+					require.NoError(t, err)
+					require.True(t, ok)
+					require.Equal(t, changed.Secret, u.Secret)
+					require.Equal(t, changed.Salt, u.Salt)
+					return nil
+				})
+				store.EXPECT().RevokeUserSessions(gomock.Any(), gomock.Eq(current.Email), gomock.Eq("session")).Return(nil)
+			},
+			code: codes.OK,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.prepare != nil {
+				tt.prepare()
+			}
+			_, err := a.ChangePassword(tt.ctx, tt.req)
+			require.Equal(t, tt.code, status.Code(err))
+		})
+	}
+}
@@ -0,0 +1,285 @@
+package authserver
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"log"
+	"net"
+	"time"
+
+	"github.com/MaximkaSha/gophkeeper/internal/models"
+	pb "github.com/MaximkaSha/gophkeeper/internal/proto"
+	"github.com/google/uuid"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// Default lifetimes, used if config doesn't set them.
+const (
+	defaultAccessTokenTTL = time.Minute
+	defaultSessionTTL     = 30 * 24 * time.Hour
+)
+
+// usedRefreshTokenTTL - used refresh token is kept this long, so its reuse ends session.
+const usedRefreshTokenTTL = 24 * time.Hour
+
+// accessTokenTTL returns lifetime of access token.
+func (a AuthGophkeeperServer) accessTokenTTL() time.Duration {
+	if a.config == nil || a.config.AccessTokenTTL == 0 {
+		return defaultAccessTokenTTL
+	}
+	return a.config.AccessTokenTTL
+}
+
+// sessionTTL returns lifetime of login session.
+func (a AuthGophkeeperServer) sessionTTL() time.Duration {
+	if a.config == nil || a.config.SessionTTL == 0 {
+		return defaultSessionTTL
+	}
+	return a.config.SessionTTL
+}
+
+// genRefreshToken returns new random refresh token and its hash.
+func genRefreshToken() (string, []byte, error) {
+	data := make([]byte, 32)
+	_, err := rand.Read(data)
+	if err != nil {
+		return "", nil, err
+	}
+	token := base64.RawURLEncoding.EncodeToString(data)
+	return token, hashRefreshToken(token), nil
+}
+
+// hashRefreshToken returns hash of refresh token which is kept in database.
+func hashRefreshToken(token string) []byte {
+	hash := sha256.Sum256([]byte(token))
+	return hash[:]
+}
+
+// peerIP returns ip address of client from peer info.
+func peerIP(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(p.Addr.String())
+	if err != nil {
+		return p.Addr.String()
+	}
+	return host
+}
+
+// newSession starts new login session for user.
+// Returns access token and first refresh token of session.
+func (a AuthGophkeeperServer) newSession(ctx context.Context, user models.User, device string, buildVersion string) (*pb.Token, error) {
+	now := time.Now()
+	session := models.Session{
+		ID:           uuid.NewString(),
+		User:         user.Email,
+		Device:       device,
+		BuildVersion: buildVersion,
+		IP:           peerIP(ctx),
+		CreatedAt:    now,
+		LastSeen:     now,
+		ExpiresAt:    now.Add(a.sessionTTL()),
+	}
+	err := a.DB.AddSession(ctx, session)
+	if err != nil {
+		return nil, models.StatusError(err)
+	}
+	return a.issueTokens(ctx, session)
+}
+
+// issueTokens returns new access token and new refresh token for given session.
+func (a AuthGophkeeperServer) issueTokens(ctx context.Context, session models.Session) (*pb.Token, error) {
+	refreshToken, hash, err := genRefreshToken()
+	if err != nil {
+		return nil, status.Errorf(codes.Unknown, "Refresh token generating error")
+	}
+	err = a.DB.AddRefreshToken(ctx, models.RefreshToken{
+		Hash:      hash,
+		SessionID: session.ID,
+		CreatedAt: time.Now(),
+	})
+	if err != nil {
+		return nil, models.StatusError(err)
+	}
+	tokenString, expiresAt, err := a.JWTClain(models.User{Email: session.User}, session.ID)
+	if err != nil {
+		return nil, status.Errorf(codes.Unknown, "JWT Generating Error")
+	}
+	return &pb.Token{
+		Email:        session.User,
+		Token:        tokenString,
+		Expires:      expiresAt,
+		RefreshToken: refreshToken,
+		SessionId:    session.ID,
+	}, nil
+}
+
+// activeSession returns session of refresh token if session is not revoked or expired.
+// Unknown or inactive token is reported by Unauthenticated error.
+func (a AuthGophkeeperServer) activeSession(ctx context.Context, refreshToken string) (models.Session, models.RefreshToken, error) {
+	token, err := a.DB.GetRefreshToken(ctx, hashRefreshToken(refreshToken))
+	if errors.Is(err, models.ErrNotFound) {
+		return models.Session{}, models.RefreshToken{}, status.Errorf(codes.Unauthenticated, "wrong refresh token")
+	}
+	if err != nil {
+		return models.Session{}, models.RefreshToken{}, models.StatusError(err)
+	}
+	session, err := a.DB.GetSession(ctx, token.SessionID)
+	if errors.Is(err, models.ErrNotFound) {
+		return models.Session{}, models.RefreshToken{}, status.Errorf(codes.Unauthenticated, "wrong refresh token")
+	}
+	if err != nil {
+		return models.Session{}, models.RefreshToken{}, models.StatusError(err)
+	}
+	if session.Revoked || time.Now().After(session.ExpiresAt) {
+		return models.Session{}, models.RefreshToken{}, status.Errorf(codes.Unauthenticated, "session expired")
+	}
+	return session, token, nil
+}
+
+// Refresh endpoint implementation.
+// This endpoint use to exchange refresh token to new access token and new refresh token.
+// Each refresh token can be used only once. If used token is presented again
+// whole session is revoked, because token was stolen either by attacker or by user.
+func (a AuthGophkeeperServer) Refresh(ctx context.Context, in *pb.RefreshRequest) (*pb.RefreshResponse, error) {
+	var response pb.RefreshResponse
+	// Refresh tokens are guessed from address, account is not known.
+	keys := clientLoginKeys(ctx, "")
+	err := a.checkLockout(ctx, keys)
+	if err != nil {
+		return &response, err
+	}
+	session, token, err := a.activeSession(ctx, in.GetToken().GetRefreshToken())
+	if status.Code(err) == codes.Unauthenticated {
+		a.loginFailed(keys)
+	}
+	if err != nil {
+		return &response, err
+	}
+	fresh := !token.Used
+	if fresh {
+		fresh, err = a.DB.UseRefreshToken(ctx, token.Hash)
+		if err != nil {
+			return &response, models.StatusError(err)
+		}
+	}
+	if !fresh {
+		log.Printf("refresh token reuse detected, session %s revoked", session.ID)
+		err = a.DB.RevokeSession(ctx, session.ID)
+		if err != nil {
+			return &response, models.StatusError(err)
+		}
+		return &response, status.Errorf(codes.Unauthenticated, "refresh token reused")
+	}
+	err = a.DB.TouchSession(ctx, session.ID, time.Now(), peerIP(ctx))
+	if err != nil {
+		return &response, models.StatusError(err)
+	}
+	response.Token, err = a.issueTokens(ctx, session)
+	if err != nil {
+		return &response, err
+	}
+	return &response, nil
+}
+
+// Logout endpoint revokes session of caller's access token.
+func (a AuthGophkeeperServer) Logout(ctx context.Context, in *pb.LogoutRequest) (*pb.LogoutResponse, error) {
+	var response pb.LogoutResponse
+	sessionID, ok := SessionFromContext(ctx)
+	if !ok {
+		return &response, status.Errorf(codes.Unauthenticated, "no session")
+	}
+	err := a.DB.RevokeSession(ctx, sessionID)
+	if err != nil {
+		return &response, models.StatusError(err)
+	}
+	return &response, nil
+}
+
+// RevokeSession endpoint revokes session of given refresh token.
+// Access token is not needed, so session can be closed after access token expired.
+func (a AuthGophkeeperServer) RevokeSession(ctx context.Context, in *pb.RevokeSessionRequest) (*pb.RevokeSessionResponse, error) {
+	var response pb.RevokeSessionResponse
+	session, _, err := a.activeSession(ctx, in.RefreshToken)
+	if err != nil {
+		return &response, err
+	}
+	err = a.DB.RevokeSession(ctx, session.ID)
+	if err != nil {
+		return &response, models.StatusError(err)
+	}
+	return &response, nil
+}
+
+// ListSessions endpoint returns active sessions of authenticated user.
+// Session of caller's access token is marked as current.
+func (a AuthGophkeeperServer) ListSessions(ctx context.Context, in *pb.ListSessionsRequest) (*pb.ListSessionsResponse, error) {
+	var response pb.ListSessionsResponse
+	email, ok := EmailFromContext(ctx)
+	if !ok {
+		return &response, status.Errorf(codes.Unauthenticated, "no session")
+	}
+	current, _ := SessionFromContext(ctx)
+	sessions, err := a.DB.ListSessions(ctx, email)
+	if err != nil {
+		return &response, models.StatusError(err)
+	}
+	for _, session := range sessions {
+		response.Sessions = append(response.Sessions, &pb.Session{
+			Id:           session.ID,
+			Device:       session.Device,
+			BuildVersion: session.BuildVersion,
+			Ip:           session.IP,
+			CreatedAt:    session.CreatedAt.Unix(),
+			LastSeen:     session.LastSeen.Unix(),
+			Current:      session.ID == current,
+		})
+	}
+	return &response, nil
+}
+
+// TerminateSession endpoint revokes one of authenticated user's sessions.
+// Access token of terminated session stays valid until it expires, but can't be refreshed.
+// Watch streams of terminated session are closed.
+func (a AuthGophkeeperServer) TerminateSession(ctx context.Context, in *pb.TerminateSessionRequest) (*pb.TerminateSessionResponse, error) {
+	var response pb.TerminateSessionResponse
+	email, ok := EmailFromContext(ctx)
+	if !ok {
+		return &response, status.Errorf(codes.Unauthenticated, "no session")
+	}
+	session, err := a.DB.GetSession(ctx, in.SessionId)
+	if err != nil && !errors.Is(err, models.ErrNotFound) {
+		return &response, models.StatusError(err)
+	}
+	// Sessions of other users are reported as missing, to not disclose them.
+	if err != nil || session.User != email {
+		return &response, status.Errorf(codes.NotFound, "session not found")
+	}
+	err = a.DB.RevokeSession(ctx, session.ID)
+	if err != nil {
+		return &response, models.StatusError(err)
+	}
+	return &response, nil
+}
+
+// PurgeRefreshTokens removes refresh tokens of ended sessions and tokens used long ago.
+// Returns number of purged tokens.
+func (a AuthGophkeeperServer) PurgeRefreshTokens(ctx context.Context, now time.Time) (int, error) {
+	return a.DB.PurgeRefreshTokens(ctx, now, now.Add(-usedRefreshTokenTTL))
+}
+
+// PurgeRefreshTokensEvery purges refresh tokens with given interval, errors are logged.
+func (a AuthGophkeeperServer) PurgeRefreshTokensEvery(interval time.Duration) {
+	for range time.Tick(interval) {
+		if _, err := a.PurgeRefreshTokens(context.Background(), time.Now()); err != nil {
+			log.Println("refresh tokens purge error: ", err)
+		}
+	}
+}
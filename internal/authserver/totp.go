@@ -0,0 +1,330 @@
+package authserver
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/MaximkaSha/gophkeeper/internal/models"
+	pb "github.com/MaximkaSha/gophkeeper/internal/proto"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/google/uuid"
+	"github.com/pquerna/otp"
+	"github.com/pquerna/otp/totp"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// totpIssuer - issuer shown by authenticator app.
+	totpIssuer = "Gophkeeper"
+	// mfaAudience - audience of token which is issued between password and code steps.
+	mfaAudience = "mfa"
+	// mfaTokenTTL - time to enter code after password.
+	mfaTokenTTL = 5 * time.Minute
+	// maxMFAAttempts - wrong codes allowed per MFA token, then password must be entered again.
+	maxMFAAttempts = 5
+	// recoveryCodesCount - number of recovery codes issued on enrollment.
+	recoveryCodesCount = 10
+	// recoveryCodeSize - random bytes of recovery code, 16 base32 characters.
+	recoveryCodeSize = 10
+	// totpPeriod - seconds of authenticator code time step.
+	totpPeriod = 30
+)
+
+// mfaClaims - claims of MFA token. Login request data is kept in token till second step.
+type mfaClaims struct {
+	Email        string `json:"email"`
+	Device       string `json:"device"`
+	BuildVersion string `json:"build_version"`
+	jwt.StandardClaims
+}
+
+// mfaAttempts counts wrong codes of MFA tokens.
+var mfaAttempts = &attemptCounter{attempts: map[string]attempt{}}
+
+type attempt struct {
+	count   int
+	expires time.Time
+}
+
+// attemptCounter - in-memory counter of failed attempts by token id.
+type attemptCounter struct {
+	mu       sync.Mutex
+	attempts map[string]attempt
+}
+
+// try counts attempt and reports whether it is allowed.
+// Counting before check keeps limit under concurrent requests.
+func (c *attemptCounter) try(id string, expires time.Time) bool {
+	return c.add(id, expires, 1) <= maxMFAAttempts
+}
+
+// reset forgets attempts, counting starts again.
+func (c *attemptCounter) reset(id string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.attempts, id)
+}
+
+// exhaust forbids further use of token, MFA token is valid for one login only.
+func (c *attemptCounter) exhaust(id string, expires time.Time) {
+	c.add(id, expires, maxMFAAttempts)
+}
+
+// add increases counter and returns its value, expired counters are dropped.
+func (c *attemptCounter) add(id string, expires time.Time, count int) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	for key, val := range c.attempts {
+		if now.After(val.expires) {
+			delete(c.attempts, key)
+		}
+	}
+	a := c.attempts[id]
+	a.count += count
+	a.expires = expires
+	c.attempts[id] = a
+	return a.count
+}
+
+// mfaToken returns token which must be presented with code to finish login.
+func (a AuthGophkeeperServer) mfaToken(user models.User, device string, buildVersion string) (string, error) {
+	claims := &mfaClaims{
+		Email:        user.Email,
+		Device:       device,
+		BuildVersion: buildVersion,
+		StandardClaims: jwt.StandardClaims{
+			Id:        uuid.NewString(),
+			Audience:  mfaAudience,
+			ExpiresAt: time.Now().Add(mfaTokenTTL).Unix(),
+		},
+	}
+	return a.keys.Sign(claims)
+}
+
+// parseMFAToken checks MFA token. Access tokens are not accepted.
+func (a AuthGophkeeperServer) parseMFAToken(token string) (*mfaClaims, error) {
+	claims := &mfaClaims{}
+	tkn, err := jwt.ParseWithClaims(token, claims, a.keys.Keyfunc)
+	if err != nil || !tkn.Valid || !claims.VerifyAudience(mfaAudience, true) {
+		return nil, status.Error(codes.Unauthenticated, "wrong mfa token")
+	}
+	return claims, nil
+}
+
+// validateTOTP checks 6-digit code of authenticator at given time and returns its time step.
+// One step of clock drift is allowed.
+func validateTOTP(code string, secret string, now time.Time) (int64, bool) {
+	current := now.Unix() / totpPeriod
+	for _, step := range []int64{current + 1, current, current - 1} {
+		ok, err := totp.ValidateCustom(code, secret, time.Unix(step*totpPeriod, 0), totp.ValidateOpts{
+			Period:    totpPeriod,
+			Digits:    otp.DigitsSix,
+			Algorithm: otp.AlgorithmSHA1,
+		})
+		if err == nil && ok {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// useTOTP checks authenticator code of user and spends it.
+// Code of step not later than last accepted one is rejected, so intercepted code can't be replayed.
+func (a AuthGophkeeperServer) useTOTP(ctx context.Context, enrollment models.TOTP, code string) (bool, error) {
+	step, ok := validateTOTP(code, enrollment.Secret, a.clock())
+	if !ok || step <= enrollment.LastStep {
+		return false, nil
+	}
+	return a.DB.UseTOTPStep(ctx, enrollment.User, step)
+}
+
+// genRecoveryCodes returns recovery codes for user and their hashes for database.
+func genRecoveryCodes() ([]string, [][]byte, error) {
+	codes := make([]string, 0, recoveryCodesCount)
+	hashes := make([][]byte, 0, recoveryCodesCount)
+	for i := 0; i < recoveryCodesCount; i++ {
+		data := make([]byte, recoveryCodeSize)
+		_, err := rand.Read(data)
+		if err != nil {
+			return nil, nil, err
+		}
+		code := base32.StdEncoding.EncodeToString(data)
+		codes = append(codes, code[0:4]+"-"+code[4:8]+"-"+code[8:12]+"-"+code[12:16])
+		hashes = append(hashes, hashRecoveryCode(code))
+	}
+	return codes, hashes, nil
+}
+
+// hashRecoveryCode returns hash of recovery code, dashes, spaces and case are ignored.
+func hashRecoveryCode(code string) []byte {
+	code = strings.ToUpper(strings.NewReplacer("-", "", " ", "").Replace(code))
+	hash := sha256.Sum256([]byte(code))
+	return hash[:]
+}
+
+// checkCode checks authenticator code or recovery code of enrolled user.
+// Code is spent on success.
+func (a AuthGophkeeperServer) checkCode(ctx context.Context, enrollment models.TOTP, code string) (bool, error) {
+	if !enrollment.Confirmed {
+		return false, nil
+	}
+	if len(code) == int(otp.DigitsSix) {
+		return a.useTOTP(ctx, enrollment, code)
+	}
+	return a.DB.UseRecoveryCode(ctx, enrollment.User, hashRecoveryCode(code))
+}
+
+// UserLoginMFA endpoint finishes login of user with enrolled authenticator.
+// MFA token from UserLogin and authenticator or recovery code must be passed.
+func (a AuthGophkeeperServer) UserLoginMFA(ctx context.Context, in *pb.UserLoginMFARequest) (*pb.UserLoginResponse, error) {
+	var response pb.UserLoginResponse
+	claims, err := a.parseMFAToken(in.MfaToken)
+	if err != nil {
+		return &response, err
+	}
+	keys := clientLoginKeys(ctx, claims.Email)
+	err = a.checkLockout(ctx, keys)
+	if err != nil {
+		return &response, err
+	}
+	expires := time.Unix(claims.ExpiresAt, 0)
+	if !mfaAttempts.try(claims.Id, expires) {
+		return &response, status.Errorf(codes.Unauthenticated, "too many attempts, login again")
+	}
+	enrollment, err := a.DB.GetTOTP(ctx, claims.Email)
+	if err != nil {
+		return &response, models.StatusError(err)
+	}
+	ok, err := a.checkCode(ctx, enrollment, in.Code)
+	if err != nil {
+		return &response, models.StatusError(err)
+	}
+	if !ok {
+		a.loginFailed(keys)
+		return &response, status.Errorf(codes.Unauthenticated, "wrong code")
+	}
+	mfaAttempts.exhaust(claims.Id, expires)
+	a.loginSucceeded(ctx, claims.Email)
+	user, err := a.DB.GetUser(ctx, models.User{Email: claims.Email})
+	if err != nil {
+		return &response, models.StatusError(err)
+	}
+	response.Token, err = a.newSession(ctx, user, claims.Device, claims.BuildVersion)
+	if err != nil {
+		return &response, err
+	}
+	response.User = user.ToProto()
+	response.DeleteAfter = deleteAfter(user.DeleteAfter)
+	return &response, nil
+}
+
+// EnrollTOTP endpoint starts authenticator enrollment of authenticated user.
+// Returns otpauth URI for authenticator app and recovery codes, which are shown only once.
+// Enrollment is not active till ConfirmTOTP.
+func (a AuthGophkeeperServer) EnrollTOTP(ctx context.Context, in *pb.EnrollTOTPRequest) (*pb.EnrollTOTPResponse, error) {
+	var response pb.EnrollTOTPResponse
+	email, ok := EmailFromContext(ctx)
+	if !ok {
+		return &response, status.Errorf(codes.Unauthenticated, "no session")
+	}
+	enrollment, err := a.DB.GetTOTP(ctx, email)
+	if err != nil {
+		return &response, models.StatusError(err)
+	}
+	if enrollment.Confirmed {
+		return &response, status.Errorf(codes.FailedPrecondition, "authenticator already enrolled, disable it first")
+	}
+	key, err := totp.Generate(totp.GenerateOpts{
+		Issuer:      totpIssuer,
+		AccountName: email,
+	})
+	if err != nil {
+		return &response, status.Errorf(codes.Unknown, "TOTP generating error")
+	}
+	recoveryCodes, hashes, err := genRecoveryCodes()
+	if err != nil {
+		return &response, status.Errorf(codes.Unknown, "Recovery codes generating error")
+	}
+	err = a.DB.AddTOTP(ctx, models.TOTP{
+		User:          email,
+		Secret:        key.Secret(),
+		RecoveryCodes: hashes,
+	})
+	if err != nil {
+		return &response, models.StatusError(err)
+	}
+	response.Uri = key.URL()
+	response.RecoveryCodes = recoveryCodes
+	return &response, nil
+}
+
+// ConfirmTOTP endpoint activates enrollment, code from authenticator app must be passed.
+// After confirmation login needs second step.
+func (a AuthGophkeeperServer) ConfirmTOTP(ctx context.Context, in *pb.ConfirmTOTPRequest) (*pb.ConfirmTOTPResponse, error) {
+	var response pb.ConfirmTOTPResponse
+	email, ok := EmailFromContext(ctx)
+	if !ok {
+		return &response, status.Errorf(codes.Unauthenticated, "no session")
+	}
+	enrollment, err := a.DB.GetTOTP(ctx, email)
+	if err != nil {
+		return &response, models.StatusError(err)
+	}
+	if enrollment.Secret == "" || enrollment.Confirmed {
+		return &response, status.Errorf(codes.FailedPrecondition, "no enrollment to confirm")
+	}
+	ok, err = a.useTOTP(ctx, enrollment, in.Code)
+	if err != nil {
+		return &response, models.StatusError(err)
+	}
+	if !ok {
+		return &response, status.Errorf(codes.InvalidArgument, "wrong code")
+	}
+	err = a.DB.ConfirmTOTP(ctx, email)
+	if err != nil {
+		return &response, models.StatusError(err)
+	}
+	return &response, nil
+}
+
+// DisableTOTP endpoint removes authenticator and recovery codes.
+// Authenticator or recovery code is needed, so stolen access token can't turn second factor off.
+func (a AuthGophkeeperServer) DisableTOTP(ctx context.Context, in *pb.DisableTOTPRequest) (*pb.DisableTOTPResponse, error) {
+	var response pb.DisableTOTPResponse
+	email, ok := EmailFromContext(ctx)
+	if !ok {
+		return &response, status.Errorf(codes.Unauthenticated, "no session")
+	}
+	enrollment, err := a.DB.GetTOTP(ctx, email)
+	if err != nil {
+		return &response, models.StatusError(err)
+	}
+	if enrollment.Secret == "" {
+		return &response, status.Errorf(codes.FailedPrecondition, "authenticator is not enrolled")
+	}
+	if enrollment.Confirmed {
+		if !mfaAttempts.try("disable:"+email, time.Now().Add(mfaTokenTTL)) {
+			return &response, status.Errorf(codes.PermissionDenied, "too many attempts, try later")
+		}
+		ok, err = a.checkCode(ctx, enrollment, in.Code)
+		if err != nil {
+			return &response, models.StatusError(err)
+		}
+		if !ok {
+			return &response, status.Errorf(codes.PermissionDenied, "wrong code")
+		}
+	}
+	err = a.DB.DelTOTP(ctx, email)
+	if err != nil {
+		return &response, models.StatusError(err)
+	}
+	mfaAttempts.reset("disable:" + email)
+	return &response, nil
+}
@@ -0,0 +1,201 @@
+package authserver
+
+import (
+	"context"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/MaximkaSha/gophkeeper/internal/mockdb"
+	"github.com/MaximkaSha/gophkeeper/internal/models"
+	pb "github.com/MaximkaSha/gophkeeper/internal/proto"
+	"github.com/golang/mock/gomock"
+	"github.com/pquerna/otp/totp"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestAuthGophkeeperServer_TOTP(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	store := mockdb.NewMockStorager(ctrl)
+	allowLogins(store)
+	store.EXPECT().UpdateUser(gomock.Any(), gomock.Any()).Return(nil).AnyTimes()
+	// codes are checked at fixed time, slow password hashing doesn't make them expire
+	now := time.Now()
+	a := AuthGophkeeperServer{
+		keys: testKeySet(t),
+		DB:   store,
+		now:  func() time.Time { return now },
+	}
+	user := models.User{
+		Email:    "test@test.com",
+		Password: "11111",
+		Secret:   []byte("secret"),
+	}
+	userHash := user
+	userHash.HashPassword()
+	ctx := NewContextWithEmail(context.Background(), user.Email)
+
+	// enrollment
+	var enrollment models.TOTP
+	store.EXPECT().GetTOTP(gomock.Any(), gomock.Eq(user.Email)).Return(models.TOTP{}, nil)
+	store.EXPECT().AddTOTP(gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, e models.TOTP) error {
+		enrollment = e
+		return nil
+	})
+	enrollResp, err := a.EnrollTOTP(ctx, &pb.EnrollTOTPRequest{})
+	require.NoError(t, err)
+	uri, err := url.Parse(enrollResp.Uri)
+	require.NoError(t, err)
+	require.Equal(t, "otpauth", uri.Scheme)
+	require.Equal(t, enrollment.Secret, uri.Query().Get("secret"))
+	require.Len(t, enrollResp.RecoveryCodes, recoveryCodesCount)
+	require.Len(t, enrollment.RecoveryCodes, recoveryCodesCount)
+	for i, code := range enrollResp.RecoveryCodes {
+		require.NotContains(t, string(enrollment.RecoveryCodes[i]), code)
+		require.Equal(t, hashRecoveryCode(code), enrollment.RecoveryCodes[i])
+	}
+
+	// confirmation
+	store.EXPECT().GetTOTP(gomock.Any(), gomock.Eq(user.Email)).Return(enrollment, nil)
+	_, err = a.ConfirmTOTP(ctx, &pb.ConfirmTOTPRequest{Code: "000000"})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+	code, err := totp.GenerateCode(enrollment.Secret, now)
+	require.NoError(t, err)
+	step := now.Unix() / totpPeriod
+	store.EXPECT().GetTOTP(gomock.Any(), gomock.Eq(user.Email)).Return(enrollment, nil)
+	store.EXPECT().UseTOTPStep(gomock.Any(), gomock.Eq(user.Email), gomock.Eq(step)).Return(true, nil)
+	store.EXPECT().ConfirmTOTP(gomock.Any(), gomock.Eq(user.Email)).Return(nil)
+	_, err = a.ConfirmTOTP(ctx, &pb.ConfirmTOTPRequest{Code: code})
+	require.NoError(t, err)
+	enrollment.Confirmed = true
+	enrollment.LastStep = step
+	store.EXPECT().GetTOTP(gomock.Any(), gomock.Eq(user.Email)).Return(enrollment, nil)
+	_, err = a.EnrollTOTP(ctx, &pb.EnrollTOTPRequest{})
+	require.Equal(t, codes.FailedPrecondition, status.Code(err))
+
+	// password step returns MFA token only
+	store.EXPECT().GetUser(gomock.Any(), gomock.Eq(user)).Return(userHash, nil)
+	store.EXPECT().GetTOTP(gomock.Any(), gomock.Eq(user.Email)).Return(enrollment, nil)
+	loginResp, err := a.UserLogin(context.Background(), &pb.UserLoginRequest{User: user.ToProto(), Device: "laptop"})
+	require.NoError(t, err)
+	require.True(t, loginResp.MfaRequired)
+	require.Nil(t, loginResp.Token)
+	require.Empty(t, loginResp.User.Secret)
+	_, err = a.parseToken(loginResp.MfaToken)
+	require.Error(t, err)
+	accessToken, _, err := a.JWTClain(user, "session")
+	require.NoError(t, err)
+	_, err = a.UserLoginMFA(context.Background(), &pb.UserLoginMFARequest{MfaToken: accessToken, Code: code})
+	require.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	// code step, code used for confirmation is spent
+	store.EXPECT().GetTOTP(gomock.Any(), gomock.Eq(user.Email)).Return(enrollment, nil)
+	_, err = a.UserLoginMFA(context.Background(), &pb.UserLoginMFARequest{MfaToken: loginResp.MfaToken, Code: "000000"})
+	require.Equal(t, codes.Unauthenticated, status.Code(err))
+	store.EXPECT().GetTOTP(gomock.Any(), gomock.Eq(user.Email)).Return(enrollment, nil)
+	_, err = a.UserLoginMFA(context.Background(), &pb.UserLoginMFARequest{MfaToken: loginResp.MfaToken, Code: code})
+	require.Equal(t, codes.Unauthenticated, status.Code(err))
+	now = now.Add(totpPeriod * time.Second)
+	code, err = totp.GenerateCode(enrollment.Secret, now)
+	require.NoError(t, err)
+	step = now.Unix() / totpPeriod
+	var session models.Session
+	store.EXPECT().GetTOTP(gomock.Any(), gomock.Eq(user.Email)).Return(enrollment, nil)
+	store.EXPECT().UseTOTPStep(gomock.Any(), gomock.Eq(user.Email), gomock.Eq(step)).Return(true, nil)
+	store.EXPECT().GetUser(gomock.Any(), gomock.Eq(models.User{Email: user.Email})).Return(userHash, nil)
+	store.EXPECT().AddSession(gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, s models.Session) error {
+		session = s
+		return nil
+	})
+	store.EXPECT().AddRefreshToken(gomock.Any(), gomock.Any()).Return(nil)
+	mfaResp, err := a.UserLoginMFA(context.Background(), &pb.UserLoginMFARequest{MfaToken: loginResp.MfaToken, Code: code})
+	require.NoError(t, err)
+	require.NotEmpty(t, mfaResp.Token.Token)
+	require.Equal(t, user.Secret, mfaResp.User.Secret)
+	require.Equal(t, "laptop", session.Device)
+	// MFA token works once
+	_, err = a.UserLoginMFA(context.Background(), &pb.UserLoginMFARequest{MfaToken: loginResp.MfaToken, Code: code})
+	require.Equal(t, codes.Unauthenticated, status.Code(err))
+	enrollment.LastStep = step
+
+	// accepted code can't be replayed with new MFA token
+	store.EXPECT().GetUser(gomock.Any(), gomock.Eq(user)).Return(userHash, nil)
+	store.EXPECT().GetTOTP(gomock.Any(), gomock.Eq(user.Email)).Return(enrollment, nil)
+	loginResp, err = a.UserLogin(context.Background(), &pb.UserLoginRequest{User: user.ToProto()})
+	require.NoError(t, err)
+	store.EXPECT().GetTOTP(gomock.Any(), gomock.Eq(user.Email)).Return(enrollment, nil)
+	_, err = a.UserLoginMFA(context.Background(), &pb.UserLoginMFARequest{MfaToken: loginResp.MfaToken, Code: code})
+	require.Equal(t, codes.Unauthenticated, status.Code(err))
+	// nor spent concurrently
+	store.EXPECT().GetTOTP(gomock.Any(), gomock.Eq(user.Email)).Return(models.TOTP{User: user.Email, Secret: enrollment.Secret, Confirmed: true, LastStep: step - 1}, nil)
+	store.EXPECT().UseTOTPStep(gomock.Any(), gomock.Eq(user.Email), gomock.Eq(step)).Return(false, nil)
+	_, err = a.UserLoginMFA(context.Background(), &pb.UserLoginMFARequest{MfaToken: loginResp.MfaToken, Code: code})
+	require.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	// recovery code
+	store.EXPECT().GetUser(gomock.Any(), gomock.Eq(user)).Return(userHash, nil)
+	store.EXPECT().GetTOTP(gomock.Any(), gomock.Eq(user.Email)).Return(enrollment, nil)
+	loginResp, err = a.UserLogin(context.Background(), &pb.UserLoginRequest{User: user.ToProto()})
+	require.NoError(t, err)
+	recovery := enrollResp.RecoveryCodes[0]
+	store.EXPECT().GetTOTP(gomock.Any(), gomock.Eq(user.Email)).Return(enrollment, nil)
+	store.EXPECT().UseRecoveryCode(gomock.Any(), gomock.Eq(user.Email), gomock.Eq(hashRecoveryCode(recovery))).Return(true, nil)
+	store.EXPECT().GetUser(gomock.Any(), gomock.Eq(models.User{Email: user.Email})).Return(userHash, nil)
+	store.EXPECT().AddSession(gomock.Any(), gomock.Any()).Return(nil)
+	store.EXPECT().AddRefreshToken(gomock.Any(), gomock.Any()).Return(nil)
+	_, err = a.UserLoginMFA(context.Background(), &pb.UserLoginMFARequest{MfaToken: loginResp.MfaToken, Code: recovery})
+	require.NoError(t, err)
+
+	// attempts limit
+	store.EXPECT().GetUser(gomock.Any(), gomock.Eq(user)).Return(userHash, nil)
+	store.EXPECT().GetTOTP(gomock.Any(), gomock.Eq(user.Email)).Return(enrollment, nil)
+	loginResp, err = a.UserLogin(context.Background(), &pb.UserLoginRequest{User: user.ToProto()})
+	require.NoError(t, err)
+	store.EXPECT().GetTOTP(gomock.Any(), gomock.Eq(user.Email)).Return(enrollment, nil).Times(maxMFAAttempts)
+	store.EXPECT().UseRecoveryCode(gomock.Any(), gomock.Eq(user.Email), gomock.Any()).Return(false, nil).Times(maxMFAAttempts)
+	for i := 0; i < maxMFAAttempts; i++ {
+		_, err = a.UserLoginMFA(context.Background(), &pb.UserLoginMFARequest{MfaToken: loginResp.MfaToken, Code: "wrong"})
+		require.Equal(t, codes.Unauthenticated, status.Code(err))
+	}
+	_, err = a.UserLoginMFA(context.Background(), &pb.UserLoginMFARequest{MfaToken: loginResp.MfaToken, Code: code})
+	require.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	// disable
+	store.EXPECT().GetTOTP(gomock.Any(), gomock.Eq(user.Email)).Return(enrollment, nil)
+	_, err = a.DisableTOTP(ctx, &pb.DisableTOTPRequest{Code: "000000"})
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+	now = now.Add(totpPeriod * time.Second)
+	code, err = totp.GenerateCode(enrollment.Secret, now)
+	require.NoError(t, err)
+	store.EXPECT().GetTOTP(gomock.Any(), gomock.Eq(user.Email)).Return(enrollment, nil)
+	store.EXPECT().UseTOTPStep(gomock.Any(), gomock.Eq(user.Email), gomock.Eq(now.Unix()/totpPeriod)).Return(true, nil)
+	store.EXPECT().DelTOTP(gomock.Any(), gomock.Eq(user.Email)).Return(nil)
+	_, err = a.DisableTOTP(ctx, &pb.DisableTOTPRequest{Code: code})
+	require.NoError(t, err)
+	// wrong codes are forgotten after disabling
+	require.NotContains(t, mfaAttempts.attempts, "disable:"+user.Email)
+	store.EXPECT().GetTOTP(gomock.Any(), gomock.Eq(user.Email)).Return(models.TOTP{}, nil)
+	_, err = a.DisableTOTP(ctx, &pb.DisableTOTPRequest{Code: code})
+	require.Equal(t, codes.FailedPrecondition, status.Code(err))
+}
+
+func TestAttemptCounter(t *testing.T) {
+	c := &attemptCounter{attempts: map[string]attempt{}}
+	expires := time.Now().Add(time.Minute)
+	for i := 0; i < maxMFAAttempts; i++ {
+		require.True(t, c.try("token", expires))
+	}
+	require.False(t, c.try("token", expires))
+	require.True(t, c.try("other", expires))
+	c.exhaust("other", expires)
+	require.False(t, c.try("other", expires))
+	c.reset("other")
+	require.True(t, c.try("other", expires))
+	// expired counters are dropped
+	c.try("old", time.Now().Add(-time.Minute))
+	c.try("token", expires)
+	require.NotContains(t, c.attempts, "old")
+}
@@ -0,0 +1,57 @@
+// Package blobstore keeps ciphered file chunks out of database.
+package blobstore
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+)
+
+// BlobStore - store of file chunk payloads, database keeps only their keys.
+type BlobStore interface {
+	// Put saves blob by key, existing blob is replaced.
+	Put(ctx context.Context, key string, data []byte) error
+	// Get returns blob by key, models.ErrNotFound returned if there is no such blob.
+	Get(ctx context.Context, key string) ([]byte, error)
+	// Delete removes blobs by keys, missing ones are skipped.
+	Delete(ctx context.Context, keys ...string) error
+}
+
+// Schemes of blob store DSN.
+const (
+	FSScheme = "file://"
+	S3Scheme = "s3://"
+)
+
+// New returns blob store of DSN:
+// empty one keeps payloads in database and returns nil,
+// file:///var/lib/gophkeeper/blobs keeps them in local directory,
+// s3://access:secret@host:9000/bucket?region=us-east-1&insecure=true keeps them in S3 compatible bucket.
+func New(dsn string) (BlobStore, error) {
+	switch {
+	case dsn == "":
+		return nil, nil
+	case strings.HasPrefix(dsn, FSScheme):
+		store, err := NewFS(strings.TrimPrefix(dsn, FSScheme))
+		if err != nil {
+			return nil, err
+		}
+		return store, nil
+	case strings.HasPrefix(dsn, S3Scheme):
+		store, err := NewS3(dsn)
+		if err != nil {
+			return nil, err
+		}
+		return store, nil
+	}
+	return nil, fmt.Errorf("unknown blob store %q", dsn)
+}
+
+// checkKey rejects keys which are not relative slash separated paths, so blobs stay inside store.
+func checkKey(key string) error {
+	if key == "" || path.IsAbs(key) || path.Clean(key) != key || key == ".." || strings.HasPrefix(key, "../") {
+		return fmt.Errorf("invalid blob key %q", key)
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package blobstore
+
+import (
+	"context"
+	"testing"
+
+	"github.com/MaximkaSha/gophkeeper/internal/models"
+	"github.com/stretchr/testify/require"
+)
+
+// testBlobStore checks behaviour shared by blob store implementations.
+func testBlobStore(t *testing.T, store BlobStore) {
+	ctx := context.Background()
+	require.NoError(t, store.Put(ctx, "upload/0", []byte("first")))
+	require.NoError(t, store.Put(ctx, "upload/1", []byte{}))
+	data, err := store.Get(ctx, "upload/0")
+	require.NoError(t, err)
+	require.Equal(t, []byte("first"), data)
+	data, err = store.Get(ctx, "upload/1")
+	require.NoError(t, err)
+	require.Empty(t, data)
+
+	// blob is replaced
+	require.NoError(t, store.Put(ctx, "upload/0", []byte("second")))
+	data, err = store.Get(ctx, "upload/0")
+	require.NoError(t, err)
+	require.Equal(t, []byte("second"), data)
+
+	// missing blobs are skipped on delete
+	require.NoError(t, store.Delete(ctx, "upload/0", "upload/1", "upload/2"))
+	_, err = store.Get(ctx, "upload/0")
+	require.ErrorIs(t, err, models.ErrNotFound)
+
+	// keys can't point outside store
+	for _, key := range []string{"", "/etc/passwd", "../upload", "upload/../../x", "upload//0"} {
+		require.Error(t, store.Put(ctx, key, []byte("data")), key)
+	}
+}
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name    string
+		dsn     string
+		nilErr  bool
+		nilBlob bool
+	}{
+		{
+			name:    "database",
+			dsn:     "",
+			nilErr:  true,
+			nilBlob: true,
+		},
+		{
+			name:   "directory",
+			dsn:    FSScheme + t.TempDir(),
+			nilErr: true,
+		},
+		{
+			name:    "no bucket",
+			dsn:     S3Scheme + "key:secret@localhost:9000",
+			nilBlob: true,
+		},
+		{
+			name:    "unknown",
+			dsn:     "ftp://localhost/blobs",
+			nilBlob: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store, err := New(tt.dsn)
+			require.Equal(t, tt.nilErr, err == nil)
+			require.Equal(t, tt.nilBlob, store == nil)
+		})
+	}
+}
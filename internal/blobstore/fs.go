@@ -0,0 +1,98 @@
+package blobstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/MaximkaSha/gophkeeper/internal/models"
+)
+
+// FS - blob store in local directory, blob key is path of file in it.
+type FS struct {
+	dir string
+}
+
+// NewFS returns blob store in given directory, it is created if missing.
+func NewFS(dir string) (*FS, error) {
+	if dir == "" {
+		return nil, errors.New("blob store directory is not set")
+	}
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return nil, err
+	}
+	return &FS{dir: dir}, nil
+}
+
+// path returns file of blob.
+func (f *FS) path(key string) (string, error) {
+	if err := checkKey(key); err != nil {
+		return "", err
+	}
+	return filepath.Join(f.dir, filepath.FromSlash(key)), nil
+}
+
+// Put writes blob to temporary file and renames it, so readers never see partial blob.
+func (f *FS) Put(ctx context.Context, key string, data []byte) error {
+	name, err := f.path(key)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(name), 0700)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(name), ".blob-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), name)
+}
+
+// Get reads blob file.
+func (f *FS) Get(ctx context.Context, key string) ([]byte, error) {
+	name, err := f.path(key)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(name)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("blob %s: %w", key, models.ErrNotFound)
+	}
+	return data, err
+}
+
+// Delete removes blob files and directories left empty by them.
+func (f *FS) Delete(ctx context.Context, keys ...string) error {
+	for _, key := range keys {
+		name, err := f.path(key)
+		if err != nil {
+			return err
+		}
+		err = os.Remove(name)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		for dir := filepath.Dir(name); dir != filepath.Clean(f.dir); dir = filepath.Dir(dir) {
+			// only empty directories are removed
+			if os.Remove(dir) != nil {
+				break
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,25 @@
+package blobstore
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFS(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewFS(dir)
+	require.NoError(t, err)
+	testBlobStore(t, store)
+
+	// emptied directories are removed, store directory is kept
+	require.NoError(t, store.Put(context.Background(), "upload/0", []byte("data")))
+	require.NoError(t, store.Delete(context.Background(), "upload/0"))
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Empty(t, entries)
+	_, err = os.Stat(dir)
+	require.NoError(t, err)
+}
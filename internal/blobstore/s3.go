@@ -0,0 +1,121 @@
+package blobstore
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/MaximkaSha/gophkeeper/internal/models"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// S3 - blob store in bucket of S3 compatible service, e.g. MinIO.
+type S3 struct {
+	client *minio.Client
+	bucket string
+	prefix string
+}
+
+// NewS3 connects to bucket of DSN s3://access:secret@host:port/bucket/prefix?region=&insecure=true,
+// bucket is created if missing.
+func NewS3(dsn string) (*S3, error) {
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return nil, err
+	}
+	bucket, prefix, _ := strings.Cut(strings.Trim(u.Path, "/"), "/")
+	if u.Host == "" || bucket == "" {
+		return nil, fmt.Errorf("S3 endpoint and bucket must be set in %s", u.Redacted())
+	}
+	insecure := false
+	if v := u.Query().Get("insecure"); v != "" {
+		insecure, err = strconv.ParseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("insecure option of S3 blob store: %w", err)
+		}
+	}
+	secret, _ := u.User.Password()
+	region := u.Query().Get("region")
+	client, err := minio.New(u.Host, &minio.Options{
+		Creds:  credentials.NewStaticV4(u.User.Username(), secret, ""),
+		Secure: !insecure,
+		Region: region,
+	})
+	if err != nil {
+		return nil, err
+	}
+	ctx := context.Background()
+	exists, err := client.BucketExists(ctx, bucket)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		err = client.MakeBucket(ctx, bucket, minio.MakeBucketOptions{Region: region})
+		if err != nil {
+			return nil, err
+		}
+	}
+	if prefix != "" {
+		prefix += "/"
+	}
+	return &S3{client: client, bucket: bucket, prefix: prefix}, nil
+}
+
+// object returns name of blob object in bucket.
+func (s *S3) object(key string) (string, error) {
+	if err := checkKey(key); err != nil {
+		return "", err
+	}
+	return s.prefix + key, nil
+}
+
+// Put uploads blob object.
+func (s *S3) Put(ctx context.Context, key string, data []byte) error {
+	name, err := s.object(key)
+	if err != nil {
+		return err
+	}
+	_, err = s.client.PutObject(ctx, s.bucket, name, bytes.NewReader(data), int64(len(data)),
+		minio.PutObjectOptions{ContentType: "application/octet-stream"})
+	return err
+}
+
+// Get downloads blob object.
+func (s *S3) Get(ctx context.Context, key string) ([]byte, error) {
+	name, err := s.object(key)
+	if err != nil {
+		return nil, err
+	}
+	obj, err := s.client.GetObject(ctx, s.bucket, name, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, err
+	}
+	defer obj.Close()
+	data, err := io.ReadAll(obj)
+	var resp minio.ErrorResponse
+	if errors.As(err, &resp) && resp.Code == "NoSuchKey" {
+		return nil, fmt.Errorf("blob %s: %w", key, models.ErrNotFound)
+	}
+	return data, err
+}
+
+// Delete removes blob objects, S3 does not fail on missing ones.
+func (s *S3) Delete(ctx context.Context, keys ...string) error {
+	for _, key := range keys {
+		name, err := s.object(key)
+		if err != nil {
+			return err
+		}
+		err = s.client.RemoveObject(ctx, s.bucket, name, minio.RemoveObjectOptions{})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,20 @@
+package blobstore
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestS3 needs S3 compatible service, e.g.
+// GOPHKEEPER_TEST_S3=s3://minioadmin:minioadmin@localhost:9000/gophkeeper?insecure=true
+func TestS3(t *testing.T) {
+	dsn := os.Getenv("GOPHKEEPER_TEST_S3")
+	if dsn == "" {
+		t.Skip("GOPHKEEPER_TEST_S3 is not set")
+	}
+	store, err := NewS3(dsn)
+	require.NoError(t, err)
+	testBlobStore(t, store)
+}
@@ -36,7 +36,8 @@ func Test_citest(t *testing.T) {
 	pb.RegisterAuthGophkeeperServer(s, Auth)
 	go s.Serve(listen)
 	t.Log("-----Gophkeeper Server Started-----")
-	client := client.NewClient("Test", "Test")
+	client, err := client.NewClient("Test", "Test")
+	require.NoError(t, err, "error starting client")
 	t.Log("-----Gophkeeper Client Started-----")
 	testCI(t, client)
 }
@@ -2,10 +2,16 @@
 package client
 
 import (
+	"bytes"
 	"context"
+	"encoding/binary"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"log"
+	"os"
+	"runtime"
+	"sync"
 	"time"
 
 	"github.com/MaximkaSha/gophkeeper/internal/config"
@@ -13,17 +19,48 @@ import (
 	"github.com/MaximkaSha/gophkeeper/internal/models"
 	pb "github.com/MaximkaSha/gophkeeper/internal/proto"
 
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
 )
 
+// ErrMFARequired returned by UserLogin if account needs authenticator code, see UserLoginMFA.
+var ErrMFARequired = errors.New("authenticator code required")
+
+// RetryAfter returns delay from RetryInfo detail of error.
+// Server sets it when login is locked after too many failed attempts.
+func RetryAfter(err error) (time.Duration, bool) {
+	for _, detail := range status.Convert(err).Details() {
+		if info, ok := detail.(*errdetails.RetryInfo); ok {
+			return info.RetryDelay.AsDuration(), true
+		}
+	}
+	return 0, false
+}
+
+// pendingLogin keeps state between password and code steps of login.
+type pendingLogin struct {
+	mfaToken string
+	kek      []byte
+	salt     []byte
+	user     models.User
+}
+
 // UnaryAuthClientInterceptor - auth middleware. Adds authorization header to each client request.
 func (a *Auth) UnaryAuthClientInterceptor(ctx context.Context, method string, req interface{}, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
-	ctx = metadata.AppendToOutgoingContext(ctx, "authorization", "bearer "+a.Token)
+	ctx = metadata.AppendToOutgoingContext(ctx, "authorization", "bearer "+a.AccessToken())
 	return invoker(ctx, method, req, reply, cc, opts...)
 }
 
+// StreamAuthClientInterceptor - auth middleware. Adds authorization header to each client stream.
+func (a *Auth) StreamAuthClientInterceptor(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	ctx = metadata.AppendToOutgoingContext(ctx, "authorization", "bearer "+a.AccessToken())
+	return streamer(ctx, desc, cc, method, opts...)
+}
+
 // Auth structure used to keep users auth info.
 type Auth struct {
 	// JWT Token.
@@ -32,8 +69,40 @@ type Auth struct {
 	Email string
 	// bcrypt hash of user's password.
 	Hash string
-	// Personal user key to crypt data.
+	// Personal user key to crypt data, unwrapped.
 	Secret []byte
+	// Refresh token of current session.
+	RefreshToken string
+	// ID of current session.
+	SessionID string
+	// Expiration time of JWT token (unix).
+	Expires int64
+	// Account is deleted by server after this time, zero if deletion is not scheduled.
+	DeleteAfter time.Time
+	// mu guards tokens, refresh goroutine changes them.
+	mu sync.RWMutex
+	// refreshMu serializes refreshes, so used refresh token is never sent twice.
+	refreshMu sync.Mutex
+	// stopRefresh stops refresh goroutine.
+	stopRefresh context.CancelFunc
+}
+
+// AccessToken returns current JWT token.
+func (a *Auth) AccessToken() string {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.Token
+}
+
+// setToken saves tokens returned by server.
+func (a *Auth) setToken(token *pb.Token) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.Token = token.Token
+	a.Email = token.Email
+	a.RefreshToken = token.RefreshToken
+	a.SessionID = token.SessionId
+	a.Expires = token.Expires
 }
 
 // LocalStorage struct used to keep models data for UI.
@@ -51,6 +120,8 @@ type AllData struct {
 	ID    string
 	JData []byte
 	Type  string
+	// Revision of record, change of record is based on it.
+	Revision int64
 }
 
 // AppendOrUpdate func append data to localstorage for UI.
@@ -61,6 +132,7 @@ func (l *LocalStorage) AppendOrUpdate(v any) {
 			if l.DataStorage[i].ID == v.ID {
 				l.DataStorage[i].Data = v.Data
 				l.DataStorage[i].Tag = v.Tag
+				l.DataStorage[i].ContentID = v.ContentID
 				return
 			}
 		}
@@ -160,6 +232,7 @@ type Client struct {
 	crypto       crypto.Crypto
 	currentUser  models.User
 	auth         *Auth
+	pending      *pendingLogin
 	// LocalStorage for UI.
 	LocalStorage *LocalStorage
 	// Configuration data.
@@ -170,23 +243,35 @@ type Client struct {
 	BuildTime string
 	// All data slice.
 	AllData []AllData
+	// Vault revision applied to AllData, SyncChanges continues after it.
+	revision int64
+	// Changes not sent to server yet.
+	outbox []outboxEntry
+	// Unlocks cached vault offline, nil if vault is not cached.
+	lock *cacheLock
+	// Client is logged in offline, changes are queued.
+	offline bool
+	// Account is registered before zero-knowledge keys, its records may be ciphered in legacy mode.
+	legacy bool
 }
 
 // NewClient Client constructor.
 // Build version and time must be passed as string.
-func NewClient(bv string, bt string) *Client {
+// Server is not required to be reachable, client connects on first request.
+func NewClient(bv string, bt string) (*Client, error) {
 	auth := &Auth{
 		Token: "",
 	}
 	config := config.NewClientConfig()
 	credsTmp, err := credentials.NewClientTLSFromFile(config.CertFile, "")
 	if err != nil {
-		log.Fatalf("loading GRPC key error: %s", err.Error())
+		return nil, fmt.Errorf("loading GRPC key error: %w", err)
 	}
 	conn, err := grpc.Dial(config.Addr, grpc.WithTransportCredentials(credsTmp),
-		grpc.WithUnaryInterceptor(auth.UnaryAuthClientInterceptor))
+		grpc.WithUnaryInterceptor(auth.UnaryAuthClientInterceptor),
+		grpc.WithStreamInterceptor(auth.StreamAuthClientInterceptor))
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
 	u := pb.NewAuthGophkeeperClient(conn)
 	c := pb.NewGophkeeperClient(conn)
@@ -195,10 +280,11 @@ func NewClient(bv string, bt string) *Client {
 		serverClient: c,
 		auth:         auth,
 		LocalStorage: NewLocalStorage(),
+		Config:       config,
 		BuildVersion: bv,
 		BuildTime:    bt,
 		AllData:      []AllData{},
-	}
+	}, nil
 }
 
 // PrinStorage print to log current state of local storage.
@@ -211,56 +297,93 @@ func (c *Client) PrinStorage() {
 }
 
 // UnmarshalProtoData function decrypt and unmarshal data from protobuf to models.
+// Uuid, type and owner of record are checked as additional data,
+// so records swapped or relabeled by server are rejected.
+// Records of legacy account may be ciphered in legacy mode, its server knows vault key anyway.
 func (c *Client) UnmarshalProtoData(val *pb.CipheredData) (interface{}, error) {
-	switch val.Type.String() {
+	plain, err := c.crypto.DecryptWithAD(val.Data, recordAD(val.Uuid, val.Type.String(), c.currentUser.Email))
+	if err != nil && c.legacy {
+		plain, err = c.crypto.DecryptLegacy(val.Data)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return unmarshalData(val.Type.String(), plain, val.Uuid)
+}
+
+// unmarshalData returns model of given type unmarshaled from plain json, uuid of record is set to it.
+func unmarshalData(dataType string, plain []byte, id string) (models.Dater, error) {
+	switch dataType {
 	case "PASSWORD":
 		data := models.Password{}
-		val.Data = c.crypto.Decrypt(val.Data)
-		err := json.Unmarshal(val.Data, &data)
+		err := json.Unmarshal(plain, &data)
 		if err != nil {
 			return nil, err
 		}
-		data.ID = val.Uuid
+		data.ID = id
 		return data, nil
 	case "TEXT":
 		data := models.Text{}
-		val.Data = c.crypto.Decrypt(val.Data)
-		err := json.Unmarshal(val.Data, &data)
+		err := json.Unmarshal(plain, &data)
 		if err != nil {
 			return nil, err
 		}
-		data.ID = val.Uuid
+		data.ID = id
 		return data, nil
 	case "CC":
 		data := models.CreditCard{}
-		val.Data = c.crypto.Decrypt(val.Data)
-		err := json.Unmarshal(val.Data, &data)
+		err := json.Unmarshal(plain, &data)
 		if err != nil {
 			return nil, err
 		}
-		data.ID = val.Uuid
+		data.ID = id
 		return data, nil
 	case "DATA":
 		data := models.Data{}
-		val.Data = c.crypto.Decrypt(val.Data)
-		err := json.Unmarshal(val.Data, &data)
+		err := json.Unmarshal(plain, &data)
 		if err != nil {
 			return nil, err
 		}
-		data.ID = val.Uuid
+		data.ID = id
 		return data, nil
 	}
 	return nil, errors.New("type unknown")
 }
 
+// recordAD returns additional data which binds ciphertext to record identity.
+// Each field is prefixed by its length, so fields can't be shifted.
+func recordAD(id string, dataType string, email string) []byte {
+	ad := []byte{}
+	for _, field := range []string{id, dataType, email} {
+		size := make([]byte, 4)
+		binary.BigEndian.PutUint32(size, uint32(len(field)))
+		ad = append(ad, size...)
+		ad = append(ad, field...)
+	}
+	return ad
+}
+
 // UserRegister - registration function.
 // models.User must be passed.
 // Return error if error occures when writing to DB (eg. User already exist).
+// Vault key is generated and wrapped locally, server gets only wrapped key,
+// salt and auth key derived from master password, which is hashed by server.
 func (c *Client) UserRegister(ctx context.Context, user models.User) error {
-	err := user.HashPassword()
+	salt, err := crypto.GenSalt()
 	if err != nil {
 		return err
 	}
+	kek, authKey := crypto.DeriveKeys(user.Password, salt)
+	key, err := crypto.GenKey()
+	if err != nil {
+		return err
+	}
+	user.Secret, err = crypto.WrapKey(kek, key)
+	if err != nil {
+		return err
+	}
+	user.Salt = salt
+	user.Password = authKey
 	userProto := user.ToProto()
 	_, err = c.authClient.UserRegister(ctx, &pb.UserRegisterRequest{User: userProto})
 	if err != nil {
@@ -273,57 +396,419 @@ func (c *Client) UserRegister(ctx context.Context, user models.User) error {
 // models.User must be passed.
 // Return error if error occures when writing to DB (eg. Bad pwd).
 // If all ok  jwt token and privite key will placed to Client object.
+// Vault key is unwrapped locally with key derived from master password.
+// ErrMFARequired is returned if account has authenticator, login is finished by UserLoginMFA.
+// ErrLegacyLogin is returned if server asks for password of account which had salt on this device.
 func (c *Client) UserLogin(ctx context.Context, user models.User) error {
+	c.pending = nil
+	saltResp, err := c.authClient.UserSalt(ctx, &pb.UserSaltRequest{Email: user.Email})
+	if err != nil {
+		return err
+	}
+	var kek []byte
+	if len(saltResp.Salt) > 0 {
+		kek, user.Password = crypto.DeriveKeys(user.Password, saltResp.Salt)
+	} else {
+		seen, err := c.saltSeen(user.Email)
+		if err != nil {
+			return err
+		}
+		if seen {
+			return ErrLegacyLogin
+		}
+	}
 	userProto := user.ToProto()
-	response, err := c.authClient.UserLogin(ctx, &pb.UserLoginRequest{User: userProto})
+	response, err := c.authClient.UserLogin(ctx, &pb.UserLoginRequest{
+		User:         userProto,
+		Device:       deviceName(),
+		BuildVersion: c.BuildVersion,
+	})
 	if err != nil {
 		return err
 	}
-	c.auth.Token = response.Token.Token
-	c.auth.Email = response.Token.Email
-	c.auth.Secret = response.User.Secret
-	go c.RefreshToken(ctx)
+	if response.MfaRequired {
+		c.pending = &pendingLogin{
+			mfaToken: response.MfaToken,
+			kek:      kek,
+			salt:     saltResp.Salt,
+			user:     user,
+		}
+		return ErrMFARequired
+	}
+	return c.finishLogin(ctx, response, kek, saltResp.Salt, user)
+}
+
+// UserLoginMFA - second login step for user with enrolled authenticator.
+// Code from authenticator app or recovery code must be passed.
+// Wrong code can be retried, server allows few attempts per login.
+func (c *Client) UserLoginMFA(ctx context.Context, code string) error {
+	if c.pending == nil {
+		return errors.New("no login in progress")
+	}
+	response, err := c.authClient.UserLoginMFA(ctx, &pb.UserLoginMFARequest{
+		MfaToken: c.pending.mfaToken,
+		Code:     code,
+	})
+	if err != nil {
+		return err
+	}
+	pending := c.pending
+	c.pending = nil
+	return c.finishLogin(ctx, response, pending.kek, pending.salt, pending.user)
+}
+
+// MFAPending reports whether login waits for authenticator code.
+func (c *Client) MFAPending() bool {
+	return c.pending != nil
+}
+
+// finishLogin unwraps vault key, starts refreshing of tokens and restores cached vault.
+// Account registered before zero-knowledge keys is upgraded to them, failed upgrade is retried on next login.
+func (c *Client) finishLogin(ctx context.Context, response *pb.UserLoginResponse, kek []byte, salt []byte, user models.User) error {
+	var err error
+	password := user.Password
+	secret := response.User.Secret
+	if kek != nil {
+		secret, err = crypto.UnwrapKey(kek, response.User.Secret)
+		if err != nil {
+			return err
+		}
+	}
+	c.auth.setToken(response.Token)
+	c.auth.Secret = secret
+	c.auth.DeleteAfter = unixTime(response.DeleteAfter)
+	if c.auth.stopRefresh != nil {
+		c.auth.stopRefresh()
+	}
+	var refreshCtx context.Context
+	refreshCtx, c.auth.stopRefresh = context.WithCancel(ctx)
+	go c.RefreshToken(refreshCtx)
 	c.crypto = *crypto.NewCrypto(c.auth.Secret)
 	user.FromProto(response.User)
 	c.currentUser = user
+	c.offline = false
+	c.legacy = kek == nil
+	c.lock = nil
+	if kek != nil {
+		c.lock = &cacheLock{Salt: salt, WrappedKey: response.User.Secret}
+		if err := c.rememberSalt(user.Email); err != nil {
+			log.Println("accounts file error: ", err)
+		}
+	}
+	c.loadCache()
+	if c.legacy {
+		if err := c.upgradeLegacy(ctx, password); err != nil {
+			log.Println("zero-knowledge keys upgrade error: ", err)
+		}
+	}
 	return nil
 }
 
-// RefreshToken - refresh token every 45 seconds.
+// Refresh timings.
+const (
+	// refreshBefore - token refreshed this time before expiration.
+	refreshBefore = 15 * time.Second
+	// minRefreshDelay - minimal delay between refreshes and retries.
+	minRefreshDelay = time.Second
+	// maxRefreshDelay - maximal delay between retries.
+	maxRefreshDelay = 30 * time.Second
+)
+
+// RefreshToken - refresh token before it expires until ctx is done.
+// Network errors are retried with exponential backoff,
+// loop stops only if server rejects session.
 func (c *Client) RefreshToken(ctx context.Context) {
-	tickerRefresh := time.NewTicker(time.Second * 45)
-	defer tickerRefresh.Stop()
-	for range tickerRefresh.C {
-		tokenOld := &pb.Token{
-			Email: c.auth.Email,
-			Token: c.auth.Token,
-		}
-		newToken, err := c.authClient.Refresh(ctx, &pb.RefreshRequest{Token: tokenOld})
-		if err != nil {
-			log.Println("Token not refreshed: ", err)
+	retryDelay := minRefreshDelay
+	for {
+		c.auth.mu.RLock()
+		delay := time.Until(time.Unix(c.auth.Expires, 0)) - refreshBefore
+		c.auth.mu.RUnlock()
+		if delay < minRefreshDelay {
+			delay = minRefreshDelay
+		}
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
 			return
+		case <-timer.C:
+		}
+		for {
+			err := c.refresh(ctx)
+			if err == nil {
+				retryDelay = minRefreshDelay
+				break
+			}
+			if status.Code(err) == codes.Unauthenticated {
+				log.Println("Session closed by server: ", err)
+				return
+			}
+			log.Println("Token not refreshed: ", err)
+			wait := retryDelay
+			if after, ok := RetryAfter(err); ok && after > wait {
+				wait = after
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(wait):
+			}
+			retryDelay *= 2
+			if retryDelay > maxRefreshDelay {
+				retryDelay = maxRefreshDelay
+			}
 		}
-		c.auth.Token = newToken.Token.Token
 	}
 }
 
-// GetAllDataFromDB - ask server for all users data in DB.
-// Data will be writen to AllData slice.
-func (c *Client) GetAllDataFromDB(ctx context.Context) error {
-	jData, err := c.serverClient.GetCipheredDataForUserRequest(ctx, &pb.GetCipheredDataRequest{Email: c.currentUser.Email})
+// refresh exchanges refresh token to new tokens.
+func (c *Client) refresh(ctx context.Context) error {
+	c.auth.refreshMu.Lock()
+	defer c.auth.refreshMu.Unlock()
+	c.auth.mu.RLock()
+	tokenOld := &pb.Token{
+		Email:        c.auth.Email,
+		RefreshToken: c.auth.RefreshToken,
+	}
+	c.auth.mu.RUnlock()
+	if tokenOld.RefreshToken == "" {
+		return status.Error(codes.Unauthenticated, "not logged in")
+	}
+	newToken, err := c.authClient.Refresh(ctx, &pb.RefreshRequest{Token: tokenOld})
+	if err != nil {
+		return err
+	}
+	c.auth.setToken(newToken.Token)
+	return nil
+}
+
+// Logout closes session on server and stops token refreshing.
+func (c *Client) Logout(ctx context.Context) error {
+	if c.auth.stopRefresh != nil {
+		c.auth.stopRefresh()
+	}
+	_, err := c.authClient.Logout(ctx, &pb.LogoutRequest{})
+	c.auth.setToken(&pb.Token{})
+	c.auth.Secret = nil
+	return err
+}
+
+// EnrollTOTP - start authenticator enrollment.
+// Returns otpauth URI for authenticator app and one-time recovery codes.
+func (c *Client) EnrollTOTP(ctx context.Context) (string, []string, error) {
+	response, err := c.authClient.EnrollTOTP(ctx, &pb.EnrollTOTPRequest{})
+	if err != nil {
+		return "", nil, err
+	}
+	return response.Uri, response.RecoveryCodes, nil
+}
+
+// ConfirmTOTP - activate enrollment with code from authenticator app.
+func (c *Client) ConfirmTOTP(ctx context.Context, code string) error {
+	_, err := c.authClient.ConfirmTOTP(ctx, &pb.ConfirmTOTPRequest{Code: code})
+	return err
+}
+
+// DisableTOTP - remove authenticator, authenticator or recovery code must be passed.
+func (c *Client) DisableTOTP(ctx context.Context, code string) error {
+	_, err := c.authClient.DisableTOTP(ctx, &pb.DisableTOTPRequest{Code: code})
+	return err
+}
+
+// ChangePassword - replace master password of logged in user.
+// Vault key is wrapped by key derived from new password with new salt,
+// so data encrypted before stays readable. User registered before
+// zero-knowledge keys gets wrapped key on the way.
+// Server ends all other sessions of user.
+func (c *Client) ChangePassword(ctx context.Context, oldPassword string, newPassword string) error {
+	if len(c.auth.Secret) == 0 {
+		return errors.New("not logged in")
+	}
+	oldPassword, err := c.authKey(ctx, oldPassword)
+	if err != nil {
+		return err
+	}
+	salt, err := crypto.GenSalt()
+	if err != nil {
+		return err
+	}
+	kek, authKey := crypto.DeriveKeys(newPassword, salt)
+	user := models.User{
+		Email:    c.currentUser.Email,
+		Password: authKey,
+		Salt:     salt,
+	}
+	user.Secret, err = crypto.WrapKey(kek, c.auth.Secret)
 	if err != nil {
 		return err
 	}
-	for _, val := range jData.Data {
+	_, err = c.authClient.ChangePassword(ctx, &pb.ChangePasswordRequest{
+		OldPassword: oldPassword,
+		User:        user.ToProto(),
+	})
+	if err != nil {
+		return err
+	}
+	// cache is unlocked by new password
+	c.lock = &cacheLock{Salt: salt, WrappedKey: user.Secret}
+	c.persistCache()
+	return nil
+}
+
+// authKey returns key which is sent to server instead of master password of current user.
+// User registered before zero-knowledge keys sends password itself.
+func (c *Client) authKey(ctx context.Context, password string) (string, error) {
+	saltResp, err := c.authClient.UserSalt(ctx, &pb.UserSaltRequest{Email: c.currentUser.Email})
+	if err != nil {
+		return "", err
+	}
+	if len(saltResp.Salt) == 0 {
+		if !c.legacy {
+			return "", ErrLegacyLogin
+		}
+		return password, nil
+	}
+	_, authKey := crypto.DeriveKeys(password, saltResp.Salt)
+	return authKey, nil
+}
+
+// DeleteAccount - schedule deletion of current account with all its data.
+// Master password and, if authenticator is enrolled, its code or recovery code must be passed.
+// Returns time when server purges account, till then CancelAccountDeletion undoes deletion.
+func (c *Client) DeleteAccount(ctx context.Context, password string, code string) (time.Time, error) {
+	authKey, err := c.authKey(ctx, password)
+	if err != nil {
+		return time.Time{}, err
+	}
+	response, err := c.authClient.DeleteAccount(ctx, &pb.DeleteAccountRequest{
+		Password: authKey,
+		Code:     code,
+	})
+	if err != nil {
+		return time.Time{}, err
+	}
+	c.auth.DeleteAfter = unixTime(response.DeleteAfter)
+	return c.auth.DeleteAfter, nil
+}
+
+// CancelAccountDeletion - undo scheduled deletion of current account.
+func (c *Client) CancelAccountDeletion(ctx context.Context) error {
+	_, err := c.authClient.CancelAccountDeletion(ctx, &pb.CancelAccountDeletionRequest{})
+	if err != nil {
+		return err
+	}
+	c.auth.DeleteAfter = time.Time{}
+	return nil
+}
+
+// DeletionScheduled returns time of scheduled deletion of current account.
+func (c *Client) DeletionScheduled() (time.Time, bool) {
+	return c.auth.DeleteAfter, !c.auth.DeleteAfter.IsZero()
+}
+
+// unixTime converts unix time from server, zero is zero time.
+func unixTime(sec int64) time.Time {
+	if sec == 0 {
+		return time.Time{}
+	}
+	return time.Unix(sec, 0)
+}
+
+// deviceName returns name of this device, which is shown in sessions list.
+func deviceName() string {
+	name, err := os.Hostname()
+	if err != nil {
+		return runtime.GOOS
+	}
+	return name + " (" + runtime.GOOS + ")"
+}
+
+// ListSessions - ask server for active sessions of current user.
+func (c *Client) ListSessions(ctx context.Context) ([]*pb.Session, error) {
+	response, err := c.authClient.ListSessions(ctx, &pb.ListSessionsRequest{})
+	if err != nil {
+		return nil, err
+	}
+	return response.Sessions, nil
+}
+
+// TerminateSession - sign out device of given session.
+// If current session is terminated, refreshing stops and client must login again.
+func (c *Client) TerminateSession(ctx context.Context, sessionID string) error {
+	_, err := c.authClient.TerminateSession(ctx, &pb.TerminateSessionRequest{SessionId: sessionID})
+	if err != nil {
+		return err
+	}
+	c.auth.mu.RLock()
+	current := c.auth.SessionID == sessionID
+	c.auth.mu.RUnlock()
+	if current {
+		if c.auth.stopRefresh != nil {
+			c.auth.stopRefresh()
+		}
+		c.auth.setToken(&pb.Token{})
+		c.auth.Secret = nil
+	}
+	return nil
+}
+
+// GetAllDataFromDB - ask server for all users data in DB page by page.
+// Data will be writen to AllData slice, SyncChanges then applies only later changes.
+// Changes not sent to server yet are kept.
+func (c *Client) GetAllDataFromDB(ctx context.Context) error {
+	c.AllData = make([]AllData, 0)
+	c.revision = 0
+	return c.SyncChanges(ctx)
+}
+
+// SyncChanges - ask server for changes of vault made after last synced revision, e.g. by other devices,
+// and apply them to AllData. Whole vault is read on first sync.
+func (c *Client) SyncChanges(ctx context.Context) error {
+	request := &pb.ListChangesRequest{SinceRevision: c.revision}
+	for {
+		resp, err := c.serverClient.ListChanges(ctx, request)
+		if err != nil {
+			return err
+		}
+		// applied changes are not requested again if next page fails
+		if err = c.ApplyChanges(ctx, resp.Changes, resp.Revision); err != nil {
+			return err
+		}
+		if !resp.More {
+			return nil
+		}
+		request.SinceRevision = resp.Revision
+	}
+}
+
+// ApplyChanges - apply changes of vault to AllData and remember given revision as synced.
+// Changes not sent to server yet stay applied over synced records.
+func (c *Client) ApplyChanges(ctx context.Context, changes []*pb.CipheredData, revision int64) error {
+	for _, val := range changes {
+		if val.Deleted {
+			c.DelFromLocalStorage(val.Uuid)
+			continue
+		}
 		data, err := c.UnmarshalProtoData(val)
 		if err != nil {
 			return err
 		}
 		c.AddDataToLocalStorage(ctx, data.(models.Dater))
+		c.setRevision(val.Uuid, val.Revision)
 	}
+	c.revision = revision
+	if err := c.applyOutbox(ctx); err != nil {
+		return err
+	}
+	c.persistCache()
 	return nil
 }
 
+// Revision - last synced revision of vault.
+func (c *Client) Revision() int64 {
+	return c.revision
+}
+
 // AddDataToLocalStorageUI - Adds data to local storage for UI
 func (c *Client) AddDataToLocalStorageUI(ctx context.Context, v any) {
 	c.LocalStorage.AppendOrUpdate(v)
@@ -346,11 +831,28 @@ func (c *Client) AddDataToLocalStorage(ctx context.Context, data models.Dater) {
 	})
 }
 
+// setRevision sets revision of record in AllData storage.
+func (c *Client) setRevision(uuid string, revision int64) {
+	for i := range c.AllData {
+		if c.AllData[i].ID == uuid {
+			c.AllData[i].Revision = revision
+			return
+		}
+	}
+}
+
+// revisionOf returns revision of record in AllData storage, 0 for new record.
+func (c *Client) revisionOf(uuid string) int64 {
+	for i := range c.AllData {
+		if c.AllData[i].ID == uuid {
+			return c.AllData[i].Revision
+		}
+	}
+	return 0
+}
+
 // DelFromLocalStorage -Del data from AllData storage by given uuid.
 func (c *Client) DelFromLocalStorage(uuid string) {
-	if len(c.AllData) == 1 {
-		c.AllData = make([]AllData, 0)
-	}
 	for i := range c.AllData {
 		if c.AllData[i].ID == uuid {
 			c.AllData = append(c.AllData[:i], c.AllData[i+1:]...)
@@ -360,14 +862,34 @@ func (c *Client) DelFromLocalStorage(uuid string) {
 }
 
 // AddData - encrypt  and push data to server.
+// File content is uploaded by stream, record of already uploaded file is updated without content.
+// Record changed by other device since last sync is not saved, *ConflictError returned.
+// Change is queued if server is not reachable, see ReplayOutbox, file content is never queued.
 func (c *Client) AddData(ctx context.Context, data models.Dater) error {
-	cData := c.crypto.Encrypt(data.GetData())
-	protoData := models.NewCipheredData(cData, c.currentUser.Email, data.Type(), data.GetID())
-	_, err := c.serverClient.AddCipheredData(ctx, &pb.AddCipheredDataRequest{Data: protoData})
+	if file, ok := data.(models.Data); ok && (!file.Chunked() || file.Data != nil) {
+		if c.offline {
+			return ErrOffline
+		}
+		_, err := c.UploadFile(ctx, file, bytes.NewReader(file.Data))
+		return err
+	}
+	return c.push(ctx, data.GetID(), data, c.baseRevision(data.GetID()))
+}
+
+// saveData - encrypt and push record based on given revision to server.
+func (c *Client) saveData(ctx context.Context, data models.Dater, expected int64) error {
+	id := data.GetID()
+	cData, err := c.crypto.EncryptWithAD(data.GetData(), recordAD(id, data.Type(), c.currentUser.Email))
 	if err != nil {
 		return err
 	}
+	protoData := models.NewCipheredData(cData, c.currentUser.Email, data.Type(), id)
+	resp, err := c.serverClient.AddCipheredData(ctx, &pb.AddCipheredDataRequest{Data: protoData, ExpectedRevision: expected})
+	if err != nil {
+		return c.conflictError(err, id, data)
+	}
 	c.AddDataToLocalStorage(ctx, data)
+	c.setRevision(id, resp.Revision)
 	return nil
 }
 
@@ -445,10 +967,17 @@ func (c *Client) AddData(ctx context.Context, data models.Dater) error {
 } */
 
 // DelData - delete data from server and local storage by given uuid.
+// Record changed by other device since last sync is not deleted, *ConflictError returned.
+// Deletion is queued if server is not reachable, see ReplayOutbox.
 func (c *Client) DelData(ctx context.Context, uuid string) error {
-	_, err := c.serverClient.DelCipheredData(ctx, &pb.DelCipheredDataRequest{Uuid: uuid})
+	return c.push(ctx, uuid, nil, c.baseRevision(uuid))
+}
+
+// deleteData - delete record based on given revision from server.
+func (c *Client) deleteData(ctx context.Context, uuid string, expected int64) error {
+	_, err := c.serverClient.DelCipheredData(ctx, &pb.DelCipheredDataRequest{Uuid: uuid, ExpectedRevision: expected})
 	if err != nil {
-		return err
+		return c.conflictError(err, uuid, nil)
 	}
 	c.DelFromLocalStorage(uuid)
 	return nil
@@ -6,20 +6,66 @@ import (
 	"log"
 	"net"
 	"reflect"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/MaximkaSha/gophkeeper/internal/authserver"
 	"github.com/MaximkaSha/gophkeeper/internal/crypto"
+	"github.com/MaximkaSha/gophkeeper/internal/jwtkeys"
 	"github.com/MaximkaSha/gophkeeper/internal/mockdb"
 	"github.com/MaximkaSha/gophkeeper/internal/models"
 	pb "github.com/MaximkaSha/gophkeeper/internal/proto"
 	"github.com/MaximkaSha/gophkeeper/internal/server"
+	"github.com/MaximkaSha/gophkeeper/internal/storage"
 	"github.com/golang/mock/gomock"
+	grpc_auth "github.com/grpc-ecosystem/go-grpc-middleware/auth"
+	"github.com/pquerna/otp/totp"
 	"github.com/stretchr/testify/require"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
 )
 
+// authInterceptor authenticates every request as given user.
+func authInterceptor(email string) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		return handler(authserver.NewContextWithEmail(ctx, email), req)
+	}
+}
+
+// testKeySet returns JWT key set with one new signing key.
+func testKeySet(t *testing.T) *jwtkeys.KeySet {
+	keys := jwtkeys.NewKeySet()
+	key, err := jwtkeys.GenerateKey()
+	require.NoError(t, err)
+	keys.Add(key)
+	return keys
+}
+
+// allowLogins expects lockout checks of auth server, failed logins are never locked.
+func allowLogins(store *mockdb.MockStorager) {
+	store.EXPECT().GetLoginAttempt(gomock.Any(), gomock.Any()).Return(models.LoginAttempt{}, nil).AnyTimes()
+	store.EXPECT().AddLoginFailure(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(1, nil).AnyTimes()
+	store.EXPECT().ClearLoginAttempts(gomock.Any(), gomock.Any()).Return(nil).AnyTimes()
+}
+
+func TestRetryAfter(t *testing.T) {
+	_, ok := RetryAfter(errors.New("no status"))
+	require.False(t, ok)
+	_, ok = RetryAfter(status.Error(codes.ResourceExhausted, "locked"))
+	require.False(t, ok)
+	st, err := status.New(codes.ResourceExhausted, "locked").
+		WithDetails(&errdetails.RetryInfo{RetryDelay: durationpb.New(30 * time.Second)})
+	require.NoError(t, err)
+	after, ok := RetryAfter(st.Err())
+	require.True(t, ok)
+	require.Equal(t, 30*time.Second, after)
+}
+
 func TestClient_AddData(t *testing.T) {
 	tests := []struct {
 		name string
@@ -41,11 +87,11 @@ func TestClient_AddData(t *testing.T) {
 				ID:       "test",
 			}
 			store := mockdb.NewMockStorager(ctrl)
-			store.EXPECT().AddCipheredData(gomock.Any()).Times(2)
+			store.EXPECT().AddCipheredData(gomock.Any(), gomock.Any()).Times(2)
 			Server := server.GophkeeperServer{
 				DB: store,
 			}
-			s := grpc.NewServer()
+			s := grpc.NewServer(grpc.UnaryInterceptor(authInterceptor("test@test.com")))
 			pb.RegisterGophkeeperServer(s, Server)
 			listen, err := net.Listen("tcp", "localhost:9994")
 			if err != nil {
@@ -62,7 +108,7 @@ func TestClient_AddData(t *testing.T) {
 			require.NoError(t, err)
 			err = tt.c.AddData(context.Background(), data)
 			require.NoError(t, err)
-			store.EXPECT().AddCipheredData(gomock.Any()).Return(errors.New("no data"))
+			store.EXPECT().AddCipheredData(gomock.Any(), gomock.Any()).Return(int64(0), errors.New("no data"))
 			err = tt.c.AddData(context.Background(), data)
 			require.Error(t, err)
 
@@ -91,11 +137,11 @@ func TestClient_DelData(t *testing.T) {
 				ID:       "test",
 			}
 			store := mockdb.NewMockStorager(ctrl)
-			store.EXPECT().DelCiphereData(gomock.Any()).Times(2)
+			store.EXPECT().DelCiphereData(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Times(2)
 			Server := server.GophkeeperServer{
 				DB: store,
 			}
-			s := grpc.NewServer()
+			s := grpc.NewServer(grpc.UnaryInterceptor(authInterceptor("test@test.com")))
 			pb.RegisterGophkeeperServer(s, Server)
 			listen, err := net.Listen("tcp", "localhost:9993")
 			if err != nil {
@@ -130,7 +176,7 @@ func TestClient_DelData(t *testing.T) {
 			tt.c.AllData = allData
 			err = tt.c.DelData(context.Background(), data.ID)
 			require.NoError(t, err)
-			store.EXPECT().DelCiphereData(gomock.Any()).Return(errors.New("no data"))
+			store.EXPECT().DelCiphereData(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(errors.New("no data"))
 			err = tt.c.DelData(context.Background(), data.ID)
 			require.Error(t, err)
 
@@ -153,11 +199,11 @@ func TestClient_GetAllDataFromDB(t *testing.T) {
 			ctrl := gomock.NewController(t)
 			defer ctrl.Finish()
 			store := mockdb.NewMockStorager(ctrl)
-			store.EXPECT().GetCipheredData(gomock.Any())
+			store.EXPECT().ListChanges(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any())
 			Server := server.GophkeeperServer{
 				DB: store,
 			}
-			s := grpc.NewServer()
+			s := grpc.NewServer(grpc.UnaryInterceptor(authInterceptor("test@test.com")))
 			pb.RegisterGophkeeperServer(s, Server)
 			listen, err := net.Listen("tcp", "localhost:9992")
 			if err != nil {
@@ -172,10 +218,10 @@ func TestClient_GetAllDataFromDB(t *testing.T) {
 			tt.c.crypto = *crypto.NewCrypto([]byte("12345678123456781234567812345678"))
 			err = tt.c.GetAllDataFromDB(context.Background())
 			require.NoError(t, err)
-			store.EXPECT().GetCipheredData(gomock.Any()).Return([]models.CipheredData{}, errors.New("no data"))
+			store.EXPECT().ListChanges(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return([]models.CipheredData{}, errors.New("no data"))
 			err = tt.c.GetAllDataFromDB(context.Background())
 			require.Error(t, err)
-			store.EXPECT().GetCipheredData(gomock.Any()).Return([]models.CipheredData{
+			store.EXPECT().ListChanges(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return([]models.CipheredData{
 				{
 					Type: "CC",
 					Data: []byte("testtesttesttest"),
@@ -201,6 +247,53 @@ func TestClient_GetAllDataFromDB(t *testing.T) {
 	}
 }
 
+func TestClient_SyncChanges(t *testing.T) {
+	store := storage.NewMemory()
+	require.NoError(t, store.AddUser(context.Background(), models.User{Email: "test@test.com", Password: "hash"}))
+	s := grpc.NewServer(grpc.UnaryInterceptor(authInterceptor("test@test.com")))
+	pb.RegisterGophkeeperServer(s, server.GophkeeperServer{DB: store})
+	listen, err := net.Listen("tcp", "localhost:9979")
+	if err != nil {
+		log.Fatal(err)
+	}
+	go s.Serve(listen)
+	defer s.Stop()
+	conn, err := grpc.Dial("localhost:9979", grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	defer conn.Close()
+	// two devices of one user
+	newClient := func() *Client {
+		return &Client{
+			serverClient: pb.NewGophkeeperClient(conn),
+			crypto:       *crypto.NewCrypto([]byte("12345678123456781234567812345678")),
+			currentUser:  models.User{Email: "test@test.com"},
+		}
+	}
+	first, second := newClient(), newClient()
+	ctx := context.Background()
+
+	password := models.Password{Login: "login", Password: "secret", ID: "111-111-111"}
+	text := models.Text{Data: "note", ID: "222-222-222"}
+	require.NoError(t, first.AddData(ctx, password))
+	require.NoError(t, first.AddData(ctx, text))
+	require.NoError(t, second.GetAllDataFromDB(ctx))
+	require.Len(t, second.AllData, 2)
+
+	password.Password = "changed"
+	require.NoError(t, first.AddData(ctx, password))
+	require.NoError(t, first.DelData(ctx, text.ID))
+	require.NoError(t, second.SyncChanges(ctx))
+	require.Len(t, second.AllData, 1)
+	require.Equal(t, password.ID, second.AllData[0].ID)
+	require.Equal(t, password.GetData(), second.AllData[0].JData)
+
+	// nothing changed
+	revision := second.revision
+	require.NoError(t, second.SyncChanges(ctx))
+	require.Equal(t, revision, second.revision)
+	require.Len(t, second.AllData, 1)
+}
+
 func TestClient_AddDataToLocalStorageUI(t *testing.T) {
 	type args struct {
 		ctx context.Context
@@ -220,46 +313,97 @@ func TestClient_AddDataToLocalStorageUI(t *testing.T) {
 	}
 }
 
-/*
 func TestClient_RefreshToken(t *testing.T) {
-	tests := []struct {
-		name string
-		c    *Client
-	}{
-		{
-			name: "test",
-			c: &Client{
-				auth: &Auth{
-					Email: "test@test.com",
-					Token: "",
-				},
-			},
-		},
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	data := models.User{
+		Email:    "test@test.com",
+		Password: "11111",
+		Secret:   []byte("12345678123456781234567812345678"),
 	}
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			auth := authserver.AuthGophkeeperServer{}
-			auth.SetJWTKey("123456578")
-			listen, err := net.Listen("tcp", "localhost:9991")
-			if err != nil {
-				log.Fatal(err)
-			}
-			s := grpc.NewServer()
-			pb.RegisterAuthGophkeeperServer(s, auth)
-			go s.Serve(listen)
-			conn, err := grpc.Dial("localhost:9991", grpc.WithTransportCredentials(insecure.NewCredentials()))
-			if err != nil {
-				log.Fatal(err)
-			}
-			tt.c.authClient = pb.NewAuthGophkeeperClient(conn)
-			token := "wrong token"
-			tt.c.auth.Token = token
-			tt.c.RefreshToken(context.Background())
-			require.Equal(t, token, tt.c.auth.Token)
-
-		})
+	dataHash := data
+	dataHash.HashPassword()
+	store := mockdb.NewMockStorager(ctrl)
+	allowLogins(store)
+	Server := authserver.AuthGophkeeperServer{
+		DB: store,
+	}
+	Server.SetKeySet(testKeySet(t))
+	s := grpc.NewServer(grpc.UnaryInterceptor(grpc_auth.UnaryServerInterceptor(Server.AuthFunc)))
+	pb.RegisterAuthGophkeeperServer(s, Server)
+	listen, err := net.Listen("tcp", "localhost:9991")
+	if err != nil {
+		log.Fatal(err)
+	}
+	go s.Serve(listen)
+	defer s.Stop()
+	auth := &Auth{}
+	conn, err := grpc.Dial("localhost:9991", grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithUnaryInterceptor(auth.UnaryAuthClientInterceptor))
+	if err != nil {
+		log.Fatal(err)
 	}
-} */
+	c := &Client{
+		authClient: pb.NewAuthGophkeeperClient(conn),
+		// no data service: upgrade of legacy account fails and is retried on next login
+		serverClient: pb.NewGophkeeperClient(conn),
+		auth:         auth,
+	}
+	var session models.Session
+	var tokens []models.RefreshToken
+	store.EXPECT().GetUser(gomock.Any(), gomock.Any()).Return(dataHash, nil).Times(2)
+	store.EXPECT().GetTOTP(gomock.Any(), gomock.Eq(data.Email)).Return(models.TOTP{}, nil)
+	// bcrypt hash is upgraded by server on login
+	store.EXPECT().UpdateUser(gomock.Any(), gomock.Any()).Return(nil)
+	store.EXPECT().AddSession(gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, s models.Session) error {
+		session = s
+		return nil
+	})
+	store.EXPECT().AddRefreshToken(gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, t models.RefreshToken) error {
+		tokens = append(tokens, t)
+		return nil
+	}).Times(2)
+	err = c.UserLogin(context.Background(), data)
+	require.NoError(t, err)
+	oldAccess, oldRefresh := c.auth.AccessToken(), c.auth.RefreshToken
+	require.NotEmpty(t, oldRefresh)
+
+	store.EXPECT().GetRefreshToken(gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, hash []byte) (models.RefreshToken, error) {
+		return tokens[0], nil
+	})
+	store.EXPECT().GetSession(gomock.Any(), gomock.Eq(session.ID)).Return(session, nil)
+	store.EXPECT().UseRefreshToken(gomock.Any(), gomock.Any()).Return(true, nil)
+	store.EXPECT().TouchSession(gomock.Any(), gomock.Eq(session.ID), gomock.Any(), gomock.Any()).Return(nil)
+	// new token must be signed later than old one to differ.
+	time.Sleep(time.Second)
+	err = c.refresh(context.Background())
+	require.NoError(t, err)
+	require.NotEqual(t, oldAccess, c.auth.AccessToken())
+	require.NotEqual(t, oldRefresh, c.auth.RefreshToken)
+
+	// sessions list
+	other := models.Session{ID: "other", User: data.Email, Device: "phone"}
+	store.EXPECT().ListSessions(gomock.Any(), gomock.Eq(data.Email)).Return([]models.Session{session, other}, nil)
+	sessions, err := c.ListSessions(context.Background())
+	require.NoError(t, err)
+	require.Len(t, sessions, 2)
+	require.True(t, sessions[0].Current)
+	require.Equal(t, deviceName(), sessions[0].Device)
+	require.False(t, sessions[1].Current)
+	store.EXPECT().GetSession(gomock.Any(), gomock.Eq(other.ID)).Return(other, nil)
+	store.EXPECT().RevokeSession(gomock.Any(), gomock.Eq(other.ID)).Return(nil)
+	err = c.TerminateSession(context.Background(), other.ID)
+	require.NoError(t, err)
+	require.NotEmpty(t, c.auth.AccessToken())
+
+	store.EXPECT().RevokeSession(gomock.Any(), gomock.Eq(session.ID)).Return(nil)
+	err = c.Logout(context.Background())
+	require.NoError(t, err)
+	require.Empty(t, c.auth.AccessToken())
+	require.Empty(t, c.auth.Secret)
+	err = c.refresh(context.Background())
+	require.Error(t, err)
+}
 
 func TestClient_UserRegister(t *testing.T) {
 	tests := []struct {
@@ -311,10 +455,12 @@ func TestClient_UserRegister(t *testing.T) {
 				log.Fatal(err)
 			}
 			tt.c.authClient = pb.NewAuthGophkeeperClient(conn)
-			store.EXPECT().AddUser(gomock.Any()).Return(nil)
+			// no data service: upgrade of legacy account fails and is retried on next login
+			tt.c.serverClient = pb.NewGophkeeperClient(conn)
+			store.EXPECT().AddUser(gomock.Any(), gomock.Any()).Return(nil)
 			err = tt.c.UserRegister(context.Background(), data)
 			require.NoError(t, err)
-			store.EXPECT().AddUser(gomock.Any()).Return(errors.New("no data"))
+			store.EXPECT().AddUser(gomock.Any(), gomock.Any()).Return(errors.New("no data"))
 			err = tt.c.UserRegister(context.Background(), data)
 			require.Error(t, err)
 		})
@@ -345,8 +491,8 @@ func TestClient_UserLogin(t *testing.T) {
 			data := models.User{
 				Email:    "test@test.com",
 				Password: "11111",
-				Secret:   []byte("secret"),
 			}
+			// user registred before zero-knowledge keys: no salt, plain secret.
 			dataHash := models.User{
 				Email:    "test@test.com",
 				Password: "11111",
@@ -354,10 +500,17 @@ func TestClient_UserLogin(t *testing.T) {
 			}
 			dataHash.HashPassword()
 			store := mockdb.NewMockStorager(ctrl)
-			store.EXPECT().GetUser(gomock.Eq(data)).Return(dataHash, nil)
+			allowLogins(store)
+			store.EXPECT().GetUser(gomock.Any(), gomock.Eq(models.User{Email: data.Email})).Return(dataHash, nil)
+			store.EXPECT().GetUser(gomock.Any(), gomock.Eq(data)).Return(dataHash, nil)
+			store.EXPECT().UpdateUser(gomock.Any(), gomock.Any()).Return(nil)
+			store.EXPECT().AddSession(gomock.Any(), gomock.Any()).Return(nil).AnyTimes()
+			store.EXPECT().AddRefreshToken(gomock.Any(), gomock.Any()).Return(nil).AnyTimes()
+			store.EXPECT().GetTOTP(gomock.Any(), gomock.Any()).Return(models.TOTP{}, nil).AnyTimes()
 			Server := authserver.AuthGophkeeperServer{
 				DB: store,
 			}
+			Server.SetKeySet(testKeySet(t))
 
 			s := grpc.NewServer()
 			pb.RegisterAuthGophkeeperServer(s, Server)
@@ -366,21 +519,265 @@ func TestClient_UserLogin(t *testing.T) {
 				log.Fatal(err)
 			}
 			go s.Serve(listen)
+			defer s.Stop()
 
 			conn, err := grpc.Dial("localhost:9995", grpc.WithTransportCredentials(insecure.NewCredentials()))
 			if err != nil {
 				log.Fatal(err)
 			}
 			tt.c.authClient = pb.NewAuthGophkeeperClient(conn)
-			tt.c.UserLogin(context.Background(), data)
+			// no data service: upgrade of legacy account fails and is retried on next login
+			tt.c.serverClient = pb.NewGophkeeperClient(conn)
+			err = tt.c.UserLogin(context.Background(), data)
+			require.NoError(t, err)
+			// refresh of last login must not call store after test
+			defer func() { tt.c.auth.stopRefresh() }()
+			require.Equal(t, dataHash.Secret, tt.c.auth.Secret)
+			store.EXPECT().GetUser(gomock.Any(), gomock.Eq(models.User{Email: data.Email})).Return(dataHash, nil)
+			store.EXPECT().GetUser(gomock.Any(), gomock.Eq(data)).Return(data, errors.New("no data"))
+			err = tt.c.UserLogin(context.Background(), data)
+			require.Error(t, err)
+
+			// zero-knowledge: server keeps only wrapped key and auth key hash.
+			var registred models.User
+			store.EXPECT().AddUser(gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, u models.User) error {
+				registred = u
+				return nil
+			})
+			err = tt.c.UserRegister(context.Background(), data)
 			require.NoError(t, err)
-			store.EXPECT().GetUser(gomock.Eq(data)).Return(data, errors.New("no data"))
+			require.NotEqual(t, data.Password, registred.Password)
+			require.False(t, data.CheckPasswordHash(registred.Password))
+			require.Len(t, registred.Salt, crypto.SaltSize)
+			store.EXPECT().GetUser(gomock.Any(), gomock.Any()).Return(registred, nil).Times(2)
+			err = tt.c.UserLogin(context.Background(), data)
+			require.NoError(t, err)
+			require.Len(t, tt.c.auth.Secret, crypto.KeySize)
+			_, err = crypto.UnwrapKey(tt.c.auth.Secret, registred.Secret)
+			require.Error(t, err)
+			// wrong password
+			store.EXPECT().GetUser(gomock.Any(), gomock.Any()).Return(registred, nil).Times(2)
+			data.Password = "22222"
 			err = tt.c.UserLogin(context.Background(), data)
 			require.Error(t, err)
 		})
 	}
 }
 
+func TestClient_UserLoginMFA(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	data := models.User{
+		Email:    "test@test.com",
+		Password: "11111",
+	}
+	dataHash := models.User{
+		Email:    "test@test.com",
+		Password: "11111",
+		Secret:   []byte("12345678123456781234567812345678"),
+	}
+	dataHash.HashPassword()
+	key, err := totp.Generate(totp.GenerateOpts{Issuer: "Gophkeeper", AccountName: data.Email})
+	require.NoError(t, err)
+	enrollment := models.TOTP{User: data.Email, Secret: key.Secret(), Confirmed: true}
+	store := mockdb.NewMockStorager(ctrl)
+	allowLogins(store)
+	Server := authserver.AuthGophkeeperServer{
+		DB: store,
+	}
+	Server.SetKeySet(testKeySet(t))
+	s := grpc.NewServer()
+	pb.RegisterAuthGophkeeperServer(s, Server)
+	listen, err := net.Listen("tcp", "localhost:9987")
+	if err != nil {
+		log.Fatal(err)
+	}
+	go s.Serve(listen)
+	defer s.Stop()
+	conn, err := grpc.Dial("localhost:9987", grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		log.Fatal(err)
+	}
+	c := &Client{
+		authClient: pb.NewAuthGophkeeperClient(conn),
+		// no data service: upgrade of legacy account fails and is retried on next login
+		serverClient: pb.NewGophkeeperClient(conn),
+		auth:         &Auth{},
+	}
+	err = c.UserLoginMFA(context.Background(), "000000")
+	require.Error(t, err)
+
+	store.EXPECT().GetUser(gomock.Any(), gomock.Any()).Return(dataHash, nil).Times(3)
+	store.EXPECT().GetTOTP(gomock.Any(), gomock.Eq(data.Email)).Return(enrollment, nil).Times(3)
+	store.EXPECT().UpdateUser(gomock.Any(), gomock.Any()).Return(nil)
+	err = c.UserLogin(context.Background(), data)
+	require.ErrorIs(t, err, ErrMFARequired)
+	require.True(t, c.MFAPending())
+	require.Empty(t, c.auth.AccessToken())
+	require.Empty(t, c.auth.Secret)
+
+	err = c.UserLoginMFA(context.Background(), "000000")
+	require.Error(t, err)
+	require.True(t, c.MFAPending())
+
+	code, err := totp.GenerateCode(key.Secret(), time.Now())
+	require.NoError(t, err)
+	store.EXPECT().UseTOTPStep(gomock.Any(), gomock.Eq(data.Email), gomock.Any()).Return(true, nil)
+	store.EXPECT().AddSession(gomock.Any(), gomock.Any()).Return(nil)
+	store.EXPECT().AddRefreshToken(gomock.Any(), gomock.Any()).Return(nil)
+	err = c.UserLoginMFA(context.Background(), code)
+	require.NoError(t, err)
+	require.False(t, c.MFAPending())
+	require.NotEmpty(t, c.auth.AccessToken())
+	require.Equal(t, dataHash.Secret, c.auth.Secret)
+	c.auth.stopRefresh()
+}
+
+func TestClient_ChangePassword(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	data := models.User{
+		Email:    "test@test.com",
+		Password: "11111",
+	}
+	store := mockdb.NewMockStorager(ctrl)
+	allowLogins(store)
+	store.EXPECT().AddSession(gomock.Any(), gomock.Any()).Return(nil).AnyTimes()
+	store.EXPECT().AddRefreshToken(gomock.Any(), gomock.Any()).Return(nil).AnyTimes()
+	store.EXPECT().GetTOTP(gomock.Any(), gomock.Any()).Return(models.TOTP{}, nil).AnyTimes()
+	Server := authserver.AuthGophkeeperServer{
+		DB: store,
+	}
+	Server.SetKeySet(testKeySet(t))
+	s := grpc.NewServer(grpc.UnaryInterceptor(grpc_auth.UnaryServerInterceptor(Server.AuthFunc)))
+	pb.RegisterAuthGophkeeperServer(s, Server)
+	listen, err := net.Listen("tcp", "localhost:9986")
+	if err != nil {
+		log.Fatal(err)
+	}
+	go s.Serve(listen)
+	defer s.Stop()
+	auth := &Auth{}
+	conn, err := grpc.Dial("localhost:9986", grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithUnaryInterceptor(auth.UnaryAuthClientInterceptor))
+	if err != nil {
+		log.Fatal(err)
+	}
+	c := &Client{
+		authClient: pb.NewAuthGophkeeperClient(conn),
+		// no data service: upgrade of legacy account fails and is retried on next login
+		serverClient: pb.NewGophkeeperClient(conn),
+		auth:         auth,
+	}
+	var registred models.User
+	store.EXPECT().AddUser(gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, u models.User) error {
+		registred = u
+		return nil
+	})
+	require.NoError(t, c.UserRegister(context.Background(), data))
+	store.EXPECT().GetUser(gomock.Any(), gomock.Any()).Return(registred, nil).Times(2)
+	require.NoError(t, c.UserLogin(context.Background(), data))
+	defer c.auth.stopRefresh()
+	vaultKey := c.auth.Secret
+
+	// wrong current password
+	store.EXPECT().GetUser(gomock.Any(), gomock.Any()).Return(registred, nil).Times(2)
+	err = c.ChangePassword(context.Background(), "22222", "33333")
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	var changed models.User
+	store.EXPECT().GetUser(gomock.Any(), gomock.Any()).Return(registred, nil).Times(2)
+	store.EXPECT().UpdateUser(gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, u models.User) error {
+		changed = u
+		return nil
+	})
+	store.EXPECT().RevokeUserSessions(gomock.Any(), gomock.Eq(data.Email), gomock.Eq(c.auth.SessionID)).Return(nil)
+	require.NoError(t, c.ChangePassword(context.Background(), data.Password, "33333"))
+	require.Equal(t, data.Email, changed.Email)
+	require.NotEqual(t, registred.Salt, changed.Salt)
+	require.NotEqual(t, registred.Secret, changed.Secret)
+
+	// vault key is the same after login with new password
+	c.auth.stopRefresh()
+	store.EXPECT().GetUser(gomock.Any(), gomock.Any()).Return(changed, nil).Times(2)
+	err = c.UserLogin(context.Background(), data)
+	require.Error(t, err)
+	store.EXPECT().GetUser(gomock.Any(), gomock.Any()).Return(changed, nil).Times(2)
+	require.NoError(t, c.UserLogin(context.Background(), models.User{Email: data.Email, Password: "33333"}))
+	require.Equal(t, vaultKey, c.auth.Secret)
+}
+
+func TestClient_DeleteAccount(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	data := models.User{
+		Email:    "test@test.com",
+		Password: "11111",
+	}
+	store := mockdb.NewMockStorager(ctrl)
+	allowLogins(store)
+	store.EXPECT().AddSession(gomock.Any(), gomock.Any()).Return(nil).AnyTimes()
+	store.EXPECT().AddRefreshToken(gomock.Any(), gomock.Any()).Return(nil).AnyTimes()
+	store.EXPECT().GetTOTP(gomock.Any(), gomock.Any()).Return(models.TOTP{}, nil).AnyTimes()
+	Server := authserver.AuthGophkeeperServer{
+		DB: store,
+	}
+	Server.SetKeySet(testKeySet(t))
+	s := grpc.NewServer(grpc.UnaryInterceptor(grpc_auth.UnaryServerInterceptor(Server.AuthFunc)))
+	pb.RegisterAuthGophkeeperServer(s, Server)
+	listen, err := net.Listen("tcp", "localhost:9985")
+	if err != nil {
+		log.Fatal(err)
+	}
+	go s.Serve(listen)
+	defer s.Stop()
+	auth := &Auth{}
+	conn, err := grpc.Dial("localhost:9985", grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithUnaryInterceptor(auth.UnaryAuthClientInterceptor))
+	if err != nil {
+		log.Fatal(err)
+	}
+	c := &Client{
+		authClient: pb.NewAuthGophkeeperClient(conn),
+		// no data service: upgrade of legacy account fails and is retried on next login
+		serverClient: pb.NewGophkeeperClient(conn),
+		auth:         auth,
+	}
+	var registred models.User
+	store.EXPECT().AddUser(gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, u models.User) error {
+		registred = u
+		return nil
+	})
+	require.NoError(t, c.UserRegister(context.Background(), data))
+	store.EXPECT().GetUser(gomock.Any(), gomock.Any()).Return(registred, nil).Times(2)
+	require.NoError(t, c.UserLogin(context.Background(), data))
+	defer c.auth.stopRefresh()
+	_, ok := c.DeletionScheduled()
+	require.False(t, ok)
+
+	store.EXPECT().GetUser(gomock.Any(), gomock.Any()).Return(registred, nil).Times(2)
+	_, err = c.DeleteAccount(context.Background(), "22222", "")
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	var scheduled time.Time
+	store.EXPECT().GetUser(gomock.Any(), gomock.Any()).Return(registred, nil).Times(2)
+	store.EXPECT().ScheduleUserDeletion(gomock.Any(), gomock.Eq(data.Email), gomock.Any()).DoAndReturn(func(_ context.Context, email string, at time.Time) error {
+		scheduled = at
+		return nil
+	})
+	at, err := c.DeleteAccount(context.Background(), data.Password, "")
+	require.NoError(t, err)
+	require.Equal(t, scheduled.Unix(), at.Unix())
+	got, ok := c.DeletionScheduled()
+	require.True(t, ok)
+	require.Equal(t, at, got)
+
+	store.EXPECT().CancelUserDeletion(gomock.Any(), gomock.Eq(data.Email)).Return(true, nil)
+	require.NoError(t, c.CancelAccountDeletion(context.Background()))
+	_, ok = c.DeletionScheduled()
+	require.False(t, ok)
+}
+
 func TestClient_UnmarshalProtoData(t *testing.T) {
 	type args struct {
 		val *pb.CipheredData
@@ -471,6 +868,96 @@ func TestClient_UnmarshalProtoData(t *testing.T) {
 	}
 }
 
+func TestClient_UnmarshalProtoDataAD(t *testing.T) {
+	c := &Client{
+		crypto:      *crypto.NewCrypto([]byte("12345678123456781234567812345678")),
+		currentUser: models.User{Email: "test@test.com"},
+	}
+	pass := models.Password{
+		Login:    "login",
+		Password: "password",
+		Tag:      "tag",
+		ID:       "111-111-1111",
+	}
+	cData, err := c.crypto.EncryptWithAD(pass.GetData(), recordAD(pass.ID, pass.Type(), c.currentUser.Email))
+	require.NoError(t, err)
+	got, err := c.UnmarshalProtoData(models.NewCipheredData(cData, c.currentUser.Email, pass.Type(), pass.ID))
+	require.NoError(t, err)
+	require.Equal(t, pass, got)
+	tests := []struct {
+		name  string
+		val   *pb.CipheredData
+		email string
+	}{
+		{
+			name:  "swapped uuid",
+			val:   models.NewCipheredData(cData, c.currentUser.Email, pass.Type(), "222-222-2222"),
+			email: c.currentUser.Email,
+		},
+		{
+			name:  "relabeled type",
+			val:   models.NewCipheredData(cData, c.currentUser.Email, "TEXT", pass.ID),
+			email: c.currentUser.Email,
+		},
+		{
+			name:  "other owner",
+			val:   models.NewCipheredData(cData, "other@test.com", pass.Type(), pass.ID),
+			email: "other@test.com",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Client{
+				crypto:      c.crypto,
+				currentUser: models.User{Email: tt.email},
+			}
+			_, err := c.UnmarshalProtoData(tt.val)
+			require.ErrorIs(t, err, crypto.ErrDecrypt)
+		})
+	}
+}
+
+func TestClient_UnmarshalProtoDataSwapped(t *testing.T) {
+	c := &Client{
+		crypto:      *crypto.NewCrypto([]byte("12345678123456781234567812345678")),
+		currentUser: models.User{Email: "test@test.com"},
+	}
+	// envelope of 16k+15 byte record has length of legacy ciphertext
+	txt := models.Text{Data: "text", ID: "111-111-1111"}
+	txt.Data += strings.Repeat("t", 15-len(txt.GetData())%16)
+	require.Equal(t, 15, len(txt.GetData())%16)
+	cData, err := c.crypto.EncryptWithAD(txt.GetData(), recordAD(txt.ID, txt.Type(), c.currentUser.Email))
+	require.NoError(t, err)
+	require.True(t, crypto.IsLegacy(cData))
+	_, err = c.UnmarshalProtoData(models.NewCipheredData(cData, c.currentUser.Email, txt.Type(), "222-222-2222"))
+	require.ErrorIs(t, err, crypto.ErrDecrypt)
+	_, err = c.UnmarshalProtoData(models.NewCipheredData(cData, c.currentUser.Email, "PASSWORD", txt.ID))
+	require.ErrorIs(t, err, crypto.ErrDecrypt)
+}
+
+func TestClient_UnmarshalProtoDataLegacy(t *testing.T) {
+	c := &Client{
+		crypto:      *crypto.NewCrypto([]byte("12345678123456781234567812345678")),
+		currentUser: models.User{Email: "test@test.com"},
+	}
+	pass := models.Password{Login: "login", Password: "password", ID: "111-111-1111"}
+	// record ciphered before envelopes: ECB padded with zeros
+	plain := pass.GetData()
+	plain = append(plain, make([]byte, c.crypto.Crypto.BlockSize()-len(plain)%c.crypto.Crypto.BlockSize())...)
+	legacy := make([]byte, len(plain))
+	for i := 0; i < len(plain); i += c.crypto.Crypto.BlockSize() {
+		c.crypto.Crypto.Encrypt(legacy[i:i+c.crypto.Crypto.BlockSize()], plain[i:i+c.crypto.Crypto.BlockSize()])
+	}
+	val := models.NewCipheredData(legacy, c.currentUser.Email, pass.Type(), pass.ID)
+	_, err := c.UnmarshalProtoData(val)
+	require.ErrorIs(t, err, crypto.ErrDecrypt)
+	// only account registered before zero-knowledge keys may have legacy records
+	c.legacy = true
+	got, err := c.UnmarshalProtoData(val)
+	require.NoError(t, err)
+	require.Equal(t, pass, got)
+}
+
 func TestClient_PrinStorage(t *testing.T) {
 	tests := []struct {
 		name string
@@ -0,0 +1,156 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/MaximkaSha/gophkeeper/internal/models"
+	pb "github.com/MaximkaSha/gophkeeper/internal/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ConflictError returned when record was changed by other device after last sync.
+// It is resolved by KeepTheirs or by ResolveConflict with version chosen by user.
+type ConflictError struct {
+	// Uuid of record.
+	ID string
+	// My version of record, nil if I deleted record.
+	Mine models.Dater
+	// Current version of record, nil if record is deleted.
+	Theirs models.Dater
+	// Revision of current version.
+	Revision int64
+}
+
+func (e *ConflictError) Error() string {
+	if e.Theirs == nil {
+		return fmt.Sprintf("record %s is deleted on other device", e.ID)
+	}
+	return fmt.Sprintf("record %s is changed on other device", e.ID)
+}
+
+// conflictError returns *ConflictError if server aborted change of record and sent its current version.
+// Other errors are returned as is.
+func (c *Client) conflictError(err error, id string, mine models.Dater) error {
+	if status.Code(err) != codes.Aborted {
+		return err
+	}
+	for _, detail := range status.Convert(err).Details() {
+		current, ok := detail.(*pb.CipheredData)
+		if !ok || current.Uuid != id {
+			continue
+		}
+		conflict := &ConflictError{ID: id, Mine: mine, Revision: current.Revision}
+		if !current.Deleted {
+			theirs, err := c.UnmarshalProtoData(current)
+			if err != nil {
+				return err
+			}
+			conflict.Theirs = theirs.(models.Dater)
+		}
+		return conflict
+	}
+	return err
+}
+
+// KeepTheirs - resolve conflict by taking current version of record, my change is dropped.
+func (c *Client) KeepTheirs(ctx context.Context, conflict *ConflictError) {
+	c.dequeue(conflict.ID)
+	if conflict.Theirs == nil {
+		c.DelFromLocalStorage(conflict.ID)
+	} else {
+		c.AddDataToLocalStorage(ctx, conflict.Theirs)
+		c.setRevision(conflict.ID, conflict.Revision)
+	}
+	c.persistCache()
+}
+
+// ResolveConflict - save version of record chosen by user over its current version.
+// Version may be mine, theirs or merged by MergeFields, nil deletes record.
+func (c *Client) ResolveConflict(ctx context.Context, data models.Dater, conflict *ConflictError) error {
+	return c.push(ctx, conflict.ID, data, conflict.Revision)
+}
+
+// Field - field of record in my and their versions.
+type Field struct {
+	// Json name of field.
+	Name   string
+	Mine   string
+	Theirs string
+}
+
+// CompareFields returns fields of two versions of record ordered by name.
+// Field missing in a version is empty, uuid is the same and not returned.
+func CompareFields(mine, theirs models.Dater) ([]Field, error) {
+	my, err := fields(mine)
+	if err != nil {
+		return nil, err
+	}
+	their, err := fields(theirs)
+	if err != nil {
+		return nil, err
+	}
+	names := []string{}
+	for name := range my {
+		names = append(names, name)
+	}
+	for name := range their {
+		if _, ok := my[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	result := []Field{}
+	for _, name := range names {
+		if name == "id" {
+			continue
+		}
+		result = append(result, Field{Name: name, Mine: fieldValue(my[name]), Theirs: fieldValue(their[name])})
+	}
+	return result, nil
+}
+
+// MergeFields returns my version of record with given fields taken from their version.
+func MergeFields(mine, theirs models.Dater, fromTheirs []string) (models.Dater, error) {
+	my, err := fields(mine)
+	if err != nil {
+		return nil, err
+	}
+	their, err := fields(theirs)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range fromTheirs {
+		if value, ok := their[name]; ok {
+			my[name] = value
+			continue
+		}
+		delete(my, name)
+	}
+	plain, err := json.Marshal(my)
+	if err != nil {
+		return nil, err
+	}
+	return unmarshalData(mine.Type(), plain, mine.GetID())
+}
+
+// fields returns json fields of record by names.
+func fields(data models.Dater) (map[string]json.RawMessage, error) {
+	result := map[string]json.RawMessage{}
+	if err := json.Unmarshal(data.GetData(), &result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// fieldValue returns json value for user, strings are unquoted.
+func fieldValue(value json.RawMessage) string {
+	var text string
+	if err := json.Unmarshal(value, &text); err == nil {
+		return text
+	}
+	return string(value)
+}
@@ -0,0 +1,109 @@
+package client
+
+import (
+	"context"
+	"log"
+	"net"
+	"testing"
+
+	"github.com/MaximkaSha/gophkeeper/internal/crypto"
+	"github.com/MaximkaSha/gophkeeper/internal/models"
+	pb "github.com/MaximkaSha/gophkeeper/internal/proto"
+	"github.com/MaximkaSha/gophkeeper/internal/server"
+	"github.com/MaximkaSha/gophkeeper/internal/storage"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+func TestClient_Conflict(t *testing.T) {
+	store := storage.NewMemory()
+	require.NoError(t, store.AddUser(context.Background(), models.User{Email: "test@test.com", Password: "hash"}))
+	s := grpc.NewServer(grpc.UnaryInterceptor(authInterceptor("test@test.com")))
+	pb.RegisterGophkeeperServer(s, server.GophkeeperServer{DB: store})
+	listen, err := net.Listen("tcp", "localhost:9975")
+	if err != nil {
+		log.Fatal(err)
+	}
+	go s.Serve(listen)
+	defer s.Stop()
+	conn, err := grpc.Dial("localhost:9975", grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	defer conn.Close()
+	// two devices of one user
+	newClient := func() *Client {
+		return &Client{
+			serverClient: pb.NewGophkeeperClient(conn),
+			crypto:       *crypto.NewCrypto([]byte("12345678123456781234567812345678")),
+			currentUser:  models.User{Email: "test@test.com"},
+		}
+	}
+	first, second := newClient(), newClient()
+	ctx := context.Background()
+
+	password := models.Password{Login: "login", Password: "secret", Tag: "mail", ID: "111-111-111"}
+	require.NoError(t, first.AddData(ctx, password))
+	require.NoError(t, second.GetAllDataFromDB(ctx))
+
+	// edit of outdated record returns their version
+	theirs := password
+	theirs.Password = "theirs"
+	require.NoError(t, first.AddData(ctx, theirs))
+	require.NoError(t, first.AddData(ctx, theirs))
+	mine := password
+	mine.Login, mine.Tag = "mine", "work"
+	err = second.AddData(ctx, mine)
+	var conflict *ConflictError
+	require.ErrorAs(t, err, &conflict)
+	require.Equal(t, theirs, conflict.Theirs)
+	require.Equal(t, int64(3), conflict.Revision)
+
+	// their version is kept
+	second.KeepTheirs(ctx, conflict)
+	require.Equal(t, theirs.GetData(), second.AllData[0].JData)
+	require.NoError(t, second.AddData(ctx, mine))
+
+	// merged version is saved over their version
+	err = first.AddData(ctx, theirs)
+	require.ErrorAs(t, err, &conflict)
+	require.Equal(t, mine, conflict.Theirs)
+	merged, err := MergeFields(theirs, conflict.Theirs, []string{"login"})
+	require.NoError(t, err)
+	require.NoError(t, first.ResolveConflict(ctx, merged, conflict))
+	require.NoError(t, second.SyncChanges(ctx))
+	require.Equal(t, models.Password{Login: "mine", Password: "theirs", Tag: "mail", ID: password.ID}, merged)
+	require.Equal(t, merged.GetData(), second.AllData[0].JData)
+
+	// deleted record is restored by my version
+	require.NoError(t, first.DelData(ctx, password.ID))
+	err = second.AddData(ctx, mine)
+	require.ErrorAs(t, err, &conflict)
+	require.Nil(t, conflict.Theirs)
+	require.NoError(t, second.ResolveConflict(ctx, mine, conflict))
+	require.NoError(t, first.SyncChanges(ctx))
+	require.Len(t, first.AllData, 1)
+	require.Equal(t, mine.GetData(), first.AllData[0].JData)
+}
+
+func TestCompareFields(t *testing.T) {
+	mine := models.CreditCard{CardNum: "4111111111111111", Exp: "12/30", Name: "MINE", Tag: "card", ID: "111-111-111"}
+	theirs := mine
+	theirs.Name, theirs.CVV = "THEIRS", "123"
+	fields, err := CompareFields(mine, theirs)
+	require.NoError(t, err)
+	require.Equal(t, []Field{
+		{Name: "cardnum", Mine: mine.CardNum, Theirs: mine.CardNum},
+		{Name: "cvv", Mine: "", Theirs: "123"},
+		{Name: "exp", Mine: mine.Exp, Theirs: mine.Exp},
+		{Name: "name", Mine: "MINE", Theirs: "THEIRS"},
+		{Name: "tag", Mine: mine.Tag, Theirs: mine.Tag},
+	}, fields)
+
+	merged, err := MergeFields(mine, theirs, []string{"cvv"})
+	require.NoError(t, err)
+	mine.CVV = "123"
+	require.Equal(t, mine, merged)
+	merged, err = MergeFields(mine, theirs, nil)
+	require.NoError(t, err)
+	require.Equal(t, mine, merged)
+}
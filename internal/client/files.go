@@ -0,0 +1,294 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/MaximkaSha/gophkeeper/internal/models"
+	pb "github.com/MaximkaSha/gophkeeper/internal/proto"
+	"github.com/google/uuid"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// FileChunkSize - size of plain file content ciphered in one chunk.
+const FileChunkSize = 1 << 20
+
+// chunkSize - chunk size used by UploadFile, changed by tests.
+var chunkSize = FileChunkSize
+
+// ErrFileTruncated returned by DownloadFile if server sent chunks out of order or not all of them.
+var ErrFileTruncated = errors.New("file content is truncated")
+
+// chunkAD returns additional data which binds chunk to file record, upload, position and end of file.
+// Chunks can't be reordered, mixed with other uploads or dropped from the end.
+func chunkAD(id string, contentID string, email string, index int64, last bool) []byte {
+	ad := recordAD(id, pb.CipheredData_DATA.String(), email)
+	pos := make([]byte, 4+len(contentID)+9)
+	binary.BigEndian.PutUint32(pos, uint32(len(contentID)))
+	copy(pos[4:], contentID)
+	binary.BigEndian.PutUint64(pos[4+len(contentID):], uint64(index))
+	if last {
+		pos[len(pos)-1] = 1
+	}
+	return append(ad, pos...)
+}
+
+// transferRetries - number of attempts to continue interrupted file transfer.
+const transferRetries = 5
+
+// transferBackoff - pause before first attempt to continue transfer, doubled on each next one.
+// Changed by tests.
+var transferBackoff = time.Second
+
+// FileUpload - started upload of file record, kept by caller to continue upload after failure.
+type FileUpload struct {
+	ID   string
+	Data models.Data
+}
+
+// retryable reports whether transfer broken by err can be continued.
+func retryable(err error) bool {
+	return status.Code(err) == codes.Unavailable
+}
+
+// waitRetry pauses before given attempt to continue transfer.
+func waitRetry(ctx context.Context, attempt int) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(transferBackoff << attempt):
+		return nil
+	}
+}
+
+// UploadFile - encrypt file content chunk by chunk and send it to server by stream.
+// Memory use does not depend on file size, so files of any size are uploaded.
+// Interrupted upload is continued from the first chunk server has not received.
+// Returns saved file record, its content is not kept in Data.
+func (c *Client) UploadFile(ctx context.Context, data models.Data, r io.ReadSeeker) (models.Data, error) {
+	upload, err := c.StartUpload(ctx, data)
+	if err != nil {
+		return models.Data{}, err
+	}
+	return c.ContinueUpload(ctx, upload, r)
+}
+
+// StartUpload - send file record to server and start upload of its content.
+func (c *Client) StartUpload(ctx context.Context, data models.Data) (FileUpload, error) {
+	if data.ID == "" {
+		data.ID = uuid.NewString()
+	}
+	data.Data = nil
+	data.ContentID = uuid.NewString()
+	meta, err := c.crypto.EncryptWithAD(data.GetData(), recordAD(data.ID, data.Type(), c.currentUser.Email))
+	if err != nil {
+		return FileUpload{}, err
+	}
+	resp, err := c.serverClient.StartUpload(ctx, &pb.StartUploadRequest{
+		Meta:             models.NewCipheredData(meta, c.currentUser.Email, data.Type(), data.ID),
+		ExpectedRevision: c.baseRevision(data.ID),
+	})
+	if err != nil {
+		return FileUpload{}, err
+	}
+	return FileUpload{ID: resp.UploadId, Data: data}, nil
+}
+
+// UploadStatus - returns number of chunks server has received by upload.
+func (c *Client) UploadStatus(ctx context.Context, upload FileUpload) (int64, error) {
+	resp, err := c.serverClient.GetUploadStatus(ctx, &pb.UploadStatusRequest{UploadId: upload.ID})
+	if err != nil {
+		return 0, err
+	}
+	return resp.Chunks, nil
+}
+
+// ContinueUpload - send content of started upload from the first chunk server has not received.
+// Upload broken by connection failure is continued several times before error is returned.
+// Upload which is not found after its last chunk was sent may be committed with response lost,
+// it is checked by sync then.
+// File changed by other device since last sync is not replaced, *ConflictError returned.
+func (c *Client) ContinueUpload(ctx context.Context, upload FileUpload, r io.ReadSeeker) (models.Data, error) {
+	var revision int64
+	sentLast := false
+	for attempt := 0; ; attempt++ {
+		var err error
+		var last bool
+		revision, last, err = c.continueUpload(ctx, upload, r)
+		if err == nil {
+			break
+		}
+		if sentLast && status.Code(err) == codes.NotFound {
+			revision, err = c.uploadCommitted(ctx, upload, err)
+			if err != nil {
+				return models.Data{}, err
+			}
+			break
+		}
+		sentLast = sentLast || last
+		if !retryable(err) || attempt == transferRetries {
+			return models.Data{}, c.conflictError(err, upload.Data.ID, upload.Data)
+		}
+		if err = waitRetry(ctx, attempt); err != nil {
+			return models.Data{}, err
+		}
+	}
+	c.AddDataToLocalStorage(ctx, upload.Data)
+	c.setRevision(upload.Data.ID, revision)
+	return upload.Data, nil
+}
+
+// continueUpload sends rest of content by one stream, returns revision of saved file record.
+// Also reports whether last chunk was sent, so upload may be committed even if error is returned.
+func (c *Client) continueUpload(ctx context.Context, upload FileUpload, r io.ReadSeeker) (int64, bool, error) {
+	next, err := c.UploadStatus(ctx, upload)
+	if err != nil {
+		return 0, false, err
+	}
+	_, err = r.Seek(next*int64(chunkSize), io.SeekStart)
+	if err != nil {
+		return 0, false, err
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stream, err := c.serverClient.UploadFile(ctx)
+	if err != nil {
+		return 0, false, err
+	}
+	err = stream.Send(&pb.UploadFileRequest{Payload: &pb.UploadFileRequest_UploadId{UploadId: upload.ID}})
+	if err != nil {
+		_, err = stream.CloseAndRecv()
+		return 0, false, err
+	}
+	data := upload.Data
+	reader := bufio.NewReaderSize(r, chunkSize)
+	buf := make([]byte, chunkSize)
+	sentLast := false
+	for index := next; ; index++ {
+		n, err := io.ReadFull(reader, buf)
+		last := err == io.EOF || err == io.ErrUnexpectedEOF
+		if err != nil && !last {
+			return 0, false, err
+		}
+		if !last {
+			// content ending right at chunk border has no empty last chunk
+			_, err = reader.Peek(1)
+			last = err == io.EOF
+			if err != nil && !last {
+				return 0, false, err
+			}
+		}
+		chunk, err := c.crypto.EncryptWithAD(buf[:n], chunkAD(data.ID, data.ContentID, c.currentUser.Email, index, last))
+		if err != nil {
+			return 0, false, err
+		}
+		err = stream.Send(&pb.UploadFileRequest{Payload: &pb.UploadFileRequest_Chunk{
+			Chunk: &pb.FileChunk{Index: index, Data: chunk, Last: last},
+		}})
+		if err != nil {
+			// real error is returned by CloseAndRecv
+			break
+		}
+		if last {
+			sentLast = true
+			break
+		}
+	}
+	resp, err := stream.CloseAndRecv()
+	if err != nil {
+		return 0, sentLast, err
+	}
+	if !resp.Finished {
+		return 0, sentLast, fmt.Errorf("upload %s is not finished, server has %d chunks", upload.ID, resp.Chunks)
+	}
+	return resp.Revision, sentLast, nil
+}
+
+// uploadCommitted checks by sync whether upload not found on server was committed.
+// Returns revision of saved file record, or given error of upload if its content is not saved.
+func (c *Client) uploadCommitted(ctx context.Context, upload FileUpload, notFound error) (int64, error) {
+	err := c.SyncChanges(ctx)
+	if err != nil {
+		return 0, err
+	}
+	for _, record := range c.AllData {
+		if record.ID != upload.Data.ID {
+			continue
+		}
+		data, err := unmarshalData(record.Type, record.JData, record.ID)
+		if err != nil {
+			return 0, err
+		}
+		if file, ok := data.(models.Data); ok && file.ContentID == upload.Data.ContentID {
+			return record.Revision, nil
+		}
+	}
+	return 0, notFound
+}
+
+// DownloadFile - receive file content from server by stream, decrypt and write it to w chunk by chunk.
+// Download broken by connection failure is continued from the next chunk.
+// Content of files saved before chunked upload is kept in record and written as is.
+func (c *Client) DownloadFile(ctx context.Context, data models.Data, w io.Writer) error {
+	if !data.Chunked() {
+		_, err := w.Write(data.Data)
+		return err
+	}
+	var index int64
+	for attempt := 0; ; attempt++ {
+		next, err := c.downloadFile(ctx, data, index, w)
+		if err == nil {
+			return nil
+		}
+		if next > index {
+			attempt = 0
+		}
+		index = next
+		if !retryable(err) || attempt == transferRetries {
+			return err
+		}
+		if err = waitRetry(ctx, attempt); err != nil {
+			return err
+		}
+	}
+}
+
+// downloadFile writes chunks received by one stream starting from given one.
+// Returns index of the first chunk not written.
+func (c *Client) downloadFile(ctx context.Context, data models.Data, from int64, w io.Writer) (int64, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stream, err := c.serverClient.DownloadFile(ctx, &pb.DownloadFileRequest{Uuid: data.ID, FromIndex: from})
+	if err != nil {
+		return from, err
+	}
+	for index := from; ; index++ {
+		chunk, err := stream.Recv()
+		if err == io.EOF {
+			return index, ErrFileTruncated
+		}
+		if err != nil {
+			return index, err
+		}
+		if chunk.Index != index {
+			return index, ErrFileTruncated
+		}
+		plain, err := c.crypto.DecryptWithAD(chunk.Data, chunkAD(data.ID, data.ContentID, c.currentUser.Email, index, chunk.Last))
+		if err != nil {
+			return index, err
+		}
+		_, err = w.Write(plain)
+		if err != nil {
+			return index, err
+		}
+		if chunk.Last {
+			return index + 1, nil
+		}
+	}
+}
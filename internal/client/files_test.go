@@ -0,0 +1,337 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"log"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/MaximkaSha/gophkeeper/internal/authserver"
+	"github.com/MaximkaSha/gophkeeper/internal/crypto"
+	"github.com/MaximkaSha/gophkeeper/internal/models"
+	pb "github.com/MaximkaSha/gophkeeper/internal/proto"
+	"github.com/MaximkaSha/gophkeeper/internal/server"
+	"github.com/MaximkaSha/gophkeeper/internal/storage"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+func streamAuthInterceptor(email string) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		return handler(srv, &authStream{ServerStream: ss, ctx: authserver.NewContextWithEmail(ss.Context(), email)})
+	}
+}
+
+// authStream - server stream with authenticated context.
+type authStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *authStream) Context() context.Context {
+	return s.ctx
+}
+
+// brokenStream - server stream which loses connection on given message.
+type brokenStream struct {
+	grpc.ServerStream
+	messages *int32
+}
+
+func (s *brokenStream) broken() error {
+	if atomic.AddInt32(s.messages, -1) == 0 {
+		return status.Error(codes.Unavailable, "connection lost")
+	}
+	return nil
+}
+
+func (s *brokenStream) RecvMsg(m interface{}) error {
+	if err := s.broken(); err != nil {
+		return err
+	}
+	return s.ServerStream.RecvMsg(m)
+}
+
+func (s *brokenStream) SendMsg(m interface{}) error {
+	if err := s.broken(); err != nil {
+		return err
+	}
+	return s.ServerStream.SendMsg(m)
+}
+
+// smallChunks makes chunks small, so files of several chunks are ciphered fast.
+func smallChunks(t *testing.T) {
+	chunkSize = 64
+	t.Cleanup(func() { chunkSize = FileChunkSize })
+}
+
+func TestClient_Files(t *testing.T) {
+	smallChunks(t)
+	store := storage.NewMemory()
+	require.NoError(t, store.AddUser(context.Background(), models.User{Email: "test@test.com", Password: "hash"}))
+	s := grpc.NewServer(grpc.UnaryInterceptor(authInterceptor("test@test.com")),
+		grpc.StreamInterceptor(streamAuthInterceptor("test@test.com")))
+	pb.RegisterGophkeeperServer(s, server.GophkeeperServer{DB: store})
+	listen, err := net.Listen("tcp", "localhost:9983")
+	if err != nil {
+		log.Fatal(err)
+	}
+	go s.Serve(listen)
+	defer s.Stop()
+	conn, err := grpc.Dial("localhost:9983", grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	defer conn.Close()
+	c := &Client{
+		serverClient: pb.NewGophkeeperClient(conn),
+		crypto:       *crypto.NewCrypto([]byte("12345678123456781234567812345678")),
+		currentUser:  models.User{Email: "test@test.com"},
+	}
+
+	tests := []struct {
+		name   string
+		size   int
+		chunks int64
+	}{
+		{
+			name:   "empty",
+			size:   0,
+			chunks: 1,
+		},
+		{
+			name:   "chunk border",
+			size:   chunkSize,
+			chunks: 1,
+		},
+		{
+			name:   "several chunks",
+			size:   2*chunkSize + 10,
+			chunks: 3,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			content := make([]byte, tt.size)
+			_, err := rand.Read(content)
+			require.NoError(t, err)
+			file, err := c.UploadFile(context.Background(), models.Data{Tag: tt.name}, bytes.NewReader(content))
+			require.NoError(t, err)
+			require.True(t, file.Chunked())
+			require.Nil(t, file.Data)
+			_, err = store.GetFileChunk(context.Background(), "test@test.com", file.ID, tt.chunks-1)
+			require.NoError(t, err)
+			_, err = store.GetFileChunk(context.Background(), "test@test.com", file.ID, tt.chunks)
+			require.ErrorIs(t, err, models.ErrNotFound)
+			var got bytes.Buffer
+			require.NoError(t, c.DownloadFile(context.Background(), file, &got))
+			require.True(t, bytes.Equal(content, got.Bytes()))
+		})
+	}
+
+	// AddData uploads file content, record is read back without content
+	require.NoError(t, c.AddData(context.Background(), models.Data{Data: []byte("content"), Tag: "tag"}))
+	stored := c.AllData[len(c.AllData)-1]
+	require.NoError(t, c.GetAllDataFromDB(context.Background()))
+	data, err := store.GetCipheredData(context.Background(), "test@test.com", models.DataPage{})
+	require.NoError(t, err)
+	var file models.Data
+	for _, val := range data {
+		if val.ID != stored.ID {
+			continue
+		}
+		v, err := c.UnmarshalProtoData(val.ToProto())
+		require.NoError(t, err)
+		file = v.(models.Data)
+	}
+	require.Equal(t, "tag", file.Tag)
+	require.Empty(t, file.Data)
+	var got bytes.Buffer
+	require.NoError(t, c.DownloadFile(context.Background(), file, &got))
+	require.Equal(t, "content", got.String())
+
+	// tag of uploaded file is changed without upload
+	file.Tag = "new tag"
+	require.NoError(t, c.AddData(context.Background(), file))
+	got.Reset()
+	require.NoError(t, c.DownloadFile(context.Background(), file, &got))
+	require.Equal(t, "content", got.String())
+
+	// file saved before chunked upload is written from record
+	got.Reset()
+	require.NoError(t, c.DownloadFile(context.Background(), models.Data{Data: []byte("legacy")}, &got))
+	require.Equal(t, "legacy", got.String())
+}
+
+func TestClient_DownloadFileTampered(t *testing.T) {
+	smallChunks(t)
+	store := storage.NewMemory()
+	require.NoError(t, store.AddUser(context.Background(), models.User{Email: "test@test.com", Password: "hash"}))
+	s := grpc.NewServer(grpc.UnaryInterceptor(authInterceptor("test@test.com")),
+		grpc.StreamInterceptor(streamAuthInterceptor("test@test.com")))
+	pb.RegisterGophkeeperServer(s, server.GophkeeperServer{DB: store})
+	listen, err := net.Listen("tcp", "localhost:9982")
+	if err != nil {
+		log.Fatal(err)
+	}
+	go s.Serve(listen)
+	defer s.Stop()
+	conn, err := grpc.Dial("localhost:9982", grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	defer conn.Close()
+	c := &Client{
+		serverClient: pb.NewGophkeeperClient(conn),
+		crypto:       *crypto.NewCrypto([]byte("12345678123456781234567812345678")),
+		currentUser:  models.User{Email: "test@test.com"},
+	}
+	content := make([]byte, 2*chunkSize+1)
+	file, err := c.UploadFile(context.Background(), models.Data{}, bytes.NewReader(content))
+	require.NoError(t, err)
+	chunk := func(index int64) models.FileChunk {
+		chunk, err := store.GetFileChunk(context.Background(), "test@test.com", file.ID, index)
+		require.NoError(t, err)
+		return chunk
+	}
+	first, second, third := chunk(0), chunk(1), chunk(2)
+	ctx := context.Background()
+
+	tests := []struct {
+		name   string
+		chunks []models.FileChunk
+		err    error
+	}{
+		{
+			name:   "swapped chunks",
+			chunks: []models.FileChunk{{Index: 0, Data: second.Data}, {Index: 1, Data: first.Data}, third},
+			err:    crypto.ErrDecrypt,
+		},
+		{
+			name:   "dropped end",
+			chunks: []models.FileChunk{first, {Index: 1, Data: second.Data, Last: true}},
+			err:    crypto.ErrDecrypt,
+		},
+		{
+			name:   "legacy looking chunk",
+			chunks: []models.FileChunk{{Index: 0, Data: make([]byte, 64), Last: true}},
+			err:    crypto.ErrDecrypt,
+		},
+	}
+	records, err := store.GetCipheredData(ctx, "test@test.com", models.DataPage{})
+	require.NoError(t, err)
+	require.Len(t, records, 1)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.NoError(t, store.AddUpload(ctx, models.Upload{ID: tt.name, Data: records[0]}))
+			for _, chunk := range tt.chunks {
+				require.NoError(t, store.AddUploadChunk(ctx, "test@test.com", tt.name, chunk, time.Now()))
+			}
+			revision, err := store.CommitUpload(ctx, "test@test.com", tt.name)
+			require.NoError(t, err)
+			records[0].Revision = revision
+			err = c.DownloadFile(ctx, file, &bytes.Buffer{})
+			require.ErrorIs(t, err, tt.err)
+		})
+	}
+
+	// file changed by uploads above is not replaced before sync
+	_, err = c.UploadFile(ctx, models.Data{ID: file.ID}, bytes.NewReader(content))
+	conflict := &ConflictError{}
+	require.ErrorAs(t, err, &conflict)
+	require.Equal(t, file.ID, conflict.ID)
+	require.Equal(t, records[0].Revision, conflict.Revision)
+	c.setRevision(file.ID, conflict.Revision)
+
+	// chunks of other upload of the same file are rejected
+	other, err := c.UploadFile(ctx, models.Data{ID: file.ID}, bytes.NewReader(content))
+	require.NoError(t, err)
+	require.NotEqual(t, file.ContentID, other.ContentID)
+	require.ErrorIs(t, c.DownloadFile(ctx, file, &bytes.Buffer{}), crypto.ErrDecrypt)
+	require.NoError(t, c.DownloadFile(ctx, other, &bytes.Buffer{}))
+}
+
+func TestClient_ResumeFile(t *testing.T) {
+	smallChunks(t)
+	transferBackoff = time.Millisecond
+	t.Cleanup(func() { transferBackoff = time.Second })
+	store := storage.NewMemory()
+	require.NoError(t, store.AddUser(context.Background(), models.User{Email: "test@test.com", Password: "hash"}))
+	var messages int32
+	auth := streamAuthInterceptor("test@test.com")
+	s := grpc.NewServer(grpc.UnaryInterceptor(authInterceptor("test@test.com")),
+		grpc.StreamInterceptor(func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+			return auth(srv, &brokenStream{ServerStream: ss, messages: &messages}, info, handler)
+		}))
+	pb.RegisterGophkeeperServer(s, server.GophkeeperServer{DB: store})
+	listen, err := net.Listen("tcp", "localhost:9980")
+	if err != nil {
+		log.Fatal(err)
+	}
+	go s.Serve(listen)
+	defer s.Stop()
+	conn, err := grpc.Dial("localhost:9980", grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	defer conn.Close()
+	c := &Client{
+		serverClient: pb.NewGophkeeperClient(conn),
+		crypto:       *crypto.NewCrypto([]byte("12345678123456781234567812345678")),
+		currentUser:  models.User{Email: "test@test.com"},
+	}
+	ctx := context.Background()
+	content := make([]byte, 3*chunkSize+1)
+	_, err = rand.Read(content)
+	require.NoError(t, err)
+
+	// upload id and first chunk are received before connection is lost
+	atomic.StoreInt32(&messages, 3)
+	file, err := c.UploadFile(ctx, models.Data{}, bytes.NewReader(content))
+	require.NoError(t, err)
+	require.Negative(t, atomic.LoadInt32(&messages))
+	var got bytes.Buffer
+	require.NoError(t, c.DownloadFile(ctx, file, &got))
+	require.True(t, bytes.Equal(content, got.Bytes()))
+
+	// download is continued after the first chunk
+	atomic.StoreInt32(&messages, 2)
+	got.Reset()
+	require.NoError(t, c.DownloadFile(ctx, file, &got))
+	require.Negative(t, atomic.LoadInt32(&messages))
+	require.True(t, bytes.Equal(content, got.Bytes()))
+
+	// upload is continued later by its id
+	upload, err := c.StartUpload(ctx, models.Data{ID: file.ID})
+	require.NoError(t, err)
+	chunks, err := c.UploadStatus(ctx, upload)
+	require.NoError(t, err)
+	require.Equal(t, int64(0), chunks)
+	file, err = c.ContinueUpload(ctx, upload, bytes.NewReader(content[:10]))
+	require.NoError(t, err)
+	got.Reset()
+	require.NoError(t, c.DownloadFile(ctx, file, &got))
+	require.Equal(t, content[:10], got.Bytes())
+	_, err = c.UploadStatus(ctx, upload)
+	require.Equal(t, codes.NotFound, status.Code(err))
+	// upload not found before its last chunk is sent is not committed
+	_, err = c.ContinueUpload(ctx, upload, bytes.NewReader(content[:10]))
+	require.Equal(t, codes.NotFound, status.Code(err))
+
+	// commit response is lost, saved file is found by sync
+	upload, err = c.StartUpload(ctx, models.Data{ID: file.ID})
+	require.NoError(t, err)
+	atomic.StoreInt32(&messages, 3)
+	file, err = c.ContinueUpload(ctx, upload, bytes.NewReader(content[:20]))
+	require.NoError(t, err)
+	// content is not sent again
+	require.Zero(t, atomic.LoadInt32(&messages))
+	changes, err := store.ListChanges(ctx, "test@test.com", 0, 10)
+	require.NoError(t, err)
+	require.Equal(t, changes[len(changes)-1].Revision, c.revisionOf(file.ID))
+	require.Equal(t, upload.Data.ContentID, file.ContentID)
+	got.Reset()
+	require.NoError(t, c.DownloadFile(ctx, file, &got))
+	require.Equal(t, content[:20], got.Bytes())
+}
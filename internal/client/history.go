@@ -0,0 +1,79 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/MaximkaSha/gophkeeper/internal/models"
+	pb "github.com/MaximkaSha/gophkeeper/internal/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ErrNoHistory returned for file record, server does not keep replaced file content.
+var ErrNoHistory = errors.New("history is not kept for files, replaced file content can't be restored")
+
+// historyError returns ErrNoHistory if server refused history of file, other errors are returned as is.
+func historyError(err error) error {
+	if status.Code(err) == codes.FailedPrecondition {
+		return ErrNoHistory
+	}
+	return err
+}
+
+// Revision - previous version of record kept by server.
+type Revision struct {
+	// Decrypted version of record.
+	Data     models.Dater
+	Revision int64
+	// Time version was replaced by later change or deletion.
+	ReplacedAt time.Time
+}
+
+// ListRevisions - ask server for previous versions of record, newest first.
+// ErrNoHistory returned for file record.
+func (c *Client) ListRevisions(ctx context.Context, id string) ([]Revision, error) {
+	if c.offline {
+		return nil, ErrOffline
+	}
+	resp, err := c.serverClient.ListRevisions(ctx, &pb.ListRevisionsRequest{Uuid: id})
+	if err != nil {
+		return nil, historyError(err)
+	}
+	revisions := make([]Revision, 0, len(resp.Revisions))
+	for _, val := range resp.Revisions {
+		data, err := c.UnmarshalProtoData(val.Data)
+		if err != nil {
+			return nil, err
+		}
+		revisions = append(revisions, Revision{
+			Data:       data.(models.Dater),
+			Revision:   val.Data.Revision,
+			ReplacedAt: unixTime(val.ReplacedAt),
+		})
+	}
+	return revisions, nil
+}
+
+// RestoreRevision - make previous version of record current again, change not sent yet is dropped.
+// Record changed by other device since last sync is not restored, *ConflictError returned.
+func (c *Client) RestoreRevision(ctx context.Context, revision Revision) error {
+	if c.offline {
+		return ErrOffline
+	}
+	id := revision.Data.GetID()
+	resp, err := c.serverClient.RestoreRevision(ctx, &pb.RestoreRevisionRequest{
+		Uuid:             id,
+		Revision:         revision.Revision,
+		ExpectedRevision: c.baseRevision(id),
+	})
+	if err != nil {
+		return c.conflictError(historyError(err), id, revision.Data)
+	}
+	c.dequeue(id)
+	c.AddDataToLocalStorage(ctx, revision.Data)
+	c.setRevision(id, resp.Revision)
+	c.persistCache()
+	return nil
+}
@@ -0,0 +1,88 @@
+package client
+
+import (
+	"context"
+	"log"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/MaximkaSha/gophkeeper/internal/config"
+	"github.com/MaximkaSha/gophkeeper/internal/crypto"
+	"github.com/MaximkaSha/gophkeeper/internal/models"
+	pb "github.com/MaximkaSha/gophkeeper/internal/proto"
+	"github.com/MaximkaSha/gophkeeper/internal/server"
+	"github.com/MaximkaSha/gophkeeper/internal/storage"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+func TestClient_Revisions(t *testing.T) {
+	store := storage.NewMemory()
+	require.NoError(t, store.AddUser(context.Background(), models.User{Email: "test@test.com", Password: "hash"}))
+	s := grpc.NewServer(grpc.UnaryInterceptor(authInterceptor("test@test.com")))
+	pb.RegisterGophkeeperServer(s, server.GophkeeperServer{DB: store, Config: &config.ServerConfig{HistoryRevisions: 10}})
+	listen, err := net.Listen("tcp", "localhost:9972")
+	if err != nil {
+		log.Fatal(err)
+	}
+	go s.Serve(listen)
+	defer s.Stop()
+	key, err := crypto.GenKey()
+	require.NoError(t, err)
+	newClient := func() *Client {
+		conn, err := grpc.Dial("localhost:9972", grpc.WithTransportCredentials(insecure.NewCredentials()))
+		require.NoError(t, err)
+		t.Cleanup(func() { conn.Close() })
+		return &Client{
+			serverClient: pb.NewGophkeeperClient(conn),
+			crypto:       *crypto.NewCrypto(key),
+			currentUser:  models.User{Email: "test@test.com"},
+			AllData:      []AllData{},
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	c, other := newClient(), newClient()
+
+	first := models.Password{Login: "login", Password: "first", ID: "111-111-111"}
+	require.NoError(t, c.AddData(ctx, first))
+	require.NoError(t, c.AddData(ctx, models.Password{Login: "login", Password: "second", ID: "111-111-111"}))
+	require.NoError(t, c.AddData(ctx, models.Password{Login: "login", Password: "third", ID: "111-111-111"}))
+
+	// previous versions are decrypted, newest first
+	revisions, err := c.ListRevisions(ctx, "111-111-111")
+	require.NoError(t, err)
+	require.Len(t, revisions, 2)
+	require.Equal(t, models.Password{Login: "login", Password: "second", ID: "111-111-111"}, revisions[0].Data)
+	require.Equal(t, first, revisions[1].Data)
+	require.False(t, revisions[1].ReplacedAt.IsZero())
+
+	// record changed by other device is not restored
+	require.NoError(t, other.SyncChanges(ctx))
+	require.NoError(t, other.AddData(ctx, models.Password{Login: "login", Password: "by other", ID: "111-111-111"}))
+	err = c.RestoreRevision(ctx, revisions[1])
+	conflict := &ConflictError{}
+	require.ErrorAs(t, err, &conflict)
+	require.Equal(t, first, conflict.Mine)
+
+	require.NoError(t, c.SyncChanges(ctx))
+	require.NoError(t, c.RestoreRevision(ctx, revisions[1]))
+	require.NoError(t, other.SyncChanges(ctx))
+	require.Equal(t, c.AllData, other.AllData)
+	data, err := unmarshalData("PASSWORD", other.AllData[0].JData, "111-111-111")
+	require.NoError(t, err)
+	require.Equal(t, first, data)
+
+	// replaced file content is not kept
+	_, err = store.AddCipheredData(ctx, models.CipheredData{Data: []byte("meta"), Type: "DATA", User: "test@test.com", ID: "222-222-222"})
+	require.NoError(t, err)
+	_, err = c.ListRevisions(ctx, "222-222-222")
+	require.ErrorIs(t, err, ErrNoHistory)
+
+	offline := &Client{offline: true}
+	_, err = offline.ListRevisions(ctx, "111-111-111")
+	require.ErrorIs(t, err, ErrOffline)
+	require.ErrorIs(t, offline.RestoreRevision(ctx, revisions[1]), ErrOffline)
+}
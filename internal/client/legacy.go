@@ -0,0 +1,87 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+)
+
+// ErrLegacyLogin returned if server offers password login without salt to account
+// which had zero-knowledge keys, server would get master password and vault key then.
+var ErrLegacyLogin = errors.New("server asks for legacy login of account with zero-knowledge keys")
+
+// saltSeen reports whether zero-knowledge keys of account were seen on this device.
+func (c *Client) saltSeen(email string) (bool, error) {
+	accounts, err := c.readAccounts()
+	if err != nil {
+		return false, err
+	}
+	for _, account := range accounts {
+		if account == email {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// rememberSalt records that account has zero-knowledge keys, its legacy login is refused later.
+func (c *Client) rememberSalt(email string) error {
+	if c.Config == nil || c.Config.AccountsFile == "" {
+		return nil
+	}
+	seen, err := c.saltSeen(email)
+	if err != nil || seen {
+		return err
+	}
+	accounts, err := c.readAccounts()
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(append(accounts, email))
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(c.Config.AccountsFile, data)
+}
+
+// readAccounts reads emails of accounts with zero-knowledge keys, nothing is kept without accounts file.
+func (c *Client) readAccounts() ([]string, error) {
+	accounts := []string{}
+	if c.Config == nil || c.Config.AccountsFile == "" {
+		return accounts, nil
+	}
+	data, err := os.ReadFile(c.Config.AccountsFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return accounts, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(data, &accounts)
+	return accounts, err
+}
+
+// upgradeLegacy - move account registered before zero-knowledge keys to them on its first login.
+// Records are ciphered again as envelopes, then vault key wrapped by key derived from
+// master password replaces plain vault key on server. Server ends other sessions of user,
+// they log in with derived keys then.
+func (c *Client) upgradeLegacy(ctx context.Context, password string) error {
+	if err := c.GetAllDataFromDB(ctx); err != nil {
+		return err
+	}
+	for _, record := range append([]AllData{}, c.AllData...) {
+		data, err := unmarshalData(record.Type, record.JData, record.ID)
+		if err != nil {
+			return err
+		}
+		if err := c.saveData(ctx, data, record.Revision); err != nil {
+			return err
+		}
+	}
+	if err := c.ChangePassword(ctx, password, password); err != nil {
+		return err
+	}
+	c.legacy = false
+	return c.rememberSalt(c.currentUser.Email)
+}
@@ -0,0 +1,111 @@
+package client
+
+import (
+	"context"
+	"log"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/MaximkaSha/gophkeeper/internal/authserver"
+	"github.com/MaximkaSha/gophkeeper/internal/config"
+	"github.com/MaximkaSha/gophkeeper/internal/crypto"
+	"github.com/MaximkaSha/gophkeeper/internal/models"
+	"github.com/MaximkaSha/gophkeeper/internal/passhash"
+	pb "github.com/MaximkaSha/gophkeeper/internal/proto"
+	"github.com/MaximkaSha/gophkeeper/internal/server"
+	"github.com/MaximkaSha/gophkeeper/internal/storage"
+	grpc_auth "github.com/grpc-ecosystem/go-grpc-middleware/auth"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+func TestClient_UpgradeLegacy(t *testing.T) {
+	store := storage.NewMemory()
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+	// account and record made before zero-knowledge keys and envelopes
+	key := []byte("12345678123456781234567812345678")
+	hash, err := passhash.Bcrypt{Cost: 4}.Hash("password")
+	require.NoError(t, err)
+	legacyUser := models.User{Email: "test@test.com", Password: hash, Secret: key}
+	require.NoError(t, store.AddUser(ctx, legacyUser))
+	pass := models.Password{Login: "login", Password: "password", ID: "111-111-1111"}
+	ecb := crypto.NewCrypto(key)
+	plain := pass.GetData()
+	plain = append(plain, make([]byte, ecb.Crypto.BlockSize()-len(plain)%ecb.Crypto.BlockSize())...)
+	legacy := make([]byte, len(plain))
+	for i := 0; i < len(plain); i += ecb.Crypto.BlockSize() {
+		ecb.Crypto.Encrypt(legacy[i:i+ecb.Crypto.BlockSize()], plain[i:i+ecb.Crypto.BlockSize()])
+	}
+	_, err = store.AddCipheredData(ctx, models.CipheredData{Type: "PASSWORD", Data: legacy, User: legacyUser.Email, ID: pass.ID})
+	require.NoError(t, err)
+
+	authServer := authserver.AuthGophkeeperServer{DB: store}
+	authServer.SetKeySet(testKeySet(t))
+	authServer.SetHasher(passhash.Bcrypt{Cost: 4})
+	s := grpc.NewServer(grpc.UnaryInterceptor(grpc_auth.UnaryServerInterceptor(authServer.AuthFunc)))
+	pb.RegisterAuthGophkeeperServer(s, authServer)
+	pb.RegisterGophkeeperServer(s, server.GophkeeperServer{DB: store})
+	listen, err := net.Listen("tcp", "localhost:9971")
+	if err != nil {
+		log.Fatal(err)
+	}
+	go s.Serve(listen)
+	defer s.Stop()
+	cfg := &config.ClientConfig{AccountsFile: filepath.Join(t.TempDir(), "accounts.json")}
+	newClient := func() *Client {
+		auth := &Auth{}
+		conn, err := grpc.Dial("localhost:9971", grpc.WithTransportCredentials(insecure.NewCredentials()),
+			grpc.WithUnaryInterceptor(auth.UnaryAuthClientInterceptor))
+		require.NoError(t, err)
+		t.Cleanup(func() { conn.Close() })
+		c := &Client{
+			authClient:   pb.NewAuthGophkeeperClient(conn),
+			serverClient: pb.NewGophkeeperClient(conn),
+			auth:         auth,
+			Config:       cfg,
+			AllData:      []AllData{},
+		}
+		t.Cleanup(func() {
+			if c.auth.stopRefresh != nil {
+				c.auth.stopRefresh()
+			}
+		})
+		return c
+	}
+
+	// first login wraps vault key and ciphers records again
+	c := newClient()
+	require.NoError(t, c.UserLogin(ctx, models.User{Email: legacyUser.Email, Password: "password"}))
+	require.False(t, c.legacy)
+	upgraded, err := store.GetUser(ctx, models.User{Email: legacyUser.Email})
+	require.NoError(t, err)
+	require.Len(t, upgraded.Salt, crypto.SaltSize)
+	require.NotEqual(t, key, upgraded.Secret)
+	kek, _ := crypto.DeriveKeys("password", upgraded.Salt)
+	unwrapped, err := crypto.UnwrapKey(kek, upgraded.Secret)
+	require.NoError(t, err)
+	require.Equal(t, key, unwrapped)
+	stored, err := store.GetCipheredData(ctx, legacyUser.Email, models.DataPage{})
+	require.NoError(t, err)
+	require.Len(t, stored, 1)
+	require.Equal(t, crypto.Version1, stored[0].Data[0])
+
+	// records are read without legacy fallback
+	other := newClient()
+	require.NoError(t, other.UserLogin(ctx, models.User{Email: legacyUser.Email, Password: "password"}))
+	require.False(t, other.legacy)
+	require.NoError(t, other.SyncChanges(ctx))
+	require.Len(t, other.AllData, 1)
+	data, err := unmarshalData(other.AllData[0].Type, other.AllData[0].JData, other.AllData[0].ID)
+	require.NoError(t, err)
+	require.Equal(t, pass, data)
+
+	// server can't force legacy login of account once its salt was seen
+	require.NoError(t, store.UpdateUser(ctx, legacyUser))
+	err = newClient().UserLogin(ctx, models.User{Email: legacyUser.Email, Password: "password"})
+	require.ErrorIs(t, err, ErrLegacyLogin)
+}
@@ -0,0 +1,330 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/MaximkaSha/gophkeeper/internal/crypto"
+	"github.com/MaximkaSha/gophkeeper/internal/models"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ErrOffline returned for operations which need server while client is logged in offline.
+var ErrOffline = errors.New("not available offline")
+
+// ErrNoCache returned by OfflineLogin if there is no cached vault of user.
+var ErrNoCache = errors.New("no offline vault for this user")
+
+// cacheLock - vault key wrapped by key derived from master password, it unlocks cache offline.
+type cacheLock struct {
+	Salt       []byte `json:"salt"`
+	WrappedKey []byte `json:"wrapped_key"`
+}
+
+// cacheFile - vault cache on disk, only vault itself is encrypted.
+type cacheFile struct {
+	Email string    `json:"email"`
+	Lock  cacheLock `json:"lock"`
+	// Number of changes not sent yet, cache of other user is not overwritten while it has some.
+	Queued int `json:"queued"`
+	// cachedVault encrypted by vault key.
+	Vault []byte `json:"vault"`
+}
+
+// cachedVault - synced vault and changes not sent yet.
+type cachedVault struct {
+	Revision int64         `json:"revision"`
+	Records  []AllData     `json:"records"`
+	Outbox   []outboxEntry `json:"outbox"`
+}
+
+// outboxEntry - change of record made while server was not reachable, see ReplayOutbox.
+type outboxEntry struct {
+	ID   string `json:"id"`
+	Type string `json:"type,omitempty"`
+	// Plain json of record, empty for deletion.
+	Data    []byte `json:"data,omitempty"`
+	Deleted bool   `json:"deleted,omitempty"`
+	// Revision of record change is based on, server detects conflicts by it.
+	Revision int64 `json:"revision"`
+}
+
+// record returns changed record, nil for deletion.
+func (e outboxEntry) record() (models.Dater, error) {
+	if e.Deleted {
+		return nil, nil
+	}
+	return unmarshalData(e.Type, e.Data, e.ID)
+}
+
+// Offline reports whether client is logged in offline by OfflineLogin.
+func (c *Client) Offline() bool {
+	return c.offline
+}
+
+// Queued returns number of changes not sent to server yet.
+func (c *Client) Queued() int {
+	return len(c.outbox)
+}
+
+// OfflineLogin - unlock vault cached by last online login when server is not reachable.
+// Master password is checked by unwrapping vault key from cache.
+// Changes are queued till next online login, files can't be uploaded or downloaded.
+func (c *Client) OfflineLogin(user models.User) error {
+	if c.Config == nil || c.Config.CacheFile == "" {
+		return ErrNoCache
+	}
+	file, err := readCache(c.Config.CacheFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return ErrNoCache
+	}
+	if err != nil {
+		return err
+	}
+	if file.Email != user.Email {
+		return ErrNoCache
+	}
+	kek, _ := crypto.DeriveKeys(user.Password, file.Lock.Salt)
+	secret, err := crypto.UnwrapKey(kek, file.Lock.WrappedKey)
+	if err != nil {
+		return errors.New("wrong password")
+	}
+	c.crypto = *crypto.NewCrypto(secret)
+	c.currentUser = models.User{Email: user.Email}
+	if err := c.restoreCache(file); err != nil {
+		return err
+	}
+	c.lock = &file.Lock
+	c.offline = true
+	return nil
+}
+
+// ReplayOutbox - send changes queued while server was not reachable in order they were made.
+// Records changed on server meanwhile are returned as conflicts,
+// their changes stay queued till resolved by ResolveConflict or KeepTheirs.
+func (c *Client) ReplayOutbox(ctx context.Context) ([]*ConflictError, error) {
+	if c.offline {
+		return nil, ErrOffline
+	}
+	conflicts := []*ConflictError{}
+	for _, entry := range append([]outboxEntry{}, c.outbox...) {
+		data, err := entry.record()
+		if err != nil {
+			return conflicts, err
+		}
+		err = c.send(ctx, entry.ID, data, entry.Revision)
+		var conflict *ConflictError
+		if errors.As(err, &conflict) {
+			conflicts = append(conflicts, conflict)
+			continue
+		}
+		if err != nil {
+			return conflicts, err
+		}
+		c.dequeue(entry.ID)
+		if err := c.saveCache(); err != nil {
+			return conflicts, err
+		}
+	}
+	return conflicts, nil
+}
+
+// push saves record, or deletes it if data is nil, change is based on given revision.
+// Change is queued if server is not reachable and cache is enabled.
+func (c *Client) push(ctx context.Context, id string, data models.Dater, expected int64) error {
+	if !c.offline {
+		err := c.send(ctx, id, data, expected)
+		if err == nil {
+			c.dequeue(id)
+			c.persistCache()
+			return nil
+		}
+		if status.Code(err) != codes.Unavailable || !c.cacheEnabled() {
+			return err
+		}
+		log.Println("server is not reachable, change is queued: ", err)
+	}
+	return c.queue(ctx, id, data, expected)
+}
+
+// send saves record on server, or deletes it if data is nil.
+func (c *Client) send(ctx context.Context, id string, data models.Dater, expected int64) error {
+	if data == nil {
+		return c.deleteData(ctx, id, expected)
+	}
+	return c.saveData(ctx, data, expected)
+}
+
+// queue applies change to AllData and appends it to outbox, cache is saved before return.
+// Later change of record replaces earlier one.
+func (c *Client) queue(ctx context.Context, id string, data models.Dater, expected int64) error {
+	entry := outboxEntry{ID: id, Deleted: data == nil, Revision: expected}
+	if data != nil {
+		entry.Type, entry.Data = data.Type(), data.GetData()
+		c.AddDataToLocalStorage(ctx, data)
+	} else {
+		c.DelFromLocalStorage(id)
+	}
+	c.dequeue(id)
+	c.outbox = append(c.outbox, entry)
+	return c.saveCache()
+}
+
+// dequeue removes queued change of record.
+func (c *Client) dequeue(id string) {
+	outbox := c.outbox[:0]
+	for _, entry := range c.outbox {
+		if entry.ID != id {
+			outbox = append(outbox, entry)
+		}
+	}
+	c.outbox = outbox
+}
+
+// baseRevision returns revision change of record is based on.
+// Queued change keeps revision it was based on, though record may be synced after it.
+func (c *Client) baseRevision(id string) int64 {
+	for _, entry := range c.outbox {
+		if entry.ID == id {
+			return entry.Revision
+		}
+	}
+	return c.revisionOf(id)
+}
+
+// applyOutbox applies queued changes over synced records of AllData.
+func (c *Client) applyOutbox(ctx context.Context) error {
+	for _, entry := range c.outbox {
+		data, err := entry.record()
+		if err != nil {
+			return err
+		}
+		if data == nil {
+			c.DelFromLocalStorage(entry.ID)
+			continue
+		}
+		c.AddDataToLocalStorage(ctx, data)
+	}
+	return nil
+}
+
+// cacheEnabled reports whether vault is cached, it needs cache file and master password derived lock.
+// Accounts registered before zero-knowledge keys have no lock.
+func (c *Client) cacheEnabled() bool {
+	return c.Config != nil && c.Config.CacheFile != "" && c.lock != nil
+}
+
+// cacheAD returns additional data which binds cached vault to user.
+func cacheAD(email string) []byte {
+	return recordAD("", "CACHE", email)
+}
+
+// saveCache writes vault and outbox to cache file.
+// File is replaced atomically, so crash never leaves partially written cache.
+func (c *Client) saveCache() error {
+	if !c.cacheEnabled() {
+		return nil
+	}
+	vault, err := json.Marshal(cachedVault{Revision: c.revision, Records: c.AllData, Outbox: c.outbox})
+	if err != nil {
+		return err
+	}
+	file := cacheFile{Email: c.currentUser.Email, Lock: *c.lock, Queued: len(c.outbox)}
+	file.Vault, err = c.crypto.EncryptWithAD(vault, cacheAD(c.currentUser.Email))
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(file)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(c.Config.CacheFile, data)
+}
+
+// persistCache saves cache after change already saved on server, error is only logged.
+func (c *Client) persistCache() {
+	if err := c.saveCache(); err != nil {
+		log.Println("cache error: ", err)
+	}
+}
+
+// loadCache restores vault and outbox of current user from cache after online login,
+// so only later changes are synced. Cache of other user with changes not sent yet is kept,
+// caching is disabled till next login then.
+func (c *Client) loadCache() {
+	if !c.cacheEnabled() {
+		return
+	}
+	file, err := readCache(c.Config.CacheFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return
+	}
+	if err != nil {
+		log.Println("cache error: ", err)
+		return
+	}
+	if file.Email != c.currentUser.Email {
+		if file.Queued > 0 {
+			log.Printf("cache keeps %d changes of other user, vault is not cached", file.Queued)
+			c.lock = nil
+		}
+		return
+	}
+	if err := c.restoreCache(file); err != nil {
+		log.Println("cache error: ", err)
+	}
+}
+
+// restoreCache decrypts cached vault and replaces AllData, synced revision and outbox by it.
+func (c *Client) restoreCache(file cacheFile) error {
+	plain, err := c.crypto.DecryptWithAD(file.Vault, cacheAD(file.Email))
+	if err != nil {
+		return err
+	}
+	vault := cachedVault{}
+	if err := json.Unmarshal(plain, &vault); err != nil {
+		return err
+	}
+	if vault.Records == nil {
+		vault.Records = []AllData{}
+	}
+	c.AllData, c.revision, c.outbox = vault.Records, vault.Revision, vault.Outbox
+	return nil
+}
+
+// readCache reads cache file.
+func readCache(path string) (cacheFile, error) {
+	file := cacheFile{}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return file, err
+	}
+	err = json.Unmarshal(data, &file)
+	return file, err
+}
+
+// writeFileAtomic writes data to temporary file readable only by user and renames it to path.
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,126 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/MaximkaSha/gophkeeper/internal/config"
+	"github.com/MaximkaSha/gophkeeper/internal/crypto"
+	"github.com/MaximkaSha/gophkeeper/internal/models"
+	pb "github.com/MaximkaSha/gophkeeper/internal/proto"
+	"github.com/MaximkaSha/gophkeeper/internal/server"
+	"github.com/MaximkaSha/gophkeeper/internal/storage"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+func TestClient_Offline(t *testing.T) {
+	transferBackoff = time.Millisecond
+	t.Cleanup(func() { transferBackoff = time.Second })
+	store := storage.NewMemory()
+	require.NoError(t, store.AddUser(context.Background(), models.User{Email: "test@test.com", Password: "hash"}))
+	serve := func() *grpc.Server {
+		s := grpc.NewServer(grpc.UnaryInterceptor(authInterceptor("test@test.com")))
+		pb.RegisterGophkeeperServer(s, server.GophkeeperServer{DB: store})
+		listen, err := net.Listen("tcp", "localhost:9974")
+		if err != nil {
+			log.Fatal(err)
+		}
+		go s.Serve(listen)
+		return s
+	}
+	connect := func() pb.GophkeeperClient {
+		conn, err := grpc.Dial("localhost:9974", grpc.WithTransportCredentials(insecure.NewCredentials()))
+		require.NoError(t, err)
+		t.Cleanup(func() { conn.Close() })
+		return pb.NewGophkeeperClient(conn)
+	}
+	s := serve()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// vault key wrapped by master password as on login
+	salt, err := crypto.GenSalt()
+	require.NoError(t, err)
+	kek, _ := crypto.DeriveKeys("password", salt)
+	key, err := crypto.GenKey()
+	require.NoError(t, err)
+	wrapped, err := crypto.WrapKey(kek, key)
+	require.NoError(t, err)
+	cfg := &config.ClientConfig{CacheFile: filepath.Join(t.TempDir(), "vault.cache")}
+	newClient := func() *Client {
+		return &Client{
+			serverClient: connect(),
+			crypto:       *crypto.NewCrypto(key),
+			currentUser:  models.User{Email: "test@test.com"},
+			Config:       cfg,
+			lock:         &cacheLock{Salt: salt, WrappedKey: wrapped},
+			AllData:      []AllData{},
+		}
+	}
+	first, other := newClient(), newClient()
+	// other device has its own cache
+	other.Config = nil
+	require.NoError(t, first.AddData(ctx, models.Text{Data: "first", Tag: "secret tag", ID: "111-111-111"}))
+	require.NoError(t, first.AddData(ctx, models.Text{Data: "second", ID: "222-222-222"}))
+	require.NoError(t, first.AddData(ctx, models.Text{Data: "third", ID: "333-333-333"}))
+	// vault is cached encrypted
+	cached, err := os.ReadFile(cfg.CacheFile)
+	require.NoError(t, err)
+	require.False(t, bytes.Contains(cached, []byte("secret tag")))
+
+	// changes are queued while server is not reachable
+	s.Stop()
+	require.NoError(t, first.AddData(ctx, models.Text{Data: "first changed", ID: "111-111-111"}))
+	require.NoError(t, first.AddData(ctx, models.Text{Data: "new", ID: "444-444-444"}))
+	require.NoError(t, first.DelData(ctx, "222-222-222"))
+	require.Equal(t, 3, first.Queued())
+	require.Len(t, first.AllData, 3)
+	// file content is not queued
+	require.Error(t, first.AddData(ctx, models.Data{Data: []byte("file"), ID: "555-555-555"}))
+	require.Equal(t, 3, first.Queued())
+
+	// cached vault is browsed and changed offline
+	offline := &Client{Config: cfg}
+	require.Error(t, offline.OfflineLogin(models.User{Email: "test@test.com", Password: "wrong"}))
+	require.ErrorIs(t, offline.OfflineLogin(models.User{Email: "other@test.com", Password: "password"}), ErrNoCache)
+	require.NoError(t, offline.OfflineLogin(models.User{Email: "test@test.com", Password: "password"}))
+	require.True(t, offline.Offline())
+	require.Equal(t, first.AllData, offline.AllData)
+	require.Equal(t, 3, offline.Queued())
+	require.NoError(t, offline.AddData(ctx, models.Text{Data: "third changed", ID: "333-333-333"}))
+	require.ErrorIs(t, offline.AddData(ctx, models.Data{Data: []byte("file"), ID: "555-555-555"}), ErrOffline)
+	_, err = offline.ReplayOutbox(ctx)
+	require.ErrorIs(t, err, ErrOffline)
+
+	// queued changes are replayed when server is reachable, changed record is conflict
+	s = serve()
+	defer s.Stop()
+	require.NoError(t, other.SyncChanges(ctx))
+	require.NoError(t, other.AddData(ctx, models.Text{Data: "first by other", ID: "111-111-111"}))
+	online := newClient()
+	online.loadCache()
+	require.Equal(t, 4, online.Queued())
+	conflicts, err := online.ReplayOutbox(ctx)
+	require.NoError(t, err)
+	require.Len(t, conflicts, 1)
+	require.Equal(t, models.Text{Data: "first changed", ID: "111-111-111"}, conflicts[0].Mine)
+	require.Equal(t, models.Text{Data: "first by other", ID: "111-111-111"}, conflicts[0].Theirs)
+	require.Equal(t, 1, online.Queued())
+	require.NoError(t, online.ResolveConflict(ctx, conflicts[0].Mine, conflicts[0]))
+	require.Equal(t, 0, online.Queued())
+
+	require.NoError(t, other.SyncChanges(ctx))
+	require.ElementsMatch(t, online.AllData, other.AllData)
+	require.Len(t, other.AllData, 3)
+	stored, err := store.GetCipheredData(ctx, "test@test.com", models.DataPage{})
+	require.NoError(t, err)
+	require.Len(t, stored, 3)
+}
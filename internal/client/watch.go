@@ -0,0 +1,50 @@
+package client
+
+import (
+	"context"
+	"io"
+
+	pb "github.com/MaximkaSha/gophkeeper/internal/proto"
+)
+
+// Watch - subscribe to changes of vault made after given revision, onEvent is called with each pushed batch of changes.
+// Lost subscription is resumed from the last received revision, so no change is missed.
+// Returns when ctx is done or on error which can not be retried.
+func (c *Client) Watch(ctx context.Context, since int64, onEvent func(*pb.WatchEvent)) error {
+	for attempt := 0; ; attempt++ {
+		next, err := c.watch(ctx, since, onEvent)
+		if next > since {
+			attempt = 0
+		}
+		since = next
+		if err != io.EOF && !retryable(err) {
+			return err
+		}
+		// subscription is kept, pauses just stop growing
+		if attempt > transferRetries {
+			attempt = transferRetries
+		}
+		if err = waitRetry(ctx, attempt); err != nil {
+			return err
+		}
+	}
+}
+
+// watch calls onEvent for events received by one stream.
+// Returns revision of the last received event.
+func (c *Client) watch(ctx context.Context, since int64, onEvent func(*pb.WatchEvent)) (int64, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stream, err := c.serverClient.Watch(ctx, &pb.WatchRequest{SinceRevision: since})
+	if err != nil {
+		return since, err
+	}
+	for {
+		event, err := stream.Recv()
+		if err != nil {
+			return since, err
+		}
+		onEvent(event)
+		since = event.Revision
+	}
+}
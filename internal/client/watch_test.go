@@ -0,0 +1,88 @@
+package client
+
+import (
+	"context"
+	"log"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/MaximkaSha/gophkeeper/internal/crypto"
+	"github.com/MaximkaSha/gophkeeper/internal/models"
+	pb "github.com/MaximkaSha/gophkeeper/internal/proto"
+	"github.com/MaximkaSha/gophkeeper/internal/server"
+	"github.com/MaximkaSha/gophkeeper/internal/storage"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+func TestClient_Watch(t *testing.T) {
+	transferBackoff = time.Millisecond
+	t.Cleanup(func() { transferBackoff = time.Second })
+	store := storage.NewMemory()
+	require.NoError(t, store.AddUser(context.Background(), models.User{Email: "test@test.com", Password: "hash"}))
+	var messages int32
+	auth := streamAuthInterceptor("test@test.com")
+	s := grpc.NewServer(grpc.UnaryInterceptor(authInterceptor("test@test.com")),
+		grpc.StreamInterceptor(func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+			return auth(srv, &brokenStream{ServerStream: ss, messages: &messages}, info, handler)
+		}))
+	pb.RegisterGophkeeperServer(s, server.GophkeeperServer{DB: store, Changes: server.NewChangeHub()})
+	listen, err := net.Listen("tcp", "localhost:9976")
+	if err != nil {
+		log.Fatal(err)
+	}
+	go s.Serve(listen)
+	defer s.Stop()
+	conn, err := grpc.Dial("localhost:9976", grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	defer conn.Close()
+	// two devices of one user
+	newClient := func() *Client {
+		return &Client{
+			serverClient: pb.NewGophkeeperClient(conn),
+			crypto:       *crypto.NewCrypto([]byte("12345678123456781234567812345678")),
+			currentUser:  models.User{Email: "test@test.com"},
+		}
+	}
+	first, second := newClient(), newClient()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	require.NoError(t, first.AddData(ctx, models.Text{Data: "first", ID: "111-111-111"}))
+	require.NoError(t, second.GetAllDataFromDB(ctx))
+	require.NoError(t, first.AddData(ctx, models.Text{Data: "second", ID: "222-222-222"}))
+
+	// request and first event are received before connection is lost
+	atomic.StoreInt32(&messages, 3)
+	watchCtx, stop := context.WithCancel(ctx)
+	events := make(chan *pb.WatchEvent, 10)
+	done := make(chan error)
+	go func() {
+		done <- second.Watch(watchCtx, second.Revision(), func(event *pb.WatchEvent) { events <- event })
+	}()
+	event := <-events
+	require.Equal(t, int64(2), event.Revision)
+	require.NoError(t, second.ApplyChanges(ctx, event.Changes, event.Revision))
+
+	// watch is resumed after the last received revision
+	require.NoError(t, first.AddData(ctx, models.Text{Data: "third", ID: "333-333-333"}))
+	event = <-events
+	require.Negative(t, atomic.LoadInt32(&messages))
+	require.Equal(t, int64(3), event.Revision)
+	require.Len(t, event.Changes, 1)
+	require.NoError(t, second.ApplyChanges(ctx, event.Changes, event.Revision))
+	require.NoError(t, first.DelData(ctx, "111-111-111"))
+	event = <-events
+	require.NoError(t, second.ApplyChanges(ctx, event.Changes, event.Revision))
+	require.Len(t, second.AllData, 2)
+	require.Equal(t, int64(4), second.Revision())
+
+	stop()
+	require.Equal(t, codes.Canceled, status.Code(<-done))
+	require.Empty(t, events)
+}
@@ -1,20 +1,53 @@
 // Package config manage server and client configurations.
 package config
 
-import "github.com/spf13/viper"
+import (
+	"time"
+
+	"github.com/spf13/viper"
+)
 
 // ServerConfig - Server configuration structure.
 type ServerConfig struct {
 	// Host:port of server.
 	Addr string
-	// DSN of database.
+	// DSN of database: postgres DSN, "sqlite://<path>" for embedded SQLite file
+	// or "memory://" for in-memory storage which is lost on exit.
 	DSN string
 	// Path to certificate file.
 	CertFile string
 	// Path to certificate key file.
 	CertKey string
-	// JWT key string.
-	JWTSecret string
+	// Directory of JWT signing and verification keys.
+	JWTKeysDir string
+	// Lifetime of access token.
+	AccessTokenTTL time.Duration
+	// Lifetime of login session, refresh token can't outlive its session.
+	SessionTTL time.Duration
+	// Time between account deletion request and purge of account data, deletion can be undone meanwhile.
+	AccountDeletionGrace time.Duration
+	// Time after last received chunk when unfinished file upload is removed.
+	UploadTTL time.Duration
+	// Store of file chunks: file:///path or s3://access:secret@host/bucket, kept in database if empty.
+	BlobStore string
+	// Number of previous versions kept for each record, no limit if 0.
+	HistoryRevisions int
+	// Time previous version of record is kept after it is replaced, no limit if 0.
+	HistoryTTL time.Duration
+	// Secret of fake salts returned for unknown emails, so salt doesn't tell whether account exists.
+	// Random key is used if empty, fake salts change on restart then.
+	SaltKey string
+	// Algorithm of password hashes: "argon2id" or "bcrypt".
+	// Hashes made by other algorithm or parameters are upgraded on login.
+	PasswordHash string
+	// Cost of bcrypt hashes.
+	BcryptCost int
+	// Argon2id passes.
+	Argon2Time uint32
+	// Argon2id memory in KiB.
+	Argon2Memory uint32
+	// Argon2id parallelism.
+	Argon2Threads uint8
 }
 
 // NewServerConfig - ServerConfig constructor.
@@ -24,14 +57,43 @@ func NewServerConfig() *ServerConfig {
 	viper.AddConfigPath("./")
 	viper.SetConfigName("config_server")
 	viper.SetConfigType("json")
+	viper.SetDefault("accesstokenttl", "1m")
+	viper.SetDefault("sessionttl", "720h")
+	viper.SetDefault("accountdeletiongrace", "168h")
+	viper.SetDefault("uploadttl", "24h")
+	viper.SetDefault("historyrevisions", 10)
+	viper.SetDefault("historyttl", "2160h")
+	viper.SetDefault("jwtkeysdir", "jwtkeys")
+	viper.SetDefault("passwordhash", "argon2id")
+	viper.SetDefault("bcryptcost", 14)
+	viper.SetDefault("argon2time", 3)
+	viper.SetDefault("argon2memory", 64*1024)
+	viper.SetDefault("argon2threads", 4)
 	viper.ReadInConfig()
 
 	return &ServerConfig{
-		Addr:      viper.GetString("addr"),
-		DSN:       viper.GetString("dsn"),
-		CertFile:  viper.GetString("certfile"),
-		CertKey:   viper.GetString("certkey"),
-		JWTSecret: viper.GetString("jwtsecret"),
+		Addr:       viper.GetString("addr"),
+		DSN:        viper.GetString("dsn"),
+		CertFile:   viper.GetString("certfile"),
+		CertKey:    viper.GetString("certkey"),
+		JWTKeysDir: viper.GetString("jwtkeysdir"),
+
+		AccessTokenTTL: viper.GetDuration("accesstokenttl"),
+		SessionTTL:     viper.GetDuration("sessionttl"),
+
+		AccountDeletionGrace: viper.GetDuration("accountdeletiongrace"),
+		UploadTTL:            viper.GetDuration("uploadttl"),
+		BlobStore:            viper.GetString("blobstore"),
+
+		HistoryRevisions: viper.GetInt("historyrevisions"),
+		HistoryTTL:       viper.GetDuration("historyttl"),
+
+		SaltKey:       viper.GetString("saltkey"),
+		PasswordHash:  viper.GetString("passwordhash"),
+		BcryptCost:    viper.GetInt("bcryptcost"),
+		Argon2Time:    viper.GetUint32("argon2time"),
+		Argon2Memory:  viper.GetUint32("argon2memory"),
+		Argon2Threads: uint8(viper.GetUint("argon2threads")),
 	}
 }
 
@@ -41,6 +103,10 @@ type ClientConfig struct {
 	Addr string
 	// Path to certificate file.
 	CertFile string
+	// Path to encrypted vault cache, vault is not available offline if empty.
+	CacheFile string
+	// Path to list of accounts with zero-knowledge keys, their legacy login is refused.
+	AccountsFile string
 }
 
 // NewClientConfig ClientConfig constructor.
@@ -53,7 +119,9 @@ func NewClientConfig() *ClientConfig {
 	viper.ReadInConfig()
 
 	return &ClientConfig{
-		Addr:     viper.GetString("addr"),
-		CertFile: viper.GetString("certfile"),
+		Addr:         viper.GetString("addr"),
+		CertFile:     viper.GetString("certfile"),
+		CacheFile:    viper.GetString("cachefile"),
+		AccountsFile: viper.GetString("accountsfile"),
 	}
 }
@@ -2,7 +2,47 @@
 package crypto
 
 import (
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+
 	crypto "github.com/ddulesov/gogost/gost3412128"
+	"github.com/ddulesov/gogost/mgm"
+	"golang.org/x/crypto/argon2"
+)
+
+// Envelope layout (version 1):
+//
+//	| version (1 byte) | nonce (16 bytes) | ciphertext | MGM tag (16 bytes) |
+//
+// Data written before envelopes were introduced is plain Kuznechik in ECB mode
+// padded with zeros and has no header at all, see DecryptLegacy.
+const (
+	// Version1 - Kuznechik-MGM envelope.
+	Version1 byte = 0x01
+	// nonceSize - MGM nonce size, equal to the cipher block size.
+	nonceSize = crypto.BlockSize
+	// tagSize - MGM authentication tag size.
+	tagSize = crypto.BlockSize
+	// headerSize - version byte and nonce.
+	headerSize = 1 + nonceSize
+	// KeySize - size of vault and key-encryption keys.
+	KeySize = 32
+	// SaltSize - size of per-user KDF salt.
+	SaltSize = 16
+)
+
+// Argon2id parameters used to derive keys from master password.
+const (
+	argonTime    = 3
+	argonMemory  = 64 * 1024
+	argonThreads = 4
+)
+
+var (
+	// ErrDecrypt returned when ciphertext is malformed or was tampered with.
+	ErrDecrypt = errors.New("crypto: message authentication failed")
 )
 
 // Crypto struct - ciphering class.
@@ -18,22 +58,95 @@ func NewCrypto(key []byte) *Crypto {
 	}
 }
 
+// aead returns new MGM instance. MGM keeps internal buffers,
+// so it is created for every operation to keep Crypto safe for concurrent use.
+func (c *Crypto) aead() (cipher.AEAD, error) {
+	return mgm.NewMGM(&c.Crypto, tagSize)
+}
+
 // Encrypt data.
-func (c *Crypto) Encrypt(data []byte) []byte {
-	dst := make([]byte, len(data))
-	if a := len(data) % c.Crypto.BlockSize(); a != 0 {
-		dst = append(dst, make([]byte, (c.Crypto.BlockSize()-a))...)
-		data = append(data, make([]byte, (c.Crypto.BlockSize()-a))...)
+// Returns versioned envelope with random nonce and authentication tag.
+func (c *Crypto) Encrypt(data []byte) ([]byte, error) {
+	return c.EncryptWithAD(data, nil)
+}
+
+// EncryptWithAD encrypts data and authenticates additional data.
+// Additional data is not stored in envelope, the same value must be passed to DecryptWithAD.
+func (c *Crypto) EncryptWithAD(data []byte, ad []byte) ([]byte, error) {
+	aead, err := c.aead()
+	if err != nil {
+		return nil, err
 	}
-	for i := 0; i < len(data); i += 16 {
-		c.Crypto.Encrypt(dst[i:c.Crypto.BlockSize()+i], data[i:c.Crypto.BlockSize()+i])
+	header := make([]byte, headerSize, headerSize+len(data)+tagSize)
+	header[0] = Version1
+	nonce := header[1:headerSize]
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
 	}
-	return dst
-
+	// MGM requires the highest bit of nonce to be zero.
+	nonce[0] &= 0x7f
+	// Header is authenticated as additional data, so version and nonce can't be changed.
+	return aead.Seal(header, nonce, data, additionalData(header, ad)), nil
 }
 
 // Decrypt data.
-func (c *Crypto) Decrypt(data []byte) []byte {
+// Envelopes are authenticated, ErrDecrypt returned if data was changed.
+func (c *Crypto) Decrypt(data []byte) ([]byte, error) {
+	return c.DecryptWithAD(data, nil)
+}
+
+// DecryptWithAD decrypts versioned envelope and checks additional data.
+// Legacy ciphertext is rejected, it is read only by DecryptLegacy.
+func (c *Crypto) DecryptWithAD(data []byte, ad []byte) ([]byte, error) {
+	if len(data) < headerSize+tagSize || data[0] != Version1 {
+		return nil, ErrDecrypt
+	}
+	return c.open(data, ad)
+}
+
+// DecryptLegacy decrypts data ciphered in legacy ECB mode.
+// Legacy ciphertext has no header and no authentication, it can't be told from envelope
+// by its bytes, so caller must know data was ciphered before envelopes were introduced.
+func (c *Crypto) DecryptLegacy(data []byte) ([]byte, error) {
+	if !IsLegacy(data) {
+		return nil, ErrDecrypt
+	}
+	return c.decryptLegacy(data), nil
+}
+
+// open checks and decrypts envelope.
+func (c *Crypto) open(data []byte, ad []byte) ([]byte, error) {
+	aead, err := c.aead()
+	if err != nil {
+		return nil, err
+	}
+	header := data[:headerSize]
+	// MGM panics on nonce with the highest bit set, it is never written so data is malformed.
+	if header[1]&0x80 != 0 {
+		return nil, ErrDecrypt
+	}
+	plain, err := aead.Open(make([]byte, 0, len(data)-headerSize-tagSize), header[1:], data[headerSize:], additionalData(header, ad))
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+	return plain, nil
+}
+
+// additionalData joins envelope header and caller's additional data.
+func additionalData(header []byte, ad []byte) []byte {
+	if len(ad) == 0 {
+		return header
+	}
+	return append(append(make([]byte, 0, len(header)+len(ad)), header...), ad...)
+}
+
+// IsLegacy reports whether data could be ciphered in legacy ECB mode.
+func IsLegacy(data []byte) bool {
+	return len(data) > 0 && len(data)%crypto.BlockSize == 0
+}
+
+// decryptLegacy decrypts ECB data and strips zero padding.
+func (c *Crypto) decryptLegacy(data []byte) []byte {
 	dst := make([]byte, len(data))
 	for i := 0; i < len(data); i += c.Crypto.BlockSize() {
 		c.Crypto.Decrypt(dst[i:c.Crypto.BlockSize()+i], data[i:c.Crypto.BlockSize()+i])
@@ -49,3 +162,39 @@ func (c *Crypto) Decrypt(data []byte) []byte {
 	dst = dst[:len(dst)-int(counter)]
 	return dst
 }
+
+// GenKey generates random vault key.
+func GenKey() ([]byte, error) {
+	return genRandom(KeySize)
+}
+
+// GenSalt generates random per-user KDF salt.
+func GenSalt() ([]byte, error) {
+	return genRandom(SaltSize)
+}
+
+func genRandom(size int) ([]byte, error) {
+	data := make([]byte, size)
+	_, err := rand.Read(data)
+	if err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// DeriveKeys derives key-encryption key and authentication key from master password (Argon2id).
+// KEK never leaves the client, auth key is sent to server instead of password.
+func DeriveKeys(password string, salt []byte) (kek []byte, authKey string) {
+	key := argon2.IDKey([]byte(password), salt, argonTime, argonMemory, argonThreads, 2*KeySize)
+	return key[:KeySize], hex.EncodeToString(key[KeySize:])
+}
+
+// WrapKey encrypts vault key with key-encryption key.
+func WrapKey(kek []byte, key []byte) ([]byte, error) {
+	return NewCrypto(kek).Encrypt(key)
+}
+
+// UnwrapKey decrypts vault key with key-encryption key.
+func UnwrapKey(kek []byte, wrapped []byte) ([]byte, error) {
+	return NewCrypto(kek).Decrypt(wrapped)
+}
@@ -1,6 +1,9 @@
 package crypto
 
 import (
+	"encoding/hex"
+	"fmt"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -22,14 +25,231 @@ func TestCrypto_Encrypt(t *testing.T) {
 				data: []byte("plain text"),
 			},
 		},
+		{
+			name: "trailing zeros",
+			c:    NewCrypto([]byte("12345678123456781234567812345678")),
+			args: args{
+				data: []byte{0x01, 0x02, 0x00, 0x00, 0x00},
+			},
+		},
+		{
+			name: "block size",
+			c:    NewCrypto([]byte("12345678123456781234567812345678")),
+			args: args{
+				data: []byte("1234567812345678"),
+			},
+		},
+		{
+			name: "empty",
+			c:    NewCrypto([]byte("12345678123456781234567812345678")),
+			args: args{
+				data: []byte{},
+			},
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			ciphered := tt.c.Encrypt(tt.args.data)
+			ciphered, err := tt.c.Encrypt(tt.args.data)
+			require.NoError(t, err)
 			require.NotEqual(t, ciphered, tt.args.data)
-			plain := tt.c.Decrypt(ciphered)
-			require.Equal(t, plain, tt.args.data)
+			require.Equal(t, Version1, ciphered[0])
+			plain, err := tt.c.Decrypt(ciphered)
+			require.NoError(t, err)
+			require.Equal(t, tt.args.data, plain)
+			ciphered2, err := tt.c.Encrypt(tt.args.data)
+			require.NoError(t, err)
+			require.NotEqual(t, ciphered, ciphered2)
+		})
+	}
+}
+
+func TestCrypto_Decrypt(t *testing.T) {
+	// envelope of 15 and 31 byte plain text has length of legacy ciphertext
+	for _, size := range []int{10, 15, 31} {
+		t.Run(fmt.Sprintf("%d bytes", size), func(t *testing.T) {
+			testDecrypt(t, size)
+		})
+	}
+}
+
+func testDecrypt(t *testing.T, size int) {
+	c := NewCrypto([]byte("12345678123456781234567812345678"))
+	plain := []byte(strings.Repeat("p", size))
+	ciphered, err := c.Encrypt(plain)
+	require.NoError(t, err)
+	require.Equal(t, size == 10, !IsLegacy(ciphered))
+	tests := []struct {
+		name   string
+		modify func([]byte) []byte
+	}{
+		{
+			name: "tag",
+			modify: func(b []byte) []byte {
+				b[len(b)-1] ^= 0x01
+				return b
+			},
+		},
+		{
+			name: "ciphertext",
+			modify: func(b []byte) []byte {
+				b[headerSize] ^= 0x01
+				return b
+			},
+		},
+		{
+			name: "nonce",
+			modify: func(b []byte) []byte {
+				b[2] ^= 0x01
+				return b
+			},
+		},
+		{
+			name: "nonce high bit",
+			modify: func(b []byte) []byte {
+				b[1] |= 0x80
+				return b
+			},
+		},
+		{
+			name: "truncated",
+			modify: func(b []byte) []byte {
+				return b[:len(b)-3]
+			},
+		},
+		{
+			name: "short",
+			modify: func(b []byte) []byte {
+				return b[:5]
+			},
+		},
+		{
+			name: "wrong key",
+			modify: func(b []byte) []byte {
+				c2 := NewCrypto([]byte("87654321876543218765432187654321"))
+				b, _ = c2.Encrypt(plain)
+				return b
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := tt.modify(append([]byte{}, ciphered...))
+			_, err := c.Decrypt(data)
+			require.ErrorIs(t, err, ErrDecrypt)
+		})
+	}
+}
+
+func TestCrypto_DecryptLegacy(t *testing.T) {
+	c := NewCrypto([]byte("12345678123456781234567812345678"))
+	plain := []byte(`{"login":"login","password":"password"}`)
+	data := append([]byte{}, plain...)
+	if a := len(data) % c.Crypto.BlockSize(); a != 0 {
+		data = append(data, make([]byte, c.Crypto.BlockSize()-a)...)
+	}
+	legacy := make([]byte, len(data))
+	for i := 0; i < len(data); i += c.Crypto.BlockSize() {
+		c.Crypto.Encrypt(legacy[i:i+c.Crypto.BlockSize()], data[i:i+c.Crypto.BlockSize()])
+	}
+	require.True(t, IsLegacy(legacy))
+	got, err := c.DecryptLegacy(legacy)
+	require.NoError(t, err)
+	require.Equal(t, plain, got)
+	// legacy ciphertext is read only explicitly, even if it starts with version byte
+	legacy[0] = Version1
+	_, err = c.Decrypt(legacy)
+	require.ErrorIs(t, err, ErrDecrypt)
+	_, err = c.DecryptLegacy(legacy)
+	require.NoError(t, err)
+	_, err = c.DecryptLegacy(legacy[:5])
+	require.ErrorIs(t, err, ErrDecrypt)
+}
 
+func TestGenKey(t *testing.T) {
+	got, err := GenKey()
+	require.NoError(t, err)
+	require.Len(t, got, KeySize)
+	got2, err := GenKey()
+	require.NoError(t, err)
+	require.NotEqual(t, got, got2)
+}
+
+func TestDeriveKeys(t *testing.T) {
+	salt, err := GenSalt()
+	require.NoError(t, err)
+	kek, authKey := DeriveKeys("password", salt)
+	require.Len(t, kek, KeySize)
+	kek2, authKey2 := DeriveKeys("password", salt)
+	require.Equal(t, kek, kek2)
+	require.Equal(t, authKey, authKey2)
+	require.NotEqual(t, hex.EncodeToString(kek), authKey)
+	salt2, err := GenSalt()
+	require.NoError(t, err)
+	kek3, authKey3 := DeriveKeys("password", salt2)
+	require.NotEqual(t, kek, kek3)
+	require.NotEqual(t, authKey, authKey3)
+}
+
+func TestWrapKey(t *testing.T) {
+	salt, err := GenSalt()
+	require.NoError(t, err)
+	kek, _ := DeriveKeys("password", salt)
+	key, err := GenKey()
+	require.NoError(t, err)
+	wrapped, err := WrapKey(kek, key)
+	require.NoError(t, err)
+	got, err := UnwrapKey(kek, wrapped)
+	require.NoError(t, err)
+	require.Equal(t, key, got)
+	wrongKek, _ := DeriveKeys("wrong password", salt)
+	_, err = UnwrapKey(wrongKek, wrapped)
+	require.ErrorIs(t, err, ErrDecrypt)
+	_, err = UnwrapKey(kek, key)
+	require.ErrorIs(t, err, ErrDecrypt)
+}
+
+func TestCrypto_EncryptWithAD(t *testing.T) {
+	c := NewCrypto([]byte("12345678123456781234567812345678"))
+	ad := []byte("uuid|PASSWORD|test@test.com")
+	ciphered, err := c.EncryptWithAD([]byte("plain text"), ad)
+	require.NoError(t, err)
+	plain, err := c.DecryptWithAD(ciphered, ad)
+	require.NoError(t, err)
+	require.Equal(t, []byte("plain text"), plain)
+	tests := []struct {
+		name string
+		ad   []byte
+	}{
+		{
+			name: "other ad",
+			ad:   []byte("uuid|TEXT|test@test.com"),
+		},
+		{
+			name: "no ad",
+			ad:   nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := c.DecryptWithAD(ciphered, tt.ad)
+			require.ErrorIs(t, err, ErrDecrypt)
 		})
 	}
 }
+
+func TestCrypto_DecryptWithAD(t *testing.T) {
+	c := NewCrypto([]byte("12345678123456781234567812345678"))
+	ad := []byte("chunk 0")
+	ciphered, err := c.EncryptWithAD([]byte("plain text"), ad)
+	require.NoError(t, err)
+	plain, err := c.DecryptWithAD(ciphered, ad)
+	require.NoError(t, err)
+	require.Equal(t, []byte("plain text"), plain)
+	_, err = c.DecryptWithAD(ciphered, []byte("chunk 1"))
+	require.ErrorIs(t, err, ErrDecrypt)
+	// legacy ciphertext has no authentication and is not accepted
+	_, err = c.DecryptWithAD(make([]byte, 64), ad)
+	require.ErrorIs(t, err, ErrDecrypt)
+	_, err = c.DecryptWithAD(nil, ad)
+	require.ErrorIs(t, err, ErrDecrypt)
+}
@@ -0,0 +1,364 @@
+// Package jwtkeys manages ES256 keys which sign and verify access tokens.
+//
+// Keys are kept in a directory, one pair of PEM files per key:
+//
+//	<kid>.pem - private key, only needed by the service which issues tokens.
+//	<kid>.pub - public key, enough to verify tokens.
+//
+// Key id is generated from creation time, so the newest active private key is used to sign
+// and every public key in directory is accepted during rotation window.
+// Rotated key is published before it signs: it becomes active after all replicas reloaded keys.
+package jwtkeys
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+const (
+	privateExt = ".pem"
+	publicExt  = ".pub"
+	// notBeforeHeader - PEM header of private key with time it starts signing.
+	notBeforeHeader = "Not-Before"
+	// DefaultKeep - number of keys kept by rotation: new key and previous one,
+	// so tokens signed just before rotation stay valid.
+	DefaultKeep = 2
+	// ReloadInterval - how often running services re-read keys directory.
+	ReloadInterval = time.Minute
+	// DefaultActivation - delay before rotated key signs tokens, one reload interval
+	// and margin for clocks of replicas, so every replica can verify them.
+	DefaultActivation = 2 * ReloadInterval
+)
+
+var (
+	// ErrNoSigningKey returned when key set has no private key.
+	ErrNoSigningKey = errors.New("jwtkeys: no signing key")
+	// ErrUnknownKey returned when token is signed by key which is not in set.
+	ErrUnknownKey = errors.New("jwtkeys: unknown key id")
+	// ErrAlgorithm returned when token is not signed with ES256.
+	ErrAlgorithm = errors.New("jwtkeys: unexpected signing method")
+	// ErrActiveKey returned on attempt to retire key which signs tokens.
+	ErrActiveKey = errors.New("jwtkeys: can't retire signing key, rotate first")
+)
+
+// Key - ES256 key pair. Private is nil for verification only keys.
+// Key signs tokens not earlier than NotBefore, zero NotBefore means at once.
+type Key struct {
+	ID        string
+	Private   *ecdsa.PrivateKey
+	Public    *ecdsa.PublicKey
+	NotBefore time.Time
+}
+
+// Active reports whether key signs tokens at given time.
+func (k Key) Active(now time.Time) bool {
+	return k.Private != nil && !now.Before(k.NotBefore)
+}
+
+// KeySet - keys known to service. Safe for concurrent use.
+type KeySet struct {
+	mu   sync.RWMutex
+	dir  string
+	keys map[string]*Key
+}
+
+// NewKeySet - constructor of empty in-memory KeySet.
+func NewKeySet() *KeySet {
+	return &KeySet{
+		keys: map[string]*Key{},
+	}
+}
+
+// Load reads keys from directory. Missing directory is an empty key set.
+func Load(dir string) (*KeySet, error) {
+	k := NewKeySet()
+	k.dir = dir
+	err := k.Reload()
+	if err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// Reload re-reads keys directory, so rotated keys are used without restart.
+func (k *KeySet) Reload() error {
+	if k.dir == "" {
+		return nil
+	}
+	keys, err := readDir(k.dir)
+	if err != nil {
+		return err
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.keys = map[string]*Key{}
+	for i := range keys {
+		k.keys[keys[i].ID] = &keys[i]
+	}
+	return nil
+}
+
+// ReloadEvery reloads key set with given interval, errors are logged and old keys are kept.
+func (k *KeySet) ReloadEvery(interval time.Duration) {
+	for range time.Tick(interval) {
+		if err := k.Reload(); err != nil {
+			log.Println("JWT keys reload error: ", err)
+		}
+	}
+}
+
+// Add puts key to the set.
+func (k *KeySet) Add(key *Key) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.keys[key.ID] = key
+}
+
+// Signer returns the newest active key with private part.
+func (k *KeySet) Signer() (*Key, error) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	now := time.Now()
+	var signer *Key
+	for _, key := range k.keys {
+		if key.Active(now) && (signer == nil || key.ID > signer.ID) {
+			signer = key
+		}
+	}
+	if signer == nil {
+		return nil, ErrNoSigningKey
+	}
+	return signer, nil
+}
+
+// Verifier returns public key by key id.
+func (k *KeySet) Verifier(kid string) (*ecdsa.PublicKey, error) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	key, ok := k.keys[kid]
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+	return key.Public, nil
+}
+
+// Sign returns token with given claims signed by current signing key.
+// Key id is placed to "kid" header.
+func (k *KeySet) Sign(claims jwt.Claims) (string, error) {
+	key, err := k.Signer()
+	if err != nil {
+		return "", err
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodES256, claims)
+	token.Header["kid"] = key.ID
+	return token.SignedString(key.Private)
+}
+
+// Keyfunc is jwt.Keyfunc, it selects verification key by "kid" header.
+// Tokens signed with other algorithms are rejected.
+func (k *KeySet) Keyfunc(token *jwt.Token) (interface{}, error) {
+	if token.Method != jwt.SigningMethodES256 {
+		return nil, ErrAlgorithm
+	}
+	kid, _ := token.Header["kid"].(string)
+	return k.Verifier(kid)
+}
+
+// GenerateKey returns new key pair with time based key id.
+func GenerateKey() (*Key, error) {
+	private, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	suffix := make([]byte, 4)
+	_, err = rand.Read(suffix)
+	if err != nil {
+		return nil, err
+	}
+	return &Key{
+		ID:      time.Now().UTC().Format("20060102T150405.000000000Z") + "-" + hex.EncodeToString(suffix),
+		Private: private,
+		Public:  &private.PublicKey,
+	}, nil
+}
+
+// List returns keys of directory, newest first.
+func List(dir string) ([]Key, error) {
+	return readDir(dir)
+}
+
+// Rotate generates new signing key in directory and retires keys
+// except the newest keep ones (new key included).
+// New key signs after activation delay, so services which reload keys meanwhile accept its tokens.
+// Current signing key is kept until then, key of empty directory signs at once.
+// Returns new key and ids of retired keys.
+func Rotate(dir string, keep int, activation time.Duration) (*Key, []string, error) {
+	if keep < 1 {
+		keep = 1
+	}
+	keys, err := readDir(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+	now := time.Now()
+	current := Current(keys, now)
+	key, err := GenerateKey()
+	if err != nil {
+		return nil, nil, err
+	}
+	if current != nil && activation > 0 {
+		key.NotBefore = now.Add(activation).UTC()
+	}
+	err = writeKey(dir, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	retired := []string{}
+	// New key is always kept, it is not in the list read before it was written.
+	kept := 1
+	for _, old := range keys {
+		if kept < keep || (current != nil && old.ID == current.ID && !key.NotBefore.IsZero()) {
+			kept++
+			continue
+		}
+		err = removeKey(dir, old.ID)
+		if err != nil {
+			return key, retired, err
+		}
+		retired = append(retired, old.ID)
+	}
+	return key, retired, nil
+}
+
+// Retire removes key from directory, tokens signed by it are not accepted anymore.
+// Signing key can't be retired, new key must be generated and activated first.
+func Retire(dir string, kid string) error {
+	keys, err := readDir(dir)
+	if err != nil {
+		return err
+	}
+	if current := Current(keys, time.Now()); current != nil && current.ID == kid {
+		return ErrActiveKey
+	}
+	for _, key := range keys {
+		if key.ID == kid {
+			return removeKey(dir, kid)
+		}
+	}
+	return ErrUnknownKey
+}
+
+// Current returns key of list sorted newest first which signs tokens at given time,
+// nil if there is none.
+func Current(keys []Key, now time.Time) *Key {
+	for i := range keys {
+		if keys[i].Active(now) {
+			return &keys[i]
+		}
+	}
+	return nil
+}
+
+// readDir parses key files, newest key first.
+func readDir(dir string) ([]Key, error) {
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return []Key{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	keys := []Key{}
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), publicExt) {
+			continue
+		}
+		key := Key{ID: strings.TrimSuffix(entry.Name(), publicExt)}
+		key.Public, err = readPublic(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		key.Private, key.NotBefore, err = readPrivate(filepath.Join(dir, key.ID+privateExt))
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].ID > keys[j].ID })
+	return keys, nil
+}
+
+func readPublic(path string) (*ecdsa.PublicKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return jwt.ParseECPublicKeyFromPEM(data)
+}
+
+// readPrivate parses private key and time it starts signing.
+func readPrivate(path string) (*ecdsa.PrivateKey, time.Time, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	private, err := jwt.ParseECPrivateKeyFromPEM(data)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	var notBefore time.Time
+	if block, _ := pem.Decode(data); block != nil && block.Headers[notBeforeHeader] != "" {
+		notBefore, err = time.Parse(time.RFC3339Nano, block.Headers[notBeforeHeader])
+		if err != nil {
+			return nil, time.Time{}, err
+		}
+	}
+	return private, notBefore, nil
+}
+
+// writeKey saves key pair, private key is readable by owner only.
+func writeKey(dir string, key *Key) error {
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return err
+	}
+	private, err := x509.MarshalECPrivateKey(key.Private)
+	if err != nil {
+		return err
+	}
+	public, err := x509.MarshalPKIXPublicKey(key.Public)
+	if err != nil {
+		return err
+	}
+	block := &pem.Block{Type: "EC PRIVATE KEY", Bytes: private}
+	if !key.NotBefore.IsZero() {
+		block.Headers = map[string]string{notBeforeHeader: key.NotBefore.UTC().Format(time.RFC3339Nano)}
+	}
+	err = os.WriteFile(filepath.Join(dir, key.ID+privateExt), pem.EncodeToMemory(block), 0600)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, key.ID+publicExt), pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: public}), 0644)
+}
+
+func removeKey(dir string, kid string) error {
+	err := os.Remove(filepath.Join(dir, kid+privateExt))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return os.Remove(filepath.Join(dir, kid+publicExt))
+}
@@ -0,0 +1,145 @@
+package jwtkeys
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeySet_Sign(t *testing.T) {
+	keys := NewKeySet()
+	_, err := keys.Sign(jwt.StandardClaims{})
+	require.ErrorIs(t, err, ErrNoSigningKey)
+	key, err := GenerateKey()
+	require.NoError(t, err)
+	keys.Add(key)
+	claims := jwt.StandardClaims{Subject: "test@test.com", ExpiresAt: time.Now().Add(time.Minute).Unix()}
+	token, err := keys.Sign(claims)
+	require.NoError(t, err)
+	parsed := jwt.StandardClaims{}
+	tkn, err := jwt.ParseWithClaims(token, &parsed, keys.Keyfunc)
+	require.NoError(t, err)
+	require.True(t, tkn.Valid)
+	require.Equal(t, key.ID, tkn.Header["kid"])
+	require.Equal(t, claims, parsed)
+	// pending key doesn't sign until activation
+	pending, err := GenerateKey()
+	require.NoError(t, err)
+	pending.NotBefore = time.Now().Add(time.Minute)
+	keys.Add(pending)
+	signer, err := keys.Signer()
+	require.NoError(t, err)
+	require.Equal(t, key.ID, signer.ID)
+	// unknown key
+	_, err = jwt.ParseWithClaims(token, &jwt.StandardClaims{}, NewKeySet().Keyfunc)
+	require.Error(t, err)
+	// HS256 token must not be accepted
+	hmac := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	hmac.Header["kid"] = key.ID
+	hmacToken, err := hmac.SignedString([]byte("secret"))
+	require.NoError(t, err)
+	_, err = jwt.ParseWithClaims(hmacToken, &jwt.StandardClaims{}, keys.Keyfunc)
+	require.Error(t, err)
+}
+
+func TestRotate(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "keys")
+	keys, err := Load(dir)
+	require.NoError(t, err)
+	_, err = keys.Signer()
+	require.ErrorIs(t, err, ErrNoSigningKey)
+
+	// first key signs at once
+	first, retired, err := Rotate(dir, DefaultKeep, DefaultActivation)
+	require.NoError(t, err)
+	require.Empty(t, retired)
+	require.True(t, first.NotBefore.IsZero())
+	require.NoError(t, keys.Reload())
+	token, err := keys.Sign(jwt.StandardClaims{})
+	require.NoError(t, err)
+
+	// rotated key is published before it signs, current key is kept meanwhile
+	pending, retired, err := Rotate(dir, 1, DefaultActivation)
+	require.NoError(t, err)
+	require.Empty(t, retired)
+	require.WithinDuration(t, time.Now().Add(DefaultActivation), pending.NotBefore, time.Minute)
+	require.NoError(t, keys.Reload())
+	signer, err := keys.Signer()
+	require.NoError(t, err)
+	require.Equal(t, first.ID, signer.ID)
+	_, err = keys.Verifier(pending.ID)
+	require.NoError(t, err)
+	list, err := List(dir)
+	require.NoError(t, err)
+	require.True(t, pending.NotBefore.Equal(list[0].NotBefore))
+	require.Equal(t, first.ID, Current(list, time.Now()).ID)
+	require.Equal(t, pending.ID, Current(list, pending.NotBefore).ID)
+	require.ErrorIs(t, Retire(dir, first.ID), ErrActiveKey)
+	require.NoError(t, Retire(dir, pending.ID))
+
+	// token of previous key is valid during rotation window
+	second, retired, err := Rotate(dir, DefaultKeep, 0)
+	require.NoError(t, err)
+	require.Empty(t, retired)
+	require.NoError(t, keys.Reload())
+	signer, err = keys.Signer()
+	require.NoError(t, err)
+	require.Equal(t, second.ID, signer.ID)
+	_, err = jwt.Parse(token, keys.Keyfunc)
+	require.NoError(t, err)
+
+	third, retired, err := Rotate(dir, DefaultKeep, 0)
+	require.NoError(t, err)
+	require.Equal(t, []string{first.ID}, retired)
+	require.NoError(t, keys.Reload())
+	_, err = jwt.Parse(token, keys.Keyfunc)
+	require.Error(t, err)
+
+	list, err = List(dir)
+	require.NoError(t, err)
+	require.Len(t, list, 2)
+	require.Equal(t, third.ID, list[0].ID)
+	require.Equal(t, second.ID, list[1].ID)
+	info, err := os.Stat(filepath.Join(dir, third.ID+privateExt))
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0600), info.Mode().Perm())
+}
+
+func TestRetire(t *testing.T) {
+	dir := t.TempDir()
+	old, _, err := Rotate(dir, DefaultKeep, 0)
+	require.NoError(t, err)
+	current, _, err := Rotate(dir, DefaultKeep, 0)
+	require.NoError(t, err)
+	require.ErrorIs(t, Retire(dir, current.ID), ErrActiveKey)
+	require.ErrorIs(t, Retire(dir, "unknown"), ErrUnknownKey)
+	require.NoError(t, Retire(dir, old.ID))
+	keys, err := Load(dir)
+	require.NoError(t, err)
+	_, err = keys.Verifier(old.ID)
+	require.ErrorIs(t, err, ErrUnknownKey)
+	_, err = keys.Verifier(current.ID)
+	require.NoError(t, err)
+}
+
+func TestLoad_PublicOnly(t *testing.T) {
+	dir := t.TempDir()
+	key, _, err := Rotate(dir, DefaultKeep, 0)
+	require.NoError(t, err)
+	signing, err := Load(dir)
+	require.NoError(t, err)
+	token, err := signing.Sign(jwt.StandardClaims{})
+	require.NoError(t, err)
+	// verifier gets public keys only
+	require.NoError(t, os.Remove(filepath.Join(dir, key.ID+privateExt)))
+	verifying, err := Load(dir)
+	require.NoError(t, err)
+	_, err = verifying.Signer()
+	require.ErrorIs(t, err, ErrNoSigningKey)
+	_, err = jwt.Parse(token, verifying.Keyfunc)
+	require.NoError(t, err)
+}
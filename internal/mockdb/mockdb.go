@@ -5,7 +5,9 @@
 package mockdb
 
 import (
+	context "context"
 	reflect "reflect"
+	time "time"
 
 	models "github.com/MaximkaSha/gophkeeper/internal/models"
 	gomock "github.com/golang/mock/gomock"
@@ -35,73 +37,600 @@ func (m *MockStorager) EXPECT() *MockStoragerMockRecorder {
 }
 
 // AddCipheredData mocks base method.
-func (m *MockStorager) AddCipheredData(arg0 models.CipheredData) error {
+func (m *MockStorager) AddCipheredData(arg0 context.Context, arg1 models.CipheredData) (int64, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "AddCipheredData", arg0)
+	ret := m.ctrl.Call(m, "AddCipheredData", arg0, arg1)
+	ret0, _ := ret[0].(int64)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// AddCipheredData indicates an expected call of AddCipheredData.
+func (mr *MockStoragerMockRecorder) AddCipheredData(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AddCipheredData", reflect.TypeOf((*MockStorager)(nil).AddCipheredData), arg0, arg1)
+}
+
+// AddLoginFailure mocks base method.
+func (m *MockStorager) AddLoginFailure(arg0 context.Context, arg1 string, arg2, arg3 time.Time) (int, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "AddLoginFailure", arg0, arg1, arg2, arg3)
+	ret0, _ := ret[0].(int)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// AddLoginFailure indicates an expected call of AddLoginFailure.
+func (mr *MockStoragerMockRecorder) AddLoginFailure(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AddLoginFailure", reflect.TypeOf((*MockStorager)(nil).AddLoginFailure), arg0, arg1, arg2, arg3)
+}
+
+// AddRefreshToken mocks base method.
+func (m *MockStorager) AddRefreshToken(arg0 context.Context, arg1 models.RefreshToken) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "AddRefreshToken", arg0, arg1)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
-// AddCipheredData indicates an expected call of AddCipheredData.
-func (mr *MockStoragerMockRecorder) AddCipheredData(arg0 interface{}) *gomock.Call {
+// AddRefreshToken indicates an expected call of AddRefreshToken.
+func (mr *MockStoragerMockRecorder) AddRefreshToken(arg0, arg1 interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AddCipheredData", reflect.TypeOf((*MockStorager)(nil).AddCipheredData), arg0)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AddRefreshToken", reflect.TypeOf((*MockStorager)(nil).AddRefreshToken), arg0, arg1)
+}
+
+// AddSession mocks base method.
+func (m *MockStorager) AddSession(arg0 context.Context, arg1 models.Session) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "AddSession", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// AddSession indicates an expected call of AddSession.
+func (mr *MockStoragerMockRecorder) AddSession(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AddSession", reflect.TypeOf((*MockStorager)(nil).AddSession), arg0, arg1)
+}
+
+// AddTOTP mocks base method.
+func (m *MockStorager) AddTOTP(arg0 context.Context, arg1 models.TOTP) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "AddTOTP", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// AddTOTP indicates an expected call of AddTOTP.
+func (mr *MockStoragerMockRecorder) AddTOTP(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AddTOTP", reflect.TypeOf((*MockStorager)(nil).AddTOTP), arg0, arg1)
+}
+
+// AddUpload mocks base method.
+func (m *MockStorager) AddUpload(arg0 context.Context, arg1 models.Upload) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "AddUpload", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// AddUpload indicates an expected call of AddUpload.
+func (mr *MockStoragerMockRecorder) AddUpload(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AddUpload", reflect.TypeOf((*MockStorager)(nil).AddUpload), arg0, arg1)
+}
+
+// AddUploadChunk mocks base method.
+func (m *MockStorager) AddUploadChunk(arg0 context.Context, arg1, arg2 string, arg3 models.FileChunk, arg4 time.Time) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "AddUploadChunk", arg0, arg1, arg2, arg3, arg4)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// AddUploadChunk indicates an expected call of AddUploadChunk.
+func (mr *MockStoragerMockRecorder) AddUploadChunk(arg0, arg1, arg2, arg3, arg4 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AddUploadChunk", reflect.TypeOf((*MockStorager)(nil).AddUploadChunk), arg0, arg1, arg2, arg3, arg4)
 }
 
 // AddUser mocks base method.
-func (m *MockStorager) AddUser(arg0 models.User) error {
+func (m *MockStorager) AddUser(arg0 context.Context, arg1 models.User) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "AddUser", arg0)
+	ret := m.ctrl.Call(m, "AddUser", arg0, arg1)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
 // AddUser indicates an expected call of AddUser.
-func (mr *MockStoragerMockRecorder) AddUser(arg0 interface{}) *gomock.Call {
+func (mr *MockStoragerMockRecorder) AddUser(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AddUser", reflect.TypeOf((*MockStorager)(nil).AddUser), arg0, arg1)
+}
+
+// CancelUserDeletion mocks base method.
+func (m *MockStorager) CancelUserDeletion(arg0 context.Context, arg1 string) (bool, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CancelUserDeletion", arg0, arg1)
+	ret0, _ := ret[0].(bool)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// CancelUserDeletion indicates an expected call of CancelUserDeletion.
+func (mr *MockStoragerMockRecorder) CancelUserDeletion(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CancelUserDeletion", reflect.TypeOf((*MockStorager)(nil).CancelUserDeletion), arg0, arg1)
+}
+
+// ClearLoginAttempts mocks base method.
+func (m *MockStorager) ClearLoginAttempts(arg0 context.Context, arg1 string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ClearLoginAttempts", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// ClearLoginAttempts indicates an expected call of ClearLoginAttempts.
+func (mr *MockStoragerMockRecorder) ClearLoginAttempts(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ClearLoginAttempts", reflect.TypeOf((*MockStorager)(nil).ClearLoginAttempts), arg0, arg1)
+}
+
+// CommitUpload mocks base method.
+func (m *MockStorager) CommitUpload(arg0 context.Context, arg1, arg2 string) (int64, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CommitUpload", arg0, arg1, arg2)
+	ret0, _ := ret[0].(int64)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// CommitUpload indicates an expected call of CommitUpload.
+func (mr *MockStoragerMockRecorder) CommitUpload(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CommitUpload", reflect.TypeOf((*MockStorager)(nil).CommitUpload), arg0, arg1, arg2)
+}
+
+// ConfirmTOTP mocks base method.
+func (m *MockStorager) ConfirmTOTP(arg0 context.Context, arg1 string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ConfirmTOTP", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// ConfirmTOTP indicates an expected call of ConfirmTOTP.
+func (mr *MockStoragerMockRecorder) ConfirmTOTP(arg0, arg1 interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AddUser", reflect.TypeOf((*MockStorager)(nil).AddUser), arg0)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ConfirmTOTP", reflect.TypeOf((*MockStorager)(nil).ConfirmTOTP), arg0, arg1)
 }
 
 // DelCiphereData mocks base method.
-func (m *MockStorager) DelCiphereData(arg0 string) error {
+func (m *MockStorager) DelCiphereData(arg0 context.Context, arg1, arg2 string, arg3 int64) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "DelCiphereData", arg0)
+	ret := m.ctrl.Call(m, "DelCiphereData", arg0, arg1, arg2, arg3)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
 // DelCiphereData indicates an expected call of DelCiphereData.
-func (mr *MockStoragerMockRecorder) DelCiphereData(arg0 interface{}) *gomock.Call {
+func (mr *MockStoragerMockRecorder) DelCiphereData(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DelCiphereData", reflect.TypeOf((*MockStorager)(nil).DelCiphereData), arg0)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DelCiphereData", reflect.TypeOf((*MockStorager)(nil).DelCiphereData), arg0, arg1, arg2, arg3)
+}
+
+// DelTOTP mocks base method.
+func (m *MockStorager) DelTOTP(arg0 context.Context, arg1 string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DelTOTP", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DelTOTP indicates an expected call of DelTOTP.
+func (mr *MockStoragerMockRecorder) DelTOTP(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DelTOTP", reflect.TypeOf((*MockStorager)(nil).DelTOTP), arg0, arg1)
 }
 
 // GetCipheredData mocks base method.
-func (m *MockStorager) GetCipheredData(arg0 string) ([]models.CipheredData, error) {
+func (m *MockStorager) GetCipheredData(arg0 context.Context, arg1 string, arg2 models.DataPage) ([]models.CipheredData, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "GetCipheredData", arg0)
+	ret := m.ctrl.Call(m, "GetCipheredData", arg0, arg1, arg2)
 	ret0, _ := ret[0].([]models.CipheredData)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // GetCipheredData indicates an expected call of GetCipheredData.
-func (mr *MockStoragerMockRecorder) GetCipheredData(arg0 interface{}) *gomock.Call {
+func (mr *MockStoragerMockRecorder) GetCipheredData(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetCipheredData", reflect.TypeOf((*MockStorager)(nil).GetCipheredData), arg0, arg1, arg2)
+}
+
+// GetFileChunk mocks base method.
+func (m *MockStorager) GetFileChunk(arg0 context.Context, arg1, arg2 string, arg3 int64) (models.FileChunk, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetFileChunk", arg0, arg1, arg2, arg3)
+	ret0, _ := ret[0].(models.FileChunk)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetFileChunk indicates an expected call of GetFileChunk.
+func (mr *MockStoragerMockRecorder) GetFileChunk(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetFileChunk", reflect.TypeOf((*MockStorager)(nil).GetFileChunk), arg0, arg1, arg2, arg3)
+}
+
+// GetLoginAttempt mocks base method.
+func (m *MockStorager) GetLoginAttempt(arg0 context.Context, arg1 string) (models.LoginAttempt, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetLoginAttempt", arg0, arg1)
+	ret0, _ := ret[0].(models.LoginAttempt)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetLoginAttempt indicates an expected call of GetLoginAttempt.
+func (mr *MockStoragerMockRecorder) GetLoginAttempt(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetLoginAttempt", reflect.TypeOf((*MockStorager)(nil).GetLoginAttempt), arg0, arg1)
+}
+
+// GetRefreshToken mocks base method.
+func (m *MockStorager) GetRefreshToken(arg0 context.Context, arg1 []byte) (models.RefreshToken, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetRefreshToken", arg0, arg1)
+	ret0, _ := ret[0].(models.RefreshToken)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetRefreshToken indicates an expected call of GetRefreshToken.
+func (mr *MockStoragerMockRecorder) GetRefreshToken(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetRefreshToken", reflect.TypeOf((*MockStorager)(nil).GetRefreshToken), arg0, arg1)
+}
+
+// GetRevision mocks base method.
+func (m *MockStorager) GetRevision(arg0 context.Context, arg1, arg2 string, arg3 int64) (models.DataRevision, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetRevision", arg0, arg1, arg2, arg3)
+	ret0, _ := ret[0].(models.DataRevision)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetRevision indicates an expected call of GetRevision.
+func (mr *MockStoragerMockRecorder) GetRevision(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetRevision", reflect.TypeOf((*MockStorager)(nil).GetRevision), arg0, arg1, arg2, arg3)
+}
+
+// GetSession mocks base method.
+func (m *MockStorager) GetSession(arg0 context.Context, arg1 string) (models.Session, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetSession", arg0, arg1)
+	ret0, _ := ret[0].(models.Session)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetSession indicates an expected call of GetSession.
+func (mr *MockStoragerMockRecorder) GetSession(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetSession", reflect.TypeOf((*MockStorager)(nil).GetSession), arg0, arg1)
+}
+
+// GetTOTP mocks base method.
+func (m *MockStorager) GetTOTP(arg0 context.Context, arg1 string) (models.TOTP, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetTOTP", arg0, arg1)
+	ret0, _ := ret[0].(models.TOTP)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetTOTP indicates an expected call of GetTOTP.
+func (mr *MockStoragerMockRecorder) GetTOTP(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetTOTP", reflect.TypeOf((*MockStorager)(nil).GetTOTP), arg0, arg1)
+}
+
+// GetUpload mocks base method.
+func (m *MockStorager) GetUpload(arg0 context.Context, arg1, arg2 string) (models.Upload, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetUpload", arg0, arg1, arg2)
+	ret0, _ := ret[0].(models.Upload)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetUpload indicates an expected call of GetUpload.
+func (mr *MockStoragerMockRecorder) GetUpload(arg0, arg1, arg2 interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetCipheredData", reflect.TypeOf((*MockStorager)(nil).GetCipheredData), arg0)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetUpload", reflect.TypeOf((*MockStorager)(nil).GetUpload), arg0, arg1, arg2)
 }
 
 // GetUser mocks base method.
-func (m *MockStorager) GetUser(arg0 models.User) (models.User, error) {
+func (m *MockStorager) GetUser(arg0 context.Context, arg1 models.User) (models.User, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "GetUser", arg0)
+	ret := m.ctrl.Call(m, "GetUser", arg0, arg1)
 	ret0, _ := ret[0].(models.User)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // GetUser indicates an expected call of GetUser.
-func (mr *MockStoragerMockRecorder) GetUser(arg0 interface{}) *gomock.Call {
+func (mr *MockStoragerMockRecorder) GetUser(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetUser", reflect.TypeOf((*MockStorager)(nil).GetUser), arg0, arg1)
+}
+
+// ListChanges mocks base method.
+func (m *MockStorager) ListChanges(arg0 context.Context, arg1 string, arg2 int64, arg3 int) ([]models.CipheredData, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListChanges", arg0, arg1, arg2, arg3)
+	ret0, _ := ret[0].([]models.CipheredData)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListChanges indicates an expected call of ListChanges.
+func (mr *MockStoragerMockRecorder) ListChanges(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListChanges", reflect.TypeOf((*MockStorager)(nil).ListChanges), arg0, arg1, arg2, arg3)
+}
+
+// ListDueDeletions mocks base method.
+func (m *MockStorager) ListDueDeletions(arg0 context.Context, arg1 time.Time) ([]string, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListDueDeletions", arg0, arg1)
+	ret0, _ := ret[0].([]string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListDueDeletions indicates an expected call of ListDueDeletions.
+func (mr *MockStoragerMockRecorder) ListDueDeletions(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListDueDeletions", reflect.TypeOf((*MockStorager)(nil).ListDueDeletions), arg0, arg1)
+}
+
+// ListLockouts mocks base method.
+func (m *MockStorager) ListLockouts(arg0 context.Context, arg1 time.Time) ([]models.LoginAttempt, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListLockouts", arg0, arg1)
+	ret0, _ := ret[0].([]models.LoginAttempt)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListLockouts indicates an expected call of ListLockouts.
+func (mr *MockStoragerMockRecorder) ListLockouts(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListLockouts", reflect.TypeOf((*MockStorager)(nil).ListLockouts), arg0, arg1)
+}
+
+// ListRevisions mocks base method.
+func (m *MockStorager) ListRevisions(arg0 context.Context, arg1, arg2 string) ([]models.DataRevision, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListRevisions", arg0, arg1, arg2)
+	ret0, _ := ret[0].([]models.DataRevision)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListRevisions indicates an expected call of ListRevisions.
+func (mr *MockStoragerMockRecorder) ListRevisions(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListRevisions", reflect.TypeOf((*MockStorager)(nil).ListRevisions), arg0, arg1, arg2)
+}
+
+// ListSessions mocks base method.
+func (m *MockStorager) ListSessions(arg0 context.Context, arg1 string) ([]models.Session, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListSessions", arg0, arg1)
+	ret0, _ := ret[0].([]models.Session)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListSessions indicates an expected call of ListSessions.
+func (mr *MockStoragerMockRecorder) ListSessions(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListSessions", reflect.TypeOf((*MockStorager)(nil).ListSessions), arg0, arg1)
+}
+
+// LockLogin mocks base method.
+func (m *MockStorager) LockLogin(arg0 context.Context, arg1 string, arg2 time.Time) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "LockLogin", arg0, arg1, arg2)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// LockLogin indicates an expected call of LockLogin.
+func (mr *MockStoragerMockRecorder) LockLogin(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "LockLogin", reflect.TypeOf((*MockStorager)(nil).LockLogin), arg0, arg1, arg2)
+}
+
+// PurgeRefreshTokens mocks base method.
+func (m *MockStorager) PurgeRefreshTokens(arg0 context.Context, arg1, arg2 time.Time) (int, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "PurgeRefreshTokens", arg0, arg1, arg2)
+	ret0, _ := ret[0].(int)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// PurgeRefreshTokens indicates an expected call of PurgeRefreshTokens.
+func (mr *MockStoragerMockRecorder) PurgeRefreshTokens(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "PurgeRefreshTokens", reflect.TypeOf((*MockStorager)(nil).PurgeRefreshTokens), arg0, arg1, arg2)
+}
+
+// PurgeRevisions mocks base method.
+func (m *MockStorager) PurgeRevisions(arg0 context.Context, arg1 int, arg2 time.Time) (int, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "PurgeRevisions", arg0, arg1, arg2)
+	ret0, _ := ret[0].(int)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// PurgeRevisions indicates an expected call of PurgeRevisions.
+func (mr *MockStoragerMockRecorder) PurgeRevisions(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "PurgeRevisions", reflect.TypeOf((*MockStorager)(nil).PurgeRevisions), arg0, arg1, arg2)
+}
+
+// PurgeUploads mocks base method.
+func (m *MockStorager) PurgeUploads(arg0 context.Context, arg1 time.Time) (int, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "PurgeUploads", arg0, arg1)
+	ret0, _ := ret[0].(int)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// PurgeUploads indicates an expected call of PurgeUploads.
+func (mr *MockStoragerMockRecorder) PurgeUploads(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "PurgeUploads", reflect.TypeOf((*MockStorager)(nil).PurgeUploads), arg0, arg1)
+}
+
+// PurgeUser mocks base method.
+func (m *MockStorager) PurgeUser(arg0 context.Context, arg1 string, arg2 time.Time) (bool, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "PurgeUser", arg0, arg1, arg2)
+	ret0, _ := ret[0].(bool)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// PurgeUser indicates an expected call of PurgeUser.
+func (mr *MockStoragerMockRecorder) PurgeUser(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "PurgeUser", reflect.TypeOf((*MockStorager)(nil).PurgeUser), arg0, arg1, arg2)
+}
+
+// RevokeSession mocks base method.
+func (m *MockStorager) RevokeSession(arg0 context.Context, arg1 string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RevokeSession", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RevokeSession indicates an expected call of RevokeSession.
+func (mr *MockStoragerMockRecorder) RevokeSession(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RevokeSession", reflect.TypeOf((*MockStorager)(nil).RevokeSession), arg0, arg1)
+}
+
+// RevokeUserSessions mocks base method.
+func (m *MockStorager) RevokeUserSessions(arg0 context.Context, arg1, arg2 string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RevokeUserSessions", arg0, arg1, arg2)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RevokeUserSessions indicates an expected call of RevokeUserSessions.
+func (mr *MockStoragerMockRecorder) RevokeUserSessions(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RevokeUserSessions", reflect.TypeOf((*MockStorager)(nil).RevokeUserSessions), arg0, arg1, arg2)
+}
+
+// ScheduleUserDeletion mocks base method.
+func (m *MockStorager) ScheduleUserDeletion(arg0 context.Context, arg1 string, arg2 time.Time) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ScheduleUserDeletion", arg0, arg1, arg2)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// ScheduleUserDeletion indicates an expected call of ScheduleUserDeletion.
+func (mr *MockStoragerMockRecorder) ScheduleUserDeletion(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ScheduleUserDeletion", reflect.TypeOf((*MockStorager)(nil).ScheduleUserDeletion), arg0, arg1, arg2)
+}
+
+// TouchSession mocks base method.
+func (m *MockStorager) TouchSession(arg0 context.Context, arg1 string, arg2 time.Time, arg3 string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "TouchSession", arg0, arg1, arg2, arg3)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// TouchSession indicates an expected call of TouchSession.
+func (mr *MockStoragerMockRecorder) TouchSession(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "TouchSession", reflect.TypeOf((*MockStorager)(nil).TouchSession), arg0, arg1, arg2, arg3)
+}
+
+// UpdateUser mocks base method.
+func (m *MockStorager) UpdateUser(arg0 context.Context, arg1 models.User) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UpdateUser", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// UpdateUser indicates an expected call of UpdateUser.
+func (mr *MockStoragerMockRecorder) UpdateUser(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateUser", reflect.TypeOf((*MockStorager)(nil).UpdateUser), arg0, arg1)
+}
+
+// UseRecoveryCode mocks base method.
+func (m *MockStorager) UseRecoveryCode(arg0 context.Context, arg1 string, arg2 []byte) (bool, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UseRecoveryCode", arg0, arg1, arg2)
+	ret0, _ := ret[0].(bool)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// UseRecoveryCode indicates an expected call of UseRecoveryCode.
+func (mr *MockStoragerMockRecorder) UseRecoveryCode(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UseRecoveryCode", reflect.TypeOf((*MockStorager)(nil).UseRecoveryCode), arg0, arg1, arg2)
+}
+
+// UseRefreshToken mocks base method.
+func (m *MockStorager) UseRefreshToken(arg0 context.Context, arg1 []byte) (bool, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UseRefreshToken", arg0, arg1)
+	ret0, _ := ret[0].(bool)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// UseRefreshToken indicates an expected call of UseRefreshToken.
+func (mr *MockStoragerMockRecorder) UseRefreshToken(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UseRefreshToken", reflect.TypeOf((*MockStorager)(nil).UseRefreshToken), arg0, arg1)
+}
+
+// UseTOTPStep mocks base method.
+func (m *MockStorager) UseTOTPStep(arg0 context.Context, arg1 string, arg2 int64) (bool, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UseTOTPStep", arg0, arg1, arg2)
+	ret0, _ := ret[0].(bool)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// UseTOTPStep indicates an expected call of UseTOTPStep.
+func (mr *MockStoragerMockRecorder) UseTOTPStep(arg0, arg1, arg2 interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetUser", reflect.TypeOf((*MockStorager)(nil).GetUser), arg0)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UseTOTPStep", reflect.TypeOf((*MockStorager)(nil).UseTOTPStep), arg0, arg1, arg2)
 }
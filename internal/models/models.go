@@ -3,12 +3,18 @@ package models
 
 import (
 	//"github.com/MaximkaSha/gophkeeper/internal/client"
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
+	"time"
 
 	pb "github.com/MaximkaSha/gophkeeper/internal/proto"
 	"github.com/google/uuid"
 	"golang.org/x/crypto/bcrypt"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 // CipheredData describes ciphered data of all given data types.
@@ -21,6 +27,63 @@ type CipheredData struct {
 	User string
 	// Uuid of data.
 	ID string
+	// Revision of user's vault when record was last changed.
+	// Saved record carries revision the change is based on, 0 for new record.
+	Revision int64
+	// Set on tombstone of deleted record, it has no data.
+	Deleted bool
+}
+
+// DataPage - position and filter of requested page of ciphered data.
+// Data is ordered by uuid, so page starts right after last uuid of previous page.
+type DataPage struct {
+	// Uuid of last record of previous page, empty for first page.
+	After string
+	// Max number of records, 0 for all records.
+	Limit int
+	// Types of data to return, all types if empty.
+	Types []string
+}
+
+// FileChunk - ciphered part of file content.
+type FileChunk struct {
+	// Number of chunk from 0.
+	Index int64
+	// Ciphered content.
+	Data []byte
+	// Last chunk of file.
+	Last bool
+}
+
+// DataRevision - previous version of record kept in history.
+// File records are not kept, their content is replaced with them.
+type DataRevision struct {
+	// Record as it was, revision is revision of vault when version was saved.
+	Data CipheredData
+	// Time version was overwritten or deleted.
+	ReplacedAt time.Time
+}
+
+// ToProto Function convert DataRevision to protobuff.
+func (r *DataRevision) ToProto() *pb.DataRevision {
+	return &pb.DataRevision{
+		Data:       r.Data.ToProto(),
+		ReplacedAt: r.ReplacedAt.Unix(),
+	}
+}
+
+// Upload - file upload in progress. Chunks are kept apart from file
+// and replace its content when last chunk is received.
+type Upload struct {
+	// Uuid of upload.
+	ID string
+	// Ciphered file record, saved when upload is finished. User is owner of upload,
+	// Revision is revision of record the upload is based on, 0 for new record.
+	Data CipheredData
+	// Number of received chunks, next chunk has this index.
+	Chunks int64
+	// Time of upload start or last received chunk.
+	UpdatedAt time.Time
 }
 
 // FromProto Function covert data from protobuf to CipheredData.
@@ -29,6 +92,8 @@ func (u *CipheredData) FromProto(proto *pb.CipheredData) {
 	u.ID = proto.Uuid
 	u.Type = proto.Type.String()
 	u.User = proto.Useremail
+	u.Revision = proto.Revision
+	u.Deleted = proto.Deleted
 }
 
 // ToProto Function convert CipheredData to protobuff.
@@ -38,6 +103,8 @@ func (u *CipheredData) ToProto() *pb.CipheredData {
 		Type:      pb.CipheredData_Type(pb.CipheredData_Type_value[u.Type]),
 		Useremail: u.User,
 		Uuid:      u.ID,
+		Revision:  u.Revision,
+		Deleted:   u.Deleted,
 	}
 }
 
@@ -57,8 +124,12 @@ type User struct {
 	Email string `json:"email"`
 	// User's password.
 	Password string `json:"password"`
-	// User's privite key.
+	// User's vault key wrapped by key derived from master password.
 	Secret []byte `json:"secret"`
+	// Salt of master password key derivation.
+	Salt []byte `json:"salt"`
+	// Account and its data are purged after this time, zero if deletion is not scheduled.
+	DeleteAfter time.Time `json:"-"`
 }
 
 // FromProto Covert protobuf User model to models.User.
@@ -66,6 +137,7 @@ func (u *User) FromProto(proto *pb.User) {
 	u.Email = proto.Email
 	u.Password = proto.Password
 	u.Secret = proto.Secret
+	u.Salt = proto.Salt
 }
 
 // ToProto Convert models.User to protobuf.
@@ -74,6 +146,7 @@ func (u *User) ToProto() *pb.User {
 		Email:    u.Email,
 		Password: u.Password,
 		Secret:   u.Secret,
+		Salt:     u.Salt,
 	}
 }
 
@@ -101,6 +174,41 @@ type Token struct {
 	Token string `json:"token"`
 }
 
+// Session model - one login of user.
+// All refresh tokens rotated from the login token belong to the same session.
+type Session struct {
+	// Uniq uuid.
+	ID string
+	// Email of session owner.
+	User string
+	// Device name reported by client.
+	Device string
+	// Client build version.
+	BuildVersion string
+	// Client ip address of last login or refresh.
+	IP string
+	// Time of login.
+	CreatedAt time.Time
+	// Time of last refresh.
+	LastSeen time.Time
+	// Session can't be refreshed after this time.
+	ExpiresAt time.Time
+	// Revoked by logout or token reuse.
+	Revoked bool
+}
+
+// RefreshToken model. Only hash of token is kept by server.
+type RefreshToken struct {
+	// SHA-256 of token.
+	Hash []byte
+	// Session which token belongs to.
+	SessionID string
+	// Token was already exchanged.
+	Used bool
+	// Time of issue.
+	CreatedAt time.Time
+}
+
 // Password model.
 type Password struct {
 	// Login.
@@ -146,13 +254,22 @@ func (d Password) Type() string {
 }
 
 // Data model for files.
+// Content of files uploaded by stream is kept in chunks, Data is empty then.
 type Data struct {
-	// Data - slice of file bytes.
+	// Data - slice of file bytes, only for files saved before chunked upload.
 	Data []byte `json:"data"`
 	// Tag - user given data.
 	Tag string `json:"tag"`
 	// Uniq uuid.
 	ID string `json:"id"`
+	// ContentID - random id of uploaded content, chunks are bound to it,
+	// so chunks of other uploads of the same file are rejected.
+	ContentID string `json:"content_id,omitempty"`
+}
+
+// Chunked reports if file content is kept in chunks.
+func (d Data) Chunked() bool {
+	return d.ContentID != ""
 }
 
 // GetData Return marshled object.
@@ -256,13 +373,130 @@ func (d CreditCard) Type() string {
 	return "CC"
 }
 
+// Errors returned by Storager, drivers' errors are wrapped by them.
+var (
+	// ErrPermissionDenied returned when user tries to access data of another user.
+	ErrPermissionDenied = errors.New("permission denied")
+	// ErrNotFound returned when requested record doesn't exist.
+	ErrNotFound = errors.New("not found")
+	// ErrAlreadyExists returned when record with the same key exists.
+	ErrAlreadyExists = errors.New("already exists")
+	// ErrConflict returned when change conflicts with concurrent change or related records.
+	ErrConflict = errors.New("conflict")
+	// ErrNoHistory returned when previous versions of file are asked, replaced file content is not kept.
+	ErrNoHistory = errors.New("history is not kept for files")
+)
+
+// ConflictError returned when saved record is based on outdated revision,
+// so change would overwrite change made by other device.
+type ConflictError struct {
+	// Current version of record, tombstone if record is deleted.
+	Current CipheredData
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("record %s is changed at revision %d", e.Current.ID, e.Current.Revision)
+}
+
+// Unwrap returns ErrConflict.
+func (e *ConflictError) Unwrap() error {
+	return ErrConflict
+}
+
+// StatusError returns gRPC status of Storager error.
+// Details of storage errors are not disclosed, unknown errors are internal.
+func StatusError(err error) error {
+	for _, e := range []struct {
+		err  error
+		code codes.Code
+	}{
+		{ErrPermissionDenied, codes.PermissionDenied},
+		{ErrNotFound, codes.NotFound},
+		{ErrAlreadyExists, codes.AlreadyExists},
+		{ErrConflict, codes.Aborted},
+		{ErrNoHistory, codes.FailedPrecondition},
+		{context.Canceled, codes.Canceled},
+		{context.DeadlineExceeded, codes.DeadlineExceeded},
+	} {
+		if errors.Is(err, e.err) {
+			return status.Error(e.code, e.err.Error())
+		}
+	}
+	return status.Error(codes.Internal, "Internal error")
+}
+
+// TOTP - user's authenticator enrollment.
+type TOTP struct {
+	// Email of user.
+	User string
+	// Base32 TOTP secret.
+	Secret string
+	// Enrollment is confirmed by valid code, login needs second step.
+	Confirmed bool
+	// Time step of last accepted authenticator code, codes of it and earlier steps are rejected.
+	LastStep int64
+	// SHA-256 of one-time recovery codes.
+	RecoveryCodes [][]byte
+}
+
+// LoginAttempt - failed logins counter of account or ip address.
+type LoginAttempt struct {
+	// "account:<email>" or "ip:<address>".
+	Key string
+	// Failed attempts in a row.
+	Failures int
+	// Time of last failed attempt.
+	LastFailure time.Time
+	// Logins are rejected till this time.
+	LockedUntil time.Time
+}
+
 // Storager Interface for database.
+// Data methods are scoped to owner's email, records of other users are never changed.
+// Methods stop when context is done, errors wrap ErrNotFound, ErrAlreadyExists,
+// ErrConflict and ErrPermissionDenied if caused by them.
 type Storager interface {
-	AddUser(User) error
-	GetUser(User) (User, error)
-	AddCipheredData(CipheredData) error
-	GetCipheredData(string) ([]CipheredData, error)
-	DelCiphereData(string) error
+	AddUser(context.Context, User) error
+	GetUser(context.Context, User) (User, error)
+	UpdateUser(context.Context, User) error
+	ScheduleUserDeletion(context.Context, string, time.Time) error
+	CancelUserDeletion(context.Context, string) (bool, error)
+	ListDueDeletions(context.Context, time.Time) ([]string, error)
+	PurgeUser(context.Context, string, time.Time) (bool, error)
+	AddCipheredData(context.Context, CipheredData) (int64, error)
+	GetCipheredData(context.Context, string, DataPage) ([]CipheredData, error)
+	DelCiphereData(context.Context, string, string, int64) error
+	ListChanges(context.Context, string, int64, int) ([]CipheredData, error)
+	ListRevisions(context.Context, string, string) ([]DataRevision, error)
+	GetRevision(context.Context, string, string, int64) (DataRevision, error)
+	PurgeRevisions(context.Context, int, time.Time) (int, error)
+	GetFileChunk(context.Context, string, string, int64) (FileChunk, error)
+	AddUpload(context.Context, Upload) error
+	GetUpload(context.Context, string, string) (Upload, error)
+	AddUploadChunk(context.Context, string, string, FileChunk, time.Time) error
+	CommitUpload(context.Context, string, string) (int64, error)
+	PurgeUploads(context.Context, time.Time) (int, error)
+	AddSession(context.Context, Session) error
+	GetSession(context.Context, string) (Session, error)
+	TouchSession(context.Context, string, time.Time, string) error
+	ListSessions(context.Context, string) ([]Session, error)
+	RevokeSession(context.Context, string) error
+	RevokeUserSessions(context.Context, string, string) error
+	AddRefreshToken(context.Context, RefreshToken) error
+	GetRefreshToken(context.Context, []byte) (RefreshToken, error)
+	UseRefreshToken(context.Context, []byte) (bool, error)
+	PurgeRefreshTokens(context.Context, time.Time, time.Time) (int, error)
+	AddTOTP(context.Context, TOTP) error
+	GetTOTP(context.Context, string) (TOTP, error)
+	ConfirmTOTP(context.Context, string) error
+	DelTOTP(context.Context, string) error
+	UseRecoveryCode(context.Context, string, []byte) (bool, error)
+	UseTOTPStep(context.Context, string, int64) (bool, error)
+	GetLoginAttempt(context.Context, string) (LoginAttempt, error)
+	AddLoginFailure(context.Context, string, time.Time, time.Time) (int, error)
+	LockLogin(context.Context, string, time.Time) error
+	ClearLoginAttempts(context.Context, string) error
+	ListLockouts(context.Context, time.Time) ([]LoginAttempt, error)
 }
 
 // Dater Interface for data converting.
@@ -0,0 +1,176 @@
+// Package passhash hashes user credentials on server.
+//
+// Algorithm and parameters are kept in the hash string, so hashes made with
+// previous configuration are still verified and can be upgraded on login:
+//
+//	$2a$14$...                                - bcrypt, cost is in hash.
+//	$argon2id$v=19$m=65536,t=3,p=4$salt$hash  - Argon2id in PHC string format.
+package passhash
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Algorithm names, used in configuration.
+const (
+	AlgorithmBcrypt   = "bcrypt"
+	AlgorithmArgon2id = "argon2id"
+)
+
+var (
+	// ErrUnknownAlgorithm returned for hash which is not made by supported algorithm,
+	// e.g. plaintext stored by old clients.
+	ErrUnknownAlgorithm = errors.New("passhash: unknown algorithm")
+	// ErrMalformed returned for hash which can't be parsed.
+	ErrMalformed = errors.New("passhash: malformed hash")
+)
+
+// Hasher hashes passwords with configured algorithm and parameters.
+type Hasher interface {
+	// Hash returns hash of password with algorithm and parameters in it.
+	Hash(password string) (string, error)
+	// NeedsRehash reports whether hash was made by other algorithm or parameters.
+	NeedsRehash(hash string) bool
+}
+
+// New returns Hasher of algorithm by name.
+func New(algorithm string, bcryptCost int, argon Argon2id) (Hasher, error) {
+	switch algorithm {
+	case AlgorithmBcrypt:
+		return Bcrypt{Cost: bcryptCost}, nil
+	case AlgorithmArgon2id, "":
+		return argon, nil
+	}
+	return nil, ErrUnknownAlgorithm
+}
+
+// Verify checks password against hash of any supported algorithm.
+// Returns false without error if password doesn't match.
+func Verify(password string, hash string) (bool, error) {
+	switch {
+	case strings.HasPrefix(hash, "$"+AlgorithmArgon2id+"$"):
+		return verifyArgon2id(password, hash)
+	case strings.HasPrefix(hash, "$2a$"), strings.HasPrefix(hash, "$2b$"), strings.HasPrefix(hash, "$2y$"):
+		err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+			return false, nil
+		}
+		return err == nil, err
+	}
+	return false, ErrUnknownAlgorithm
+}
+
+// Bcrypt - bcrypt hasher.
+type Bcrypt struct {
+	Cost int
+}
+
+// Hash returns bcrypt hash of password.
+func (b Bcrypt) Hash(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), b.Cost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// NeedsRehash reports whether hash is not bcrypt or made with other cost.
+func (b Bcrypt) NeedsRehash(hash string) bool {
+	cost, err := bcrypt.Cost([]byte(hash))
+	return err != nil || cost != b.Cost
+}
+
+// Argon2id - Argon2id hasher.
+type Argon2id struct {
+	// Number of passes.
+	Time uint32
+	// Memory in KiB.
+	Memory uint32
+	// Degree of parallelism.
+	Threads uint8
+	// Length of hash in bytes.
+	KeyLen uint32
+	// Length of random salt in bytes.
+	SaltLen uint32
+}
+
+// DefaultArgon2id - RFC 9106 second recommended option, with 64 MiB of memory.
+var DefaultArgon2id = Argon2id{
+	Time:    3,
+	Memory:  64 * 1024,
+	Threads: 4,
+	KeyLen:  32,
+	SaltLen: 16,
+}
+
+// Hash returns Argon2id hash of password in PHC string format.
+func (a Argon2id) Hash(password string) (string, error) {
+	salt := make([]byte, a.SaltLen)
+	_, err := rand.Read(salt)
+	if err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, a.Time, a.Memory, a.Threads, a.KeyLen)
+	return a.encode(salt, key), nil
+}
+
+// NeedsRehash reports whether hash is not Argon2id or made with other parameters.
+func (a Argon2id) NeedsRehash(hash string) bool {
+	params, salt, key, err := decodeArgon2id(hash)
+	if err != nil {
+		return true
+	}
+	params.SaltLen = uint32(len(salt))
+	params.KeyLen = uint32(len(key))
+	return params != a
+}
+
+func (a Argon2id) encode(salt []byte, key []byte) string {
+	return fmt.Sprintf("$%s$v=%d$m=%d,t=%d,p=%d$%s$%s", AlgorithmArgon2id, argon2.Version,
+		a.Memory, a.Time, a.Threads,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key))
+}
+
+// decodeArgon2id parses PHC string, salt and key lengths are not set in returned parameters.
+func decodeArgon2id(hash string) (Argon2id, []byte, []byte, error) {
+	var params Argon2id
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[1] != AlgorithmArgon2id {
+		return params, nil, nil, ErrMalformed
+	}
+	var version int
+	_, err := fmt.Sscanf(parts[2], "v=%d", &version)
+	if err != nil || version != argon2.Version {
+		return params, nil, nil, ErrMalformed
+	}
+	_, err = fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Time, &params.Threads)
+	if err != nil || params.Time == 0 || params.Threads == 0 {
+		return params, nil, nil, ErrMalformed
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return params, nil, nil, ErrMalformed
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return params, nil, nil, ErrMalformed
+	}
+	return params, salt, key, nil
+}
+
+func verifyArgon2id(password string, hash string) (bool, error) {
+	params, salt, key, err := decodeArgon2id(hash)
+	if err != nil {
+		return false, err
+	}
+	got := argon2.IDKey([]byte(password), salt, params.Time, params.Memory, params.Threads, uint32(len(key)))
+	return subtle.ConstantTimeCompare(got, key) == 1, nil
+}
@@ -0,0 +1,148 @@
+package passhash
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// testArgon2id - cheap parameters to keep tests fast.
+var testArgon2id = Argon2id{
+	Time:    1,
+	Memory:  1024,
+	Threads: 1,
+	KeyLen:  32,
+	SaltLen: 16,
+}
+
+func TestVerify(t *testing.T) {
+	bcryptHash, err := Bcrypt{Cost: 4}.Hash("11111")
+	require.NoError(t, err)
+	argonHash, err := testArgon2id.Hash("11111")
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(argonHash, "$argon2id$v=19$m=1024,t=1,p=1$"))
+	tests := []struct {
+		name     string
+		password string
+		hash     string
+		want     bool
+		err      error
+	}{
+		{
+			name:     "bcrypt",
+			password: "11111",
+			hash:     bcryptHash,
+			want:     true,
+		},
+		{
+			name:     "bcrypt wrong password",
+			password: "22222",
+			hash:     bcryptHash,
+			want:     false,
+		},
+		{
+			name:     "argon2id",
+			password: "11111",
+			hash:     argonHash,
+			want:     true,
+		},
+		{
+			name:     "argon2id wrong password",
+			password: "22222",
+			hash:     argonHash,
+			want:     false,
+		},
+		{
+			name:     "plaintext",
+			password: "11111",
+			hash:     "11111",
+			err:      ErrUnknownAlgorithm,
+		},
+		{
+			name:     "malformed argon2id",
+			password: "11111",
+			hash:     "$argon2id$v=19$m=1024,t=0,p=1$c2FsdA$aGFzaA",
+			err:      ErrMalformed,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Verify(tt.password, tt.hash)
+			if tt.err != nil {
+				require.ErrorIs(t, err, tt.err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestNeedsRehash(t *testing.T) {
+	bcryptHash, err := Bcrypt{Cost: 4}.Hash("11111")
+	require.NoError(t, err)
+	argonHash, err := testArgon2id.Hash("11111")
+	require.NoError(t, err)
+	stronger := testArgon2id
+	stronger.Time = 2
+	tests := []struct {
+		name   string
+		hasher Hasher
+		hash   string
+		want   bool
+	}{
+		{
+			name:   "same bcrypt cost",
+			hasher: Bcrypt{Cost: 4},
+			hash:   bcryptHash,
+			want:   false,
+		},
+		{
+			name:   "bcrypt cost raised",
+			hasher: Bcrypt{Cost: 5},
+			hash:   bcryptHash,
+			want:   true,
+		},
+		{
+			name:   "bcrypt to argon2id",
+			hasher: testArgon2id,
+			hash:   bcryptHash,
+			want:   true,
+		},
+		{
+			name:   "same argon2id parameters",
+			hasher: testArgon2id,
+			hash:   argonHash,
+			want:   false,
+		},
+		{
+			name:   "argon2id parameters changed",
+			hasher: stronger,
+			hash:   argonHash,
+			want:   true,
+		},
+		{
+			name:   "argon2id to bcrypt",
+			hasher: Bcrypt{Cost: 4},
+			hash:   argonHash,
+			want:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.want, tt.hasher.NeedsRehash(tt.hash))
+		})
+	}
+}
+
+func TestNew(t *testing.T) {
+	hasher, err := New(AlgorithmBcrypt, 12, DefaultArgon2id)
+	require.NoError(t, err)
+	require.Equal(t, Bcrypt{Cost: 12}, hasher)
+	hasher, err = New(AlgorithmArgon2id, 12, testArgon2id)
+	require.NoError(t, err)
+	require.Equal(t, testArgon2id, hasher)
+	_, err = New("md5", 12, DefaultArgon2id)
+	require.ErrorIs(t, err, ErrUnknownAlgorithm)
+}
@@ -0,0 +1,203 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"time"
+
+	"github.com/MaximkaSha/gophkeeper/internal/models"
+	pb "github.com/MaximkaSha/gophkeeper/internal/proto"
+	"github.com/google/uuid"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// startUpload saves file record of new upload based on expected revision of record,
+// it is written to file when upload is finished.
+func (g GophkeeperServer) startUpload(ctx context.Context, email string, meta *pb.CipheredData, expected int64) (models.Upload, error) {
+	data := models.CipheredData{}
+	data.FromProto(meta)
+	data.Revision = expected
+	if data.Type != pb.CipheredData_DATA.String() {
+		return models.Upload{}, status.Errorf(codes.InvalidArgument, `Only files are uploaded by stream`)
+	}
+	if data.User != "" && data.User != email {
+		return models.Upload{}, status.Errorf(codes.PermissionDenied, `Access denied`)
+	}
+	data.User = email
+	if data.ID == "" {
+		data.ID = uuid.NewString()
+	}
+	upload := models.Upload{ID: uuid.NewString(), Data: data, UpdatedAt: time.Now()}
+	err := g.DB.AddUpload(ctx, upload)
+	if errors.Is(err, models.ErrPermissionDenied) {
+		return models.Upload{}, status.Errorf(codes.PermissionDenied, `Access denied`)
+	}
+	if err != nil {
+		return models.Upload{}, models.StatusError(err)
+	}
+	return upload, nil
+}
+
+// getUpload returns started upload of user.
+func (g GophkeeperServer) getUpload(ctx context.Context, email string, id string) (models.Upload, error) {
+	upload, err := g.DB.GetUpload(ctx, email, id)
+	if errors.Is(err, models.ErrNotFound) {
+		return models.Upload{}, status.Errorf(codes.NotFound, `Upload is finished or expired`)
+	}
+	if err != nil {
+		return models.Upload{}, models.StatusError(err)
+	}
+	return upload, nil
+}
+
+// StartUpload - gRPC endpoint starts upload of file record, its chunks are sent by UploadFile.
+func (g GophkeeperServer) StartUpload(ctx context.Context, in *pb.StartUploadRequest) (*pb.StartUploadResponse, error) {
+	var response pb.StartUploadResponse
+	email, err := principal(ctx)
+	if err != nil {
+		return &response, err
+	}
+	upload, err := g.startUpload(ctx, email, in.Meta, in.ExpectedRevision)
+	if err != nil {
+		return &response, err
+	}
+	response.UploadId = upload.ID
+	return &response, nil
+}
+
+// GetUploadStatus - gRPC endpoint returns number of chunks received by started upload,
+// so interrupted upload is continued from the next one.
+func (g GophkeeperServer) GetUploadStatus(ctx context.Context, in *pb.UploadStatusRequest) (*pb.UploadStatusResponse, error) {
+	var response pb.UploadStatusResponse
+	email, err := principal(ctx)
+	if err != nil {
+		return &response, err
+	}
+	upload, err := g.getUpload(ctx, email, in.UploadId)
+	if err != nil {
+		return &response, err
+	}
+	response.Chunks = upload.Chunks
+	return &response, nil
+}
+
+// UploadFile - gRPC endpoint receives chunks of file by stream.
+// Stream starts with file record for new upload or with id of upload to continue.
+// Chunks are ciphered by client and must come in order, received ones are kept if stream breaks.
+// File content is replaced by uploaded chunks when last one is received,
+// unless file record is changed after revision the upload is based on.
+func (g GophkeeperServer) UploadFile(stream pb.Gophkeeper_UploadFileServer) error {
+	ctx := stream.Context()
+	email, err := principal(ctx)
+	if err != nil {
+		return err
+	}
+	req, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+	var upload models.Upload
+	switch payload := req.Payload.(type) {
+	case *pb.UploadFileRequest_Meta:
+		upload, err = g.startUpload(ctx, email, payload.Meta, req.ExpectedRevision)
+	case *pb.UploadFileRequest_UploadId:
+		upload, err = g.getUpload(ctx, email, payload.UploadId)
+	default:
+		err = status.Errorf(codes.InvalidArgument, `File record or upload id must be sent first`)
+	}
+	if err != nil {
+		return err
+	}
+	count := upload.Chunks
+	for {
+		req, err := stream.Recv()
+		if err == io.EOF {
+			return stream.SendAndClose(&pb.UploadFileResponse{Chunks: count, UploadId: upload.ID})
+		}
+		if err != nil {
+			return err
+		}
+		chunk := req.GetChunk()
+		if chunk == nil || chunk.Index != count {
+			return status.Errorf(codes.InvalidArgument, `Chunks must be sent in order, next chunk is %d`, count)
+		}
+		err = g.DB.AddUploadChunk(ctx, email, upload.ID, models.FileChunk{Index: chunk.Index, Data: chunk.Data, Last: chunk.Last}, time.Now())
+		if errors.Is(err, models.ErrNotFound) {
+			return status.Errorf(codes.NotFound, `Upload is finished or expired`)
+		}
+		if err != nil {
+			return models.StatusError(err)
+		}
+		count++
+		if !chunk.Last {
+			continue
+		}
+		revision, err := g.DB.CommitUpload(ctx, email, upload.ID)
+		if errors.Is(err, models.ErrPermissionDenied) {
+			return status.Errorf(codes.PermissionDenied, `Access denied`)
+		}
+		var conflict *models.ConflictError
+		if errors.As(err, &conflict) {
+			return conflictError(conflict.Current)
+		}
+		if err != nil {
+			return models.StatusError(err)
+		}
+		g.Changes.Notify(email)
+		return stream.SendAndClose(&pb.UploadFileResponse{Chunks: count, UploadId: upload.ID, Finished: true, Revision: revision})
+	}
+}
+
+// DownloadFile - gRPC endpoint streams chunks of authenticated user's file, one read at a time.
+// Interrupted download is continued from given chunk.
+func (g GophkeeperServer) DownloadFile(in *pb.DownloadFileRequest, stream pb.Gophkeeper_DownloadFileServer) error {
+	ctx := stream.Context()
+	email, err := principal(ctx)
+	if err != nil {
+		return err
+	}
+	if in.FromIndex < 0 {
+		return status.Errorf(codes.InvalidArgument, `Chunk index must not be negative`)
+	}
+	for index := in.FromIndex; ; index++ {
+		chunk, err := g.DB.GetFileChunk(ctx, email, in.Uuid, index)
+		if errors.Is(err, models.ErrNotFound) {
+			return status.Errorf(codes.NotFound, `File chunk %d not found`, index)
+		}
+		if err != nil {
+			return models.StatusError(err)
+		}
+		err = stream.Send(&pb.FileChunk{Index: chunk.Index, Data: chunk.Data, Last: chunk.Last})
+		if err != nil {
+			return err
+		}
+		if chunk.Last {
+			return nil
+		}
+	}
+}
+
+// PurgeUploads removes uploads which got no chunks for upload TTL.
+// Returns number of purged uploads.
+func (g GophkeeperServer) PurgeUploads(ctx context.Context, now time.Time) (int, error) {
+	count, err := g.DB.PurgeUploads(ctx, now.Add(-g.Config.UploadTTL))
+	if err != nil {
+		return 0, err
+	}
+	if count > 0 {
+		log.Printf("%d abandoned uploads purged", count)
+	}
+	return count, nil
+}
+
+// PurgeUploadsEvery purges abandoned uploads with given interval, errors are logged.
+func (g GophkeeperServer) PurgeUploadsEvery(interval time.Duration) {
+	for range time.Tick(interval) {
+		if _, err := g.PurgeUploads(context.Background(), time.Now()); err != nil {
+			log.Println("uploads purge error: ", err)
+		}
+	}
+}
@@ -0,0 +1,254 @@
+package server
+
+import (
+	"context"
+	"log"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/MaximkaSha/gophkeeper/internal/authserver"
+	"github.com/MaximkaSha/gophkeeper/internal/config"
+	"github.com/MaximkaSha/gophkeeper/internal/models"
+	pb "github.com/MaximkaSha/gophkeeper/internal/proto"
+	"github.com/MaximkaSha/gophkeeper/internal/storage"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+func streamAuthInterceptor(email string) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		return handler(srv, &authStream{ServerStream: ss, ctx: authserver.NewContextWithEmail(ss.Context(), email)})
+	}
+}
+
+// authStream - server stream with authenticated context.
+type authStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *authStream) Context() context.Context {
+	return s.ctx
+}
+
+// upload sends file record based on expected revision and chunks, returns server response.
+func upload(t *testing.T, c pb.GophkeeperClient, meta *pb.CipheredData, expected int64, chunks ...*pb.FileChunk) (*pb.UploadFileResponse, error) {
+	stream, err := c.UploadFile(context.Background())
+	require.NoError(t, err)
+	if meta != nil {
+		require.NoError(t, stream.Send(&pb.UploadFileRequest{Payload: &pb.UploadFileRequest_Meta{Meta: meta}, ExpectedRevision: expected}))
+	}
+	for _, chunk := range chunks {
+		err = stream.Send(&pb.UploadFileRequest{Payload: &pb.UploadFileRequest_Chunk{Chunk: chunk}})
+		if err != nil {
+			break
+		}
+	}
+	return stream.CloseAndRecv()
+}
+
+// download receives all chunks of file.
+func download(c pb.GophkeeperClient, id string) ([]*pb.FileChunk, error) {
+	stream, err := c.DownloadFile(context.Background(), &pb.DownloadFileRequest{Uuid: id})
+	if err != nil {
+		return nil, err
+	}
+	var chunks []*pb.FileChunk
+	for {
+		chunk, err := stream.Recv()
+		if err != nil {
+			return chunks, err
+		}
+		chunks = append(chunks, chunk)
+		if chunk.Last {
+			return chunks, nil
+		}
+	}
+}
+
+func TestGophkeeperServer_Files(t *testing.T) {
+	store := storage.NewMemory()
+	require.NoError(t, store.AddUser(context.Background(), models.User{Email: "test@test.com", Password: "hash"}))
+	require.NoError(t, store.AddUser(context.Background(), models.User{Email: "other@test.com", Password: "hash"}))
+	s := grpc.NewServer(grpc.UnaryInterceptor(authInterceptor("test@test.com")),
+		grpc.StreamInterceptor(streamAuthInterceptor("test@test.com")))
+	pb.RegisterGophkeeperServer(s, GophkeeperServer{DB: store})
+	listen, err := net.Listen("tcp", "localhost:9984")
+	if err != nil {
+		log.Fatal(err)
+	}
+	go s.Serve(listen)
+	defer s.Stop()
+	conn, err := grpc.Dial("localhost:9984", grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	defer conn.Close()
+	c := pb.NewGophkeeperClient(conn)
+
+	meta := models.NewCipheredData([]byte("meta"), "", "DATA", "5a1b4b35-e5a8-4bbc-9d4c-c0c2b5d2c8a7")
+	resp, err := upload(t, c, meta, 0,
+		&pb.FileChunk{Index: 0, Data: []byte("first")},
+		&pb.FileChunk{Index: 1, Data: []byte("second")},
+		&pb.FileChunk{Index: 2, Data: []byte("third"), Last: true})
+	require.NoError(t, err)
+	require.Equal(t, int64(3), resp.Chunks)
+	chunks, err := download(c, meta.Uuid)
+	require.NoError(t, err)
+	require.Len(t, chunks, 3)
+	require.Equal(t, []byte("second"), chunks[1].Data)
+
+	// upload based on outdated revision does not replace content
+	_, err = upload(t, c, meta, 0, &pb.FileChunk{Index: 0, Data: []byte("stale"), Last: true})
+	require.Equal(t, codes.Aborted, status.Code(err))
+	details := status.Convert(err).Details()
+	require.Len(t, details, 1)
+	require.Equal(t, resp.Revision, details[0].(*pb.CipheredData).Revision)
+	chunks, err = download(c, meta.Uuid)
+	require.NoError(t, err)
+	require.Len(t, chunks, 3)
+
+	// shorter upload replaces content
+	replaced, err := upload(t, c, meta, resp.Revision, &pb.FileChunk{Index: 0, Data: []byte("new"), Last: true})
+	require.NoError(t, err)
+	require.Greater(t, replaced.Revision, resp.Revision)
+	chunks, err = download(c, meta.Uuid)
+	require.NoError(t, err)
+	require.Len(t, chunks, 1)
+	require.Equal(t, []byte("new"), chunks[0].Data)
+
+	tests := []struct {
+		name   string
+		meta   *pb.CipheredData
+		chunks []*pb.FileChunk
+		code   codes.Code
+	}{
+		{
+			name:   "no record",
+			chunks: []*pb.FileChunk{{Index: 0, Last: true}},
+			code:   codes.InvalidArgument,
+		},
+		{
+			name:   "not file",
+			meta:   models.NewCipheredData([]byte("meta"), "", "TEXT", meta.Uuid),
+			chunks: []*pb.FileChunk{{Index: 0, Last: true}},
+			code:   codes.InvalidArgument,
+		},
+		{
+			name:   "other user",
+			meta:   models.NewCipheredData([]byte("meta"), "other@test.com", "DATA", meta.Uuid),
+			chunks: []*pb.FileChunk{{Index: 0, Last: true}},
+			code:   codes.PermissionDenied,
+		},
+		{
+			name:   "out of order",
+			meta:   meta,
+			chunks: []*pb.FileChunk{{Index: 1, Last: true}},
+			code:   codes.InvalidArgument,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := upload(t, c, tt.meta, replaced.Revision, tt.chunks...)
+			require.Equal(t, tt.code, status.Code(err))
+		})
+	}
+
+	// file of other user is not found
+	ctx := context.Background()
+	otherFile := models.CipheredData{Data: []byte("meta"), Type: "DATA", User: "other@test.com", ID: "6a1b4b35-e5a8-4bbc-9d4c-c0c2b5d2c8a7"}
+	require.NoError(t, store.AddUpload(ctx, models.Upload{ID: "other", Data: otherFile}))
+	require.NoError(t, store.AddUploadChunk(ctx, otherFile.User, "other", models.FileChunk{Index: 0, Last: true}, time.Now()))
+	_, err = store.CommitUpload(ctx, otherFile.User, "other")
+	require.NoError(t, err)
+	_, err = download(c, otherFile.ID)
+	require.Equal(t, codes.NotFound, status.Code(err))
+	_, err = c.GetUploadStatus(ctx, &pb.UploadStatusRequest{UploadId: "other"})
+	require.Equal(t, codes.NotFound, status.Code(err))
+}
+
+func TestGophkeeperServer_ResumeUpload(t *testing.T) {
+	store := storage.NewMemory()
+	require.NoError(t, store.AddUser(context.Background(), models.User{Email: "test@test.com", Password: "hash"}))
+	s := grpc.NewServer(grpc.UnaryInterceptor(authInterceptor("test@test.com")),
+		grpc.StreamInterceptor(streamAuthInterceptor("test@test.com")))
+	g := GophkeeperServer{DB: store, Config: &config.ServerConfig{UploadTTL: time.Hour}}
+	pb.RegisterGophkeeperServer(s, g)
+	listen, err := net.Listen("tcp", "localhost:9981")
+	if err != nil {
+		log.Fatal(err)
+	}
+	go s.Serve(listen)
+	defer s.Stop()
+	conn, err := grpc.Dial("localhost:9981", grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	defer conn.Close()
+	c := pb.NewGophkeeperClient(conn)
+	ctx := context.Background()
+
+	// broken stream keeps received chunks
+	meta := models.NewCipheredData([]byte("meta"), "", "DATA", "7a1b4b35-e5a8-4bbc-9d4c-c0c2b5d2c8a7")
+	resp, err := upload(t, c, meta, 0, &pb.FileChunk{Index: 0, Data: []byte("first")})
+	require.NoError(t, err)
+	require.False(t, resp.Finished)
+	require.Equal(t, int64(1), resp.Chunks)
+	progress, err := c.GetUploadStatus(ctx, &pb.UploadStatusRequest{UploadId: resp.UploadId})
+	require.NoError(t, err)
+	require.Equal(t, int64(1), progress.Chunks)
+	_, err = download(c, meta.Uuid)
+	require.Equal(t, codes.NotFound, status.Code(err))
+
+	// upload is continued by id from the next chunk
+	resume := func(id string, chunks ...*pb.FileChunk) (*pb.UploadFileResponse, error) {
+		stream, err := c.UploadFile(ctx)
+		require.NoError(t, err)
+		require.NoError(t, stream.Send(&pb.UploadFileRequest{Payload: &pb.UploadFileRequest_UploadId{UploadId: id}}))
+		for _, chunk := range chunks {
+			if err = stream.Send(&pb.UploadFileRequest{Payload: &pb.UploadFileRequest_Chunk{Chunk: chunk}}); err != nil {
+				break
+			}
+		}
+		return stream.CloseAndRecv()
+	}
+	_, err = resume(resp.UploadId, &pb.FileChunk{Index: 0, Data: []byte("first")})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+	resp, err = resume(resp.UploadId, &pb.FileChunk{Index: 1, Data: []byte("second"), Last: true})
+	require.NoError(t, err)
+	require.True(t, resp.Finished)
+	require.Equal(t, int64(2), resp.Chunks)
+	chunks, err := download(c, meta.Uuid)
+	require.NoError(t, err)
+	require.Len(t, chunks, 2)
+
+	// download is continued from given chunk
+	stream, err := c.DownloadFile(ctx, &pb.DownloadFileRequest{Uuid: meta.Uuid, FromIndex: 1})
+	require.NoError(t, err)
+	chunk, err := stream.Recv()
+	require.NoError(t, err)
+	require.Equal(t, []byte("second"), chunk.Data)
+	stream, err = c.DownloadFile(ctx, &pb.DownloadFileRequest{Uuid: meta.Uuid, FromIndex: -1})
+	require.NoError(t, err)
+	_, err = stream.Recv()
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	// finished upload is not continued
+	_, err = resume(resp.UploadId, &pb.FileChunk{Index: 2, Last: true})
+	require.Equal(t, codes.NotFound, status.Code(err))
+
+	// abandoned upload is purged after TTL
+	started, err := c.StartUpload(ctx, &pb.StartUploadRequest{Meta: meta})
+	require.NoError(t, err)
+	count, err := g.PurgeUploads(ctx, time.Now())
+	require.NoError(t, err)
+	require.Equal(t, 0, count)
+	count, err = g.PurgeUploads(ctx, time.Now().Add(2*time.Hour))
+	require.NoError(t, err)
+	require.Equal(t, 1, count)
+	_, err = resume(started.UploadId, &pb.FileChunk{Index: 0, Last: true})
+	require.Equal(t, codes.NotFound, status.Code(err))
+	chunks, err = download(c, meta.Uuid)
+	require.NoError(t, err)
+	require.Len(t, chunks, 2)
+}
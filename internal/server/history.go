@@ -0,0 +1,84 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/MaximkaSha/gophkeeper/internal/models"
+	pb "github.com/MaximkaSha/gophkeeper/internal/proto"
+)
+
+// ListRevisions - gRPC endpoint returns previous versions of authenticated user's record, newest first.
+// Replaced content of file is not kept, FailedPrecondition returned for file record.
+func (g GophkeeperServer) ListRevisions(ctx context.Context, in *pb.ListRevisionsRequest) (*pb.ListRevisionsResponse, error) {
+	var response pb.ListRevisionsResponse
+	email, err := principal(ctx)
+	if err != nil {
+		return &response, err
+	}
+	revisions, err := g.DB.ListRevisions(ctx, email, in.Uuid)
+	if err != nil {
+		return &response, models.StatusError(err)
+	}
+	for _, revision := range revisions {
+		response.Revisions = append(response.Revisions, revision.ToProto())
+	}
+	return &response, nil
+}
+
+// RestoreRevision - gRPC endpoint saves previous version of record as its current version,
+// current version is kept in history. Deleted record is restored as well.
+// Record changed after expected revision is not restored, Aborted returned with current record in details.
+// File record has no previous versions, FailedPrecondition returned.
+func (g GophkeeperServer) RestoreRevision(ctx context.Context, in *pb.RestoreRevisionRequest) (*pb.RestoreRevisionResponse, error) {
+	var response pb.RestoreRevisionResponse
+	email, err := principal(ctx)
+	if err != nil {
+		return &response, err
+	}
+	revision, err := g.DB.GetRevision(ctx, email, in.Uuid, in.Revision)
+	if err != nil {
+		return &response, models.StatusError(err)
+	}
+	data := revision.Data
+	data.Revision = in.ExpectedRevision
+	response.Revision, err = g.DB.AddCipheredData(ctx, data)
+	var conflict *models.ConflictError
+	if errors.As(err, &conflict) {
+		return &response, conflictError(conflict.Current)
+	}
+	if err != nil {
+		return &response, models.StatusError(err)
+	}
+	g.Changes.Notify(email)
+	return &response, nil
+}
+
+// PurgeRevisions removes previous versions of records beyond kept number or older than history TTL,
+// zero number or TTL is no limit.
+// Returns number of purged versions.
+func (g GophkeeperServer) PurgeRevisions(ctx context.Context, now time.Time) (int, error) {
+	before := time.Time{}
+	if g.Config.HistoryTTL > 0 {
+		before = now.Add(-g.Config.HistoryTTL)
+	}
+	count, err := g.DB.PurgeRevisions(ctx, g.Config.HistoryRevisions, before)
+	if err != nil {
+		return 0, err
+	}
+	if count > 0 {
+		log.Printf("%d previous versions of records purged", count)
+	}
+	return count, nil
+}
+
+// PurgeRevisionsEvery purges history of records with given interval, errors are logged.
+func (g GophkeeperServer) PurgeRevisionsEvery(interval time.Duration) {
+	for range time.Tick(interval) {
+		if _, err := g.PurgeRevisions(context.Background(), time.Now()); err != nil {
+			log.Println("history purge error: ", err)
+		}
+	}
+}
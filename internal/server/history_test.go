@@ -0,0 +1,91 @@
+package server
+
+import (
+	"context"
+	"log"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/MaximkaSha/gophkeeper/internal/config"
+	"github.com/MaximkaSha/gophkeeper/internal/models"
+	pb "github.com/MaximkaSha/gophkeeper/internal/proto"
+	"github.com/MaximkaSha/gophkeeper/internal/storage"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+func TestGophkeeperServer_Revisions(t *testing.T) {
+	store := storage.NewMemory()
+	require.NoError(t, store.AddUser(context.Background(), models.User{Email: "test@test.com", Password: "hash"}))
+	g := GophkeeperServer{DB: store, Config: &config.ServerConfig{HistoryRevisions: 1, HistoryTTL: time.Hour}}
+	s := grpc.NewServer(grpc.UnaryInterceptor(authInterceptor("test@test.com")))
+	pb.RegisterGophkeeperServer(s, g)
+	listen, err := net.Listen("tcp", "localhost:9973")
+	if err != nil {
+		log.Fatal(err)
+	}
+	go s.Serve(listen)
+	defer s.Stop()
+	conn, err := grpc.Dial("localhost:9973", grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	defer conn.Close()
+	c := pb.NewGophkeeperClient(conn)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	data := models.NewCipheredData([]byte("first"), "", "PASSWORD", "1a1b4b35-e5a8-4bbc-9d4c-c0c2b5d2c8a7")
+	first, err := c.AddCipheredData(ctx, &pb.AddCipheredDataRequest{Data: data})
+	require.NoError(t, err)
+	data.Data = []byte("second")
+	second, err := c.AddCipheredData(ctx, &pb.AddCipheredDataRequest{Data: data, ExpectedRevision: first.Revision})
+	require.NoError(t, err)
+
+	// replaced version is listed
+	resp, err := c.ListRevisions(ctx, &pb.ListRevisionsRequest{Uuid: data.Uuid})
+	require.NoError(t, err)
+	require.Len(t, resp.Revisions, 1)
+	require.Equal(t, []byte("first"), resp.Revisions[0].Data.Data)
+	require.Equal(t, first.Revision, resp.Revisions[0].Data.Revision)
+	require.NotZero(t, resp.Revisions[0].ReplacedAt)
+
+	// restore is based on current revision
+	_, err = c.RestoreRevision(ctx, &pb.RestoreRevisionRequest{Uuid: data.Uuid, Revision: first.Revision, ExpectedRevision: first.Revision})
+	require.Equal(t, codes.Aborted, status.Code(err))
+	_, err = c.RestoreRevision(ctx, &pb.RestoreRevisionRequest{Uuid: data.Uuid, Revision: second.Revision, ExpectedRevision: second.Revision})
+	require.Equal(t, codes.NotFound, status.Code(err))
+	restored, err := c.RestoreRevision(ctx, &pb.RestoreRevisionRequest{Uuid: data.Uuid, Revision: first.Revision, ExpectedRevision: second.Revision})
+	require.NoError(t, err)
+	stored, err := store.GetCipheredData(ctx, "test@test.com", models.DataPage{})
+	require.NoError(t, err)
+	require.Len(t, stored, 1)
+	require.Equal(t, []byte("first"), stored[0].Data)
+	require.Equal(t, restored.Revision, stored[0].Revision)
+
+	// history of file is rejected
+	file := models.NewCipheredData([]byte("meta"), "", "DATA", "2a1b4b35-e5a8-4bbc-9d4c-c0c2b5d2c8a7")
+	saved, err := c.AddCipheredData(ctx, &pb.AddCipheredDataRequest{Data: file})
+	require.NoError(t, err)
+	_, err = c.ListRevisions(ctx, &pb.ListRevisionsRequest{Uuid: file.Uuid})
+	require.Equal(t, codes.FailedPrecondition, status.Code(err))
+	_, err = c.RestoreRevision(ctx, &pb.RestoreRevisionRequest{Uuid: file.Uuid, Revision: saved.Revision, ExpectedRevision: saved.Revision})
+	require.Equal(t, codes.FailedPrecondition, status.Code(err))
+
+	// versions beyond kept number and retention are purged
+	resp, err = c.ListRevisions(ctx, &pb.ListRevisionsRequest{Uuid: data.Uuid})
+	require.NoError(t, err)
+	require.Len(t, resp.Revisions, 2)
+	unlimited := GophkeeperServer{DB: store, Config: &config.ServerConfig{}}
+	count, err := unlimited.PurgeRevisions(ctx, time.Now())
+	require.NoError(t, err)
+	require.Zero(t, count)
+	count, err = g.PurgeRevisions(ctx, time.Now())
+	require.NoError(t, err)
+	require.Equal(t, 1, count)
+	count, err = g.PurgeRevisions(ctx, time.Now().Add(2*time.Hour))
+	require.NoError(t, err)
+	require.Equal(t, 1, count)
+}
@@ -3,11 +3,19 @@ package server
 
 import (
 	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"time"
 
+	"github.com/MaximkaSha/gophkeeper/internal/authserver"
+	"github.com/MaximkaSha/gophkeeper/internal/blobstore"
 	"github.com/MaximkaSha/gophkeeper/internal/config"
 	"github.com/MaximkaSha/gophkeeper/internal/models"
 	pb "github.com/MaximkaSha/gophkeeper/internal/proto"
 	"github.com/MaximkaSha/gophkeeper/internal/storage"
+	"github.com/google/uuid"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 )
@@ -19,35 +27,151 @@ type GophkeeperServer struct {
 	pb.UnimplementedGophkeeperServer
 	// Serves's config.
 	Config *config.ServerConfig
+	// Watchers of users' vaults.
+	Changes *ChangeHub
 }
 
 // NewGophkeeperServer COnstructor of GophkeeperServer
 func NewGophkeeperServer() GophkeeperServer {
 	config := config.NewServerConfig()
-	return GophkeeperServer{
-		DB:     storage.NewStorage(config.DSN),
-		Config: config,
+	blobs, err := blobstore.New(config.BlobStore)
+	if err != nil {
+		log.Fatalf("blob store error: %s", err.Error())
+	}
+	server := GophkeeperServer{
+		DB:      storage.NewStorager(config.DSN, blobs),
+		Config:  config,
+		Changes: NewChangeHub(),
 	}
+	go server.PurgeUploadsEvery(time.Minute)
+	go server.PurgeRevisionsEvery(time.Minute)
+	return server
+}
+
+// principal returns email of authenticated user.
+func principal(ctx context.Context) (string, error) {
+	email, ok := authserver.EmailFromContext(ctx)
+	if !ok {
+		return "", status.Errorf(codes.Unauthenticated, `user not authenticated`)
+	}
+	return email, nil
 }
 
 // AddCipheredData - gRPC endpoint push data to server's db.
+// Data is always saved for authenticated user.
+// Record changed after expected revision is not saved, Aborted returned with current record in details.
 func (g GophkeeperServer) AddCipheredData(ctx context.Context, in *pb.AddCipheredDataRequest) (*pb.AddCipheredDataResponse, error) {
 	var response pb.AddCipheredDataResponse
+	email, err := principal(ctx)
+	if err != nil {
+		return &response, err
+	}
 	data := models.CipheredData{}
 	data.FromProto(in.Data)
-	err := g.DB.AddCipheredData(data)
+	if data.User != "" && data.User != email {
+		return &response, status.Errorf(codes.PermissionDenied, `Access denied`)
+	}
+	data.User = email
+	data.Revision = in.ExpectedRevision
+	response.Revision, err = g.DB.AddCipheredData(ctx, data)
+	if errors.Is(err, models.ErrPermissionDenied) {
+		return &response, status.Errorf(codes.PermissionDenied, `Access denied`)
+	}
+	var conflict *models.ConflictError
+	if errors.As(err, &conflict) {
+		return &response, conflictError(conflict.Current)
+	}
 	if err != nil {
-		return &response, status.Errorf(codes.InvalidArgument, `Error adding ciphered data `)
+		return &response, models.StatusError(err)
 	}
+	g.Changes.Notify(email)
 	return &response, nil
 }
 
-// GetCipheredDataForUserRequest gRPC endpoint returns all data for given user.
+// conflictError returns Aborted error with current version of record in details,
+// client resolves conflict and saves record again based on its revision.
+func conflictError(current models.CipheredData) error {
+	msg := fmt.Sprintf("record is changed at revision %d", current.Revision)
+	st, err := status.New(codes.Aborted, msg).WithDetails(current.ToProto())
+	if err != nil {
+		return status.Error(codes.Aborted, msg)
+	}
+	return st.Err()
+}
+
+// Page sizes of GetCipheredDataForUserRequest.
+const (
+	defaultPageSize = 100
+	maxPageSize     = 1000
+)
+
+// pageLimit returns page size of request limited by server, negative size is rejected.
+func pageLimit(size int32) (int, error) {
+	if size < 0 {
+		return 0, status.Errorf(codes.InvalidArgument, `Page size must not be negative`)
+	}
+	if size == 0 {
+		return defaultPageSize, nil
+	}
+	if size > maxPageSize {
+		return maxPageSize, nil
+	}
+	return int(size), nil
+}
+
+// encodePageToken returns opaque page token starting after given uuid.
+func encodePageToken(after string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(after))
+}
+
+// decodePageToken returns uuid of last record of previous page.
+func decodePageToken(token string) (string, error) {
+	if token == "" {
+		return "", nil
+	}
+	after, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return "", err
+	}
+	id, err := uuid.Parse(string(after))
+	if err != nil {
+		return "", err
+	}
+	return id.String(), nil
+}
+
+// GetCipheredDataForUserRequest gRPC endpoint returns page of data for authenticated user.
+// Empty vault is returned as empty page.
 func (g GophkeeperServer) GetCipheredDataForUserRequest(ctx context.Context, in *pb.GetCipheredDataRequest) (*pb.GetCipheredDataResponse, error) {
 	var response pb.GetCipheredDataResponse
-	data, err := g.DB.GetCipheredData(in.Email)
+	email, err := principal(ctx)
+	if err != nil {
+		return &response, err
+	}
+	if in.Email != "" && in.Email != email {
+		return &response, status.Errorf(codes.PermissionDenied, `Access denied`)
+	}
+	page := models.DataPage{}
+	page.Limit, err = pageLimit(in.PageSize)
+	if err != nil {
+		return &response, err
+	}
+	page.After, err = decodePageToken(in.PageToken)
 	if err != nil {
-		return &response, status.Errorf(codes.NotFound, `Error getting all ciphered data`)
+		return &response, status.Errorf(codes.InvalidArgument, `Bad page token`)
+	}
+	for _, t := range in.Types {
+		page.Types = append(page.Types, t.String())
+	}
+	// one more record is read to know if there is next page
+	page.Limit++
+	data, err := g.DB.GetCipheredData(ctx, email, page)
+	if err != nil {
+		return &response, models.StatusError(err)
+	}
+	if len(data) == page.Limit {
+		data = data[:len(data)-1]
+		response.NextPageToken = encodePageToken(data[len(data)-1].ID)
 	}
 	for _, val := range data {
 		pVal := val.ToProto()
@@ -56,12 +180,57 @@ func (g GophkeeperServer) GetCipheredDataForUserRequest(ctx context.Context, in
 	return &response, nil
 }
 
-// DelCipheredData - gRPC endpoint delete data from by given uuid
+// DelCipheredData - gRPC endpoint delete data of authenticated user by given uuid
+// Record changed after expected revision is not deleted, Aborted returned with current record in details.
 func (g GophkeeperServer) DelCipheredData(ctx context.Context, in *pb.DelCipheredDataRequest) (*pb.DelCiphereDataResponse, error) {
 	var response pb.DelCiphereDataResponse
-	err := g.DB.DelCiphereData(in.Uuid)
+	email, err := principal(ctx)
 	if err != nil {
-		return &response, status.Errorf(codes.Unknown, `Error getting all ciphered data`)
+		return &response, err
+	}
+	err = g.DB.DelCiphereData(ctx, email, in.Uuid, in.ExpectedRevision)
+	if errors.Is(err, models.ErrPermissionDenied) {
+		return &response, status.Errorf(codes.PermissionDenied, `Access denied`)
+	}
+	var conflict *models.ConflictError
+	if errors.As(err, &conflict) {
+		return &response, conflictError(conflict.Current)
+	}
+	if err != nil {
+		return &response, models.StatusError(err)
+	}
+	g.Changes.Notify(email)
+	return &response, nil
+}
+
+// ListChanges - gRPC endpoint returns records of authenticated user changed after given revision,
+// deleted records are returned as tombstones without data.
+func (g GophkeeperServer) ListChanges(ctx context.Context, in *pb.ListChangesRequest) (*pb.ListChangesResponse, error) {
+	var response pb.ListChangesResponse
+	email, err := principal(ctx)
+	if err != nil {
+		return &response, err
+	}
+	if in.SinceRevision < 0 {
+		return &response, status.Errorf(codes.InvalidArgument, `Revision must not be negative`)
+	}
+	limit, err := pageLimit(in.PageSize)
+	if err != nil {
+		return &response, err
+	}
+	// one more change is read to know if there are more
+	changes, err := g.DB.ListChanges(ctx, email, in.SinceRevision, limit+1)
+	if err != nil {
+		return &response, models.StatusError(err)
+	}
+	if len(changes) > limit {
+		changes = changes[:limit]
+		response.More = true
+	}
+	response.Revision = in.SinceRevision
+	for _, change := range changes {
+		response.Changes = append(response.Changes, change.ToProto())
+		response.Revision = change.Revision
 	}
 	return &response, nil
 }
@@ -7,15 +7,26 @@ import (
 	"net"
 	"testing"
 
+	"github.com/MaximkaSha/gophkeeper/internal/authserver"
 	"github.com/MaximkaSha/gophkeeper/internal/mockdb"
 	"github.com/MaximkaSha/gophkeeper/internal/models"
 	pb "github.com/MaximkaSha/gophkeeper/internal/proto"
 	"github.com/golang/mock/gomock"
 	"github.com/stretchr/testify/require"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
 )
 
+// authInterceptor authenticates every request as given user.
+func authInterceptor(email string) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		return handler(authserver.NewContextWithEmail(ctx, email), req)
+	}
+}
+
 func TestGophkeeperServer_AddCipheredData(t *testing.T) {
 
 	tests := []struct {
@@ -36,11 +47,11 @@ func TestGophkeeperServer_AddCipheredData(t *testing.T) {
 				ID:   "111-111-111",
 			}
 			store := mockdb.NewMockStorager(ctrl)
-			store.EXPECT().AddCipheredData(gomock.Eq(data))
+			store.EXPECT().AddCipheredData(gomock.Any(), gomock.Eq(data)).Return(int64(1), nil)
 			Server := GophkeeperServer{
 				DB: store,
 			}
-			s := grpc.NewServer()
+			s := grpc.NewServer(grpc.UnaryInterceptor(authInterceptor(data.User)))
 			pb.RegisterGophkeeperServer(s, Server)
 			listen, err := net.Listen("tcp", "localhost:9999")
 			if err != nil {
@@ -53,15 +64,30 @@ func TestGophkeeperServer_AddCipheredData(t *testing.T) {
 				log.Fatal(err)
 			}
 			c := pb.NewGophkeeperClient(conn)
-			_, err = c.AddCipheredData(context.Background(), &pb.AddCipheredDataRequest{
+			resp, err := c.AddCipheredData(context.Background(), &pb.AddCipheredDataRequest{
 				Data: data.ToProto(),
 			})
 			require.NoError(t, err)
-			store.EXPECT().AddCipheredData(gomock.Eq(data)).Return(errors.New("no data"))
+			require.Equal(t, int64(1), resp.Revision)
+			store.EXPECT().AddCipheredData(gomock.Any(), gomock.Eq(data)).Return(int64(0), errors.New("no data"))
 			_, err = c.AddCipheredData(context.Background(), &pb.AddCipheredDataRequest{
 				Data: data.ToProto(),
 			})
 			require.Error(t, err)
+
+			// change based on outdated revision returns current record in details
+			current := data
+			current.Data, current.Revision = []byte("2"), 3
+			data.Revision = 1
+			store.EXPECT().AddCipheredData(gomock.Any(), gomock.Eq(data)).Return(int64(0), &models.ConflictError{Current: current})
+			_, err = c.AddCipheredData(context.Background(), &pb.AddCipheredDataRequest{
+				Data:             data.ToProto(),
+				ExpectedRevision: 1,
+			})
+			require.Equal(t, codes.Aborted, status.Code(err))
+			details := status.Convert(err).Details()
+			require.Len(t, details, 1)
+			require.True(t, proto.Equal(current.ToProto(), details[0].(*pb.CipheredData)))
 		})
 	}
 }
@@ -85,7 +111,7 @@ func TestGophkeeperServer_GetCipheredDataForUserRequest(t *testing.T) {
 				ID:   "111-111-111",
 			}
 			store := mockdb.NewMockStorager(ctrl)
-			store.EXPECT().GetCipheredData(gomock.Eq(data.User)).Return([]models.CipheredData{
+			store.EXPECT().GetCipheredData(gomock.Any(), gomock.Eq(data.User), gomock.Any()).Return([]models.CipheredData{
 				{
 					Type: "CC",
 					Data: []byte("1"),
@@ -102,7 +128,7 @@ func TestGophkeeperServer_GetCipheredDataForUserRequest(t *testing.T) {
 			Server := GophkeeperServer{
 				DB: store,
 			}
-			s := grpc.NewServer()
+			s := grpc.NewServer(grpc.UnaryInterceptor(authInterceptor(data.User)))
 			pb.RegisterGophkeeperServer(s, Server)
 			listen, err := net.Listen("tcp", "localhost:9998")
 			if err != nil {
@@ -120,11 +146,16 @@ func TestGophkeeperServer_GetCipheredDataForUserRequest(t *testing.T) {
 				Email: data.User,
 			})
 			require.NoError(t, err)
-			store.EXPECT().GetCipheredData(gomock.Eq(data.User)).Return([]models.CipheredData{}, errors.New("no data"))
+			store.EXPECT().GetCipheredData(gomock.Any(), gomock.Eq(data.User), gomock.Any()).Return([]models.CipheredData{}, models.ErrNotFound)
 			_, err = c.GetCipheredDataForUserRequest(context.Background(), &pb.GetCipheredDataRequest{
 				Email: data.User,
 			})
-			require.Error(t, err)
+			require.Equal(t, codes.NotFound, status.Code(err))
+			store.EXPECT().GetCipheredData(gomock.Any(), gomock.Eq(data.User), gomock.Any()).Return([]models.CipheredData{}, errors.New("connection refused"))
+			_, err = c.GetCipheredDataForUserRequest(context.Background(), &pb.GetCipheredDataRequest{
+				Email: data.User,
+			})
+			require.Equal(t, codes.Internal, status.Code(err))
 
 		})
 	}
@@ -149,11 +180,11 @@ func TestGophkeeperServer_DelCipheredData(t *testing.T) {
 				ID:   "111-111-111",
 			}
 			store := mockdb.NewMockStorager(ctrl)
-			store.EXPECT().DelCiphereData(gomock.Eq(data.ID)).Return(nil)
+			store.EXPECT().DelCiphereData(gomock.Any(), gomock.Eq(data.User), gomock.Eq(data.ID), gomock.Eq(int64(0))).Return(nil)
 			Server := GophkeeperServer{
 				DB: store,
 			}
-			s := grpc.NewServer()
+			s := grpc.NewServer(grpc.UnaryInterceptor(authInterceptor(data.User)))
 			pb.RegisterGophkeeperServer(s, Server)
 			listen, err := net.Listen("tcp", "localhost:9997")
 			if err != nil {
@@ -171,12 +202,158 @@ func TestGophkeeperServer_DelCipheredData(t *testing.T) {
 				Uuid: data.ID,
 			})
 			require.NoError(t, err)
-			store.EXPECT().DelCiphereData(gomock.Eq(data.ID)).Return(errors.New("no data"))
+			store.EXPECT().DelCiphereData(gomock.Any(), gomock.Eq(data.User), gomock.Eq(data.ID), gomock.Eq(int64(0))).Return(errors.New("no data"))
 			_, err = c.DelCipheredData(context.Background(), &pb.DelCipheredDataRequest{
 				Uuid: data.ID,
 			})
 			require.Error(t, err)
 
+			// deletion based on outdated revision returns current record in details
+			current := data
+			current.Revision = 3
+			store.EXPECT().DelCiphereData(gomock.Any(), gomock.Eq(data.User), gomock.Eq(data.ID), gomock.Eq(int64(1))).Return(&models.ConflictError{Current: current})
+			_, err = c.DelCipheredData(context.Background(), &pb.DelCipheredDataRequest{
+				Uuid:             data.ID,
+				ExpectedRevision: 1,
+			})
+			require.Equal(t, codes.Aborted, status.Code(err))
+			details := status.Convert(err).Details()
+			require.Len(t, details, 1)
+			require.True(t, proto.Equal(current.ToProto(), details[0].(*pb.CipheredData)))
 		})
 	}
 }
+
+func TestGophkeeperServer_GetCipheredDataPages(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	store := mockdb.NewMockStorager(ctrl)
+	Server := GophkeeperServer{
+		DB: store,
+	}
+	ctx := authserver.NewContextWithEmail(context.Background(), "test@test.com")
+	first := models.CipheredData{Type: "CC", Data: []byte("1"), User: "test@test.com", ID: "1a1b4b35-e5a8-4bbc-9d4c-c0c2b5d2c8a7"}
+	second := models.CipheredData{Type: "CC", Data: []byte("2"), User: "test@test.com", ID: "2a1b4b35-e5a8-4bbc-9d4c-c0c2b5d2c8a7"}
+
+	// empty vault is empty page
+	store.EXPECT().GetCipheredData(gomock.Any(), gomock.Eq("test@test.com"), gomock.Eq(models.DataPage{Limit: defaultPageSize + 1})).Return([]models.CipheredData{}, nil)
+	resp, err := Server.GetCipheredDataForUserRequest(ctx, &pb.GetCipheredDataRequest{})
+	require.NoError(t, err)
+	require.Empty(t, resp.Data)
+	require.Empty(t, resp.NextPageToken)
+
+	// one more record than page size means next page
+	store.EXPECT().GetCipheredData(gomock.Any(), gomock.Eq("test@test.com"), gomock.Eq(models.DataPage{Limit: 2, Types: []string{"CC"}})).Return([]models.CipheredData{first, second}, nil)
+	resp, err = Server.GetCipheredDataForUserRequest(ctx, &pb.GetCipheredDataRequest{
+		PageSize: 1,
+		Types:    []pb.CipheredData_Type{pb.CipheredData_CC},
+	})
+	require.NoError(t, err)
+	require.Len(t, resp.Data, 1)
+	require.Equal(t, first.ID, resp.Data[0].Uuid)
+	require.NotEmpty(t, resp.NextPageToken)
+	store.EXPECT().GetCipheredData(gomock.Any(), gomock.Eq("test@test.com"), gomock.Eq(models.DataPage{After: first.ID, Limit: 2, Types: []string{"CC"}})).Return([]models.CipheredData{second}, nil)
+	resp, err = Server.GetCipheredDataForUserRequest(ctx, &pb.GetCipheredDataRequest{
+		PageSize:  1,
+		PageToken: resp.NextPageToken,
+		Types:     []pb.CipheredData_Type{pb.CipheredData_CC},
+	})
+	require.NoError(t, err)
+	require.Len(t, resp.Data, 1)
+	require.Equal(t, second.ID, resp.Data[0].Uuid)
+	require.Empty(t, resp.NextPageToken)
+
+	// page size is limited
+	store.EXPECT().GetCipheredData(gomock.Any(), gomock.Any(), gomock.Eq(models.DataPage{Limit: maxPageSize + 1})).Return([]models.CipheredData{}, nil)
+	_, err = Server.GetCipheredDataForUserRequest(ctx, &pb.GetCipheredDataRequest{PageSize: maxPageSize * 10})
+	require.NoError(t, err)
+
+	_, err = Server.GetCipheredDataForUserRequest(ctx, &pb.GetCipheredDataRequest{PageSize: -1})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+	_, err = Server.GetCipheredDataForUserRequest(ctx, &pb.GetCipheredDataRequest{PageToken: "not a token"})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+	_, err = Server.GetCipheredDataForUserRequest(ctx, &pb.GetCipheredDataRequest{PageToken: encodePageToken("'; DROP TABLE users")})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestGophkeeperServer_ListChanges(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	store := mockdb.NewMockStorager(ctrl)
+	Server := GophkeeperServer{
+		DB: store,
+	}
+	ctx := authserver.NewContextWithEmail(context.Background(), "test@test.com")
+	added := models.CipheredData{Type: "CC", Data: []byte("1"), User: "test@test.com", ID: "1a1b4b35-e5a8-4bbc-9d4c-c0c2b5d2c8a7", Revision: 3}
+	deleted := models.CipheredData{Type: "CC", User: "test@test.com", ID: "2a1b4b35-e5a8-4bbc-9d4c-c0c2b5d2c8a7", Revision: 4, Deleted: true}
+
+	// no changes keeps revision
+	store.EXPECT().ListChanges(gomock.Any(), gomock.Eq("test@test.com"), gomock.Eq(int64(5)), gomock.Eq(defaultPageSize+1)).Return([]models.CipheredData{}, nil)
+	resp, err := Server.ListChanges(ctx, &pb.ListChangesRequest{SinceRevision: 5})
+	require.NoError(t, err)
+	require.Empty(t, resp.Changes)
+	require.Equal(t, int64(5), resp.Revision)
+	require.False(t, resp.More)
+
+	// one more change than page size means more changes
+	store.EXPECT().ListChanges(gomock.Any(), gomock.Eq("test@test.com"), gomock.Eq(int64(2)), gomock.Eq(2)).Return([]models.CipheredData{added, deleted}, nil)
+	resp, err = Server.ListChanges(ctx, &pb.ListChangesRequest{SinceRevision: 2, PageSize: 1})
+	require.NoError(t, err)
+	require.Len(t, resp.Changes, 1)
+	require.Equal(t, added.ID, resp.Changes[0].Uuid)
+	require.Equal(t, int64(3), resp.Revision)
+	require.True(t, resp.More)
+	store.EXPECT().ListChanges(gomock.Any(), gomock.Eq("test@test.com"), gomock.Eq(int64(3)), gomock.Eq(2)).Return([]models.CipheredData{deleted}, nil)
+	resp, err = Server.ListChanges(ctx, &pb.ListChangesRequest{SinceRevision: resp.Revision, PageSize: 1})
+	require.NoError(t, err)
+	require.Len(t, resp.Changes, 1)
+	require.True(t, resp.Changes[0].Deleted)
+	require.Empty(t, resp.Changes[0].Data)
+	require.Equal(t, int64(4), resp.Revision)
+	require.False(t, resp.More)
+
+	store.EXPECT().ListChanges(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(nil, errors.New("no data"))
+	_, err = Server.ListChanges(ctx, &pb.ListChangesRequest{})
+	require.Equal(t, codes.Internal, status.Code(err))
+	_, err = Server.ListChanges(ctx, &pb.ListChangesRequest{SinceRevision: -1})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+	_, err = Server.ListChanges(ctx, &pb.ListChangesRequest{PageSize: -1})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+	_, err = Server.ListChanges(context.Background(), &pb.ListChangesRequest{})
+	require.Equal(t, codes.Unauthenticated, status.Code(err))
+}
+
+func TestGophkeeperServer_Principal(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	store := mockdb.NewMockStorager(ctrl)
+	Server := GophkeeperServer{
+		DB: store,
+	}
+	data := models.CipheredData{
+		Type: "CC",
+		Data: []byte("1"),
+		User: "other@test.com",
+		ID:   "111-111-111",
+	}
+	ctx := context.Background()
+	_, err := Server.GetCipheredDataForUserRequest(ctx, &pb.GetCipheredDataRequest{})
+	require.Equal(t, codes.Unauthenticated, status.Code(err))
+	ctx = authserver.NewContextWithEmail(ctx, "test@test.com")
+	_, err = Server.GetCipheredDataForUserRequest(ctx, &pb.GetCipheredDataRequest{Email: data.User})
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+	_, err = Server.AddCipheredData(ctx, &pb.AddCipheredDataRequest{Data: data.ToProto()})
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+	data.User = ""
+	store.EXPECT().AddCipheredData(gomock.Any(), gomock.Eq(models.CipheredData{
+		Type: data.Type,
+		Data: data.Data,
+		User: "test@test.com",
+		ID:   data.ID,
+	})).Return(int64(0), models.ErrPermissionDenied)
+	_, err = Server.AddCipheredData(ctx, &pb.AddCipheredDataRequest{Data: data.ToProto()})
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+	store.EXPECT().DelCiphereData(gomock.Any(), gomock.Eq("test@test.com"), gomock.Eq(data.ID), gomock.Any()).Return(models.ErrPermissionDenied)
+	_, err = Server.DelCipheredData(ctx, &pb.DelCipheredDataRequest{Uuid: data.ID})
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+}
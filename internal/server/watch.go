@@ -0,0 +1,167 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/MaximkaSha/gophkeeper/internal/authserver"
+	"github.com/MaximkaSha/gophkeeper/internal/models"
+	pb "github.com/MaximkaSha/gophkeeper/internal/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// watchPoll - interval of checking vault for changes made by other server instances.
+var watchPoll = 30 * time.Second
+
+// ChangeHub - notifies watchers of user's vault about new changes.
+// Nil hub notifies nobody, watchers only poll then.
+type ChangeHub struct {
+	mu       sync.Mutex
+	watchers map[string]map[chan struct{}]struct{}
+}
+
+// NewChangeHub - constructor of ChangeHub.
+func NewChangeHub() *ChangeHub {
+	return &ChangeHub{
+		watchers: make(map[string]map[chan struct{}]struct{}),
+	}
+}
+
+// Subscribe returns channel signaled on changes of user's vault and func to unsubscribe.
+func (h *ChangeHub) Subscribe(email string) (<-chan struct{}, func()) {
+	if h == nil {
+		return nil, func() {}
+	}
+	// changes made while watcher is busy are signaled once
+	changed := make(chan struct{}, 1)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.watchers[email] == nil {
+		h.watchers[email] = make(map[chan struct{}]struct{})
+	}
+	h.watchers[email][changed] = struct{}{}
+	return changed, func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		delete(h.watchers[email], changed)
+		if len(h.watchers[email]) == 0 {
+			delete(h.watchers, email)
+		}
+	}
+}
+
+// Notify signals watchers of user's vault.
+func (h *ChangeHub) Notify(email string) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for changed := range h.watchers[email] {
+		select {
+		case changed <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Watch - gRPC endpoint streams changes of authenticated user's vault made after given revision.
+// Changes already made are sent first, later ones are pushed as they happen.
+// Stream ends with Unauthenticated when access token expires or its session is revoked,
+// client reconnects with refreshed token and resumes after last received revision.
+func (g GophkeeperServer) Watch(in *pb.WatchRequest, stream pb.Gophkeeper_WatchServer) error {
+	ctx := stream.Context()
+	email, err := principal(ctx)
+	if err != nil {
+		return err
+	}
+	if in.SinceRevision < 0 {
+		return status.Errorf(codes.InvalidArgument, `Revision must not be negative`)
+	}
+	err = g.checkSession(ctx)
+	if err != nil {
+		return err
+	}
+	var expired <-chan time.Time
+	if expires, ok := authserver.ExpiresFromContext(ctx); ok {
+		timer := time.NewTimer(time.Until(expires))
+		defer timer.Stop()
+		expired = timer.C
+	}
+	// subscribed before reading, so no change is missed
+	changed, unsubscribe := g.Changes.Subscribe(email)
+	defer unsubscribe()
+	poll := time.NewTicker(watchPoll)
+	defer poll.Stop()
+	since := in.SinceRevision
+	for {
+		since, err = g.sendChanges(stream, email, since)
+		if err != nil {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return status.FromContextError(ctx.Err()).Err()
+		case <-expired:
+			return status.Errorf(codes.Unauthenticated, `token expired`)
+		case <-changed:
+		case <-poll.C:
+			err = g.checkSession(ctx)
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// checkSession checks that session of authenticated request is not revoked or expired.
+// Requests without session are not checked.
+func (g GophkeeperServer) checkSession(ctx context.Context) error {
+	id, ok := authserver.SessionFromContext(ctx)
+	if !ok {
+		return nil
+	}
+	session, err := g.DB.GetSession(ctx, id)
+	if errors.Is(err, models.ErrNotFound) {
+		return status.Errorf(codes.Unauthenticated, `session not found`)
+	}
+	if err != nil {
+		return models.StatusError(err)
+	}
+	if session.Revoked || time.Now().After(session.ExpiresAt) {
+		return status.Errorf(codes.Unauthenticated, `session revoked`)
+	}
+	return nil
+}
+
+// sendChanges sends changes after given revision by pages, returns revision of last sent change.
+// Cancelled stream stops paging.
+func (g GophkeeperServer) sendChanges(stream pb.Gophkeeper_WatchServer, email string, since int64) (int64, error) {
+	for {
+		if err := stream.Context().Err(); err != nil {
+			return since, status.FromContextError(err).Err()
+		}
+		changes, err := g.DB.ListChanges(stream.Context(), email, since, maxPageSize)
+		if err != nil {
+			return since, models.StatusError(err)
+		}
+		if len(changes) == 0 {
+			return since, nil
+		}
+		event := pb.WatchEvent{}
+		for _, change := range changes {
+			event.Changes = append(event.Changes, change.ToProto())
+		}
+		since = changes[len(changes)-1].Revision
+		event.Revision = since
+		if err := stream.Send(&event); err != nil {
+			return since, err
+		}
+		if len(changes) < maxPageSize {
+			return since, nil
+		}
+	}
+}
@@ -0,0 +1,274 @@
+package server
+
+import (
+	"context"
+	"log"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/MaximkaSha/gophkeeper/internal/authserver"
+	"github.com/MaximkaSha/gophkeeper/internal/mockdb"
+	"github.com/MaximkaSha/gophkeeper/internal/models"
+	pb "github.com/MaximkaSha/gophkeeper/internal/proto"
+	"github.com/MaximkaSha/gophkeeper/internal/storage"
+	"github.com/golang/mock/gomock"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+func TestChangeHub(t *testing.T) {
+	hub := NewChangeHub()
+	first, unsubscribe := hub.Subscribe("test@test.com")
+	second, _ := hub.Subscribe("test@test.com")
+	other, _ := hub.Subscribe("other@test.com")
+
+	// changes are signaled once until received
+	hub.Notify("test@test.com")
+	hub.Notify("test@test.com")
+	require.Len(t, first, 1)
+	require.Len(t, second, 1)
+	require.Len(t, other, 0)
+	<-first
+	<-second
+
+	unsubscribe()
+	hub.Notify("test@test.com")
+	require.Len(t, first, 0)
+	require.Len(t, second, 1)
+
+	// nil hub notifies nobody
+	var none *ChangeHub
+	changed, unsubscribe := none.Subscribe("test@test.com")
+	require.Nil(t, changed)
+	none.Notify("test@test.com")
+	unsubscribe()
+}
+
+func TestGophkeeperServer_Watch(t *testing.T) {
+	store := storage.NewMemory()
+	require.NoError(t, store.AddUser(context.Background(), models.User{Email: "test@test.com", Password: "hash"}))
+	s := grpc.NewServer(grpc.UnaryInterceptor(authInterceptor("test@test.com")),
+		grpc.StreamInterceptor(streamAuthInterceptor("test@test.com")))
+	pb.RegisterGophkeeperServer(s, GophkeeperServer{DB: store, Changes: NewChangeHub()})
+	listen, err := net.Listen("tcp", "localhost:9978")
+	if err != nil {
+		log.Fatal(err)
+	}
+	go s.Serve(listen)
+	defer s.Stop()
+	conn, err := grpc.Dial("localhost:9978", grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	defer conn.Close()
+	c := pb.NewGophkeeperClient(conn)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	first := models.NewCipheredData([]byte("first"), "", "TEXT", "1a1b4b35-e5a8-4bbc-9d4c-c0c2b5d2c8a7")
+	second := models.NewCipheredData([]byte("second"), "", "TEXT", "2a1b4b35-e5a8-4bbc-9d4c-c0c2b5d2c8a7")
+	_, err = c.AddCipheredData(ctx, &pb.AddCipheredDataRequest{Data: first})
+	require.NoError(t, err)
+
+	// changes already made are sent first
+	stream, err := c.Watch(ctx, &pb.WatchRequest{})
+	require.NoError(t, err)
+	event, err := stream.Recv()
+	require.NoError(t, err)
+	require.Len(t, event.Changes, 1)
+	require.Equal(t, first.Uuid, event.Changes[0].Uuid)
+	require.Equal(t, int64(1), event.Revision)
+
+	// later changes are pushed
+	_, err = c.AddCipheredData(ctx, &pb.AddCipheredDataRequest{Data: second})
+	require.NoError(t, err)
+	event, err = stream.Recv()
+	require.NoError(t, err)
+	require.Len(t, event.Changes, 1)
+	require.Equal(t, second.Uuid, event.Changes[0].Uuid)
+	require.Equal(t, int64(2), event.Revision)
+	_, err = c.DelCipheredData(ctx, &pb.DelCipheredDataRequest{Uuid: first.Uuid, ExpectedRevision: 1})
+	require.NoError(t, err)
+	event, err = stream.Recv()
+	require.NoError(t, err)
+	require.Len(t, event.Changes, 1)
+	require.True(t, event.Changes[0].Deleted)
+	require.Equal(t, int64(3), event.Revision)
+
+	// watch is resumed after given revision
+	resumed, err := c.Watch(ctx, &pb.WatchRequest{SinceRevision: 2})
+	require.NoError(t, err)
+	event, err = resumed.Recv()
+	require.NoError(t, err)
+	require.Len(t, event.Changes, 1)
+	require.Equal(t, first.Uuid, event.Changes[0].Uuid)
+	require.True(t, event.Changes[0].Deleted)
+
+	invalid, err := c.Watch(ctx, &pb.WatchRequest{SinceRevision: -1})
+	require.NoError(t, err)
+	_, err = invalid.Recv()
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestGophkeeperServer_WatchPoll(t *testing.T) {
+	watchPoll = 10 * time.Millisecond
+	defer func() { watchPoll = 30 * time.Second }()
+	store := storage.NewMemory()
+	require.NoError(t, store.AddUser(context.Background(), models.User{Email: "test@test.com", Password: "hash"}))
+	s := grpc.NewServer(grpc.StreamInterceptor(streamAuthInterceptor("test@test.com")))
+	// server without hub is not notified, changes are polled
+	pb.RegisterGophkeeperServer(s, GophkeeperServer{DB: store})
+	listen, err := net.Listen("tcp", "localhost:9977")
+	if err != nil {
+		log.Fatal(err)
+	}
+	go s.Serve(listen)
+	defer s.Stop()
+	conn, err := grpc.Dial("localhost:9977", grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	defer conn.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	stream, err := pb.NewGophkeeperClient(conn).Watch(ctx, &pb.WatchRequest{})
+	require.NoError(t, err)
+	data := models.CipheredData{Data: []byte("data"), Type: "TEXT", User: "test@test.com", ID: "1a1b4b35-e5a8-4bbc-9d4c-c0c2b5d2c8a7"}
+	_, err = store.AddCipheredData(ctx, data)
+	require.NoError(t, err)
+	event, err := stream.Recv()
+	require.NoError(t, err)
+	require.Len(t, event.Changes, 1)
+	require.Equal(t, data.ID, event.Changes[0].Uuid)
+}
+
+// streamSessionInterceptor authenticates every stream as given session with token expiring at given time.
+func streamSessionInterceptor(session models.Session, expires func() time.Time) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx := authserver.NewContextWithSession(ss.Context(), session.User, session.ID, expires())
+		return handler(srv, &authStream{ServerStream: ss, ctx: ctx})
+	}
+}
+
+func TestGophkeeperServer_WatchSession(t *testing.T) {
+	watchPoll = 10 * time.Millisecond
+	defer func() { watchPoll = 30 * time.Second }()
+	store := storage.NewMemory()
+	require.NoError(t, store.AddUser(context.Background(), models.User{Email: "test@test.com", Password: "hash"}))
+	session := models.Session{
+		ID:        "b5d0c2a8-4bbc-4a5e-9d4c-c0c2b5d2c8a7",
+		User:      "test@test.com",
+		CreatedAt: time.Now(),
+		LastSeen:  time.Now(),
+		ExpiresAt: time.Now().Add(time.Hour),
+	}
+	require.NoError(t, store.AddSession(context.Background(), session))
+	tokenTTL := time.Hour
+	s := grpc.NewServer(grpc.StreamInterceptor(streamSessionInterceptor(session, func() time.Time { return time.Now().Add(tokenTTL) })))
+	pb.RegisterGophkeeperServer(s, GophkeeperServer{DB: store, Changes: NewChangeHub()})
+	listen, err := net.Listen("tcp", "localhost:9970")
+	if err != nil {
+		log.Fatal(err)
+	}
+	go s.Serve(listen)
+	defer s.Stop()
+	conn, err := grpc.Dial("localhost:9970", grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	defer conn.Close()
+	c := pb.NewGophkeeperClient(conn)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	data := models.CipheredData{Data: []byte("data"), Type: "TEXT", User: session.User, ID: "1a1b4b35-e5a8-4bbc-9d4c-c0c2b5d2c8a7"}
+	_, err = store.AddCipheredData(ctx, data)
+	require.NoError(t, err)
+
+	// stream ends when token expires
+	tokenTTL = 2 * time.Second
+	stream, err := c.Watch(ctx, &pb.WatchRequest{})
+	require.NoError(t, err)
+	_, err = stream.Recv()
+	require.NoError(t, err)
+	_, err = stream.Recv()
+	require.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	// stream ends when session is terminated
+	tokenTTL = time.Hour
+	stream, err = c.Watch(ctx, &pb.WatchRequest{})
+	require.NoError(t, err)
+	_, err = stream.Recv()
+	require.NoError(t, err)
+	auth := authserver.AuthGophkeeperServer{DB: store}
+	_, err = auth.TerminateSession(authserver.NewContextWithEmail(ctx, session.User), &pb.TerminateSessionRequest{SessionId: session.ID})
+	require.NoError(t, err)
+	_, err = stream.Recv()
+	require.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	// stream of revoked session is not opened
+	stream, err = c.Watch(ctx, &pb.WatchRequest{})
+	require.NoError(t, err)
+	_, err = stream.Recv()
+	require.Equal(t, codes.Unauthenticated, status.Code(err))
+}
+
+// watchStream - server side of watch stream which collects sent events.
+// Context is cancelled after given number of events.
+type watchStream struct {
+	pb.Gophkeeper_WatchServer
+	ctx         context.Context
+	cancel      context.CancelFunc
+	cancelAfter int
+	events      []*pb.WatchEvent
+}
+
+func (s *watchStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *watchStream) Send(event *pb.WatchEvent) error {
+	s.events = append(s.events, event)
+	if len(s.events) == s.cancelAfter {
+		s.cancel()
+	}
+	return nil
+}
+
+func TestGophkeeperServer_sendChanges(t *testing.T) {
+	store := storage.NewMemory()
+	require.NoError(t, store.AddUser(context.Background(), models.User{Email: "test@test.com", Password: "hash"}))
+	for i := 0; i < maxPageSize+1; i++ {
+		_, err := store.AddCipheredData(context.Background(), models.CipheredData{Data: []byte("data"), Type: "TEXT", User: "test@test.com", ID: uuid.NewString()})
+		require.NoError(t, err)
+	}
+	g := GophkeeperServer{DB: store}
+
+	// backlog is sent by pages
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stream := &watchStream{ctx: ctx, cancel: cancel}
+	since, err := g.sendChanges(stream, "test@test.com", 0)
+	require.NoError(t, err)
+	require.Equal(t, int64(maxPageSize+1), since)
+	require.Len(t, stream.events, 2)
+	require.Len(t, stream.events[0].Changes, maxPageSize)
+	require.Equal(t, int64(maxPageSize), stream.events[0].Revision)
+	require.Len(t, stream.events[1].Changes, 1)
+	require.Equal(t, since, stream.events[1].Revision)
+
+	// cancelled stream stops paging, next page is not read
+	changes, err := store.ListChanges(context.Background(), "test@test.com", 0, maxPageSize)
+	require.NoError(t, err)
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mock := mockdb.NewMockStorager(ctrl)
+	mock.EXPECT().ListChanges(gomock.Any(), gomock.Eq("test@test.com"), gomock.Eq(int64(0)), gomock.Eq(maxPageSize)).Return(changes, nil)
+	g = GophkeeperServer{DB: mock}
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	stream = &watchStream{ctx: ctx, cancel: cancel, cancelAfter: 1}
+	since, err = g.sendChanges(stream, "test@test.com", 0)
+	require.Equal(t, codes.Canceled, status.Code(err))
+	require.Equal(t, int64(maxPageSize), since)
+	require.Len(t, stream.events, 1)
+}
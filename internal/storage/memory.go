@@ -0,0 +1,998 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/MaximkaSha/gophkeeper/internal/blobstore"
+	"github.com/MaximkaSha/gophkeeper/internal/models"
+)
+
+// MemoryScheme - DSN prefix of in-memory storage, e.g. "memory://" or "memory://test".
+// Storages with the same DSN are shared inside process, data is lost on exit.
+const MemoryScheme = "memory://"
+
+var (
+	memoryStoresMu sync.Mutex
+	memoryStores   = make(map[string]*Memory)
+)
+
+// memoryUser - user with id which ciphered data refers to and revision of its vault.
+type memoryUser struct {
+	models.User
+	id       int
+	revision int64
+}
+
+// memoryData - ciphered data with owner id and previous versions, oldest first.
+type memoryData struct {
+	models.CipheredData
+	userID  int
+	history []models.DataRevision
+}
+
+// archive keeps current version in history before it is replaced.
+// File records are not kept, their content is replaced with them.
+func (d *memoryData) archive(now time.Time) {
+	if d.Deleted || d.Type == "DATA" {
+		return
+	}
+	version := d.CipheredData
+	version.Data = cloneBytes(version.Data)
+	d.history = append(d.history, models.DataRevision{Data: version, ReplacedAt: now})
+}
+
+// memoryUpload - upload with owner id and received chunks.
+type memoryUpload struct {
+	models.Upload
+	userID int
+	chunks map[int64]models.FileChunk
+}
+
+// memoryRecoveryCode - recovery code of user.
+type memoryRecoveryCode struct {
+	email string
+	used  bool
+}
+
+// Memory - thread-safe in-memory storage for development and tests.
+// It follows semantics of SQL storage, missing records are reported by models.ErrNotFound.
+type Memory struct {
+	mu            sync.RWMutex
+	lastUserID    int
+	users         map[string]*memoryUser
+	data          map[string]*memoryData
+	chunks        map[string]map[int64]models.FileChunk
+	uploads       map[string]*memoryUpload
+	sessions      map[string]*models.Session
+	refreshTokens map[string]*models.RefreshToken
+	totp          map[string]*models.TOTP
+	recoveryCodes map[string]*memoryRecoveryCode
+	loginAttempts map[string]*models.LoginAttempt
+}
+
+// NewMemory constructor of empty in-memory storage.
+func NewMemory() *Memory {
+	return &Memory{
+		users:         make(map[string]*memoryUser),
+		data:          make(map[string]*memoryData),
+		chunks:        make(map[string]map[int64]models.FileChunk),
+		uploads:       make(map[string]*memoryUpload),
+		sessions:      make(map[string]*models.Session),
+		refreshTokens: make(map[string]*models.RefreshToken),
+		totp:          make(map[string]*models.TOTP),
+		recoveryCodes: make(map[string]*memoryRecoveryCode),
+		loginAttempts: make(map[string]*models.LoginAttempt),
+	}
+}
+
+// memoryStore returns in-memory storage of DSN, created on first use.
+func memoryStore(dsn string) *Memory {
+	memoryStoresMu.Lock()
+	defer memoryStoresMu.Unlock()
+	name := strings.TrimPrefix(dsn, MemoryScheme)
+	m, ok := memoryStores[name]
+	if !ok {
+		m = NewMemory()
+		memoryStores[name] = m
+	}
+	return m
+}
+
+// NewStorager returns storage of DSN: in-memory one for MemoryScheme, SQL database otherwise.
+// SQL database keeps file chunk payloads in given blob store if it is not nil.
+func NewStorager(dsn string, blobs blobstore.BlobStore) models.Storager {
+	if strings.HasPrefix(dsn, MemoryScheme) {
+		log.Println("Using in-memory storage, data is lost on exit!")
+		return memoryStore(dsn)
+	}
+	s := NewStorage(dsn)
+	s.Blobs = blobs
+	return s
+}
+
+// cloneBytes copies slice, so stored values are not changed by callers.
+func cloneBytes(b []byte) []byte {
+	if b == nil {
+		return nil
+	}
+	return append([]byte{}, b...)
+}
+
+// cloneUser returns copy of user.
+func cloneUser(user models.User) models.User {
+	user.Secret = cloneBytes(user.Secret)
+	user.Salt = cloneBytes(user.Salt)
+	return user
+}
+
+// AddUser - insert user, email must be unique.
+func (m *Memory) AddUser(ctx context.Context, user models.User) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.users[user.Email]; ok {
+		return fmt.Errorf("user %s: %w", user.Email, models.ErrAlreadyExists)
+	}
+	m.lastUserID++
+	user = cloneUser(user)
+	user.DeleteAfter = time.Time{}
+	m.users[user.Email] = &memoryUser{User: user, id: m.lastUserID}
+	return nil
+}
+
+// GetUser - select user by email.
+func (m *Memory) GetUser(ctx context.Context, user models.User) (models.User, error) {
+	if err := ctx.Err(); err != nil {
+		return models.User{}, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	stored, ok := m.users[user.Email]
+	if !ok {
+		return models.User{}, models.ErrNotFound
+	}
+	return cloneUser(stored.User), nil
+}
+
+// UpdateUser - update password hash, wrapped vault key and salt of user.
+func (m *Memory) UpdateUser(ctx context.Context, user models.User) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	stored, ok := m.users[user.Email]
+	if !ok {
+		return nil
+	}
+	stored.Password = user.Password
+	stored.Secret = cloneBytes(user.Secret)
+	stored.Salt = cloneBytes(user.Salt)
+	return nil
+}
+
+// ScheduleUserDeletion - mark user to be purged after given time.
+func (m *Memory) ScheduleUserDeletion(ctx context.Context, email string, at time.Time) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if stored, ok := m.users[email]; ok {
+		stored.DeleteAfter = at
+	}
+	return nil
+}
+
+// CancelUserDeletion - unmark user scheduled for deletion.
+// Returns false if deletion was not scheduled.
+func (m *Memory) CancelUserDeletion(ctx context.Context, email string) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	stored, ok := m.users[email]
+	if !ok || stored.DeleteAfter.IsZero() {
+		return false, nil
+	}
+	stored.DeleteAfter = time.Time{}
+	return true, nil
+}
+
+// ListDueDeletions - select emails of users which must be purged at given time.
+func (m *Memory) ListDueDeletions(ctx context.Context, now time.Time) ([]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	emails := []string{}
+	for email, user := range m.users {
+		if !user.DeleteAfter.IsZero() && !user.DeleteAfter.After(now) {
+			emails = append(emails, email)
+		}
+	}
+	sort.Strings(emails)
+	return emails, nil
+}
+
+// PurgeUser - delete user scheduled for deletion and all of its data.
+// Returns false if user is not due at given time.
+func (m *Memory) PurgeUser(ctx context.Context, email string, now time.Time) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	user, ok := m.users[email]
+	if !ok || user.DeleteAfter.IsZero() || user.DeleteAfter.After(now) {
+		return false, nil
+	}
+	for id, data := range m.data {
+		if data.userID == user.id {
+			delete(m.data, id)
+			delete(m.chunks, id)
+		}
+	}
+	for id, upload := range m.uploads {
+		if upload.userID == user.id {
+			delete(m.uploads, id)
+		}
+	}
+	for id, session := range m.sessions {
+		if session.User != email {
+			continue
+		}
+		for hash, token := range m.refreshTokens {
+			if token.SessionID == id {
+				delete(m.refreshTokens, hash)
+			}
+		}
+		delete(m.sessions, id)
+	}
+	m.deleteRecoveryCodes(email)
+	delete(m.totp, email)
+	delete(m.users, email)
+	return true, nil
+}
+
+// AddCipheredData - insert ciphered data, returns revision of saved record.
+// Existing record is updated only if it belongs to the same user,
+// otherwise models.ErrPermissionDenied returned.
+// Record changed after revision of data is not updated, models.ConflictError returned.
+func (m *Memory) AddCipheredData(ctx context.Context, data models.CipheredData) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	user, ok := m.users[data.User]
+	if !ok {
+		return 0, fmt.Errorf("user %s: %w", data.User, models.ErrNotFound)
+	}
+	if data.ID == "" {
+		data.ID = uuid.NewString()
+	}
+	// missing record is tombstone of revision 0
+	current := models.CipheredData{ID: data.ID, Type: data.Type, User: data.User, Deleted: true}
+	stored, ok := m.data[data.ID]
+	if ok {
+		if stored.userID != user.id {
+			return 0, models.ErrPermissionDenied
+		}
+		current = stored.CipheredData
+		current.User = data.User
+	}
+	if current.Revision != data.Revision {
+		current.Data = cloneBytes(current.Data)
+		return 0, &models.ConflictError{Current: current}
+	}
+	data.Data = cloneBytes(data.Data)
+	user.revision++
+	data.Revision, data.Deleted = user.revision, false
+	record := &memoryData{CipheredData: data, userID: user.id}
+	if ok {
+		stored.archive(time.Now())
+		record.history = stored.history
+	}
+	m.data[data.ID] = record
+	return data.Revision, nil
+}
+
+// GetCipheredData - returns page of user data ordered by uuid.
+func (m *Memory) GetCipheredData(ctx context.Context, email string, page models.DataPage) ([]models.CipheredData, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	data := []models.CipheredData{}
+	user, ok := m.users[email]
+	if !ok {
+		return data, nil
+	}
+	for _, stored := range m.data {
+		if stored.userID != user.id || stored.Deleted || stored.ID <= page.After || !hasType(page.Types, stored.Type) {
+			continue
+		}
+		model := stored.CipheredData
+		model.User = email
+		model.Data = cloneBytes(model.Data)
+		data = append(data, model)
+	}
+	sort.Slice(data, func(i, j int) bool {
+		return data[i].ID < data[j].ID
+	})
+	if page.Limit > 0 && len(data) > page.Limit {
+		data = data[:page.Limit]
+	}
+	return data, nil
+}
+
+// hasType reports if data type passes types filter, empty filter passes all types.
+func hasType(types []string, dataType string) bool {
+	if len(types) == 0 {
+		return true
+	}
+	for _, t := range types {
+		if t == dataType {
+			return true
+		}
+	}
+	return false
+}
+
+// DelCiphereData - delete user data by given owner's email and uuid.
+// Record is replaced by tombstone, so deletion is listed by ListChanges.
+// If record belongs to another user models.ErrPermissionDenied returned.
+// Record changed after expected revision is not deleted, models.ConflictError returned.
+func (m *Memory) DelCiphereData(ctx context.Context, email string, uuid string, expected int64) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	stored, ok := m.data[uuid]
+	if !ok {
+		return nil
+	}
+	user, ok := m.users[email]
+	if !ok || stored.userID != user.id {
+		return models.ErrPermissionDenied
+	}
+	if stored.Deleted {
+		return nil
+	}
+	if stored.Revision != expected {
+		current := stored.CipheredData
+		current.User, current.Data = email, cloneBytes(current.Data)
+		return &models.ConflictError{Current: current}
+	}
+	stored.archive(time.Now())
+	user.revision++
+	stored.Data, stored.Deleted, stored.Revision = nil, true, user.revision
+	delete(m.chunks, uuid)
+	return nil
+}
+
+// ListRevisions - returns previous versions of user's record, newest first.
+// Record of other user has no versions, models.ErrNoHistory returned for file record.
+func (m *Memory) ListRevisions(ctx context.Context, email string, uuid string) ([]models.DataRevision, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	revisions := []models.DataRevision{}
+	user, ok := m.users[email]
+	stored, found := m.data[uuid]
+	if !ok || !found || stored.userID != user.id {
+		return revisions, nil
+	}
+	if stored.Type == "DATA" {
+		return nil, fmt.Errorf("record %s: %w", uuid, models.ErrNoHistory)
+	}
+	for i := len(stored.history) - 1; i >= 0; i-- {
+		revision := stored.history[i]
+		revision.Data.User = email
+		revision.Data.Data = cloneBytes(revision.Data.Data)
+		revisions = append(revisions, revision)
+	}
+	return revisions, nil
+}
+
+// GetRevision - returns previous version of user's record by revision, models.ErrNotFound if it is not kept.
+// models.ErrNoHistory returned for file record.
+func (m *Memory) GetRevision(ctx context.Context, email string, uuid string, revision int64) (models.DataRevision, error) {
+	revisions, err := m.ListRevisions(ctx, email, uuid)
+	if err != nil {
+		return models.DataRevision{}, err
+	}
+	for _, version := range revisions {
+		if version.Data.Revision == revision {
+			return version, nil
+		}
+	}
+	return models.DataRevision{}, fmt.Errorf("revision %d of %s: %w", revision, uuid, models.ErrNotFound)
+}
+
+// PurgeRevisions - delete previous versions replaced before given time
+// and ones beyond given number of newest versions of record, number is not limited if it is not positive.
+// Returns number of deleted versions.
+func (m *Memory) PurgeRevisions(ctx context.Context, keep int, before time.Time) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	count := 0
+	for _, stored := range m.data {
+		history := []models.DataRevision{}
+		for i, version := range stored.history {
+			if keep > 0 && len(stored.history)-i > keep || version.ReplacedAt.Before(before) {
+				count++
+				continue
+			}
+			history = append(history, version)
+		}
+		stored.history = history
+	}
+	return count, nil
+}
+
+// ListChanges - returns records of user changed after given revision in order of revision,
+// deleted ones are returned as tombstones. All changes are returned if limit is 0.
+func (m *Memory) ListChanges(ctx context.Context, email string, since int64, limit int) ([]models.CipheredData, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	changes := []models.CipheredData{}
+	user, ok := m.users[email]
+	if !ok {
+		return changes, nil
+	}
+	for _, stored := range m.data {
+		if stored.userID != user.id || stored.Revision <= since {
+			continue
+		}
+		change := stored.CipheredData
+		change.User = email
+		change.Data = cloneBytes(change.Data)
+		changes = append(changes, change)
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Revision < changes[j].Revision
+	})
+	if limit > 0 && len(changes) > limit {
+		changes = changes[:limit]
+	}
+	return changes, nil
+}
+
+// ownData checks that record belongs to given user, models.ErrNotFound returned otherwise.
+func (m *Memory) ownData(email string, id string) error {
+	user, ok := m.users[email]
+	stored, found := m.data[id]
+	if !ok || !found || stored.userID != user.id || stored.Deleted {
+		return fmt.Errorf("file %s: %w", id, models.ErrNotFound)
+	}
+	return nil
+}
+
+// GetFileChunk - returns chunk of file record owned by given user.
+func (m *Memory) GetFileChunk(ctx context.Context, email string, id string, index int64) (models.FileChunk, error) {
+	if err := ctx.Err(); err != nil {
+		return models.FileChunk{}, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if err := m.ownData(email, id); err != nil {
+		return models.FileChunk{}, err
+	}
+	chunk, ok := m.chunks[id][index]
+	if !ok {
+		return models.FileChunk{}, fmt.Errorf("chunk %d of file %s: %w", index, id, models.ErrNotFound)
+	}
+	chunk.Data = cloneBytes(chunk.Data)
+	return chunk, nil
+}
+
+// AddUpload - insert new upload of user's file.
+// models.ErrPermissionDenied returned if file belongs to another user.
+func (m *Memory) AddUpload(ctx context.Context, upload models.Upload) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	user, ok := m.users[upload.Data.User]
+	if !ok {
+		return fmt.Errorf("user %s: %w", upload.Data.User, models.ErrNotFound)
+	}
+	if stored, ok := m.data[upload.Data.ID]; ok && stored.userID != user.id {
+		return models.ErrPermissionDenied
+	}
+	if _, ok := m.uploads[upload.ID]; ok {
+		return fmt.Errorf("upload %s: %w", upload.ID, models.ErrAlreadyExists)
+	}
+	upload.Chunks = 0
+	upload.Data.Data = cloneBytes(upload.Data.Data)
+	m.uploads[upload.ID] = &memoryUpload{Upload: upload, userID: user.id, chunks: make(map[int64]models.FileChunk)}
+	return nil
+}
+
+// ownUpload returns upload of given user, models.ErrNotFound if user has no such upload.
+func (m *Memory) ownUpload(email string, id string) (*memoryUpload, error) {
+	user, ok := m.users[email]
+	upload, found := m.uploads[id]
+	if !ok || !found || upload.userID != user.id {
+		return nil, fmt.Errorf("upload %s: %w", id, models.ErrNotFound)
+	}
+	return upload, nil
+}
+
+// GetUpload - returns upload of given user with number of received chunks.
+func (m *Memory) GetUpload(ctx context.Context, email string, id string) (models.Upload, error) {
+	if err := ctx.Err(); err != nil {
+		return models.Upload{}, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	upload, err := m.ownUpload(email, id)
+	if err != nil {
+		return models.Upload{}, err
+	}
+	result := upload.Upload
+	result.Data.Data = cloneBytes(result.Data.Data)
+	result.Chunks = int64(len(upload.chunks))
+	return result, nil
+}
+
+// AddUploadChunk - insert or replace chunk of user's upload, upload is kept alive till given time plus TTL.
+func (m *Memory) AddUploadChunk(ctx context.Context, email string, id string, chunk models.FileChunk, at time.Time) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	upload, err := m.ownUpload(email, id)
+	if err != nil {
+		return err
+	}
+	chunk.Data = cloneBytes(chunk.Data)
+	upload.chunks[chunk.Index] = chunk
+	upload.UpdatedAt = at
+	return nil
+}
+
+// CommitUpload - save file record of finished upload and replace file content by uploaded chunks,
+// returns revision of saved record.
+// models.ErrConflict returned if upload has no last chunk or misses some chunks before it.
+// File changed after revision upload is based on is not replaced, models.ConflictError returned
+// and upload is deleted.
+func (m *Memory) CommitUpload(ctx context.Context, email string, id string) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	upload, err := m.ownUpload(email, id)
+	if err != nil {
+		return 0, err
+	}
+	last := int64(-1)
+	for index, chunk := range upload.chunks {
+		if chunk.Last {
+			last = index
+		}
+	}
+	if last < 0 {
+		return 0, fmt.Errorf("upload %s is not finished: %w", id, models.ErrConflict)
+	}
+	chunks := make(map[int64]models.FileChunk, last+1)
+	for index := int64(0); index <= last; index++ {
+		chunk, ok := upload.chunks[index]
+		if !ok {
+			return 0, fmt.Errorf("upload %s misses chunks: %w", id, models.ErrConflict)
+		}
+		chunks[index] = chunk
+	}
+	// missing record is tombstone of revision 0
+	current := models.CipheredData{ID: upload.Data.ID, Type: upload.Data.Type, User: email, Deleted: true}
+	if stored, ok := m.data[upload.Data.ID]; ok {
+		if stored.userID != upload.userID {
+			return 0, models.ErrPermissionDenied
+		}
+		current = stored.CipheredData
+		current.User = email
+	}
+	if current.Revision != upload.Data.Revision {
+		// upload can't be committed anymore
+		delete(m.uploads, id)
+		current.Data = cloneBytes(current.Data)
+		return 0, &models.ConflictError{Current: current}
+	}
+	user := m.users[email]
+	user.revision++
+	data := upload.Data
+	data.Revision, data.Deleted = user.revision, false
+	m.data[upload.Data.ID] = &memoryData{CipheredData: data, userID: upload.userID}
+	m.chunks[upload.Data.ID] = chunks
+	delete(m.uploads, id)
+	return data.Revision, nil
+}
+
+// PurgeUploads - delete uploads abandoned before given time with their chunks.
+// Returns number of deleted uploads.
+func (m *Memory) PurgeUploads(ctx context.Context, before time.Time) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	count := 0
+	for id, upload := range m.uploads {
+		if upload.UpdatedAt.Before(before) {
+			delete(m.uploads, id)
+			count++
+		}
+	}
+	return count, nil
+}
+
+// AddSession - insert new login session.
+func (m *Memory) AddSession(ctx context.Context, session models.Session) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.sessions[session.ID]; ok {
+		return fmt.Errorf("session %s: %w", session.ID, models.ErrAlreadyExists)
+	}
+	m.sessions[session.ID] = &session
+	return nil
+}
+
+// GetSession - select session by given uuid.
+func (m *Memory) GetSession(ctx context.Context, id string) (models.Session, error) {
+	if err := ctx.Err(); err != nil {
+		return models.Session{}, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	session, ok := m.sessions[id]
+	if !ok {
+		return models.Session{}, models.ErrNotFound
+	}
+	return *session, nil
+}
+
+// ListSessions - select active sessions of user, newest first.
+func (m *Memory) ListSessions(ctx context.Context, email string) ([]models.Session, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	now := time.Now()
+	sessions := []models.Session{}
+	for _, session := range m.sessions {
+		if session.User == email && !session.Revoked && session.ExpiresAt.After(now) {
+			sessions = append(sessions, *session)
+		}
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].LastSeen.After(sessions[j].LastSeen)
+	})
+	return sessions, nil
+}
+
+// TouchSession - update last seen time and ip of session.
+func (m *Memory) TouchSession(ctx context.Context, id string, lastSeen time.Time, ip string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if session, ok := m.sessions[id]; ok {
+		session.LastSeen = lastSeen
+		session.IP = ip
+	}
+	return nil
+}
+
+// RevokeSession - revoke session, its refresh tokens can't be used anymore.
+func (m *Memory) RevokeSession(ctx context.Context, id string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if session, ok := m.sessions[id]; ok {
+		session.Revoked = true
+	}
+	return nil
+}
+
+// RevokeUserSessions - revoke all sessions of user except given one.
+func (m *Memory) RevokeUserSessions(ctx context.Context, email string, except string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for id, session := range m.sessions {
+		if session.User == email && id != except {
+			session.Revoked = true
+		}
+	}
+	return nil
+}
+
+// AddRefreshToken - insert hash of refresh token.
+func (m *Memory) AddRefreshToken(ctx context.Context, token models.RefreshToken) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.refreshTokens[string(token.Hash)]; ok {
+		return fmt.Errorf("refresh token: %w", models.ErrAlreadyExists)
+	}
+	token.Hash = cloneBytes(token.Hash)
+	m.refreshTokens[string(token.Hash)] = &token
+	return nil
+}
+
+// GetRefreshToken - select refresh token by given hash.
+func (m *Memory) GetRefreshToken(ctx context.Context, hash []byte) (models.RefreshToken, error) {
+	if err := ctx.Err(); err != nil {
+		return models.RefreshToken{}, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	token, ok := m.refreshTokens[string(hash)]
+	if !ok {
+		return models.RefreshToken{}, models.ErrNotFound
+	}
+	result := *token
+	result.Hash = cloneBytes(token.Hash)
+	return result, nil
+}
+
+// UseRefreshToken - mark refresh token as used.
+// Returns false if token was already used before.
+func (m *Memory) UseRefreshToken(ctx context.Context, hash []byte) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	token, ok := m.refreshTokens[string(hash)]
+	if !ok || token.Used {
+		return false, nil
+	}
+	token.Used = true
+	return true, nil
+}
+
+// PurgeRefreshTokens - delete refresh tokens of revoked or expired sessions
+// and tokens used before given time. Returns number of deleted tokens.
+func (m *Memory) PurgeRefreshTokens(ctx context.Context, now time.Time, usedBefore time.Time) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	count := 0
+	for hash, token := range m.refreshTokens {
+		session, ok := m.sessions[token.SessionID]
+		if ok && !session.Revoked && session.ExpiresAt.After(now) && !(token.Used && token.CreatedAt.Before(usedBefore)) {
+			continue
+		}
+		delete(m.refreshTokens, hash)
+		count++
+	}
+	return count, nil
+}
+
+// deleteRecoveryCodes removes recovery codes of user, m.mu must be locked.
+func (m *Memory) deleteRecoveryCodes(email string) {
+	for hash, code := range m.recoveryCodes {
+		if code.email == email {
+			delete(m.recoveryCodes, hash)
+		}
+	}
+}
+
+// AddTOTP - insert new unconfirmed TOTP enrollment and its recovery codes.
+// Previous enrollment and recovery codes of user are replaced.
+func (m *Memory) AddTOTP(ctx context.Context, totp models.TOTP) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, hash := range totp.RecoveryCodes {
+		if code, ok := m.recoveryCodes[string(hash)]; ok && code.email != totp.User {
+			return fmt.Errorf("recovery code: %w", models.ErrAlreadyExists)
+		}
+	}
+	enrollment := &models.TOTP{User: totp.User, Secret: totp.Secret}
+	if previous, ok := m.totp[totp.User]; ok {
+		// used codes stay used after new enrollment
+		enrollment.LastStep = previous.LastStep
+	}
+	m.totp[totp.User] = enrollment
+	m.deleteRecoveryCodes(totp.User)
+	for _, hash := range totp.RecoveryCodes {
+		m.recoveryCodes[string(hash)] = &memoryRecoveryCode{email: totp.User}
+	}
+	return nil
+}
+
+// GetTOTP - select TOTP enrollment of user.
+// Returns empty TOTP without error if user is not enrolled.
+func (m *Memory) GetTOTP(ctx context.Context, email string) (models.TOTP, error) {
+	if err := ctx.Err(); err != nil {
+		return models.TOTP{}, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	totp, ok := m.totp[email]
+	if !ok {
+		return models.TOTP{}, nil
+	}
+	return *totp, nil
+}
+
+// ConfirmTOTP - mark TOTP enrollment of user as confirmed.
+func (m *Memory) ConfirmTOTP(ctx context.Context, email string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if totp, ok := m.totp[email]; ok {
+		totp.Confirmed = true
+	}
+	return nil
+}
+
+// DelTOTP - delete TOTP enrollment and recovery codes of user.
+func (m *Memory) DelTOTP(ctx context.Context, email string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.totp, email)
+	m.deleteRecoveryCodes(email)
+	return nil
+}
+
+// UseRecoveryCode - mark recovery code of user as used.
+// Returns false if there is no such unused code.
+func (m *Memory) UseRecoveryCode(ctx context.Context, email string, hash []byte) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	code, ok := m.recoveryCodes[string(hash)]
+	if !ok || code.email != email || code.used {
+		return false, nil
+	}
+	code.used = true
+	return true, nil
+}
+
+// UseTOTPStep - record time step of accepted authenticator code of user.
+// Returns false if code of the same or later step was accepted before, so code is not used twice.
+func (m *Memory) UseTOTPStep(ctx context.Context, email string, step int64) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	totp, ok := m.totp[email]
+	if !ok || totp.LastStep >= step {
+		return false, nil
+	}
+	totp.LastStep = step
+	return true, nil
+}
+
+// GetLoginAttempt - select failed logins counter by key.
+// Returns LoginAttempt without failures and lockout if key is not known.
+func (m *Memory) GetLoginAttempt(ctx context.Context, key string) (models.LoginAttempt, error) {
+	if err := ctx.Err(); err != nil {
+		return models.LoginAttempt{}, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	attempt, ok := m.loginAttempts[key]
+	if !ok {
+		return models.LoginAttempt{Key: key}, nil
+	}
+	return *attempt, nil
+}
+
+// AddLoginFailure - increase failed logins counter and return its new value.
+// Counter starts from one if last failure was before resetBefore.
+func (m *Memory) AddLoginFailure(ctx context.Context, key string, at time.Time, resetBefore time.Time) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	attempt, ok := m.loginAttempts[key]
+	if !ok {
+		m.loginAttempts[key] = &models.LoginAttempt{Key: key, Failures: 1, LastFailure: at, LockedUntil: at}
+		return 1, nil
+	}
+	if attempt.LastFailure.Before(resetBefore) {
+		attempt.Failures = 1
+	} else {
+		attempt.Failures++
+	}
+	attempt.LastFailure = at
+	return attempt.Failures, nil
+}
+
+// LockLogin - reject logins by key till given time.
+func (m *Memory) LockLogin(ctx context.Context, key string, until time.Time) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if attempt, ok := m.loginAttempts[key]; ok {
+		attempt.LockedUntil = until
+	}
+	return nil
+}
+
+// ClearLoginAttempts - reset failed logins counter and lockout of key.
+func (m *Memory) ClearLoginAttempts(ctx context.Context, key string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.loginAttempts, key)
+	return nil
+}
+
+// ListLockouts - select keys which are locked at given time.
+func (m *Memory) ListLockouts(ctx context.Context, now time.Time) ([]models.LoginAttempt, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	attempts := []models.LoginAttempt{}
+	for _, attempt := range m.loginAttempts {
+		if attempt.LockedUntil.After(now) {
+			attempts = append(attempts, *attempt)
+		}
+	}
+	sort.Slice(attempts, func(i, j int) bool {
+		return attempts[i].LockedUntil.After(attempts[j].LockedUntil)
+	})
+	return attempts, nil
+}
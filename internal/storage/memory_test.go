@@ -0,0 +1,70 @@
+package storage
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/MaximkaSha/gophkeeper/internal/models"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemory_UsersAndData(t *testing.T) {
+	testUsersAndData(t, NewMemory())
+}
+
+func TestMemory_SessionsAndLockouts(t *testing.T) {
+	testSessionsAndLockouts(t, NewMemory())
+}
+
+func TestMemory_Uploads(t *testing.T) {
+	testUploads(t, NewMemory())
+}
+
+func TestMemory_TOTP(t *testing.T) {
+	testTOTP(t, NewMemory())
+}
+
+func TestMemory_Changes(t *testing.T) {
+	testChanges(t, NewMemory())
+}
+
+func TestMemory_Revisions(t *testing.T) {
+	testRevisions(t, NewMemory())
+}
+
+func TestNewStorager(t *testing.T) {
+	s := NewStorager("memory://shared", nil)
+	require.Same(t, s, NewStorager("memory://shared", nil))
+	require.NotSame(t, s, NewStorager("memory://other", nil))
+	_, err := Open("memory://shared")
+	require.Error(t, err)
+}
+
+func TestMemory_Concurrent(t *testing.T) {
+	m := NewMemory()
+	require.NoError(t, m.AddUser(context.Background(), models.User{Email: "test@test.com", Password: "hash"}))
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				_, err := m.AddLoginFailure(context.Background(), "ip:127.0.0.1", time.Now(), time.Time{})
+				require.NoError(t, err)
+				_, err = m.AddCipheredData(context.Background(), models.CipheredData{Data: []byte("data"), Type: "TEXT", User: "test@test.com"})
+				require.NoError(t, err)
+				_, err = m.GetCipheredData(context.Background(), "test@test.com", models.DataPage{})
+				require.NoError(t, err)
+			}
+		}()
+	}
+	wg.Wait()
+	attempt, err := m.GetLoginAttempt(context.Background(), "ip:127.0.0.1")
+	require.NoError(t, err)
+	require.Equal(t, 1000, attempt.Failures)
+	data, err := m.GetCipheredData(context.Background(), "test@test.com", models.DataPage{})
+	require.NoError(t, err)
+	require.Len(t, data, 1000)
+}
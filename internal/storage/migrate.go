@@ -0,0 +1,265 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"log"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// migrationFiles - schema migrations of every dialect, named NNNN_name.up.sql and NNNN_name.down.sql.
+//
+//go:embed migrations/postgres/*.sql migrations/sqlite/*.sql
+var migrationFiles embed.FS
+
+// migrationLockID - key of postgres advisory lock held while migrating,
+// so servers started at the same time don't apply migrations twice.
+const migrationLockID = 7383297245
+
+var migrationName = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+// Migration - numbered schema change with its rollback.
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// MigrationStatus - migration and time it was applied, zero if pending.
+type MigrationStatus struct {
+	Migration
+	AppliedAt time.Time
+}
+
+// Applied reports if migration is applied to database.
+func (m MigrationStatus) Applied() bool {
+	return !m.AppliedAt.IsZero()
+}
+
+// loadMigrations reads migrations from dir of fsys sorted by version.
+// Every version must have both up and down file.
+func loadMigrations(fsys fs.FS, dir string) ([]Migration, error) {
+	files, err := fs.Glob(fsys, path.Join(dir, "*.sql"))
+	if err != nil {
+		return nil, err
+	}
+	byVersion := make(map[int]*Migration)
+	for _, file := range files {
+		base := path.Base(file)
+		parts := migrationName.FindStringSubmatch(base)
+		if parts == nil {
+			return nil, fmt.Errorf("bad migration file name %s", base)
+		}
+		version, _ := strconv.Atoi(parts[1])
+		body, err := fs.ReadFile(fsys, file)
+		if err != nil {
+			return nil, err
+		}
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: parts[2]}
+			byVersion[version] = m
+		}
+		if m.Name != parts[2] {
+			return nil, fmt.Errorf("migration %d has two names: %s and %s", version, m.Name, parts[2])
+		}
+		if parts[3] == "up" {
+			m.Up = string(body)
+		} else {
+			m.Down = string(body)
+		}
+	}
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" || m.Down == "" {
+			return nil, fmt.Errorf("migration %d %s must have up and down files", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	return migrations, nil
+}
+
+// migrations returns migrations of storage dialect.
+func (s Storage) migrations() ([]Migration, error) {
+	dir := "migrations/" + dialectPostgres
+	if s.isSQLite() {
+		dir = "migrations/" + dialectSQLite
+	}
+	return loadMigrations(migrationFiles, dir)
+}
+
+// withMigrationLock runs f on single connection holding migration lock.
+// schema_migrations table is created if needed.
+// SQLite has no advisory locks, its migrations are serialized by database file lock.
+func (s Storage) withMigrationLock(ctx context.Context, f func(conn *sql.Conn) error) error {
+	conn, err := s.DB.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	var query = `CREATE TABLE IF NOT EXISTS schema_migrations
+(
+    version integer NOT NULL,
+    name character varying(100) NOT NULL,
+    applied_at timestamp with time zone NOT NULL,
+    CONSTRAINT schema_migrations_pkey PRIMARY KEY (version)
+);`
+	if s.isSQLite() {
+		// modernc driver parses times only of columns declared as timestamp
+		query = strings.Replace(query, "timestamp with time zone", "timestamp", 1)
+	} else {
+		_, err = conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", migrationLockID)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			_, err := conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1)", migrationLockID)
+			CheckError(err)
+		}()
+	}
+	_, err = conn.ExecContext(ctx, query)
+	if err != nil {
+		return err
+	}
+	return f(conn)
+}
+
+// appliedMigrations returns apply time of every applied version.
+func appliedMigrations(ctx context.Context, conn *sql.Conn) (map[int]time.Time, error) {
+	rows, err := conn.QueryContext(ctx, "SELECT version, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	applied := make(map[int]time.Time)
+	for rows.Next() {
+		var version int
+		var at time.Time
+		err = rows.Scan(&version, &at)
+		if err != nil {
+			return nil, err
+		}
+		applied[version] = at
+	}
+	return applied, rows.Err()
+}
+
+// MigrateUp applies pending migrations in version order, each in own transaction.
+// Returns number of applied migrations.
+func (s Storage) MigrateUp(ctx context.Context) (int, error) {
+	migrations, err := s.migrations()
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	err = s.withMigrationLock(ctx, func(conn *sql.Conn) error {
+		applied, err := appliedMigrations(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for _, m := range migrations {
+			if _, ok := applied[m.Version]; ok {
+				continue
+			}
+			err = runMigration(ctx, conn, m.Up, "INSERT INTO schema_migrations (version, name, applied_at) VALUES ($1, $2, $3)",
+				m.Version, m.Name, time.Now())
+			if err != nil {
+				return fmt.Errorf("migration %d %s: %w", m.Version, m.Name, err)
+			}
+			log.Printf("migration %d %s applied", m.Version, m.Name)
+			count++
+		}
+		return nil
+	})
+	return count, err
+}
+
+// MigrateDown rolls back latest applied migration.
+// Returns rolled back migration, false if nothing is applied.
+func (s Storage) MigrateDown(ctx context.Context) (Migration, bool, error) {
+	migrations, err := s.migrations()
+	if err != nil {
+		return Migration{}, false, err
+	}
+	var rolledBack Migration
+	var ok bool
+	err = s.withMigrationLock(ctx, func(conn *sql.Conn) error {
+		applied, err := appliedMigrations(ctx, conn)
+		if err != nil {
+			return err
+		}
+		latest := 0
+		for version := range applied {
+			if version > latest {
+				latest = version
+			}
+		}
+		if latest == 0 {
+			return nil
+		}
+		for _, m := range migrations {
+			if m.Version != latest {
+				continue
+			}
+			err = runMigration(ctx, conn, m.Down, "DELETE FROM schema_migrations WHERE version = $1", m.Version)
+			if err != nil {
+				return fmt.Errorf("migration %d %s: %w", m.Version, m.Name, err)
+			}
+			log.Printf("migration %d %s rolled back", m.Version, m.Name)
+			rolledBack, ok = m, true
+			return nil
+		}
+		return fmt.Errorf("migration %d is applied but unknown to this server version", latest)
+	})
+	return rolledBack, ok, err
+}
+
+// Migrations returns all known migrations with their apply time.
+func (s Storage) Migrations(ctx context.Context) ([]MigrationStatus, error) {
+	migrations, err := s.migrations()
+	if err != nil {
+		return nil, err
+	}
+	var statuses []MigrationStatus
+	err = s.withMigrationLock(ctx, func(conn *sql.Conn) error {
+		applied, err := appliedMigrations(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for _, m := range migrations {
+			statuses = append(statuses, MigrationStatus{Migration: m, AppliedAt: applied[m.Version]})
+		}
+		return nil
+	})
+	return statuses, err
+}
+
+// runMigration executes migration script and bookkeeping query in one transaction.
+func runMigration(ctx context.Context, conn *sql.Conn, script string, bookkeeping string, args ...interface{}) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	_, err = tx.ExecContext(ctx, script)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, bookkeeping, args...)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,132 @@
+package storage
+
+import (
+	"context"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadMigrations(t *testing.T) {
+	migrations, err := loadMigrations(migrationFiles, "migrations/postgres")
+	require.NoError(t, err)
+	require.GreaterOrEqual(t, len(migrations), 2)
+	require.Equal(t, 1, migrations[0].Version)
+	require.Equal(t, "init", migrations[0].Name)
+	require.Equal(t, "ciphereddata_user_fk", migrations[1].Name)
+	for i, m := range migrations {
+		require.Equal(t, i+1, m.Version, "migration versions must have no gaps")
+	}
+
+	tests := []struct {
+		name string
+		fsys fstest.MapFS
+	}{
+		{
+			name: "bad name",
+			fsys: fstest.MapFS{"migrations/init.up.sql": {Data: []byte("SELECT 1;")}},
+		},
+		{
+			name: "no down",
+			fsys: fstest.MapFS{"migrations/0001_init.up.sql": {Data: []byte("SELECT 1;")}},
+		},
+		{
+			name: "two names",
+			fsys: fstest.MapFS{
+				"migrations/0001_init.up.sql":    {Data: []byte("SELECT 1;")},
+				"migrations/0001_other.down.sql": {Data: []byte("SELECT 1;")},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := loadMigrations(tt.fsys, "migrations")
+			require.Error(t, err)
+		})
+	}
+}
+
+func TestStorage_MigrateUp(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+	s := Storage{DB: db}
+	migrations, err := s.migrations()
+	require.NoError(t, err)
+	// only migration 2 is pending
+	rows := sqlmock.NewRows([]string{"version", "applied_at"})
+	for _, m := range migrations {
+		if m.Version != 2 {
+			rows.AddRow(m.Version, time.Now())
+		}
+	}
+	mock.ExpectExec("SELECT pg_advisory_lock").WithArgs(migrationLockID).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("CREATE TABLE IF NOT EXISTS schema_migrations").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT version, applied_at FROM schema_migrations").WillReturnRows(rows)
+	mock.ExpectBegin()
+	mock.ExpectExec("ALTER TABLE ciphereddata ADD CONSTRAINT ciphereddata_user_id_fkey").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("INSERT INTO schema_migrations").WithArgs(2, "ciphereddata_user_fk", sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectExec("SELECT pg_advisory_unlock").WithArgs(migrationLockID).WillReturnResult(sqlmock.NewResult(0, 0))
+	applied, err := s.MigrateUp(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, 1, applied)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestStorage_MigrateDown(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+	s := Storage{DB: db}
+	now := time.Now()
+	mock.ExpectExec("SELECT pg_advisory_lock").WithArgs(migrationLockID).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("CREATE TABLE IF NOT EXISTS schema_migrations").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT version, applied_at FROM schema_migrations").WillReturnRows(
+		sqlmock.NewRows([]string{"version", "applied_at"}).AddRow(1, now).AddRow(2, now))
+	mock.ExpectBegin()
+	mock.ExpectExec("DROP INDEX IF EXISTS ciphereddata_user_id_idx").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("DELETE FROM schema_migrations").WithArgs(2).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectExec("SELECT pg_advisory_unlock").WithArgs(migrationLockID).WillReturnResult(sqlmock.NewResult(0, 0))
+	m, ok, err := s.MigrateDown(context.Background())
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, 2, m.Version)
+
+	// failed migration is rolled back with its bookkeeping
+	mock.ExpectExec("SELECT pg_advisory_lock").WithArgs(migrationLockID).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("CREATE TABLE IF NOT EXISTS schema_migrations").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT version, applied_at FROM schema_migrations").WillReturnRows(
+		sqlmock.NewRows([]string{"version", "applied_at"}).AddRow(1, now))
+	mock.ExpectBegin()
+	mock.ExpectExec("DROP TABLE IF EXISTS loginattempts").WillReturnError(context.DeadlineExceeded)
+	mock.ExpectRollback()
+	mock.ExpectExec("SELECT pg_advisory_unlock").WithArgs(migrationLockID).WillReturnResult(sqlmock.NewResult(0, 0))
+	_, ok, err = s.MigrateDown(context.Background())
+	require.Error(t, err)
+	require.False(t, ok)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestStorage_Migrations(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+	s := Storage{DB: db}
+	now := time.Now()
+	mock.ExpectExec("SELECT pg_advisory_lock").WithArgs(migrationLockID).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("CREATE TABLE IF NOT EXISTS schema_migrations").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT version, applied_at FROM schema_migrations").WillReturnRows(
+		sqlmock.NewRows([]string{"version", "applied_at"}).AddRow(1, now))
+	mock.ExpectExec("SELECT pg_advisory_unlock").WithArgs(migrationLockID).WillReturnResult(sqlmock.NewResult(0, 0))
+	statuses, err := s.Migrations(context.Background())
+	require.NoError(t, err)
+	require.True(t, statuses[0].Applied())
+	require.Equal(t, now, statuses[0].AppliedAt)
+	require.False(t, statuses[1].Applied())
+	require.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,63 @@
+-- Schema created by CreateTableIfNotExist before migrations,
+-- statements are idempotent so existing databases are adopted as is.
+CREATE TABLE IF NOT EXISTS users
+(
+    email character varying(100) NOT NULL,
+    password character varying(100) NOT NULL,
+    id serial,
+    secret bytea,
+    CONSTRAINT users_pkey PRIMARY KEY (email)
+);
+CREATE TABLE IF NOT EXISTS ciphereddata
+(
+    data bytea,
+    type character varying(100) NOT NULL,
+    user_id serial,
+    uuid uuid NOT NULL,
+    CONSTRAINT ciphereddata_pkey PRIMARY KEY (uuid)
+);
+ALTER TABLE users ADD COLUMN IF NOT EXISTS salt bytea;
+CREATE TABLE IF NOT EXISTS sessions
+(
+    id uuid NOT NULL,
+    email character varying(100) NOT NULL,
+    created_at timestamp with time zone NOT NULL,
+    last_seen timestamp with time zone NOT NULL,
+    expires_at timestamp with time zone NOT NULL,
+    revoked boolean NOT NULL DEFAULT false,
+    CONSTRAINT sessions_pkey PRIMARY KEY (id)
+);
+CREATE TABLE IF NOT EXISTS refreshtokens
+(
+    hash bytea NOT NULL,
+    session_id uuid NOT NULL,
+    used boolean NOT NULL DEFAULT false,
+    created_at timestamp with time zone NOT NULL,
+    CONSTRAINT refreshtokens_pkey PRIMARY KEY (hash)
+);
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS device character varying(100) NOT NULL DEFAULT '';
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS build_version character varying(100) NOT NULL DEFAULT '';
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS ip character varying(100) NOT NULL DEFAULT '';
+CREATE TABLE IF NOT EXISTS totp
+(
+    email character varying(100) NOT NULL,
+    secret character varying(100) NOT NULL,
+    confirmed boolean NOT NULL DEFAULT false,
+    CONSTRAINT totp_pkey PRIMARY KEY (email)
+);
+CREATE TABLE IF NOT EXISTS recoverycodes
+(
+    hash bytea NOT NULL,
+    email character varying(100) NOT NULL,
+    used boolean NOT NULL DEFAULT false,
+    CONSTRAINT recoverycodes_pkey PRIMARY KEY (hash)
+);
+CREATE TABLE IF NOT EXISTS loginattempts
+(
+    key character varying(200) NOT NULL,
+    failures integer NOT NULL DEFAULT 0,
+    last_failure timestamp with time zone NOT NULL,
+    locked_until timestamp with time zone NOT NULL,
+    CONSTRAINT loginattempts_pkey PRIMARY KEY (key)
+);
+ALTER TABLE users ADD COLUMN IF NOT EXISTS delete_after timestamp with time zone;
@@ -0,0 +1,4 @@
+-- Removed orphaned rows and serial default of user_id are not restored.
+DROP INDEX IF EXISTS ciphereddata_user_id_idx;
+ALTER TABLE ciphereddata DROP CONSTRAINT IF EXISTS ciphereddata_user_id_fkey;
+ALTER TABLE users DROP CONSTRAINT IF EXISTS users_id_key;
@@ -0,0 +1,9 @@
+-- ciphereddata.user_id was a serial, so rows got their own ids when
+-- user_id was not set, and nothing removed rows of deleted users.
+DELETE FROM ciphereddata WHERE user_id NOT IN (SELECT id FROM users);
+ALTER TABLE ciphereddata ALTER COLUMN user_id DROP DEFAULT;
+DROP SEQUENCE IF EXISTS ciphereddata_user_id_seq;
+ALTER TABLE users ADD CONSTRAINT users_id_key UNIQUE (id);
+ALTER TABLE ciphereddata ADD CONSTRAINT ciphereddata_user_id_fkey
+    FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE;
+CREATE INDEX ciphereddata_user_id_idx ON ciphereddata (user_id);
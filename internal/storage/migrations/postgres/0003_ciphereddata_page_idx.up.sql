@@ -0,0 +1,3 @@
+-- Data pages are read by user in uuid order.
+CREATE INDEX ciphereddata_user_id_uuid_idx ON ciphereddata (user_id, uuid);
+DROP INDEX IF EXISTS ciphereddata_user_id_idx;
@@ -0,0 +1,8 @@
+CREATE TABLE filechunks
+(
+    uuid uuid NOT NULL REFERENCES ciphereddata (uuid) ON DELETE CASCADE,
+    idx bigint NOT NULL,
+    data bytea NOT NULL,
+    last boolean NOT NULL DEFAULT false,
+    CONSTRAINT filechunks_pkey PRIMARY KEY (uuid, idx)
+);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS uploadchunks;
+DROP TABLE IF EXISTS uploads;
@@ -0,0 +1,20 @@
+-- Chunks of unfinished uploads are kept apart from file content.
+CREATE TABLE uploads
+(
+    id uuid NOT NULL,
+    user_id integer NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    uuid uuid NOT NULL,
+    type character varying(100) NOT NULL,
+    data bytea,
+    updated_at timestamp with time zone NOT NULL,
+    CONSTRAINT uploads_pkey PRIMARY KEY (id)
+);
+CREATE INDEX uploads_updated_at_idx ON uploads (updated_at);
+CREATE TABLE uploadchunks
+(
+    upload_id uuid NOT NULL REFERENCES uploads (id) ON DELETE CASCADE,
+    idx bigint NOT NULL,
+    data bytea NOT NULL,
+    last boolean NOT NULL DEFAULT false,
+    CONSTRAINT uploadchunks_pkey PRIMARY KEY (upload_id, idx)
+);
@@ -0,0 +1,3 @@
+-- Payload of chunk with blob_key is kept in blob store, data column is left empty.
+ALTER TABLE filechunks ADD COLUMN blob_key text;
+ALTER TABLE uploadchunks ADD COLUMN blob_key text;
@@ -0,0 +1,10 @@
+-- Each change of user's vault gets next revision of user, deleted records are kept as tombstones.
+ALTER TABLE users ADD COLUMN revision bigint NOT NULL DEFAULT 0;
+ALTER TABLE ciphereddata ADD COLUMN revision bigint NOT NULL DEFAULT 0;
+ALTER TABLE ciphereddata ADD COLUMN deleted boolean NOT NULL DEFAULT false;
+-- existing records are numbered in order of uuid
+UPDATE ciphereddata SET revision = numbered.revision
+FROM (SELECT uuid, row_number() OVER (PARTITION BY user_id ORDER BY uuid) AS revision FROM ciphereddata) AS numbered
+WHERE ciphereddata.uuid = numbered.uuid;
+UPDATE users SET revision = (SELECT count(*) FROM ciphereddata WHERE ciphereddata.user_id = users.id);
+CREATE INDEX ciphereddata_revision_idx ON ciphereddata (user_id, revision);
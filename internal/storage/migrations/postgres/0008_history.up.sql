@@ -0,0 +1,11 @@
+-- Previous versions of records, replaced_at is time version was overwritten or deleted.
+CREATE TABLE cipheredhistory
+(
+    uuid uuid NOT NULL REFERENCES ciphereddata (uuid) ON DELETE CASCADE,
+    revision bigint NOT NULL,
+    data bytea NOT NULL,
+    type character varying(100) NOT NULL,
+    replaced_at timestamp with time zone NOT NULL,
+    CONSTRAINT cipheredhistory_pkey PRIMARY KEY (uuid, revision)
+);
+CREATE INDEX cipheredhistory_replaced_at_idx ON cipheredhistory (replaced_at);
@@ -0,0 +1 @@
+ALTER TABLE totp DROP COLUMN last_step;
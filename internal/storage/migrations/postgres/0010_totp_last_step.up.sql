@@ -0,0 +1,2 @@
+-- Time step of last accepted authenticator code, the code can't be used again.
+ALTER TABLE totp ADD COLUMN last_step bigint NOT NULL DEFAULT 0;
@@ -0,0 +1,7 @@
+DROP TABLE IF EXISTS loginattempts;
+DROP TABLE IF EXISTS recoverycodes;
+DROP TABLE IF EXISTS totp;
+DROP TABLE IF EXISTS refreshtokens;
+DROP TABLE IF EXISTS sessions;
+DROP TABLE IF EXISTS ciphereddata;
+DROP TABLE IF EXISTS users;
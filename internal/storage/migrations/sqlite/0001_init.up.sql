@@ -0,0 +1,56 @@
+-- Times are stored as UTC text, see sqliteConn, so they sort chronologically.
+CREATE TABLE users
+(
+    id integer PRIMARY KEY AUTOINCREMENT,
+    email text NOT NULL UNIQUE,
+    password text NOT NULL,
+    secret blob,
+    salt blob,
+    delete_after timestamp
+);
+CREATE TABLE ciphereddata
+(
+    uuid text NOT NULL PRIMARY KEY,
+    data blob,
+    type text NOT NULL,
+    user_id integer NOT NULL REFERENCES users (id) ON DELETE CASCADE
+);
+CREATE INDEX ciphereddata_user_id_idx ON ciphereddata (user_id);
+CREATE TABLE sessions
+(
+    id text NOT NULL PRIMARY KEY,
+    email text NOT NULL,
+    created_at timestamp NOT NULL,
+    last_seen timestamp NOT NULL,
+    expires_at timestamp NOT NULL,
+    revoked boolean NOT NULL DEFAULT false,
+    device text NOT NULL DEFAULT '',
+    build_version text NOT NULL DEFAULT '',
+    ip text NOT NULL DEFAULT ''
+);
+CREATE TABLE refreshtokens
+(
+    hash blob NOT NULL PRIMARY KEY,
+    session_id text NOT NULL,
+    used boolean NOT NULL DEFAULT false,
+    created_at timestamp NOT NULL
+);
+CREATE TABLE totp
+(
+    email text NOT NULL PRIMARY KEY,
+    secret text NOT NULL,
+    confirmed boolean NOT NULL DEFAULT false
+);
+CREATE TABLE recoverycodes
+(
+    hash blob NOT NULL PRIMARY KEY,
+    email text NOT NULL,
+    used boolean NOT NULL DEFAULT false
+);
+CREATE TABLE loginattempts
+(
+    key text NOT NULL PRIMARY KEY,
+    failures integer NOT NULL DEFAULT 0,
+    last_failure timestamp NOT NULL,
+    locked_until timestamp NOT NULL
+);
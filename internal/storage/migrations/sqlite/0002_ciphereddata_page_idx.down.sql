@@ -0,0 +1,2 @@
+CREATE INDEX ciphereddata_user_id_idx ON ciphereddata (user_id);
+DROP INDEX IF EXISTS ciphereddata_user_id_uuid_idx;
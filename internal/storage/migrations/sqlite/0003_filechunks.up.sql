@@ -0,0 +1,8 @@
+CREATE TABLE filechunks
+(
+    uuid text NOT NULL REFERENCES ciphereddata (uuid) ON DELETE CASCADE,
+    idx integer NOT NULL,
+    data blob NOT NULL,
+    last boolean NOT NULL DEFAULT false,
+    PRIMARY KEY (uuid, idx)
+);
@@ -0,0 +1,19 @@
+-- Chunks of unfinished uploads are kept apart from file content.
+CREATE TABLE uploads
+(
+    id text NOT NULL PRIMARY KEY,
+    user_id integer NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    uuid text NOT NULL,
+    type text NOT NULL,
+    data blob,
+    updated_at timestamp NOT NULL
+);
+CREATE INDEX uploads_updated_at_idx ON uploads (updated_at);
+CREATE TABLE uploadchunks
+(
+    upload_id text NOT NULL REFERENCES uploads (id) ON DELETE CASCADE,
+    idx integer NOT NULL,
+    data blob NOT NULL,
+    last boolean NOT NULL DEFAULT false,
+    PRIMARY KEY (upload_id, idx)
+);
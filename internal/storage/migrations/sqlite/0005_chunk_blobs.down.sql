@@ -0,0 +1,2 @@
+ALTER TABLE uploadchunks DROP COLUMN blob_key;
+ALTER TABLE filechunks DROP COLUMN blob_key;
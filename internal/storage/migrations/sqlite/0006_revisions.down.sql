@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS ciphereddata_revision_idx;
+DELETE FROM ciphereddata WHERE deleted;
+ALTER TABLE ciphereddata DROP COLUMN deleted;
+ALTER TABLE ciphereddata DROP COLUMN revision;
+ALTER TABLE users DROP COLUMN revision;
@@ -0,0 +1,11 @@
+-- Previous versions of records, replaced_at is time version was overwritten or deleted.
+CREATE TABLE cipheredhistory
+(
+    uuid text NOT NULL REFERENCES ciphereddata (uuid) ON DELETE CASCADE,
+    revision bigint NOT NULL,
+    data blob NOT NULL,
+    type text NOT NULL,
+    replaced_at timestamp NOT NULL,
+    PRIMARY KEY (uuid, revision)
+);
+CREATE INDEX cipheredhistory_replaced_at_idx ON cipheredhistory (replaced_at);
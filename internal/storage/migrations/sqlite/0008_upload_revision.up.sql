@@ -0,0 +1,2 @@
+-- Upload is based on revision of file record, it is not committed over later change.
+ALTER TABLE uploads ADD COLUMN revision bigint NOT NULL DEFAULT 0;
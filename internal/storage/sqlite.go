@@ -0,0 +1,93 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"strings"
+	"time"
+
+	"modernc.org/sqlite"
+)
+
+// SQLiteScheme - DSN prefix of embedded SQLite database,
+// e.g. "sqlite:///var/lib/gophkeeper/data.db" or "sqlite://data.db".
+const SQLiteScheme = "sqlite://"
+
+// sqliteDriver - name of registered SQLite driver with UTC times.
+const sqliteDriver = "gophkeeper-sqlite"
+
+// sqliteParams - foreign keys are off in SQLite by default,
+// times are written in format which modernc driver parses back.
+const sqliteParams = "_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)&_time_format=sqlite"
+
+func init() {
+	sql.Register(sqliteDriver, sqliteUTCDriver{})
+}
+
+// sqliteUTCDriver opens SQLite connections which store times in UTC.
+type sqliteUTCDriver struct{}
+
+// Open opens connection by modernc driver.
+func (sqliteUTCDriver) Open(name string) (driver.Conn, error) {
+	conn, err := (&sqlite.Driver{}).Open(name)
+	if err != nil {
+		return nil, err
+	}
+	c, ok := conn.(sqliteDriverConn)
+	if !ok {
+		conn.Close()
+		return nil, errors.New("sqlite: driver connection doesn't support context")
+	}
+	return sqliteConn{c}, nil
+}
+
+// sqliteDriverConn - interfaces of modernc connection used by database/sql,
+// transactions and queries are cancelled with context only if connection implements them.
+type sqliteDriverConn interface {
+	driver.Conn
+	driver.ConnBeginTx
+	driver.ConnPrepareContext
+	driver.ExecerContext
+	driver.QueryerContext
+	driver.Pinger
+}
+
+// sqliteConn - SQLite keeps times as text, so times are converted to UTC
+// to be compared in queries in chronological order.
+// Other methods are forwarded to modernc connection.
+type sqliteConn struct {
+	sqliteDriverConn
+}
+
+// ResetSession forwards to modernc connection if it resets sessions.
+func (c sqliteConn) ResetSession(ctx context.Context) error {
+	if r, ok := c.sqliteDriverConn.(driver.SessionResetter); ok {
+		return r.ResetSession(ctx)
+	}
+	return nil
+}
+
+// CheckNamedValue converts time arguments to UTC, others are converted by default.
+func (sqliteConn) CheckNamedValue(nv *driver.NamedValue) error {
+	if t, ok := nv.Value.(time.Time); ok {
+		nv.Value = t.UTC()
+		return nil
+	}
+	return driver.ErrSkip
+}
+
+// openArgs returns database driver name and data source for storage DSN.
+// DSN starting with SQLiteScheme opens SQLite file, any other DSN is for postgres.
+func openArgs(dsn string) (string, string) {
+	if !strings.HasPrefix(dsn, SQLiteScheme) {
+		return dialectPostgres, dsn
+	}
+	path := strings.TrimPrefix(dsn, SQLiteScheme)
+	separator := "?"
+	if strings.Contains(path, "?") {
+		separator = "&"
+	}
+	return sqliteDriver, "file:" + path + separator + sqliteParams
+}
@@ -1,21 +1,27 @@
 // Package storage implements function to work with postgres sql.
+// Embedded SQLite database is used if DSN starts with "sqlite://",
+// in-memory storage for development and tests if DSN starts with "memory://".
 package storage
 
 import (
 	//"context"
 	"context"
-	"crypto/rand"
 	"database/sql"
 	"errors"
+	"fmt"
 	"log"
+	"strings"
 	"time"
 
 	//"time"
 
 	"github.com/google/uuid"
 	// pq - driver for postgre
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
 
+	"github.com/MaximkaSha/gophkeeper/internal/blobstore"
 	"github.com/MaximkaSha/gophkeeper/internal/models"
 )
 
@@ -25,6 +31,21 @@ type Storage struct {
 	ConnectionString string
 	// DB handler.
 	DB *sql.DB
+	// Store of file chunk payloads, they are kept in DB if nil.
+	Blobs blobstore.BlobStore
+	// dialect of DB, postgres if empty.
+	dialect string
+}
+
+// SQL dialects of supported databases.
+const (
+	dialectPostgres = "postgres"
+	dialectSQLite   = "sqlite"
+)
+
+// isSQLite reports if storage works with embedded SQLite database.
+func (s Storage) isSQLite() bool {
+	return s.dialect == dialectSQLite
 }
 
 // NewStorage Constrctor of storage. Needs dsn.
@@ -39,18 +60,49 @@ func NewStorage(dsn string) *Storage {
 	return s
 }
 
-// initDB - initialize database.
-func (s *Storage) initDB() error {
-	psqlconn := s.ConnectionString
+// Open connects to database without migrating it, used by migrate command.
+func Open(dsn string) (*Storage, error) {
+	if strings.HasPrefix(dsn, MemoryScheme) {
+		return nil, errors.New("in-memory storage has no database schema")
+	}
+	s := &Storage{ConnectionString: dsn}
+	err := s.connect()
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// connect - open database handler and check connection.
+func (s *Storage) connect() error {
 	var err error
-	s.DB, err = sql.Open("postgres", psqlconn)
-	CheckError(err)
-	err = s.DB.Ping()
+	driverName, source := openArgs(s.ConnectionString)
+	s.dialect = dialectPostgres
+	if driverName == sqliteDriver {
+		s.dialect = dialectSQLite
+	}
+	s.DB, err = sql.Open(driverName, source)
+	if err != nil {
+		return err
+	}
+	if s.isSQLite() {
+		// SQLite has single writer, one connection avoids busy errors.
+		s.DB.SetMaxOpenConns(1)
+	}
+	return s.DB.Ping()
+}
+
+// initDB - initialize database and apply pending migrations.
+func (s *Storage) initDB() error {
+	err := s.connect()
 	CheckError(err)
 	/*err = s.CreateDBIfNotExist()
 	CheckError(err) */
-	err = s.CreateTableIfNotExist()
+	applied, err := s.MigrateUp(context.Background())
 	CheckError(err)
+	if applied > 0 {
+		log.Printf("DB migrated, %d migrations applied", applied)
+	}
 	return err
 }
 
@@ -61,133 +113,1164 @@ func CheckError(err error) {
 	}
 }
 
-// CreateTableIfNotExist creates DBs tables if needed.
-func (s Storage) CreateTableIfNotExist() error {
-	var query = `
-CREATE TABLE IF NOT EXISTS users
-(
-    email character varying(100) NOT NULL,
-    password character varying(100) NOT NULL,
-    id serial,
-    secret bytea,
-    CONSTRAINT users_pkey PRIMARY KEY (email)
-);
-CREATE TABLE IF NOT EXISTS ciphereddata
-(
-    data bytea,
-    type character varying(100) NOT NULL,
-    user_id serial,
-    uuid uuid NOT NULL,
-    CONSTRAINT ciphereddata_pkey PRIMARY KEY (uuid)
-);
-`
-	ctx, cancelfunc := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancelfunc()
-	_, err := s.DB.ExecContext(ctx, query)
-	if err != nil {
-		log.Printf("Error %s when creating  table", err.Error())
-		return err
+// dbError wraps driver error by models error of the same meaning.
+// Context errors and unknown errors are returned as is.
+func dbError(err error) error {
+	if err == nil {
+		return nil
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		return models.ErrNotFound
+	}
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		switch pqErr.Code {
+		case "23505": // unique_violation
+			return fmt.Errorf("%w: %s", models.ErrAlreadyExists, err)
+		case "23502": // not_null_violation, id of referenced record is looked up by subquery
+			return fmt.Errorf("%w: %s", models.ErrNotFound, err)
+		case "23503", "40001", "40P01": // foreign_key_violation, serialization_failure, deadlock_detected
+			return fmt.Errorf("%w: %s", models.ErrConflict, err)
+		}
+	}
+	var sqliteErr *sqlite.Error
+	if errors.As(err, &sqliteErr) {
+		switch sqliteErr.Code() {
+		case sqlite3.SQLITE_CONSTRAINT_UNIQUE, sqlite3.SQLITE_CONSTRAINT_PRIMARYKEY:
+			return fmt.Errorf("%w: %s", models.ErrAlreadyExists, err)
+		case sqlite3.SQLITE_CONSTRAINT_NOTNULL:
+			return fmt.Errorf("%w: %s", models.ErrNotFound, err)
+		case sqlite3.SQLITE_CONSTRAINT_FOREIGNKEY, sqlite3.SQLITE_BUSY:
+			return fmt.Errorf("%w: %s", models.ErrConflict, err)
+		}
 	}
-
 	return err
 }
 
 // AddUser - insert user to database.
-func (s Storage) AddUser(user models.User) error {
+// Secret must be already wrapped by client, server never sees plain vault key.
+func (s Storage) AddUser(ctx context.Context, user models.User) error {
 	var query = `
-	INSERT INTO users (email,password,secret)
-	VALUES ($1, $2, $3)`
-	secret, err := s.GenSecretKey()
-	if err != nil {
-		log.Println(err)
-		return err
-	}
-	_, err = s.DB.Exec(query, user.Email, user.Password, secret)
+	INSERT INTO users (email,password,secret,salt)
+	VALUES ($1, $2, $3, $4)`
+	_, err := s.DB.ExecContext(ctx, query, user.Email, user.Password, user.Secret, user.Salt)
 	if err != nil {
 		log.Println(err)
-		return err
+		return dbError(err)
 	}
 	return nil
 }
 
-// GenSecretKey Generate user privite key.
-func (s Storage) GenSecretKey() (string, error) {
-	data := make([]byte, 32)
-	_, err := rand.Read(data)
+// UpdateUser - update password hash, wrapped vault key and salt of user.
+func (s Storage) UpdateUser(ctx context.Context, user models.User) error {
+	var query = `UPDATE users SET password = $2, secret = $3, salt = $4 WHERE email = $1`
+	_, err := s.DB.ExecContext(ctx, query, user.Email, user.Password, user.Secret, user.Salt)
 	if err != nil {
-		return ``, err
+		log.Println(err)
+		return dbError(err)
 	}
-	return string(data), nil
+	return nil
 }
 
 // GetUser - select user model from database.
-func (s Storage) GetUser(user models.User) (models.User, error) {
-	var query = `SELECT email,password,secret from users where email = $1`
+func (s Storage) GetUser(ctx context.Context, user models.User) (models.User, error) {
+	var query = `SELECT email,password,secret,salt,delete_after from users where email = $1`
 	data := models.User{}
-	err := s.DB.QueryRow(query, user.Email).Scan(&data.Email, &data.Password, &data.Secret)
+	var deleteAfter sql.NullTime
+	err := s.DB.QueryRowContext(ctx, query, user.Email).Scan(&data.Email, &data.Password, &data.Secret, &data.Salt, &deleteAfter)
 	if err != nil {
 		log.Println(err)
-		return models.User{}, err
+		return models.User{}, dbError(err)
 	}
+	data.DeleteAfter = deleteAfter.Time
 	return data, nil
 }
 
-// AddCipheredData - insert ciphered data to database.
-func (s Storage) AddCipheredData(data models.CipheredData) error {
-	var query = `INSERT INTO ciphereddata (data, type, user_id, uuid)
-		VALUES ($1, $2, (SELECT id from users where email = $3), $4)
+// ScheduleUserDeletion - mark user to be purged after given time.
+func (s Storage) ScheduleUserDeletion(ctx context.Context, email string, at time.Time) error {
+	var query = `UPDATE users SET delete_after = $2 WHERE email = $1`
+	_, err := s.DB.ExecContext(ctx, query, email, at)
+	if err != nil {
+		log.Println(err)
+		return dbError(err)
+	}
+	return nil
+}
+
+// CancelUserDeletion - unmark user scheduled for deletion.
+// Returns false if deletion was not scheduled.
+func (s Storage) CancelUserDeletion(ctx context.Context, email string) (bool, error) {
+	var query = `UPDATE users SET delete_after = NULL WHERE email = $1 AND delete_after IS NOT NULL`
+	res, err := s.DB.ExecContext(ctx, query, email)
+	if err != nil {
+		log.Println(err)
+		return false, dbError(err)
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		log.Println(err)
+		return false, dbError(err)
+	}
+	return rows == 1, nil
+}
+
+// ListDueDeletions - select emails of users which must be purged at given time.
+func (s Storage) ListDueDeletions(ctx context.Context, now time.Time) ([]string, error) {
+	var query = `SELECT email from users where delete_after <= $1`
+	emails := []string{}
+	rows, err := s.DB.QueryContext(ctx, query, now)
+	if err != nil {
+		log.Println(err)
+		return emails, dbError(err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var email string
+		err = rows.Scan(&email)
+		if err != nil {
+			log.Println(err)
+			return emails, dbError(err)
+		}
+		emails = append(emails, email)
+	}
+	return emails, dbError(rows.Err())
+}
+
+// PurgeUser - delete user scheduled for deletion and all of its data in one transaction.
+// Returns false if user is not due at given time, e.g. deletion was canceled meanwhile.
+func (s Storage) PurgeUser(ctx context.Context, email string, now time.Time) (bool, error) {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		log.Println(err)
+		return false, dbError(err)
+	}
+	defer tx.Rollback()
+	var query = `SELECT id from users WHERE email = $1 AND delete_after <= $2`
+	if !s.isSQLite() {
+		// SQLite transactions are serialized by single connection
+		query += ` FOR UPDATE`
+	}
+	var id int
+	err = tx.QueryRowContext(ctx, query, email, now).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		log.Println(err)
+		return false, dbError(err)
+	}
+	blobs, err := deletedBlobs(ctx, tx, `DELETE from uploadchunks
+		WHERE upload_id IN (SELECT id from uploads WHERE user_id = $1) RETURNING blob_key`, id)
+	if err != nil {
+		return false, err
+	}
+	fileBlobs, err := deletedBlobs(ctx, tx, `DELETE from filechunks
+		WHERE uuid IN (SELECT uuid from ciphereddata WHERE user_id = $1) RETURNING blob_key`, id)
+	if err != nil {
+		return false, err
+	}
+	blobs = append(blobs, fileBlobs...)
+	queries := []struct {
+		query string
+		arg   interface{}
+	}{
+		{`DELETE from uploads WHERE user_id = $1`, id},
+		{`DELETE from ciphereddata WHERE user_id = $1`, id},
+		{`DELETE from refreshtokens WHERE session_id IN (SELECT id from sessions WHERE email = $1)`, email},
+		{`DELETE from sessions WHERE email = $1`, email},
+		{`DELETE from recoverycodes WHERE email = $1`, email},
+		{`DELETE from totp WHERE email = $1`, email},
+		{`DELETE from users WHERE id = $1`, id},
+	}
+	for _, q := range queries {
+		_, err = tx.ExecContext(ctx, q.query, q.arg)
+		if err != nil {
+			log.Println(err)
+			return false, dbError(err)
+		}
+	}
+	err = tx.Commit()
+	if err != nil {
+		log.Println(err)
+		return false, dbError(err)
+	}
+	s.deleteBlobs(ctx, blobs)
+	return true, nil
+}
+
+// AddCipheredData - insert ciphered data to database, returns revision of saved record.
+// Existing record is updated only if it belongs to the same user,
+// otherwise models.ErrPermissionDenied returned.
+// Record changed after revision of data is not updated, models.ConflictError returned.
+func (s Storage) AddCipheredData(ctx context.Context, data models.CipheredData) (int64, error) {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		log.Println(err)
+		return 0, dbError(err)
+	}
+	defer tx.Rollback()
+	revision, err := nextRevision(ctx, tx, data.User)
+	if err != nil {
+		return 0, err
+	}
+	if data.ID == "" {
+		data.ID = uuid.NewString()
+	}
+	current, err := currentData(ctx, tx, data)
+	if err != nil {
+		return 0, err
+	}
+	if current.Revision != data.Revision {
+		return 0, &models.ConflictError{Current: current}
+	}
+	if err := archiveData(ctx, tx, current); err != nil {
+		return 0, err
+	}
+	var query = `INSERT INTO ciphereddata (data, type, user_id, uuid, revision)
+		VALUES ($1, $2, (SELECT id from users where email = $3), $4, $5)
 		ON CONFLICT (uuid)
 		DO UPDATE SET
 		data = EXCLUDED.data,
-		type = EXCLUDED.type`
-	if data.ID == "" {
-		data.ID = uuid.NewString()
+		type = EXCLUDED.type,
+		revision = EXCLUDED.revision,
+		deleted = false
+		WHERE ciphereddata.user_id = EXCLUDED.user_id`
+	res, err := tx.ExecContext(ctx, query, data.Data, data.Type, data.User, data.ID, revision)
+	if err != nil {
+		log.Println(err)
+		return 0, dbError(err)
 	}
-	_, err := s.DB.Exec(query, data.Data, data.Type, data.User, data.ID)
+	rows, err := res.RowsAffected()
 	if err != nil {
 		log.Println(err)
-		return err
+		return 0, dbError(err)
+	}
+	if rows == 0 {
+		return 0, models.ErrPermissionDenied
+	}
+	err = tx.Commit()
+	if err != nil {
+		log.Println(err)
+		return 0, dbError(err)
+	}
+	return revision, nil
+}
+
+// currentData returns stored version of given record, missing record is tombstone of revision 0.
+// User's records are not changed concurrently, user row is locked by nextRevision.
+// If record belongs to another user models.ErrPermissionDenied returned.
+func currentData(ctx context.Context, tx *sql.Tx, data models.CipheredData) (models.CipheredData, error) {
+	current := models.CipheredData{ID: data.ID, Type: data.Type, User: data.User, Deleted: true}
+	var owned bool
+	err := tx.QueryRowContext(ctx, `SELECT data, type, revision, deleted, user_id = (SELECT id from users where email = $2)
+		from ciphereddata WHERE uuid = $1`, data.ID, data.User).
+		Scan(&current.Data, &current.Type, &current.Revision, &current.Deleted, &owned)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+	case err != nil:
+		log.Println(err)
+		return current, dbError(err)
+	case !owned:
+		return current, models.ErrPermissionDenied
+	}
+	return current, nil
+}
+
+// archiveData keeps current version of record in history before it is replaced.
+// File records are not kept, their content is replaced with them.
+func archiveData(ctx context.Context, tx *sql.Tx, current models.CipheredData) error {
+	if current.Deleted || current.Type == "DATA" {
+		return nil
+	}
+	_, err := tx.ExecContext(ctx, `INSERT INTO cipheredhistory (uuid, revision, data, type, replaced_at)
+		VALUES ($1, $2, $3, $4, $5)`, current.ID, current.Revision, current.Data, current.Type, time.Now())
+	if err != nil {
+		log.Println(err)
+		return dbError(err)
 	}
 	return nil
 }
 
-// GetCipheredData - returns all users data from database by given user.
-func (s Storage) GetCipheredData(email string) ([]models.CipheredData, error) {
-	var query = `SELECT * from ciphereddata where user_id = (SELECT id from users where email = $1)`
-	rows, err := s.DB.Query(query, email)
+// nextRevision increments revision of user's vault in transaction and returns it.
+// User row stays locked till transaction ends, so changes are committed in order of revisions.
+func nextRevision(ctx context.Context, tx *sql.Tx, email string) (int64, error) {
+	var revision int64
+	err := tx.QueryRowContext(ctx, `UPDATE users SET revision = revision + 1 WHERE email = $1 RETURNING revision`, email).Scan(&revision)
+	if err != nil {
+		log.Println(err)
+		return 0, dbError(err)
+	}
+	return revision, nil
+}
+
+// GetCipheredData - returns page of user data from database ordered by uuid.
+// Keyset pagination is used, so pages are read by index however far they are.
+func (s Storage) GetCipheredData(ctx context.Context, email string, page models.DataPage) ([]models.CipheredData, error) {
+	var query = `SELECT data, type, uuid, revision from ciphereddata
+		where user_id = (SELECT id from users where email = $1) AND NOT deleted`
+	args := []interface{}{email}
+	if page.After != "" {
+		args = append(args, page.After)
+		query += fmt.Sprintf(` AND uuid > $%d`, len(args))
+	}
+	if len(page.Types) > 0 {
+		placeholders := make([]string, len(page.Types))
+		for i, t := range page.Types {
+			args = append(args, t)
+			placeholders[i] = fmt.Sprintf("$%d", len(args))
+		}
+		query += ` AND type IN (` + strings.Join(placeholders, ", ") + `)`
+	}
+	query += ` ORDER BY uuid`
+	if page.Limit > 0 {
+		args = append(args, page.Limit)
+		query += fmt.Sprintf(` LIMIT $%d`, len(args))
+	}
+	rows, err := s.DB.QueryContext(ctx, query, args...)
 	if err != nil {
 		log.Printf("Error %s when getting all data", err)
-		return []models.CipheredData{}, err
+		return []models.CipheredData{}, dbError(err)
 	}
 	defer rows.Close()
 	data := []models.CipheredData{}
-	counter := 0
 	for rows.Next() {
-		model := models.CipheredData{}
-		if err := rows.Scan(&model.Data, &model.Type, &model.User, &model.ID); err != nil {
+		model := models.CipheredData{
+			User: email,
+		}
+		if err := rows.Scan(&model.Data, &model.Type, &model.ID, &model.Revision); err != nil {
 			log.Println(err)
-			return []models.CipheredData{}, err
+			return []models.CipheredData{}, dbError(err)
 		}
-		counter++
 		data = append(data, model)
 	}
-	if counter == 0 {
-		return []models.CipheredData{}, errors.New("no data for user")
+	if err := rows.Err(); err != nil {
+		return []models.CipheredData{}, dbError(err)
 	}
 	return data, nil
 }
 
-// DelCiphereData - delete user data from database by given uuid.
-func (s Storage) DelCiphereData(uuid string) error {
-	var query = `DELETE from ciphereddata WHERE uuid = $1`
-	_, err := s.DB.Exec(query, uuid)
+// DelCiphereData - delete user data from database by given owner's email and uuid.
+// Record is replaced by tombstone, so deletion is listed by ListChanges.
+// If record belongs to another user models.ErrPermissionDenied returned.
+// Record changed after expected revision is not deleted, models.ConflictError returned.
+func (s Storage) DelCiphereData(ctx context.Context, email string, uuid string, expected int64) error {
+	tx, err := s.DB.BeginTx(ctx, nil)
 	if err != nil {
 		log.Println(err)
+		return dbError(err)
+	}
+	defer tx.Rollback()
+	revision, err := nextRevision(ctx, tx, email)
+	if err != nil {
+		return err
+	}
+	current, err := currentData(ctx, tx, models.CipheredData{ID: uuid, User: email})
+	if err != nil {
+		return err
+	}
+	if current.Deleted {
+		return nil
+	}
+	if current.Revision != expected {
+		return &models.ConflictError{Current: current}
+	}
+	if err := archiveData(ctx, tx, current); err != nil {
+		return err
+	}
+	blobs, err := deletedBlobs(ctx, tx, `DELETE from filechunks WHERE uuid = $1 RETURNING blob_key`, uuid)
+	if err != nil {
 		return err
 	}
+	_, err = tx.ExecContext(ctx, `UPDATE ciphereddata SET data = NULL, deleted = true, revision = $2 WHERE uuid = $1`, uuid, revision)
+	if err != nil {
+		log.Println(err)
+		return dbError(err)
+	}
+	err = tx.Commit()
+	if err != nil {
+		log.Println(err)
+		return dbError(err)
+	}
+	s.deleteBlobs(ctx, blobs)
 	return nil
 }
 
+// ListChanges - returns records of user changed after given revision in order of revision,
+// deleted ones are returned as tombstones. All changes are returned if limit is 0.
+func (s Storage) ListChanges(ctx context.Context, email string, since int64, limit int) ([]models.CipheredData, error) {
+	var query = `SELECT data, type, uuid, revision, deleted from ciphereddata
+		WHERE user_id = (SELECT id from users where email = $1) AND revision > $2
+		ORDER BY revision`
+	args := []interface{}{email, since}
+	if limit > 0 {
+		args = append(args, limit)
+		query += ` LIMIT $3`
+	}
+	rows, err := s.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		log.Println(err)
+		return nil, dbError(err)
+	}
+	defer rows.Close()
+	changes := []models.CipheredData{}
+	for rows.Next() {
+		change := models.CipheredData{User: email}
+		err = rows.Scan(&change.Data, &change.Type, &change.ID, &change.Revision, &change.Deleted)
+		if err != nil {
+			log.Println(err)
+			return nil, dbError(err)
+		}
+		changes = append(changes, change)
+	}
+	return changes, dbError(rows.Err())
+}
+
+// ListRevisions - returns previous versions of user's record, newest first.
+// Record of other user has no versions, models.ErrNoHistory returned for file record.
+func (s Storage) ListRevisions(ctx context.Context, email string, uuid string) ([]models.DataRevision, error) {
+	if err := s.checkHistory(ctx, email, uuid); err != nil {
+		return nil, err
+	}
+	var query = `SELECT data, type, revision, replaced_at from cipheredhistory
+		WHERE uuid = $1 AND uuid IN (SELECT uuid from ciphereddata WHERE user_id = (SELECT id from users where email = $2))
+		ORDER BY revision DESC`
+	rows, err := s.DB.QueryContext(ctx, query, uuid, email)
+	if err != nil {
+		log.Println(err)
+		return nil, dbError(err)
+	}
+	defer rows.Close()
+	revisions := []models.DataRevision{}
+	for rows.Next() {
+		revision := models.DataRevision{Data: models.CipheredData{ID: uuid, User: email}}
+		err = rows.Scan(&revision.Data.Data, &revision.Data.Type, &revision.Data.Revision, &revision.ReplacedAt)
+		if err != nil {
+			log.Println(err)
+			return nil, dbError(err)
+		}
+		revisions = append(revisions, revision)
+	}
+	return revisions, dbError(rows.Err())
+}
+
+// GetRevision - returns previous version of user's record by revision, models.ErrNotFound if it is not kept.
+// models.ErrNoHistory returned for file record.
+func (s Storage) GetRevision(ctx context.Context, email string, uuid string, revision int64) (models.DataRevision, error) {
+	if err := s.checkHistory(ctx, email, uuid); err != nil {
+		return models.DataRevision{}, err
+	}
+	var query = `SELECT data, type, replaced_at from cipheredhistory
+		WHERE uuid = $1 AND revision = $2
+		AND uuid IN (SELECT uuid from ciphereddata WHERE user_id = (SELECT id from users where email = $3))`
+	version := models.DataRevision{Data: models.CipheredData{ID: uuid, User: email, Revision: revision}}
+	err := s.DB.QueryRowContext(ctx, query, uuid, revision, email).Scan(&version.Data.Data, &version.Data.Type, &version.ReplacedAt)
+	if err != nil {
+		log.Println(err)
+		return models.DataRevision{}, dbError(err)
+	}
+	return version, nil
+}
+
+// checkHistory returns models.ErrNoHistory if user's record is file, its replaced content is not kept.
+func (s Storage) checkHistory(ctx context.Context, email string, uuid string) error {
+	var dataType string
+	err := s.DB.QueryRowContext(ctx, `SELECT type from ciphereddata
+		WHERE uuid = $1 AND user_id = (SELECT id from users where email = $2)`, uuid, email).Scan(&dataType)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		log.Println(err)
+		return dbError(err)
+	}
+	if dataType == "DATA" {
+		return fmt.Errorf("record %s: %w", uuid, models.ErrNoHistory)
+	}
+	return nil
+}
+
+// PurgeRevisions - delete previous versions replaced before given time
+// and ones beyond given number of newest versions of record, number is not limited if it is not positive.
+// Returns number of deleted versions.
+func (s Storage) PurgeRevisions(ctx context.Context, keep int, before time.Time) (int, error) {
+	res, err := s.DB.ExecContext(ctx, `DELETE from cipheredhistory WHERE replaced_at < $1
+		OR (uuid, revision) IN (SELECT uuid, revision from (SELECT uuid, revision,
+			row_number() OVER (PARTITION BY uuid ORDER BY revision DESC) AS newer from cipheredhistory) AS numbered
+		WHERE $2 > 0 AND newer > $2)`, before, keep)
+	if err != nil {
+		log.Println(err)
+		return 0, dbError(err)
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		log.Println(err)
+		return 0, dbError(err)
+	}
+	return int(rows), nil
+}
+
+// GetFileChunk - returns chunk of file record owned by given user.
+func (s Storage) GetFileChunk(ctx context.Context, email string, id string, index int64) (models.FileChunk, error) {
+	var query = `SELECT idx, data, last, blob_key from filechunks WHERE uuid = $1 AND idx = $2
+		AND uuid IN (SELECT uuid from ciphereddata WHERE user_id = (SELECT id from users where email = $3))`
+	chunk := models.FileChunk{}
+	var key sql.NullString
+	err := s.DB.QueryRowContext(ctx, query, id, index, email).Scan(&chunk.Index, &chunk.Data, &chunk.Last, &key)
+	if err != nil {
+		log.Println(err)
+		return models.FileChunk{}, dbError(err)
+	}
+	if key.Valid {
+		if s.Blobs == nil {
+			return models.FileChunk{}, fmt.Errorf("chunk %d of %s is kept in blob store, but it is not configured", index, id)
+		}
+		chunk.Data, err = s.Blobs.Get(ctx, key.String)
+		if err != nil {
+			log.Println(err)
+			return models.FileChunk{}, err
+		}
+	}
+	return chunk, nil
+}
+
+// AddUpload - insert new upload of user's file.
+// models.ErrPermissionDenied returned if file belongs to another user.
+func (s Storage) AddUpload(ctx context.Context, upload models.Upload) error {
+	var denied bool
+	err := s.DB.QueryRowContext(ctx, `SELECT EXISTS(SELECT 1 from ciphereddata WHERE uuid = $1
+		AND user_id <> (SELECT id from users where email = $2))`, upload.Data.ID, upload.Data.User).Scan(&denied)
+	if err != nil {
+		log.Println(err)
+		return dbError(err)
+	}
+	if denied {
+		return models.ErrPermissionDenied
+	}
+	var query = `INSERT INTO uploads (id, user_id, uuid, type, data, revision, updated_at)
+		VALUES ($1, (SELECT id from users where email = $2), $3, $4, $5, $6, $7)`
+	_, err = s.DB.ExecContext(ctx, query, upload.ID, upload.Data.User, upload.Data.ID, upload.Data.Type, upload.Data.Data,
+		upload.Data.Revision, upload.UpdatedAt)
+	if err != nil {
+		log.Println(err)
+		return dbError(err)
+	}
+	return nil
+}
+
+// GetUpload - returns upload of given user with number of received chunks.
+func (s Storage) GetUpload(ctx context.Context, email string, id string) (models.Upload, error) {
+	var query = `SELECT id, uuid, type, data, revision, updated_at,
+		(SELECT count(*) from uploadchunks WHERE upload_id = uploads.id)
+		from uploads WHERE id = $1 AND user_id = (SELECT id from users where email = $2)`
+	upload := models.Upload{Data: models.CipheredData{User: email}}
+	err := s.DB.QueryRowContext(ctx, query, id, email).Scan(&upload.ID, &upload.Data.ID, &upload.Data.Type,
+		&upload.Data.Data, &upload.Data.Revision, &upload.UpdatedAt, &upload.Chunks)
+	if err != nil {
+		log.Println(err)
+		return models.Upload{}, dbError(err)
+	}
+	return upload, nil
+}
+
+// AddUploadChunk - insert or replace chunk of user's upload, upload is kept alive till given time plus TTL.
+func (s Storage) AddUploadChunk(ctx context.Context, email string, id string, chunk models.FileChunk, at time.Time) error {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		log.Println(err)
+		return dbError(err)
+	}
+	defer tx.Rollback()
+	res, err := tx.ExecContext(ctx, `UPDATE uploads SET updated_at = $1
+		WHERE id = $2 AND user_id = (SELECT id from users where email = $3)`, at, id, email)
+	if err != nil {
+		log.Println(err)
+		return dbError(err)
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		log.Println(err)
+		return dbError(err)
+	}
+	if rows == 0 {
+		return fmt.Errorf("upload %s: %w", id, models.ErrNotFound)
+	}
+	data, key := chunk.Data, sql.NullString{}
+	if s.Blobs != nil {
+		// key of upload chunk is unique, so resent chunk replaces its blob
+		key = sql.NullString{String: fmt.Sprintf("%s/%d", id, chunk.Index), Valid: true}
+		err = s.Blobs.Put(ctx, key.String, chunk.Data)
+		if err != nil {
+			log.Println(err)
+			return err
+		}
+		data = []byte{}
+	}
+	var query = `INSERT INTO uploadchunks (upload_id, idx, data, last, blob_key)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (upload_id, idx)
+		DO UPDATE SET
+		data = EXCLUDED.data,
+		last = EXCLUDED.last,
+		blob_key = EXCLUDED.blob_key`
+	_, err = tx.ExecContext(ctx, query, id, chunk.Index, data, chunk.Last, key)
+	if err != nil {
+		log.Println(err)
+		return dbError(err)
+	}
+	err = tx.Commit()
+	if err != nil {
+		log.Println(err)
+		return dbError(err)
+	}
+	return nil
+}
+
+// CommitUpload - save file record of finished upload and replace file content by uploaded chunks,
+// returns revision of saved record.
+// models.ErrConflict returned if upload has no last chunk or misses some chunks before it.
+// File changed after revision upload is based on is not replaced, models.ConflictError returned
+// and upload is deleted.
+func (s Storage) CommitUpload(ctx context.Context, email string, id string) (int64, error) {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		log.Println(err)
+		return 0, dbError(err)
+	}
+	defer tx.Rollback()
+	var query = `SELECT user_id, uuid, type, data, revision from uploads WHERE id = $1 AND user_id = (SELECT id from users where email = $2)`
+	if !s.isSQLite() {
+		query += ` FOR UPDATE`
+	}
+	var userID int
+	data := models.CipheredData{User: email}
+	err = tx.QueryRowContext(ctx, query, id, email).Scan(&userID, &data.ID, &data.Type, &data.Data, &data.Revision)
+	if err != nil {
+		log.Println(err)
+		return 0, dbError(err)
+	}
+	var last, count int64
+	err = tx.QueryRowContext(ctx, `SELECT idx from uploadchunks WHERE upload_id = $1 AND last`, id).Scan(&last)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, fmt.Errorf("upload %s is not finished: %w", id, models.ErrConflict)
+	}
+	if err != nil {
+		log.Println(err)
+		return 0, dbError(err)
+	}
+	err = tx.QueryRowContext(ctx, `SELECT count(*) from uploadchunks WHERE upload_id = $1 AND idx <= $2`, id, last).Scan(&count)
+	if err != nil {
+		log.Println(err)
+		return 0, dbError(err)
+	}
+	if count != last+1 {
+		return 0, fmt.Errorf("upload %s misses chunks: %w", id, models.ErrConflict)
+	}
+	revision, err := nextRevision(ctx, tx, email)
+	if err != nil {
+		return 0, err
+	}
+	current, err := currentData(ctx, tx, data)
+	if err != nil {
+		return 0, err
+	}
+	if current.Revision != data.Revision {
+		tx.Rollback()
+		// upload can't be committed anymore
+		if err := s.deleteUpload(ctx, id); err != nil {
+			return 0, err
+		}
+		return 0, &models.ConflictError{Current: current}
+	}
+	res, err := tx.ExecContext(ctx, `INSERT INTO ciphereddata (data, type, user_id, uuid, revision)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (uuid)
+		DO UPDATE SET
+		data = EXCLUDED.data,
+		type = EXCLUDED.type,
+		revision = EXCLUDED.revision,
+		deleted = false
+		WHERE ciphereddata.user_id = EXCLUDED.user_id`, data.Data, data.Type, userID, data.ID, revision)
+	if err != nil {
+		log.Println(err)
+		return 0, dbError(err)
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		log.Println(err)
+		return 0, dbError(err)
+	}
+	if rows == 0 {
+		return 0, models.ErrPermissionDenied
+	}
+	blobs, err := deletedBlobs(ctx, tx, `DELETE from filechunks WHERE uuid = (SELECT uuid from uploads WHERE id = $1) RETURNING blob_key`, id)
+	if err != nil {
+		return 0, err
+	}
+	_, err = tx.ExecContext(ctx, `INSERT INTO filechunks (uuid, idx, data, last, blob_key)
+		SELECT uploads.uuid, uploadchunks.idx, uploadchunks.data, uploadchunks.last, uploadchunks.blob_key
+		from uploadchunks JOIN uploads ON uploads.id = uploadchunks.upload_id
+		WHERE uploadchunks.upload_id = $1 AND uploadchunks.idx <= $2`, id, last)
+	if err != nil {
+		log.Println(err)
+		return 0, dbError(err)
+	}
+	// chunks after the last one are not file content
+	unused, err := deletedBlobs(ctx, tx, `DELETE from uploadchunks WHERE upload_id = $1 AND idx > $2 RETURNING blob_key`, id, last)
+	if err != nil {
+		return 0, err
+	}
+	blobs = append(blobs, unused...)
+	_, err = tx.ExecContext(ctx, `DELETE from uploads WHERE id = $1`, id)
+	if err != nil {
+		log.Println(err)
+		return 0, dbError(err)
+	}
+	err = tx.Commit()
+	if err != nil {
+		log.Println(err)
+		return 0, dbError(err)
+	}
+	s.deleteBlobs(ctx, blobs)
+	return revision, nil
+}
+
+// deleteUpload deletes upload with its chunks.
+func (s Storage) deleteUpload(ctx context.Context, id string) error {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		log.Println(err)
+		return dbError(err)
+	}
+	defer tx.Rollback()
+	blobs, err := deletedBlobs(ctx, tx, `DELETE from uploadchunks WHERE upload_id = $1 RETURNING blob_key`, id)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `DELETE from uploads WHERE id = $1`, id)
+	if err != nil {
+		log.Println(err)
+		return dbError(err)
+	}
+	err = tx.Commit()
+	if err != nil {
+		log.Println(err)
+		return dbError(err)
+	}
+	s.deleteBlobs(ctx, blobs)
+	return nil
+}
+
+// PurgeUploads - delete uploads abandoned before given time with their chunks.
+// Returns number of deleted uploads.
+func (s Storage) PurgeUploads(ctx context.Context, before time.Time) (int, error) {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		log.Println(err)
+		return 0, dbError(err)
+	}
+	defer tx.Rollback()
+	blobs, err := deletedBlobs(ctx, tx, `DELETE from uploadchunks
+		WHERE upload_id IN (SELECT id from uploads WHERE updated_at < $1) RETURNING blob_key`, before)
+	if err != nil {
+		return 0, err
+	}
+	res, err := tx.ExecContext(ctx, `DELETE from uploads WHERE updated_at < $1`, before)
+	if err != nil {
+		log.Println(err)
+		return 0, dbError(err)
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		log.Println(err)
+		return 0, dbError(err)
+	}
+	err = tx.Commit()
+	if err != nil {
+		log.Println(err)
+		return 0, dbError(err)
+	}
+	s.deleteBlobs(ctx, blobs)
+	return int(rows), nil
+}
+
+// deletedBlobs runs chunks delete query returning their blob keys.
+func deletedBlobs(ctx context.Context, tx *sql.Tx, query string, args ...interface{}) ([]string, error) {
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		log.Println(err)
+		return nil, dbError(err)
+	}
+	defer rows.Close()
+	var keys []string
+	for rows.Next() {
+		var key sql.NullString
+		err = rows.Scan(&key)
+		if err != nil {
+			log.Println(err)
+			return nil, dbError(err)
+		}
+		if key.Valid {
+			keys = append(keys, key.String)
+		}
+	}
+	return keys, dbError(rows.Err())
+}
+
+// deleteBlobs removes payloads of chunks deleted from DB.
+// Failure leaves only unreferenced blobs, so it is logged and not returned.
+func (s Storage) deleteBlobs(ctx context.Context, keys []string) {
+	if len(keys) == 0 {
+		return
+	}
+	if s.Blobs == nil {
+		log.Printf("%d deleted chunks are kept in blob store, but it is not configured", len(keys))
+		return
+	}
+	err := s.Blobs.Delete(ctx, keys...)
+	if err != nil {
+		log.Println("blobs delete error: ", err)
+	}
+}
+
+// AddSession - insert new login session.
+func (s Storage) AddSession(ctx context.Context, session models.Session) error {
+	var query = `INSERT INTO sessions (id, email, created_at, last_seen, expires_at, revoked, device, build_version, ip)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`
+	_, err := s.DB.ExecContext(ctx, query, session.ID, session.User, session.CreatedAt, session.LastSeen, session.ExpiresAt, session.Revoked,
+		session.Device, session.BuildVersion, session.IP)
+	if err != nil {
+		log.Println(err)
+		return dbError(err)
+	}
+	return nil
+}
+
+// GetSession - select session by given uuid.
+func (s Storage) GetSession(ctx context.Context, id string) (models.Session, error) {
+	var query = `SELECT id, email, created_at, last_seen, expires_at, revoked, device, build_version, ip from sessions where id = $1`
+	session := models.Session{}
+	err := s.DB.QueryRowContext(ctx, query, id).Scan(&session.ID, &session.User, &session.CreatedAt, &session.LastSeen, &session.ExpiresAt, &session.Revoked,
+		&session.Device, &session.BuildVersion, &session.IP)
+	if err != nil {
+		log.Println(err)
+		return models.Session{}, dbError(err)
+	}
+	return session, nil
+}
+
+// ListSessions - select active sessions of user, newest first.
+func (s Storage) ListSessions(ctx context.Context, email string) ([]models.Session, error) {
+	var query = `SELECT id, email, created_at, last_seen, expires_at, revoked, device, build_version, ip from sessions
+		where email = $1 AND revoked = false AND expires_at > CURRENT_TIMESTAMP ORDER BY last_seen DESC`
+	sessions := []models.Session{}
+	rows, err := s.DB.QueryContext(ctx, query, email)
+	if err != nil {
+		log.Println(err)
+		return sessions, dbError(err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		session := models.Session{}
+		err = rows.Scan(&session.ID, &session.User, &session.CreatedAt, &session.LastSeen, &session.ExpiresAt, &session.Revoked,
+			&session.Device, &session.BuildVersion, &session.IP)
+		if err != nil {
+			log.Println(err)
+			return sessions, dbError(err)
+		}
+		sessions = append(sessions, session)
+	}
+	return sessions, dbError(rows.Err())
+}
+
+// TouchSession - update last seen time and ip of session.
+func (s Storage) TouchSession(ctx context.Context, id string, lastSeen time.Time, ip string) error {
+	var query = `UPDATE sessions SET last_seen = $2, ip = $3 WHERE id = $1`
+	_, err := s.DB.ExecContext(ctx, query, id, lastSeen, ip)
+	if err != nil {
+		log.Println(err)
+		return dbError(err)
+	}
+	return nil
+}
+
+// RevokeSession - revoke session, its refresh tokens can't be used anymore.
+func (s Storage) RevokeSession(ctx context.Context, id string) error {
+	var query = `UPDATE sessions SET revoked = true WHERE id = $1`
+	_, err := s.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		log.Println(err)
+		return dbError(err)
+	}
+	return nil
+}
+
+// RevokeUserSessions - revoke all sessions of user except given one.
+func (s Storage) RevokeUserSessions(ctx context.Context, email string, except string) error {
+	var query = `UPDATE sessions SET revoked = true WHERE email = $1 AND CAST(id AS text) <> $2`
+	_, err := s.DB.ExecContext(ctx, query, email, except)
+	if err != nil {
+		log.Println(err)
+		return dbError(err)
+	}
+	return nil
+}
+
+// AddRefreshToken - insert hash of refresh token.
+func (s Storage) AddRefreshToken(ctx context.Context, token models.RefreshToken) error {
+	var query = `INSERT INTO refreshtokens (hash, session_id, used, created_at)
+		VALUES ($1, $2, $3, $4)`
+	_, err := s.DB.ExecContext(ctx, query, token.Hash, token.SessionID, token.Used, token.CreatedAt)
+	if err != nil {
+		log.Println(err)
+		return dbError(err)
+	}
+	return nil
+}
+
+// GetRefreshToken - select refresh token by given hash.
+func (s Storage) GetRefreshToken(ctx context.Context, hash []byte) (models.RefreshToken, error) {
+	var query = `SELECT hash, session_id, used, created_at from refreshtokens where hash = $1`
+	token := models.RefreshToken{}
+	err := s.DB.QueryRowContext(ctx, query, hash).Scan(&token.Hash, &token.SessionID, &token.Used, &token.CreatedAt)
+	if err != nil {
+		log.Println(err)
+		return models.RefreshToken{}, dbError(err)
+	}
+	return token, nil
+}
+
+// UseRefreshToken - mark refresh token as used.
+// Returns false if token was already used before.
+func (s Storage) UseRefreshToken(ctx context.Context, hash []byte) (bool, error) {
+	var query = `UPDATE refreshtokens SET used = true WHERE hash = $1 AND used = false`
+	res, err := s.DB.ExecContext(ctx, query, hash)
+	if err != nil {
+		log.Println(err)
+		return false, dbError(err)
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		log.Println(err)
+		return false, dbError(err)
+	}
+	return rows == 1, nil
+}
+
+// PurgeRefreshTokens - delete refresh tokens of revoked or expired sessions
+// and tokens used before given time. Returns number of deleted tokens.
+func (s Storage) PurgeRefreshTokens(ctx context.Context, now time.Time, usedBefore time.Time) (int, error) {
+	var query = `DELETE from refreshtokens WHERE (used AND created_at < $2)
+		OR session_id NOT IN (SELECT id from sessions WHERE NOT revoked AND expires_at > $1)`
+	res, err := s.DB.ExecContext(ctx, query, now, usedBefore)
+	if err != nil {
+		log.Println(err)
+		return 0, dbError(err)
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		log.Println(err)
+		return 0, dbError(err)
+	}
+	return int(rows), nil
+}
+
+// AddTOTP - insert new unconfirmed TOTP enrollment and its recovery codes.
+// Previous enrollment and recovery codes of user are replaced.
+func (s Storage) AddTOTP(ctx context.Context, totp models.TOTP) error {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		log.Println(err)
+		return dbError(err)
+	}
+	defer tx.Rollback()
+	var query = `INSERT INTO totp (email, secret, confirmed)
+		VALUES ($1, $2, false)
+		ON CONFLICT (email)
+		DO UPDATE SET
+		secret = EXCLUDED.secret,
+		confirmed = false`
+	_, err = tx.ExecContext(ctx, query, totp.User, totp.Secret)
+	if err != nil {
+		log.Println(err)
+		return dbError(err)
+	}
+	_, err = tx.ExecContext(ctx, `DELETE from recoverycodes WHERE email = $1`, totp.User)
+	if err != nil {
+		log.Println(err)
+		return dbError(err)
+	}
+	for _, hash := range totp.RecoveryCodes {
+		_, err = tx.ExecContext(ctx, `INSERT INTO recoverycodes (hash, email) VALUES ($1, $2)`, hash, totp.User)
+		if err != nil {
+			log.Println(err)
+			return dbError(err)
+		}
+	}
+	return dbError(tx.Commit())
+}
+
+// GetTOTP - select TOTP enrollment of user.
+// Returns empty TOTP without error if user is not enrolled.
+func (s Storage) GetTOTP(ctx context.Context, email string) (models.TOTP, error) {
+	var query = `SELECT email, secret, confirmed, last_step from totp where email = $1`
+	totp := models.TOTP{}
+	err := s.DB.QueryRowContext(ctx, query, email).Scan(&totp.User, &totp.Secret, &totp.Confirmed, &totp.LastStep)
+	if errors.Is(err, sql.ErrNoRows) {
+		return models.TOTP{}, nil
+	}
+	if err != nil {
+		log.Println(err)
+		return models.TOTP{}, dbError(err)
+	}
+	return totp, nil
+}
+
+// ConfirmTOTP - mark TOTP enrollment of user as confirmed.
+func (s Storage) ConfirmTOTP(ctx context.Context, email string) error {
+	var query = `UPDATE totp SET confirmed = true WHERE email = $1`
+	_, err := s.DB.ExecContext(ctx, query, email)
+	if err != nil {
+		log.Println(err)
+		return dbError(err)
+	}
+	return nil
+}
+
+// DelTOTP - delete TOTP enrollment and recovery codes of user.
+func (s Storage) DelTOTP(ctx context.Context, email string) error {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		log.Println(err)
+		return dbError(err)
+	}
+	defer tx.Rollback()
+	_, err = tx.ExecContext(ctx, `DELETE from totp WHERE email = $1`, email)
+	if err != nil {
+		log.Println(err)
+		return dbError(err)
+	}
+	_, err = tx.ExecContext(ctx, `DELETE from recoverycodes WHERE email = $1`, email)
+	if err != nil {
+		log.Println(err)
+		return dbError(err)
+	}
+	return dbError(tx.Commit())
+}
+
+// UseRecoveryCode - mark recovery code of user as used.
+// Returns false if there is no such unused code.
+func (s Storage) UseRecoveryCode(ctx context.Context, email string, hash []byte) (bool, error) {
+	var query = `UPDATE recoverycodes SET used = true WHERE email = $1 AND hash = $2 AND used = false`
+	res, err := s.DB.ExecContext(ctx, query, email, hash)
+	if err != nil {
+		log.Println(err)
+		return false, dbError(err)
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		log.Println(err)
+		return false, dbError(err)
+	}
+	return rows == 1, nil
+}
+
+// UseTOTPStep - record time step of accepted authenticator code of user.
+// Returns false if code of the same or later step was accepted before, so code is not used twice.
+func (s Storage) UseTOTPStep(ctx context.Context, email string, step int64) (bool, error) {
+	var query = `UPDATE totp SET last_step = $2 WHERE email = $1 AND last_step < $2`
+	res, err := s.DB.ExecContext(ctx, query, email, step)
+	if err != nil {
+		log.Println(err)
+		return false, dbError(err)
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		log.Println(err)
+		return false, dbError(err)
+	}
+	return rows == 1, nil
+}
+
+// GetLoginAttempt - select failed logins counter by key.
+// Returns LoginAttempt without failures and lockout if key is not known.
+func (s Storage) GetLoginAttempt(ctx context.Context, key string) (models.LoginAttempt, error) {
+	var query = `SELECT key, failures, last_failure, locked_until from loginattempts where key = $1`
+	attempt := models.LoginAttempt{}
+	err := s.DB.QueryRowContext(ctx, query, key).Scan(&attempt.Key, &attempt.Failures, &attempt.LastFailure, &attempt.LockedUntil)
+	if errors.Is(err, sql.ErrNoRows) {
+		return models.LoginAttempt{Key: key}, nil
+	}
+	if err != nil {
+		log.Println(err)
+		return models.LoginAttempt{}, dbError(err)
+	}
+	return attempt, nil
+}
+
+// AddLoginFailure - increase failed logins counter and return its new value.
+// Counter starts from one if last failure was before resetBefore.
+func (s Storage) AddLoginFailure(ctx context.Context, key string, at time.Time, resetBefore time.Time) (int, error) {
+	var query = `INSERT INTO loginattempts (key, failures, last_failure, locked_until)
+		VALUES ($1, 1, $2, $2)
+		ON CONFLICT (key)
+		DO UPDATE SET
+		failures = CASE WHEN loginattempts.last_failure < $3 THEN 1 ELSE loginattempts.failures + 1 END,
+		last_failure = EXCLUDED.last_failure
+		RETURNING failures`
+	var failures int
+	err := s.DB.QueryRowContext(ctx, query, key, at, resetBefore).Scan(&failures)
+	if err != nil {
+		log.Println(err)
+		return 0, dbError(err)
+	}
+	return failures, nil
+}
+
+// LockLogin - reject logins by key till given time.
+func (s Storage) LockLogin(ctx context.Context, key string, until time.Time) error {
+	var query = `UPDATE loginattempts SET locked_until = $2 WHERE key = $1`
+	_, err := s.DB.ExecContext(ctx, query, key, until)
+	if err != nil {
+		log.Println(err)
+		return dbError(err)
+	}
+	return nil
+}
+
+// ClearLoginAttempts - reset failed logins counter and lockout of key.
+func (s Storage) ClearLoginAttempts(ctx context.Context, key string) error {
+	var query = `DELETE from loginattempts WHERE key = $1`
+	_, err := s.DB.ExecContext(ctx, query, key)
+	if err != nil {
+		log.Println(err)
+		return dbError(err)
+	}
+	return nil
+}
+
+// ListLockouts - select keys which are locked at given time.
+func (s Storage) ListLockouts(ctx context.Context, now time.Time) ([]models.LoginAttempt, error) {
+	var query = `SELECT key, failures, last_failure, locked_until from loginattempts
+		where locked_until > $1 ORDER BY locked_until DESC`
+	attempts := []models.LoginAttempt{}
+	rows, err := s.DB.QueryContext(ctx, query, now)
+	if err != nil {
+		log.Println(err)
+		return attempts, dbError(err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		attempt := models.LoginAttempt{}
+		err = rows.Scan(&attempt.Key, &attempt.Failures, &attempt.LastFailure, &attempt.LockedUntil)
+		if err != nil {
+			log.Println(err)
+			return attempts, dbError(err)
+		}
+		attempts = append(attempts, attempt)
+	}
+	return attempts, dbError(rows.Err())
+}
+
 /*
 func (s Storage) CreateDBIfNotExist() error {
 	var query = `SELECT 'CREATE DATABASE gophkeeper'
@@ -1,8 +1,11 @@
 package storage
 
 import (
+	"context"
+	"database/sql"
 	"errors"
 	"testing"
+	"time"
 
 	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/MaximkaSha/gophkeeper/internal/models"
@@ -26,7 +29,8 @@ func TestStorage_AddUser(t *testing.T) {
 				user: models.User{
 					Email:    "test@test.com",
 					Password: "pass",
-					Secret:   []byte(""),
+					Secret:   []byte("wrapped"),
+					Salt:     []byte("salt"),
 				},
 			},
 		},
@@ -39,47 +43,107 @@ func TestStorage_AddUser(t *testing.T) {
 			}
 			defer db.Close()
 			tt.s.DB = db
-			mock.ExpectExec("INSERT INTO").WithArgs(tt.args.user.Email, tt.args.user.Password, sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(1, 1))
-			err = tt.s.AddUser(tt.args.user)
+			mock.ExpectExec("INSERT INTO").WithArgs(tt.args.user.Email, tt.args.user.Password, tt.args.user.Secret, tt.args.user.Salt).WillReturnResult(sqlmock.NewResult(1, 1))
+			err = tt.s.AddUser(context.Background(), tt.args.user)
 			require.NoError(t, err)
 			if err := mock.ExpectationsWereMet(); err != nil {
 				t.Errorf("there were unfulfilled expectations: %s", err)
 			}
-			mock.ExpectExec("INSERT INTO").WithArgs(tt.args.user.Email, tt.args.user.Password, sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 0)).WillReturnError(errors.New("no"))
-			err = tt.s.AddUser(tt.args.user)
+			mock.ExpectExec("INSERT INTO").WithArgs(tt.args.user.Email, tt.args.user.Password, tt.args.user.Secret, tt.args.user.Salt).WillReturnResult(sqlmock.NewResult(0, 0)).WillReturnError(errors.New("no"))
+			err = tt.s.AddUser(context.Background(), tt.args.user)
 			require.Error(t, err)
 		})
 	}
 }
 
-func TestStorage_GenSecretKey(t *testing.T) {
-	tests := []struct {
-		name    string
-		s       Storage
-		wantErr bool
-	}{
-		{
-			name:    "pos 1",
-			s:       Storage{},
-			wantErr: false,
-		},
+func TestStorage_UpdateUser(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
 	}
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			got, err := tt.s.GenSecretKey()
-			if (err != nil) != tt.wantErr {
-				t.Errorf("Storage.GenSecretKey() error = %v, wantErr %v", err, tt.wantErr)
-				return
-			}
-			got2, err := tt.s.GenSecretKey()
-			if (err != nil) != tt.wantErr {
-				t.Errorf("Storage.GenSecretKey() error = %v, wantErr %v", err, tt.wantErr)
-				return
-			}
-			if got == got2 {
-				t.Errorf("Storage.GenSecretKey() equal keys")
-			}
-		})
+	defer db.Close()
+	s := Storage{DB: db}
+	user := models.User{
+		Email:    "test@test.com",
+		Password: "newpass",
+		Secret:   []byte("rewrapped"),
+		Salt:     []byte("newsalt"),
+	}
+	mock.ExpectExec("UPDATE users SET password").WithArgs(user.Email, user.Password, user.Secret, user.Salt).WillReturnResult(sqlmock.NewResult(0, 1))
+	require.NoError(t, s.UpdateUser(context.Background(), user))
+	mock.ExpectExec("UPDATE users SET password").WithArgs(user.Email, user.Password, user.Secret, user.Salt).WillReturnError(errors.New("no"))
+	require.Error(t, s.UpdateUser(context.Background(), user))
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestStorage_UserDeletion(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	s := Storage{DB: db}
+	email := "test@test.com"
+	now := time.Now()
+
+	mock.ExpectExec("UPDATE users SET delete_after").WithArgs(email, now).WillReturnResult(sqlmock.NewResult(0, 1))
+	require.NoError(t, s.ScheduleUserDeletion(context.Background(), email, now))
+	mock.ExpectExec("UPDATE users SET delete_after = NULL").WithArgs(email).WillReturnResult(sqlmock.NewResult(0, 1))
+	ok, err := s.CancelUserDeletion(context.Background(), email)
+	require.NoError(t, err)
+	require.True(t, ok)
+	mock.ExpectExec("UPDATE users SET delete_after = NULL").WithArgs(email).WillReturnResult(sqlmock.NewResult(0, 0))
+	ok, err = s.CancelUserDeletion(context.Background(), email)
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	mock.ExpectQuery("SELECT email from users where delete_after").WithArgs(now).WillReturnRows(
+		sqlmock.NewRows([]string{"email"}).AddRow(email))
+	emails, err := s.ListDueDeletions(context.Background(), now)
+	require.NoError(t, err)
+	require.Equal(t, []string{email}, emails)
+
+	// user and all its data are deleted in one transaction
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT id from users (.+) FOR UPDATE").WithArgs(email, now).WillReturnRows(
+		sqlmock.NewRows([]string{"id"}).AddRow(7))
+	mock.ExpectQuery("DELETE from uploadchunks (.+) RETURNING blob_key").WithArgs(7).WillReturnRows(sqlmock.NewRows([]string{"blob_key"}))
+	mock.ExpectQuery("DELETE from filechunks (.+) RETURNING blob_key").WithArgs(7).WillReturnRows(sqlmock.NewRows([]string{"blob_key"}).AddRow(nil))
+	mock.ExpectExec("DELETE from uploads").WithArgs(7).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("DELETE from ciphereddata").WithArgs(7).WillReturnResult(sqlmock.NewResult(0, 3))
+	mock.ExpectExec("DELETE from refreshtokens").WithArgs(email).WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec("DELETE from sessions").WithArgs(email).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("DELETE from recoverycodes").WithArgs(email).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("DELETE from totp").WithArgs(email).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("DELETE from users").WithArgs(7).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	ok, err = s.PurgeUser(context.Background(), email, now)
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	// deletion canceled meanwhile
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT id from users (.+) FOR UPDATE").WithArgs(email, now).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	mock.ExpectRollback()
+	ok, err = s.PurgeUser(context.Background(), email, now)
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	// nothing is deleted on error
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT id from users (.+) FOR UPDATE").WithArgs(email, now).WillReturnRows(
+		sqlmock.NewRows([]string{"id"}).AddRow(7))
+	mock.ExpectQuery("DELETE from uploadchunks (.+) RETURNING blob_key").WithArgs(7).WillReturnRows(sqlmock.NewRows([]string{"blob_key"}))
+	mock.ExpectQuery("DELETE from filechunks (.+) RETURNING blob_key").WithArgs(7).WillReturnRows(sqlmock.NewRows([]string{"blob_key"}))
+	mock.ExpectExec("DELETE from uploads").WithArgs(7).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("DELETE from ciphereddata").WithArgs(7).WillReturnError(errors.New("no"))
+	mock.ExpectRollback()
+	_, err = s.PurgeUser(context.Background(), email, now)
+	require.Error(t, err)
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
 	}
 }
 
@@ -112,18 +176,27 @@ func TestStorage_GetUser(t *testing.T) {
 			}
 			defer db.Close()
 			tt.s.DB = db
-			mockUserRows := sqlmock.NewRows([]string{"email", "password", "secret"}).AddRow(
-				"test@test.com", "passNew", "newSecret",
+			mockUserRows := sqlmock.NewRows([]string{"email", "password", "secret", "salt", "delete_after"}).AddRow(
+				"test@test.com", "passNew", "newSecret", "salt", nil,
 			)
-			mock.ExpectQuery("SELECT email,password,secret from users where email = ?").WithArgs(tt.args.user.Email).WillReturnRows(mockUserRows)
-			_, err = tt.s.GetUser(tt.args.user)
+			mock.ExpectQuery("SELECT email,password,secret,salt,delete_after from users where email = ?").WithArgs(tt.args.user.Email).WillReturnRows(mockUserRows)
+			got, err := tt.s.GetUser(context.Background(), tt.args.user)
 			require.NoError(t, err)
+			require.True(t, got.DeleteAfter.IsZero())
+			deleteAfter := time.Now().Add(time.Hour)
+			mockUserRows = sqlmock.NewRows([]string{"email", "password", "secret", "salt", "delete_after"}).AddRow(
+				"test@test.com", "passNew", "newSecret", "salt", deleteAfter,
+			)
+			mock.ExpectQuery("SELECT email,password,secret,salt,delete_after from users where email = ?").WithArgs(tt.args.user.Email).WillReturnRows(mockUserRows)
+			got, err = tt.s.GetUser(context.Background(), tt.args.user)
+			require.NoError(t, err)
+			require.Equal(t, deleteAfter, got.DeleteAfter)
 			if err := mock.ExpectationsWereMet(); err != nil {
 				t.Errorf("there were unfulfilled expectations: %s", err)
 			}
-			mock.ExpectQuery("SELECT email,password,secret from users where email = ?").WithArgs("no data").WillReturnError(errors.New("no data"))
+			mock.ExpectQuery("SELECT email,password,secret,salt,delete_after from users where email = ?").WithArgs("no data").WillReturnError(errors.New("no data"))
 			tt.args.user.Email = "no data"
-			_, err = tt.s.GetUser(tt.args.user)
+			_, err = tt.s.GetUser(context.Background(), tt.args.user)
 			require.Error(t, err)
 		})
 	}
@@ -161,19 +234,65 @@ func TestStorage_AddCipheredData(t *testing.T) {
 			defer db.Close()
 			tt.s.DB = db
 
-			mock.ExpectExec("INSERT INTO").WithArgs(tt.args.data.Data, tt.args.data.Type, tt.args.data.User, tt.args.data.ID).WillReturnResult(sqlmock.NewResult(1, 1))
-			err = tt.s.AddCipheredData(tt.args.data)
+			// current record is read after revision of vault is taken
+			current := func(rows *sqlmock.Rows) {
+				mock.ExpectBegin()
+				mock.ExpectQuery("UPDATE users SET revision = revision \\+ 1").WithArgs(tt.args.data.User).
+					WillReturnRows(sqlmock.NewRows([]string{"revision"}).AddRow(2))
+				mock.ExpectQuery("SELECT data, type, revision, deleted").WithArgs(tt.args.data.ID, tt.args.data.User).
+					WillReturnRows(rows)
+			}
+			record := func() *sqlmock.Rows {
+				return sqlmock.NewRows([]string{"data", "type", "revision", "deleted", "owned"})
+			}
+			current(record())
+			mock.ExpectExec("INSERT INTO").WithArgs(tt.args.data.Data, tt.args.data.Type, tt.args.data.User, tt.args.data.ID, int64(2)).WillReturnResult(sqlmock.NewResult(1, 1))
+			mock.ExpectCommit()
+			revision, err := tt.s.AddCipheredData(context.Background(), tt.args.data)
 			require.NoError(t, err)
+			require.Equal(t, int64(2), revision)
 			if err := mock.ExpectationsWereMet(); err != nil {
 				t.Errorf("there were unfulfilled expectations: %s", err)
 			}
-			mock.ExpectExec("INSERT INTO").WithArgs(tt.args.data.Data, "no data", tt.args.data.User, tt.args.data.ID).WillReturnResult(sqlmock.NewResult(1, 1)).WillReturnError(errors.New("no data"))
+			// record changed after expected revision is not updated
+			current(record().AddRow([]byte("current"), "CC", 1, false, true))
+			mock.ExpectRollback()
+			_, err = tt.s.AddCipheredData(context.Background(), tt.args.data)
+			var conflict *models.ConflictError
+			require.ErrorAs(t, err, &conflict)
+			want := tt.args.data
+			want.Data, want.Revision = []byte("current"), 1
+			require.Equal(t, want, conflict.Current)
+			require.NoError(t, mock.ExpectationsWereMet())
+			tt.args.data.Revision = 1
+			current(record().AddRow([]byte("current"), "CC", 1, false, true))
+			// replaced version is kept in history
+			mock.ExpectExec("INSERT INTO cipheredhistory").WithArgs(tt.args.data.ID, int64(1), []byte("current"), "CC", sqlmock.AnyArg()).
+				WillReturnResult(sqlmock.NewResult(1, 1))
+			mock.ExpectExec("INSERT INTO ciphereddata").WithArgs(tt.args.data.Data, "no data", tt.args.data.User, tt.args.data.ID, int64(2)).WillReturnResult(sqlmock.NewResult(1, 1)).WillReturnError(errors.New("no data"))
+			mock.ExpectRollback()
 			tt.args.data.Type = "no data"
-			err = tt.s.AddCipheredData(tt.args.data)
+			_, err = tt.s.AddCipheredData(context.Background(), tt.args.data)
 			if err := mock.ExpectationsWereMet(); err != nil {
 				t.Errorf("there were unfulfilled expectations: %s", err)
 			}
 			require.Error(t, err)
+			// record of another user is not updated
+			current(record().AddRow([]byte("current"), "CC", 1, false, false))
+			mock.ExpectRollback()
+			_, err = tt.s.AddCipheredData(context.Background(), tt.args.data)
+			require.ErrorIs(t, err, models.ErrPermissionDenied)
+			require.NoError(t, mock.ExpectationsWereMet())
+			current(record())
+			mock.ExpectRollback()
+			_, err = tt.s.AddCipheredData(context.Background(), tt.args.data)
+			require.ErrorIs(t, err, models.ErrConflict)
+			current(record().AddRow(nil, "CC", 1, true, true))
+			mock.ExpectExec("INSERT INTO").WithArgs(tt.args.data.Data, tt.args.data.Type, tt.args.data.User, tt.args.data.ID, int64(2)).WillReturnResult(sqlmock.NewResult(0, 0))
+			mock.ExpectRollback()
+			_, err = tt.s.AddCipheredData(context.Background(), tt.args.data)
+			require.ErrorIs(t, err, models.ErrPermissionDenied)
+			require.NoError(t, mock.ExpectationsWereMet())
 
 		})
 	}
@@ -206,26 +325,36 @@ func TestStorage_GetCipheredData(t *testing.T) {
 			}
 			defer db.Close()
 			tt.s.DB = db
-			mockDataRows := sqlmock.NewRows([]string{"email", "password", "secret", "data"}).AddRow(
-				"test@test.com", "passNew", "newSecret", "data",
+			mockDataRows := sqlmock.NewRows([]string{"data", "type", "uuid", "revision"}).AddRow(
+				"data", "CC", "111-11-11-111", 1,
 			)
 			mock.ExpectQuery("SELECT (.+) from ciphereddata where user_id").WithArgs(tt.args.email).WillReturnRows(mockDataRows)
-			_, err = tt.s.GetCipheredData(tt.args.email)
+			data, err := tt.s.GetCipheredData(context.Background(), tt.args.email, models.DataPage{})
 			require.NoError(t, err)
+			require.Equal(t, tt.args.email, data[0].User)
 			if err := mock.ExpectationsWereMet(); err != nil {
 				t.Errorf("there were unfulfilled expectations: %s", err)
 			}
 			mock.ExpectQuery("SELECT (.+) from ciphereddata where user_id").WithArgs(tt.args.email).WillReturnRows(mockDataRows).WillReturnError(errors.New("no data"))
-			_, err = tt.s.GetCipheredData(tt.args.email)
+			_, err = tt.s.GetCipheredData(context.Background(), tt.args.email, models.DataPage{})
 			require.Error(t, err)
-			mock.ExpectQuery("SELECT (.+) from ciphereddata where user_id").WithArgs(tt.args.email).WillReturnRows()
+			// next page of given types
+			after := "5a1b4b35-e5a8-4bbc-9d4c-c0c2b5d2c8a7"
+			mock.ExpectQuery(`AND uuid > \$2 AND type IN \(\$3, \$4\) ORDER BY uuid LIMIT \$5`).
+				WithArgs(tt.args.email, after, "CC", "TEXT", 10).
+				WillReturnRows(sqlmock.NewRows([]string{"data", "type", "uuid", "revision"}))
+			data, err = tt.s.GetCipheredData(context.Background(), tt.args.email, models.DataPage{After: after, Limit: 10, Types: []string{"CC", "TEXT"}})
+			require.NoError(t, err)
+			require.Empty(t, data)
+			require.NoError(t, mock.ExpectationsWereMet())
 		})
 	}
 }
 
 func TestStorage_DelCiphereData(t *testing.T) {
 	type args struct {
-		uuid string
+		email string
+		uuid  string
 	}
 	tests := []struct {
 		name    string
@@ -237,7 +366,8 @@ func TestStorage_DelCiphereData(t *testing.T) {
 			name: "test 1",
 			s:    Storage{},
 			args: args{
-				uuid: "111-11-11-111",
+				email: "test@test.com",
+				uuid:  "111-11-11-111",
 			},
 		},
 	}
@@ -250,19 +380,325 @@ func TestStorage_DelCiphereData(t *testing.T) {
 			defer db.Close()
 			tt.s.DB = db
 
-			mock.ExpectExec("DELETE from ciphereddata WHERE").WithArgs(tt.args.uuid).WillReturnResult(sqlmock.NewResult(1, 1))
-			err = tt.s.DelCiphereData(tt.args.uuid)
+			current := func(rows *sqlmock.Rows) {
+				mock.ExpectBegin()
+				mock.ExpectQuery("UPDATE users SET revision = revision \\+ 1").WithArgs(tt.args.email).
+					WillReturnRows(sqlmock.NewRows([]string{"revision"}).AddRow(2))
+				mock.ExpectQuery("SELECT data, type, revision, deleted").WithArgs(tt.args.uuid, tt.args.email).
+					WillReturnRows(rows)
+			}
+			record := func() *sqlmock.Rows {
+				return sqlmock.NewRows([]string{"data", "type", "revision", "deleted", "owned"})
+			}
+			chunks := func() {
+				current(record().AddRow([]byte("data"), "CC", 1, false, true))
+				// deleted version is kept in history
+				mock.ExpectExec("INSERT INTO cipheredhistory").WithArgs(tt.args.uuid, int64(1), []byte("data"), "CC", sqlmock.AnyArg()).
+					WillReturnResult(sqlmock.NewResult(1, 1))
+				mock.ExpectQuery("DELETE from filechunks (.+) RETURNING blob_key").WithArgs(tt.args.uuid).
+					WillReturnRows(sqlmock.NewRows([]string{"blob_key"}))
+			}
+			chunks()
+			mock.ExpectExec("UPDATE ciphereddata SET data = NULL, deleted = true").WithArgs(tt.args.uuid, int64(2)).WillReturnResult(sqlmock.NewResult(1, 1))
+			mock.ExpectCommit()
+			err = tt.s.DelCiphereData(context.Background(), tt.args.email, tt.args.uuid, 1)
 			require.NoError(t, err)
 			if err := mock.ExpectationsWereMet(); err != nil {
 				t.Errorf("there were unfulfilled expectations: %s", err)
 			}
-			mock.ExpectExec("DELETE from ciphereddata WHERE").WithArgs(tt.args.uuid).WillReturnResult(sqlmock.NewResult(1, 1)).WillReturnError(errors.New("no data"))
-			err = tt.s.DelCiphereData(tt.args.uuid)
+			chunks()
+			mock.ExpectExec("UPDATE ciphereddata SET data = NULL, deleted = true").WithArgs(tt.args.uuid, int64(2)).WillReturnError(errors.New("no data"))
+			mock.ExpectRollback()
+			err = tt.s.DelCiphereData(context.Background(), tt.args.email, tt.args.uuid, 1)
 			require.Error(t, err)
+			// record of another user
+			current(record().AddRow([]byte("data"), "CC", 1, false, false))
+			mock.ExpectRollback()
+			err = tt.s.DelCiphereData(context.Background(), tt.args.email, tt.args.uuid, 1)
+			require.ErrorIs(t, err, models.ErrPermissionDenied)
+			// record changed after expected revision is not deleted
+			current(record().AddRow([]byte("current"), "CC", 3, false, true))
+			mock.ExpectRollback()
+			err = tt.s.DelCiphereData(context.Background(), tt.args.email, tt.args.uuid, 1)
+			var conflict *models.ConflictError
+			require.ErrorAs(t, err, &conflict)
+			require.Equal(t, []byte("current"), conflict.Current.Data)
+			// no record at all
+			current(record())
+			mock.ExpectRollback()
+			err = tt.s.DelCiphereData(context.Background(), tt.args.email, tt.args.uuid, 1)
+			require.NoError(t, err)
+			require.NoError(t, mock.ExpectationsWereMet())
 		})
 	}
 }
 
+func TestStorage_Revisions(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+	s := Storage{DB: db}
+	ctx := context.Background()
+	id := "1a1b4b35-e5a8-4bbc-9d4c-c0c2b5d2c8a7"
+	replaced := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	mock.ExpectQuery("SELECT type from ciphereddata").WithArgs(id, "test@test.com").
+		WillReturnRows(sqlmock.NewRows([]string{"type"}).AddRow("TEXT"))
+	mock.ExpectQuery("SELECT data, type, revision, replaced_at from cipheredhistory (.+) ORDER BY revision DESC").WithArgs(id, "test@test.com").
+		WillReturnRows(sqlmock.NewRows([]string{"data", "type", "revision", "replaced_at"}).
+			AddRow([]byte("second"), "TEXT", 2, replaced).
+			AddRow([]byte("first"), "TEXT", 1, replaced))
+	revisions, err := s.ListRevisions(ctx, "test@test.com", id)
+	require.NoError(t, err)
+	require.Equal(t, []models.DataRevision{
+		{Data: models.CipheredData{Data: []byte("second"), Type: "TEXT", User: "test@test.com", ID: id, Revision: 2}, ReplacedAt: replaced},
+		{Data: models.CipheredData{Data: []byte("first"), Type: "TEXT", User: "test@test.com", ID: id, Revision: 1}, ReplacedAt: replaced},
+	}, revisions)
+
+	mock.ExpectQuery("SELECT type from ciphereddata").WithArgs(id, "test@test.com").
+		WillReturnRows(sqlmock.NewRows([]string{"type"}).AddRow("TEXT"))
+	mock.ExpectQuery("SELECT data, type, replaced_at from cipheredhistory").WithArgs(id, int64(1), "test@test.com").
+		WillReturnRows(sqlmock.NewRows([]string{"data", "type", "replaced_at"}).AddRow([]byte("first"), "TEXT", replaced))
+	revision, err := s.GetRevision(ctx, "test@test.com", id, 1)
+	require.NoError(t, err)
+	require.Equal(t, revisions[1], revision)
+	mock.ExpectQuery("SELECT type from ciphereddata").WithArgs(id, "test@test.com").
+		WillReturnRows(sqlmock.NewRows([]string{"type"}))
+	mock.ExpectQuery("SELECT data, type, replaced_at from cipheredhistory").WithArgs(id, int64(3), "test@test.com").
+		WillReturnRows(sqlmock.NewRows([]string{"data", "type", "replaced_at"}))
+	_, err = s.GetRevision(ctx, "test@test.com", id, 3)
+	require.ErrorIs(t, err, models.ErrNotFound)
+
+	// replaced content of file is not kept
+	mock.ExpectQuery("SELECT type from ciphereddata").WithArgs(id, "test@test.com").
+		WillReturnRows(sqlmock.NewRows([]string{"type"}).AddRow("DATA"))
+	_, err = s.ListRevisions(ctx, "test@test.com", id)
+	require.ErrorIs(t, err, models.ErrNoHistory)
+
+	mock.ExpectExec("DELETE from cipheredhistory WHERE replaced_at < \\$1").WithArgs(replaced, 10).WillReturnResult(sqlmock.NewResult(0, 4))
+	purged, err := s.PurgeRevisions(ctx, 10, replaced)
+	require.NoError(t, err)
+	require.Equal(t, 4, purged)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestStorage_Session(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	s := Storage{DB: db}
+	now := time.Now()
+	session := models.Session{
+		ID:        "7a2d3c9e-4a51-4a0b-9a7f-1f8d6f0b7c11",
+		User:      "test@test.com",
+		CreatedAt: now,
+		LastSeen:  now,
+		ExpiresAt: now.Add(time.Hour),
+		Device:    "laptop",
+		IP:        "127.0.0.1",
+	}
+	columns := []string{"id", "email", "created_at", "last_seen", "expires_at", "revoked", "device", "build_version", "ip"}
+	mock.ExpectExec("INSERT INTO sessions").WithArgs(session.ID, session.User, session.CreatedAt, session.LastSeen, session.ExpiresAt, session.Revoked,
+		session.Device, session.BuildVersion, session.IP).WillReturnResult(sqlmock.NewResult(1, 1))
+	require.NoError(t, s.AddSession(context.Background(), session))
+	mockRows := sqlmock.NewRows(columns).AddRow(
+		session.ID, session.User, session.CreatedAt, session.LastSeen, session.ExpiresAt, session.Revoked, session.Device, session.BuildVersion, session.IP)
+	mock.ExpectQuery("SELECT (.+) from sessions where id").WithArgs(session.ID).WillReturnRows(mockRows)
+	got, err := s.GetSession(context.Background(), session.ID)
+	require.NoError(t, err)
+	require.Equal(t, session, got)
+	mock.ExpectQuery("SELECT (.+) from sessions where id").WithArgs("no data").WillReturnError(errors.New("no data"))
+	_, err = s.GetSession(context.Background(), "no data")
+	require.Error(t, err)
+	mock.ExpectExec("UPDATE sessions SET last_seen").WithArgs(session.ID, now, "10.0.0.1").WillReturnResult(sqlmock.NewResult(0, 1))
+	require.NoError(t, s.TouchSession(context.Background(), session.ID, now, "10.0.0.1"))
+	mockRows = sqlmock.NewRows(columns).AddRow(
+		session.ID, session.User, session.CreatedAt, session.LastSeen, session.ExpiresAt, session.Revoked, session.Device, session.BuildVersion, session.IP)
+	mock.ExpectQuery("SELECT (.+) from sessions").WithArgs(session.User).WillReturnRows(mockRows)
+	sessions, err := s.ListSessions(context.Background(), session.User)
+	require.NoError(t, err)
+	require.Equal(t, []models.Session{session}, sessions)
+	mock.ExpectQuery("SELECT (.+) from sessions").WithArgs("nobody").WillReturnRows(sqlmock.NewRows(columns))
+	sessions, err = s.ListSessions(context.Background(), "nobody")
+	require.NoError(t, err)
+	require.Empty(t, sessions)
+	mock.ExpectExec("UPDATE sessions SET revoked").WithArgs(session.ID).WillReturnResult(sqlmock.NewResult(0, 1))
+	require.NoError(t, s.RevokeSession(context.Background(), session.ID))
+	mock.ExpectExec("UPDATE sessions SET revoked").WithArgs(session.ID).WillReturnError(errors.New("no"))
+	require.Error(t, s.RevokeSession(context.Background(), session.ID))
+	mock.ExpectExec("UPDATE sessions SET revoked (.+) AND CAST\\(id AS text\\)").WithArgs(session.User, session.ID).WillReturnResult(sqlmock.NewResult(0, 2))
+	require.NoError(t, s.RevokeUserSessions(context.Background(), session.User, session.ID))
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestStorage_RefreshToken(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	s := Storage{DB: db}
+	token := models.RefreshToken{
+		Hash:      []byte("hash"),
+		SessionID: "7a2d3c9e-4a51-4a0b-9a7f-1f8d6f0b7c11",
+		CreatedAt: time.Now(),
+	}
+	mock.ExpectExec("INSERT INTO refreshtokens").WithArgs(token.Hash, token.SessionID, token.Used, token.CreatedAt).WillReturnResult(sqlmock.NewResult(1, 1))
+	require.NoError(t, s.AddRefreshToken(context.Background(), token))
+	mockRows := sqlmock.NewRows([]string{"hash", "session_id", "used", "created_at"}).AddRow(
+		token.Hash, token.SessionID, token.Used, token.CreatedAt)
+	mock.ExpectQuery("SELECT (.+) from refreshtokens where hash").WithArgs(token.Hash).WillReturnRows(mockRows)
+	got, err := s.GetRefreshToken(context.Background(), token.Hash)
+	require.NoError(t, err)
+	require.Equal(t, token, got)
+	mock.ExpectExec("UPDATE refreshtokens SET used").WithArgs(token.Hash).WillReturnResult(sqlmock.NewResult(0, 1))
+	ok, err := s.UseRefreshToken(context.Background(), token.Hash)
+	require.NoError(t, err)
+	require.True(t, ok)
+	mock.ExpectExec("UPDATE refreshtokens SET used").WithArgs(token.Hash).WillReturnResult(sqlmock.NewResult(0, 0))
+	ok, err = s.UseRefreshToken(context.Background(), token.Hash)
+	require.NoError(t, err)
+	require.False(t, ok)
+	now := time.Now()
+	mock.ExpectExec("DELETE from refreshtokens").WithArgs(now, now.Add(-time.Hour)).WillReturnResult(sqlmock.NewResult(0, 3))
+	purged, err := s.PurgeRefreshTokens(context.Background(), now, now.Add(-time.Hour))
+	require.NoError(t, err)
+	require.Equal(t, 3, purged)
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestStorage_TOTP(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	s := Storage{DB: db}
+	totp := models.TOTP{
+		User:          "test@test.com",
+		Secret:        "JBSWY3DPEHPK3PXP",
+		RecoveryCodes: [][]byte{[]byte("hash1"), []byte("hash2")},
+	}
+	mock.ExpectBegin()
+	mock.ExpectExec("INSERT INTO totp").WithArgs(totp.User, totp.Secret).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("DELETE from recoverycodes").WithArgs(totp.User).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("INSERT INTO recoverycodes").WithArgs(totp.RecoveryCodes[0], totp.User).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("INSERT INTO recoverycodes").WithArgs(totp.RecoveryCodes[1], totp.User).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+	require.NoError(t, s.AddTOTP(context.Background(), totp))
+	mock.ExpectBegin()
+	mock.ExpectExec("INSERT INTO totp").WithArgs(totp.User, totp.Secret).WillReturnError(errors.New("no"))
+	mock.ExpectRollback()
+	require.Error(t, s.AddTOTP(context.Background(), totp))
+
+	mock.ExpectQuery("SELECT (.+) from totp where email").WithArgs(totp.User).WillReturnRows(
+		sqlmock.NewRows([]string{"email", "secret", "confirmed", "last_step"}).AddRow(totp.User, totp.Secret, true, 7))
+	got, err := s.GetTOTP(context.Background(), totp.User)
+	require.NoError(t, err)
+	require.Equal(t, models.TOTP{User: totp.User, Secret: totp.Secret, Confirmed: true, LastStep: 7}, got)
+	mock.ExpectQuery("SELECT (.+) from totp where email").WithArgs("nobody").WillReturnRows(
+		sqlmock.NewRows([]string{"email", "secret", "confirmed", "last_step"}))
+	got, err = s.GetTOTP(context.Background(), "nobody")
+	require.NoError(t, err)
+	require.Equal(t, models.TOTP{}, got)
+	mock.ExpectQuery("SELECT (.+) from totp where email").WithArgs(totp.User).WillReturnError(errors.New("no"))
+	_, err = s.GetTOTP(context.Background(), totp.User)
+	require.Error(t, err)
+
+	mock.ExpectExec("UPDATE totp SET confirmed").WithArgs(totp.User).WillReturnResult(sqlmock.NewResult(0, 1))
+	require.NoError(t, s.ConfirmTOTP(context.Background(), totp.User))
+
+	mock.ExpectExec("UPDATE totp SET last_step").WithArgs(totp.User, int64(8)).WillReturnResult(sqlmock.NewResult(0, 1))
+	ok, err := s.UseTOTPStep(context.Background(), totp.User, 8)
+	require.NoError(t, err)
+	require.True(t, ok)
+	mock.ExpectExec("UPDATE totp SET last_step").WithArgs(totp.User, int64(8)).WillReturnResult(sqlmock.NewResult(0, 0))
+	ok, err = s.UseTOTPStep(context.Background(), totp.User, 8)
+	require.NoError(t, err)
+	require.False(t, ok)
+	mock.ExpectExec("UPDATE totp SET last_step").WithArgs(totp.User, int64(9)).WillReturnError(errors.New("no"))
+	_, err = s.UseTOTPStep(context.Background(), totp.User, 9)
+	require.Error(t, err)
+
+	mock.ExpectExec("UPDATE recoverycodes SET used").WithArgs(totp.User, totp.RecoveryCodes[0]).WillReturnResult(sqlmock.NewResult(0, 1))
+	ok, err = s.UseRecoveryCode(context.Background(), totp.User, totp.RecoveryCodes[0])
+	require.NoError(t, err)
+	require.True(t, ok)
+	mock.ExpectExec("UPDATE recoverycodes SET used").WithArgs(totp.User, totp.RecoveryCodes[0]).WillReturnResult(sqlmock.NewResult(0, 0))
+	ok, err = s.UseRecoveryCode(context.Background(), totp.User, totp.RecoveryCodes[0])
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	mock.ExpectBegin()
+	mock.ExpectExec("DELETE from totp").WithArgs(totp.User).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("DELETE from recoverycodes").WithArgs(totp.User).WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectCommit()
+	require.NoError(t, s.DelTOTP(context.Background(), totp.User))
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestStorage_LoginAttempt(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	s := Storage{DB: db}
+	now := time.Now()
+	attempt := models.LoginAttempt{
+		Key:         "account:test@test.com",
+		Failures:    5,
+		LastFailure: now,
+		LockedUntil: now.Add(time.Minute),
+	}
+	columns := []string{"key", "failures", "last_failure", "locked_until"}
+
+	mock.ExpectQuery("INSERT INTO loginattempts").WithArgs(attempt.Key, now, now.Add(-time.Hour)).WillReturnRows(
+		sqlmock.NewRows([]string{"failures"}).AddRow(5))
+	failures, err := s.AddLoginFailure(context.Background(), attempt.Key, now, now.Add(-time.Hour))
+	require.NoError(t, err)
+	require.Equal(t, 5, failures)
+	mock.ExpectQuery("INSERT INTO loginattempts").WillReturnError(errors.New("no"))
+	_, err = s.AddLoginFailure(context.Background(), attempt.Key, now, now.Add(-time.Hour))
+	require.Error(t, err)
+
+	mock.ExpectExec("UPDATE loginattempts SET locked_until").WithArgs(attempt.Key, attempt.LockedUntil).WillReturnResult(sqlmock.NewResult(0, 1))
+	require.NoError(t, s.LockLogin(context.Background(), attempt.Key, attempt.LockedUntil))
+
+	mock.ExpectQuery("SELECT (.+) from loginattempts where key").WithArgs(attempt.Key).WillReturnRows(
+		sqlmock.NewRows(columns).AddRow(attempt.Key, attempt.Failures, attempt.LastFailure, attempt.LockedUntil))
+	got, err := s.GetLoginAttempt(context.Background(), attempt.Key)
+	require.NoError(t, err)
+	require.Equal(t, attempt, got)
+	mock.ExpectQuery("SELECT (.+) from loginattempts where key").WithArgs("ip:10.0.0.1").WillReturnRows(sqlmock.NewRows(columns))
+	got, err = s.GetLoginAttempt(context.Background(), "ip:10.0.0.1")
+	require.NoError(t, err)
+	require.Equal(t, models.LoginAttempt{Key: "ip:10.0.0.1"}, got)
+	mock.ExpectQuery("SELECT (.+) from loginattempts where key").WithArgs(attempt.Key).WillReturnError(errors.New("no"))
+	_, err = s.GetLoginAttempt(context.Background(), attempt.Key)
+	require.Error(t, err)
+
+	mock.ExpectQuery("SELECT (.+) from loginattempts where locked_until").WithArgs(now).WillReturnRows(
+		sqlmock.NewRows(columns).AddRow(attempt.Key, attempt.Failures, attempt.LastFailure, attempt.LockedUntil))
+	list, err := s.ListLockouts(context.Background(), now)
+	require.NoError(t, err)
+	require.Equal(t, []models.LoginAttempt{attempt}, list)
+
+	mock.ExpectExec("DELETE from loginattempts").WithArgs(attempt.Key).WillReturnResult(sqlmock.NewResult(0, 1))
+	require.NoError(t, s.ClearLoginAttempts(context.Background(), attempt.Key))
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
 func TestStorage_initDB(t *testing.T) {
 	tests := []struct {
 		name    string
@@ -283,3 +719,42 @@ func TestStorage_initDB(t *testing.T) {
 		})
 	}
 }
+
+func TestStorage_CommitUpload(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+	s := Storage{DB: db}
+	// unfinished upload is not committed
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT user_id, uuid, type, data, revision from uploads (.+) FOR UPDATE").WithArgs("upload", "test@test.com").
+		WillReturnRows(sqlmock.NewRows([]string{"user_id", "uuid", "type", "data", "revision"}).AddRow(1, "uuid", "DATA", []byte("meta"), 0))
+	mock.ExpectQuery("SELECT idx from uploadchunks").WithArgs("upload").WillReturnError(sql.ErrNoRows)
+	mock.ExpectRollback()
+	_, err = s.CommitUpload(context.Background(), "test@test.com", "upload")
+	require.ErrorIs(t, err, models.ErrConflict)
+	// file changed after revision of upload is not replaced
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT user_id, uuid, type, data, revision from uploads (.+) FOR UPDATE").WithArgs("upload", "test@test.com").
+		WillReturnRows(sqlmock.NewRows([]string{"user_id", "uuid", "type", "data", "revision"}).AddRow(1, "uuid", "DATA", []byte("meta"), 3))
+	mock.ExpectQuery("SELECT idx from uploadchunks").WithArgs("upload").WillReturnRows(sqlmock.NewRows([]string{"idx"}).AddRow(0))
+	mock.ExpectQuery("SELECT count").WithArgs("upload", int64(0)).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	mock.ExpectQuery("UPDATE users SET revision").WithArgs("test@test.com").WillReturnRows(sqlmock.NewRows([]string{"revision"}).AddRow(5))
+	mock.ExpectQuery("SELECT data, type, revision, deleted").WithArgs("uuid", "test@test.com").
+		WillReturnRows(sqlmock.NewRows([]string{"data", "type", "revision", "deleted", "owned"}).AddRow([]byte("theirs"), "DATA", 4, false, true))
+	mock.ExpectRollback()
+	mock.ExpectBegin()
+	mock.ExpectQuery("DELETE from uploadchunks").WithArgs("upload").WillReturnRows(sqlmock.NewRows([]string{"blob_key"}))
+	mock.ExpectExec("DELETE from uploads").WithArgs("upload").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	_, err = s.CommitUpload(context.Background(), "test@test.com", "upload")
+	conflict := &models.ConflictError{}
+	require.ErrorAs(t, err, &conflict)
+	require.Equal(t, int64(4), conflict.Current.Revision)
+	require.Equal(t, []byte("theirs"), conflict.Current.Data)
+	mock.ExpectQuery("SELECT idx, data, last, blob_key from filechunks").WithArgs("uuid", int64(2), "test@test.com").
+		WillReturnError(sql.ErrNoRows)
+	_, err = s.GetFileChunk(context.Background(), "test@test.com", "uuid", 2)
+	require.ErrorIs(t, err, models.ErrNotFound)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
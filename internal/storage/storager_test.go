@@ -0,0 +1,434 @@
+package storage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/MaximkaSha/gophkeeper/internal/models"
+	"github.com/stretchr/testify/require"
+)
+
+// testUsersAndData checks users and ciphered data semantics common for all storages.
+func testUsersAndData(t *testing.T, s models.Storager) {
+	user := models.User{Email: "test@test.com", Password: "hash", Secret: []byte("wrapped"), Salt: []byte("salt")}
+	require.NoError(t, s.AddUser(context.Background(), user))
+	require.ErrorIs(t, s.AddUser(context.Background(), user), models.ErrAlreadyExists)
+	require.NoError(t, s.AddUser(context.Background(), models.User{Email: "other@test.com", Password: "hash"}))
+	got, err := s.GetUser(context.Background(), models.User{Email: user.Email})
+	require.NoError(t, err)
+	require.Equal(t, user, got)
+
+	data := models.CipheredData{Data: []byte("data"), Type: "CARD", User: user.Email, ID: "5a1b4b35-e5a8-4bbc-9d4c-c0c2b5d2c8a7"}
+	revision, err := s.AddCipheredData(context.Background(), data)
+	require.NoError(t, err)
+	require.Equal(t, int64(1), revision)
+	data.Data = []byte("changed")
+	data.Revision = revision
+	// each change of vault gets next revision
+	data.Revision, err = s.AddCipheredData(context.Background(), data)
+	require.NoError(t, err)
+	require.Equal(t, int64(2), data.Revision)
+	stolen := data
+	stolen.User = "other@test.com"
+	_, err = s.AddCipheredData(context.Background(), stolen)
+	require.ErrorIs(t, err, models.ErrPermissionDenied)
+	require.ErrorIs(t, s.DelCiphereData(context.Background(), stolen.User, stolen.ID, data.Revision), models.ErrPermissionDenied)
+	stored, err := s.GetCipheredData(context.Background(), user.Email, models.DataPage{})
+	require.NoError(t, err)
+	require.Equal(t, []models.CipheredData{data}, stored)
+
+	// pages are ordered by uuid and filtered by type
+	text := models.CipheredData{Data: []byte("text"), Type: "TEXT", User: user.Email, ID: "1a1b4b35-e5a8-4bbc-9d4c-c0c2b5d2c8a7"}
+	text.Revision, err = s.AddCipheredData(context.Background(), text)
+	require.NoError(t, err)
+	last := models.CipheredData{Data: []byte("last"), Type: "CARD", User: user.Email, ID: "9a1b4b35-e5a8-4bbc-9d4c-c0c2b5d2c8a7"}
+	last.Revision, err = s.AddCipheredData(context.Background(), last)
+	require.NoError(t, err)
+	require.Equal(t, int64(4), last.Revision)
+	stored, err = s.GetCipheredData(context.Background(), user.Email, models.DataPage{Limit: 2})
+	require.NoError(t, err)
+	require.Equal(t, []models.CipheredData{text, data}, stored)
+	stored, err = s.GetCipheredData(context.Background(), user.Email, models.DataPage{After: data.ID, Limit: 2})
+	require.NoError(t, err)
+	require.Equal(t, []models.CipheredData{last}, stored)
+	stored, err = s.GetCipheredData(context.Background(), user.Email, models.DataPage{Types: []string{"CARD"}})
+	require.NoError(t, err)
+	require.Equal(t, []models.CipheredData{data, last}, stored)
+	stored, err = s.GetCipheredData(context.Background(), "other@test.com", models.DataPage{})
+	require.NoError(t, err)
+	require.Empty(t, stored)
+
+	now := time.Now()
+	require.NoError(t, s.ScheduleUserDeletion(context.Background(), user.Email, now.Add(-time.Minute)))
+	due, err := s.ListDueDeletions(context.Background(), now)
+	require.NoError(t, err)
+	require.Equal(t, []string{user.Email}, due)
+	ok, err := s.PurgeUser(context.Background(), user.Email, now)
+	require.NoError(t, err)
+	require.True(t, ok)
+	_, err = s.GetUser(context.Background(), models.User{Email: user.Email})
+	require.ErrorIs(t, err, models.ErrNotFound)
+	stored, err = s.GetCipheredData(context.Background(), user.Email, models.DataPage{})
+	require.NoError(t, err)
+	require.Empty(t, stored)
+	ok, err = s.PurgeUser(context.Background(), user.Email, now)
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = s.GetUser(ctx, models.User{Email: "other@test.com"})
+	require.ErrorIs(t, err, context.Canceled)
+}
+
+// testChanges checks revisions and tombstones semantics common for all storages.
+func testChanges(t *testing.T, s models.Storager) {
+	ctx := context.Background()
+	require.NoError(t, s.AddUser(ctx, models.User{Email: "test@test.com", Password: "hash"}))
+	require.NoError(t, s.AddUser(ctx, models.User{Email: "other@test.com", Password: "hash"}))
+	first := models.CipheredData{Data: []byte("first"), Type: "TEXT", User: "test@test.com", ID: "5a1b4b35-e5a8-4bbc-9d4c-c0c2b5d2c8a7"}
+	second := models.CipheredData{Data: []byte("second"), Type: "CARD", User: "test@test.com", ID: "1a1b4b35-e5a8-4bbc-9d4c-c0c2b5d2c8a7"}
+	save := func(data models.CipheredData) int64 {
+		revision, err := s.AddCipheredData(ctx, data)
+		require.NoError(t, err)
+		return revision
+	}
+	first.Revision = save(first)
+	second.Revision = save(second)
+	save(models.CipheredData{Data: []byte("other"), Type: "TEXT", User: "other@test.com", ID: "9a1b4b35-e5a8-4bbc-9d4c-c0c2b5d2c8a7"})
+	stale := first
+	first.Data = []byte("changed")
+	first.Revision = save(first)
+
+	// changes are ordered by revision, changed record is listed once
+	changes, err := s.ListChanges(ctx, "test@test.com", 0, 0)
+	require.NoError(t, err)
+	require.Equal(t, int64(2), second.Revision)
+	require.Equal(t, int64(3), first.Revision)
+	require.Equal(t, []models.CipheredData{second, first}, changes)
+	changes, err = s.ListChanges(ctx, "test@test.com", 0, 1)
+	require.NoError(t, err)
+	require.Equal(t, []models.CipheredData{second}, changes)
+	changes, err = s.ListChanges(ctx, "test@test.com", 3, 0)
+	require.NoError(t, err)
+	require.Empty(t, changes)
+
+	// deleted record is listed as tombstone and hidden from data
+	var conflict *models.ConflictError
+	require.ErrorAs(t, s.DelCiphereData(ctx, "test@test.com", second.ID, 1), &conflict)
+	require.Equal(t, second, conflict.Current)
+	require.NoError(t, s.DelCiphereData(ctx, "test@test.com", second.ID, second.Revision))
+	require.NoError(t, s.DelCiphereData(ctx, "test@test.com", second.ID, second.Revision))
+	require.NoError(t, s.DelCiphereData(ctx, "test@test.com", "0a1b4b35-e5a8-4bbc-9d4c-c0c2b5d2c8a7", 0))
+	changes, err = s.ListChanges(ctx, "test@test.com", 3, 0)
+	require.NoError(t, err)
+	require.Equal(t, []models.CipheredData{{Type: "CARD", User: "test@test.com", ID: second.ID, Revision: 4, Deleted: true}}, changes)
+	stored, err := s.GetCipheredData(ctx, "test@test.com", models.DataPage{})
+	require.NoError(t, err)
+	require.Equal(t, []models.CipheredData{first}, stored)
+	require.ErrorIs(t, s.DelCiphereData(ctx, "other@test.com", second.ID, 0), models.ErrPermissionDenied)
+	_, err = s.AddCipheredData(ctx, models.CipheredData{Data: []byte("stolen"), Type: "CARD", User: "other@test.com", ID: second.ID})
+	require.ErrorIs(t, err, models.ErrPermissionDenied)
+
+	// change based on outdated revision returns current record
+	stale.Data = []byte("stale")
+	_, err = s.AddCipheredData(ctx, stale)
+	require.ErrorAs(t, err, &conflict)
+	require.ErrorIs(t, err, models.ErrConflict)
+	require.Equal(t, first, conflict.Current)
+	_, err = s.AddCipheredData(ctx, second)
+	require.ErrorAs(t, err, &conflict)
+	require.Equal(t, models.CipheredData{Type: "CARD", User: "test@test.com", ID: second.ID, Revision: 4, Deleted: true}, conflict.Current)
+	missing := models.CipheredData{Data: []byte("missing"), Type: "TEXT", User: "test@test.com", ID: "3a1b4b35-e5a8-4bbc-9d4c-c0c2b5d2c8a7", Revision: 2}
+	_, err = s.AddCipheredData(ctx, missing)
+	require.ErrorAs(t, err, &conflict)
+	require.Equal(t, models.CipheredData{Type: "TEXT", User: "test@test.com", ID: missing.ID, Deleted: true}, conflict.Current)
+	changes, err = s.ListChanges(ctx, "test@test.com", 4, 0)
+	require.NoError(t, err)
+	require.Empty(t, changes)
+
+	// deleted record is restored by change based on tombstone
+	second.Revision = 4
+	second.Revision = save(second)
+	changes, err = s.ListChanges(ctx, "test@test.com", 4, 0)
+	require.NoError(t, err)
+	require.Equal(t, int64(5), second.Revision)
+	require.Equal(t, []models.CipheredData{second}, changes)
+
+	// revisions of users are separate
+	changes, err = s.ListChanges(ctx, "other@test.com", 0, 0)
+	require.NoError(t, err)
+	require.Len(t, changes, 1)
+	require.Equal(t, int64(1), changes[0].Revision)
+}
+
+// testRevisions checks history of records common for all storages.
+func testRevisions(t *testing.T, s models.Storager) {
+	ctx := context.Background()
+	require.NoError(t, s.AddUser(ctx, models.User{Email: "test@test.com", Password: "hash"}))
+	require.NoError(t, s.AddUser(ctx, models.User{Email: "other@test.com", Password: "hash"}))
+	data := models.CipheredData{Type: "PASSWORD", User: "test@test.com", ID: "1a1b4b35-e5a8-4bbc-9d4c-c0c2b5d2c8a7"}
+	versions := []models.CipheredData{}
+	for _, value := range []string{"first", "second", "third"} {
+		data.Data = []byte(value)
+		revision, err := s.AddCipheredData(ctx, data)
+		require.NoError(t, err)
+		data.Revision = revision
+		versions = append(versions, data)
+	}
+
+	// replaced versions are kept newest first
+	revisions, err := s.ListRevisions(ctx, data.User, data.ID)
+	require.NoError(t, err)
+	require.Len(t, revisions, 2)
+	require.Equal(t, versions[1], revisions[0].Data)
+	require.Equal(t, versions[0], revisions[1].Data)
+	require.False(t, revisions[0].ReplacedAt.IsZero())
+	version, err := s.GetRevision(ctx, data.User, data.ID, versions[0].Revision)
+	require.NoError(t, err)
+	require.Equal(t, versions[0], version.Data)
+	_, err = s.GetRevision(ctx, data.User, data.ID, versions[2].Revision)
+	require.ErrorIs(t, err, models.ErrNotFound)
+	revisions, err = s.ListRevisions(ctx, "other@test.com", data.ID)
+	require.NoError(t, err)
+	require.Empty(t, revisions)
+	_, err = s.GetRevision(ctx, "other@test.com", data.ID, versions[0].Revision)
+	require.ErrorIs(t, err, models.ErrNotFound)
+
+	// deleted version is kept, file records are not
+	require.NoError(t, s.DelCiphereData(ctx, data.User, data.ID, data.Revision))
+	file := models.CipheredData{Data: []byte("meta"), Type: "DATA", User: data.User, ID: "2a1b4b35-e5a8-4bbc-9d4c-c0c2b5d2c8a7"}
+	file.Revision, err = s.AddCipheredData(ctx, file)
+	require.NoError(t, err)
+	_, err = s.AddCipheredData(ctx, file)
+	require.NoError(t, err)
+	_, err = s.ListRevisions(ctx, data.User, file.ID)
+	require.ErrorIs(t, err, models.ErrNoHistory)
+	_, err = s.GetRevision(ctx, data.User, file.ID, file.Revision)
+	require.ErrorIs(t, err, models.ErrNoHistory)
+	revisions, err = s.ListRevisions(ctx, data.User, data.ID)
+	require.NoError(t, err)
+	require.Len(t, revisions, 3)
+	require.Equal(t, versions[2], revisions[0].Data)
+
+	// zero number and time are no limits
+	purged, err := s.PurgeRevisions(ctx, 0, time.Time{})
+	require.NoError(t, err)
+	require.Zero(t, purged)
+
+	// only newest versions are kept, none after retention
+	purged, err = s.PurgeRevisions(ctx, 2, time.Now().Add(-time.Hour))
+	require.NoError(t, err)
+	require.Equal(t, 1, purged)
+	revisions, err = s.ListRevisions(ctx, data.User, data.ID)
+	require.NoError(t, err)
+	require.Len(t, revisions, 2)
+	require.Equal(t, versions[1], revisions[1].Data)
+	purged, err = s.PurgeRevisions(ctx, 2, time.Now().Add(time.Hour))
+	require.NoError(t, err)
+	require.Equal(t, 2, purged)
+	revisions, err = s.ListRevisions(ctx, data.User, data.ID)
+	require.NoError(t, err)
+	require.Empty(t, revisions)
+}
+
+// testSessionsAndLockouts checks sessions, refresh tokens and lockouts semantics common for all storages.
+func testSessionsAndLockouts(t *testing.T, s models.Storager) {
+	// times in other zones must be compared as instants
+	zone := time.FixedZone("UTC+5", 5*60*60)
+	now := time.Now().In(zone)
+	session := models.Session{
+		ID:        "b5d0c2a8-4bbc-4a5e-9d4c-c0c2b5d2c8a7",
+		User:      "test@test.com",
+		CreatedAt: now,
+		LastSeen:  now,
+		ExpiresAt: now.Add(time.Hour),
+		Device:    "test",
+	}
+	require.NoError(t, s.AddSession(context.Background(), session))
+	expired := session
+	expired.ID = "c5d0c2a8-4bbc-4a5e-9d4c-c0c2b5d2c8a7"
+	expired.ExpiresAt = time.Now().UTC().Add(-time.Minute)
+	require.NoError(t, s.AddSession(context.Background(), expired))
+	sessions, err := s.ListSessions(context.Background(), session.User)
+	require.NoError(t, err)
+	require.Len(t, sessions, 1)
+	require.Equal(t, session.ID, sessions[0].ID)
+	require.True(t, session.ExpiresAt.Equal(sessions[0].ExpiresAt))
+	require.NoError(t, s.RevokeUserSessions(context.Background(), session.User, session.ID))
+	got, err := s.GetSession(context.Background(), expired.ID)
+	require.NoError(t, err)
+	require.True(t, got.Revoked)
+
+	token := models.RefreshToken{Hash: []byte("hash"), SessionID: session.ID, CreatedAt: now}
+	require.NoError(t, s.AddRefreshToken(context.Background(), token))
+	ok, err := s.UseRefreshToken(context.Background(), token.Hash)
+	require.NoError(t, err)
+	require.True(t, ok)
+	ok, err = s.UseRefreshToken(context.Background(), token.Hash)
+	require.NoError(t, err)
+	require.False(t, ok)
+	// tokens of expired session and long ago used tokens are purged
+	current := models.RefreshToken{Hash: []byte("current"), SessionID: session.ID, CreatedAt: now}
+	require.NoError(t, s.AddRefreshToken(context.Background(), current))
+	require.NoError(t, s.AddRefreshToken(context.Background(), models.RefreshToken{Hash: []byte("expired"), SessionID: expired.ID, CreatedAt: now}))
+	purged, err := s.PurgeRefreshTokens(context.Background(), time.Now(), now.Add(-time.Hour))
+	require.NoError(t, err)
+	require.Equal(t, 1, purged)
+	purged, err = s.PurgeRefreshTokens(context.Background(), time.Now(), now.Add(time.Minute))
+	require.NoError(t, err)
+	require.Equal(t, 1, purged)
+	_, err = s.GetRefreshToken(context.Background(), token.Hash)
+	require.ErrorIs(t, err, models.ErrNotFound)
+	_, err = s.GetRefreshToken(context.Background(), current.Hash)
+	require.NoError(t, err)
+
+	key := "account:test@test.com"
+	failures, err := s.AddLoginFailure(context.Background(), key, now, now.Add(-time.Hour))
+	require.NoError(t, err)
+	require.Equal(t, 1, failures)
+	failures, err = s.AddLoginFailure(context.Background(), key, now, now.Add(-time.Hour))
+	require.NoError(t, err)
+	require.Equal(t, 2, failures)
+	failures, err = s.AddLoginFailure(context.Background(), key, now.Add(time.Minute), now.Add(time.Second))
+	require.NoError(t, err)
+	require.Equal(t, 1, failures)
+	require.NoError(t, s.LockLogin(context.Background(), key, now.Add(time.Minute)))
+	lockouts, err := s.ListLockouts(context.Background(), time.Now().UTC())
+	require.NoError(t, err)
+	require.Len(t, lockouts, 1)
+	require.Equal(t, key, lockouts[0].Key)
+}
+
+// testTOTP checks authenticator enrollment semantics common for all storages.
+func testTOTP(t *testing.T, s models.Storager) {
+	user := "test@test.com"
+	ok, err := s.UseTOTPStep(context.Background(), user, 1)
+	require.NoError(t, err)
+	require.False(t, ok)
+	require.NoError(t, s.AddTOTP(context.Background(), models.TOTP{User: user, Secret: "secret", RecoveryCodes: [][]byte{[]byte("code")}}))
+	ok, err = s.UseTOTPStep(context.Background(), user, 10)
+	require.NoError(t, err)
+	require.True(t, ok)
+	// code of the same or earlier step is spent
+	ok, err = s.UseTOTPStep(context.Background(), user, 10)
+	require.NoError(t, err)
+	require.False(t, ok)
+	ok, err = s.UseTOTPStep(context.Background(), user, 9)
+	require.NoError(t, err)
+	require.False(t, ok)
+	// used codes stay used after new enrollment
+	require.NoError(t, s.AddTOTP(context.Background(), models.TOTP{User: user, Secret: "other"}))
+	got, err := s.GetTOTP(context.Background(), user)
+	require.NoError(t, err)
+	require.Equal(t, "other", got.Secret)
+	require.Equal(t, int64(10), got.LastStep)
+	ok, err = s.UseTOTPStep(context.Background(), user, 11)
+	require.NoError(t, err)
+	require.True(t, ok)
+}
+
+// testUploads checks uploads and file chunks semantics common for all storages.
+func testUploads(t *testing.T, s models.Storager) {
+	ctx := context.Background()
+	now := time.Now()
+	require.NoError(t, s.AddUser(ctx, models.User{Email: "test@test.com", Password: "hash"}))
+	require.NoError(t, s.AddUser(ctx, models.User{Email: "other@test.com", Password: "hash"}))
+	file := models.CipheredData{Data: []byte("meta"), Type: "DATA", User: "test@test.com", ID: "5a1b4b35-e5a8-4bbc-9d4c-c0c2b5d2c8a7"}
+	upload := models.Upload{ID: "7a1b4b35-e5a8-4bbc-9d4c-c0c2b5d2c8a7", Data: file, UpdatedAt: now}
+	require.NoError(t, s.AddUpload(ctx, upload))
+	require.ErrorIs(t, s.AddUpload(ctx, upload), models.ErrAlreadyExists)
+	first := models.FileChunk{Index: 0, Data: []byte("first")}
+	second := models.FileChunk{Index: 1, Data: []byte("second"), Last: true}
+	require.NoError(t, s.AddUploadChunk(ctx, file.User, upload.ID, first, now))
+	require.ErrorIs(t, s.AddUploadChunk(ctx, "other@test.com", upload.ID, second, now), models.ErrNotFound)
+	_, err := s.GetUpload(ctx, "other@test.com", upload.ID)
+	require.ErrorIs(t, err, models.ErrNotFound)
+	got, err := s.GetUpload(ctx, file.User, upload.ID)
+	require.NoError(t, err)
+	require.Equal(t, int64(1), got.Chunks)
+	require.Equal(t, file, got.Data)
+	_, err = s.CommitUpload(ctx, file.User, upload.ID)
+	require.ErrorIs(t, err, models.ErrConflict)
+	// file is not changed until upload is finished
+	_, err = s.GetFileChunk(ctx, file.User, file.ID, 0)
+	require.ErrorIs(t, err, models.ErrNotFound)
+	require.NoError(t, s.AddUploadChunk(ctx, file.User, upload.ID, second, now))
+	_, err = s.CommitUpload(ctx, "other@test.com", upload.ID)
+	require.ErrorIs(t, err, models.ErrNotFound)
+	revision, err := s.CommitUpload(ctx, file.User, upload.ID)
+	require.NoError(t, err)
+	require.Equal(t, int64(1), revision)
+	_, err = s.GetUpload(ctx, file.User, upload.ID)
+	require.ErrorIs(t, err, models.ErrNotFound)
+	stored, err := s.GetCipheredData(ctx, file.User, models.DataPage{})
+	require.NoError(t, err)
+	file.Revision = 1
+	require.Equal(t, []models.CipheredData{file}, stored)
+	chunk, err := s.GetFileChunk(ctx, file.User, file.ID, 1)
+	require.NoError(t, err)
+	require.Equal(t, second, chunk)
+	_, err = s.GetFileChunk(ctx, "other@test.com", file.ID, 1)
+	require.ErrorIs(t, err, models.ErrNotFound)
+
+	// upload based on outdated revision does not replace file
+	upload.ID = "4a1b4b35-e5a8-4bbc-9d4c-c0c2b5d2c8a7"
+	require.NoError(t, s.AddUpload(ctx, upload))
+	require.NoError(t, s.AddUploadChunk(ctx, file.User, upload.ID, models.FileChunk{Index: 0, Data: []byte("stale"), Last: true}, now))
+	_, err = s.CommitUpload(ctx, file.User, upload.ID)
+	conflict := &models.ConflictError{}
+	require.ErrorAs(t, err, &conflict)
+	require.Equal(t, file, conflict.Current)
+	chunk, err = s.GetFileChunk(ctx, file.User, file.ID, 0)
+	require.NoError(t, err)
+	require.Equal(t, []byte("first"), chunk.Data)
+	_, err = s.GetUpload(ctx, file.User, upload.ID)
+	require.ErrorIs(t, err, models.ErrNotFound)
+
+	// shorter upload replaces all chunks
+	upload.ID = "8a1b4b35-e5a8-4bbc-9d4c-c0c2b5d2c8a7"
+	upload.Data.Revision = file.Revision
+	require.NoError(t, s.AddUpload(ctx, upload))
+	got, err = s.GetUpload(ctx, file.User, upload.ID)
+	require.NoError(t, err)
+	require.Equal(t, file.Revision, got.Data.Revision)
+	first.Last = true
+	require.NoError(t, s.AddUploadChunk(ctx, file.User, upload.ID, first, now))
+	_, err = s.CommitUpload(ctx, file.User, upload.ID)
+	require.NoError(t, err)
+	chunk, err = s.GetFileChunk(ctx, file.User, file.ID, 0)
+	require.NoError(t, err)
+	require.Equal(t, first, chunk)
+	_, err = s.GetFileChunk(ctx, file.User, file.ID, 1)
+	require.ErrorIs(t, err, models.ErrNotFound)
+
+	// file of other user can't be uploaded
+	stolen := upload
+	stolen.ID = "9a1b4b35-e5a8-4bbc-9d4c-c0c2b5d2c8a7"
+	stolen.Data.User = "other@test.com"
+	require.ErrorIs(t, s.AddUpload(ctx, stolen), models.ErrPermissionDenied)
+
+	// abandoned uploads are purged
+	upload.ID = "6a1b4b35-e5a8-4bbc-9d4c-c0c2b5d2c8a7"
+	upload.UpdatedAt = now.Add(-time.Hour)
+	require.NoError(t, s.AddUpload(ctx, upload))
+	require.NoError(t, s.AddUploadChunk(ctx, file.User, upload.ID, first, now.Add(-time.Hour)))
+	purged, err := s.PurgeUploads(ctx, now.Add(-time.Minute))
+	require.NoError(t, err)
+	require.Equal(t, 1, purged)
+	_, err = s.GetUpload(ctx, file.User, upload.ID)
+	require.ErrorIs(t, err, models.ErrNotFound)
+
+	// chunks are deleted with file
+	changes, err := s.ListChanges(ctx, file.User, 0, 0)
+	require.NoError(t, err)
+	require.NoError(t, s.DelCiphereData(ctx, file.User, file.ID, changes[len(changes)-1].Revision))
+	changes, err = s.ListChanges(ctx, file.User, 0, 0)
+	require.NoError(t, err)
+	file.Revision = changes[len(changes)-1].Revision
+	_, err = s.AddCipheredData(ctx, file)
+	require.NoError(t, err)
+	_, err = s.GetFileChunk(ctx, file.User, file.ID, 0)
+	require.ErrorIs(t, err, models.ErrNotFound)
+}
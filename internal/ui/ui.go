@@ -6,7 +6,6 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io/fs"
 	"io/ioutil"
 	"log"
 	"os"
@@ -14,13 +13,18 @@ import (
 	"runtime"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/MaximkaSha/gophkeeper/internal/client"
 	"github.com/MaximkaSha/gophkeeper/internal/models"
+	pb "github.com/MaximkaSha/gophkeeper/internal/proto"
 	"github.com/gdamore/tcell/v2"
 	"github.com/google/uuid"
 	"github.com/rivo/tview"
+	qrcode "github.com/skip2/go-qrcode"
 	"github.com/theplant/luhn"
+	"google.golang.org/grpc/codes"
+	grpcstatus "google.golang.org/grpc/status"
 )
 
 var logo = `   
@@ -46,6 +50,7 @@ func UI(ctx context.Context, client client.Client) {
 
 	user := models.User{}
 	app := tview.NewApplication()
+	var grid2 *tview.Flex
 	logo := tview.NewTextView().SetText(logo)
 	status := tview.NewTextView()
 	form := tview.NewForm().
@@ -57,6 +62,18 @@ func UI(ctx context.Context, client client.Client) {
 		}).
 		AddButton("Login", func() {
 			err := client.UserLogin(ctx, user)
+			if client.MFAPending() {
+				app.SetRoot(DrawMFALogin(ctx, client, app, grid2), true)
+				return
+			}
+			// cached vault is opened if server is not reachable
+			if grpcstatus.Code(err) == codes.Unavailable {
+				if offlineErr := client.OfflineLogin(user); offlineErr != nil {
+					err = fmt.Errorf("%w, offline login: %s", err, offlineErr)
+				} else {
+					err = nil
+				}
+			}
 			if err != nil {
 				status.SetText(err.Error())
 			} else {
@@ -75,7 +92,7 @@ func UI(ctx context.Context, client client.Client) {
 		})
 	status.SetText("Client version: " + client.BuildVersion + ", client build time: " + client.BuildTime)
 
-	grid2 := tview.NewFlex().AddItem(tview.NewBox(), 0, 1, false).
+	grid2 = tview.NewFlex().AddItem(tview.NewBox(), 0, 1, false).
 		AddItem(tview.NewFlex().SetDirection(tview.FlexRow).
 			AddItem(logo, 0, 2, false).
 			AddItem(form, 0, 1, true).
@@ -92,6 +109,29 @@ func UI(ctx context.Context, client client.Client) {
 	}
 }
 
+// DrawMFALogin - draws second login step, asks authenticator or recovery code.
+func DrawMFALogin(ctx context.Context, client client.Client, app *tview.Application, back tview.Primitive) *tview.Flex {
+	code := ""
+	status := tview.NewTextView().SetText("Enter code from authenticator app or recovery code")
+	form := tview.NewForm().
+		AddInputField("Code: ", "", 20, nil, func(text string) {
+			code = text
+		}).
+		AddButton("Login", func() {
+			err := client.UserLoginMFA(ctx, code)
+			if err != nil {
+				status.SetText(err.Error())
+				return
+			}
+			app.Stop()
+			loggedIn(ctx, client)
+		}).
+		AddButton("Back", func() { app.SetRoot(back, true) })
+	return tview.NewFlex().SetDirection(tview.FlexRow).
+		AddItem(form, 0, 1, true).
+		AddItem(status, 1, 0, false)
+}
+
 // DrawError - draws error for user.
 func DrawError(err error) {
 	app := tview.NewApplication()
@@ -205,17 +245,20 @@ func DrawEditFromTable(ctx context.Context, client client.Client, app *tview.App
 						loggedIn(ctx, client)
 					}
 
-					err := client.AddData(ctx, cc)
-					if err != nil {
-						DrawError(err)
+					if !saveEdit(ctx, &client, app, cc) {
+						return
 					}
 				}
 				app.Stop()
 				loggedIn(ctx, client)
 			}).AddButton("Del", func() {
-			client.DelData(ctx, cc.ID)
+			if !delEdit(ctx, &client, app, cc.ID) {
+				return
+			}
 			app.Stop()
 			loggedIn(ctx, client)
+		}).AddButton("History", func() {
+			app.SetRoot(DrawHistory(ctx, &client, app, form, cc.ID), true)
 		})
 	case 0:
 		isChanged := false
@@ -233,19 +276,20 @@ func DrawEditFromTable(ctx context.Context, client client.Client, app *tview.App
 				pass.Tag = text
 			}).
 			AddButton("Add/Update", func() {
-				if isChanged {
-					err := client.AddData(ctx, pass)
-					if err != nil {
-						DrawError(err)
-					}
+				if isChanged && !saveEdit(ctx, &client, app, pass) {
+					return
 				}
 				app.Stop()
 				loggedIn(ctx, client)
 			}).AddButton("Del", func() {
 			log.Println("Del id", pass.ID)
-			client.DelData(ctx, pass.ID)
+			if !delEdit(ctx, &client, app, pass.ID) {
+				return
+			}
 			app.Stop()
 			loggedIn(ctx, client)
+		}).AddButton("History", func() {
+			app.SetRoot(DrawHistory(ctx, &client, app, form, pass.ID), true)
 		})
 	case 2:
 		isChanged := false
@@ -259,18 +303,19 @@ func DrawEditFromTable(ctx context.Context, client client.Client, app *tview.App
 				txt.Tag = text
 			}).
 			AddButton("Add/Update", func() {
-				if isChanged {
-					err := client.AddData(ctx, txt)
-					if err != nil {
-						DrawError(err)
-					}
+				if isChanged && !saveEdit(ctx, &client, app, txt) {
+					return
 				}
 				app.Stop()
 				loggedIn(ctx, client)
 			}).AddButton("Del", func() {
-			client.DelData(ctx, txt.ID)
+			if !delEdit(ctx, &client, app, txt.ID) {
+				return
+			}
 			app.Stop()
 			loggedIn(ctx, client)
+		}).AddButton("History", func() {
+			app.SetRoot(DrawHistory(ctx, &client, app, form, txt.ID), true)
 		})
 	case 3:
 		path, err := os.Getwd()
@@ -286,36 +331,244 @@ func DrawEditFromTable(ctx context.Context, client client.Client, app *tview.App
 				data.Tag = text
 			}).
 			AddButton("Write file", func() {
-				os.WriteFile(path, data.Data, fs.FileMode(os.O_WRONLY))
+				err := writeFile(ctx, client, data, path)
+				if err != nil {
+					DrawError(err)
+				}
 				app.Stop()
 				loggedIn(ctx, client)
 			}).AddButton("Del", func() {
-			client.DelData(ctx, data.ID)
+			if !delEdit(ctx, &client, app, data.ID) {
+				return
+			}
 			app.Stop()
 			loggedIn(ctx, client)
+		}).AddButton("History", func() {
+			// server refuses history of files, the reason is shown
+			app.SetRoot(DrawHistory(ctx, &client, app, form, data.ID), true)
 		}).AddButton("Exit", func() { app.Stop() })
 
 	}
 	return form
 }
 
-func loggedIn(ctx context.Context, client client.Client) {
+// saveEdit - save edited record, conflict with change made on other device is drawn for resolution.
+// Returns false if conflict is drawn.
+func saveEdit(ctx context.Context, c *client.Client, app *tview.Application, data models.Dater) bool {
+	return checkConflict(ctx, c, app, c.AddData(ctx, data))
+}
 
-	app := tview.NewApplication()
-	err := client.GetAllDataFromDB(ctx)
+// delEdit - delete record, conflict with change made on other device is drawn for resolution.
+// Returns false if conflict is drawn.
+func delEdit(ctx context.Context, c *client.Client, app *tview.Application, id string) bool {
+	return checkConflict(ctx, c, app, c.DelData(ctx, id))
+}
+
+// checkConflict - draw conflict returned by change of record, other error is shown.
+// Returns false if conflict is drawn.
+func checkConflict(ctx context.Context, c *client.Client, app *tview.Application, err error) bool {
+	var conflict *client.ConflictError
+	if errors.As(err, &conflict) {
+		app.SetRoot(DrawConflict(ctx, c, app, conflict), true)
+		return false
+	}
+	if err != nil {
+		DrawError(err)
+	}
+	return true
+}
+
+// DrawConflict - draw my and their versions of record side by side.
+// User keeps one of them or merges them field by field.
+func DrawConflict(ctx context.Context, c *client.Client, app *tview.Application, conflict *client.ConflictError) *tview.Flex {
+	mine := conflict.Mine
+	info := tview.NewTextView().SetText(conflict.Error())
+	resolve := func(data models.Dater) {
+		err := c.ResolveConflict(ctx, data, conflict)
+		var again *client.ConflictError
+		if errors.As(err, &again) {
+			app.SetRoot(DrawConflict(ctx, c, app, again), true)
+			return
+		}
+		if err != nil {
+			info.SetText(err.Error())
+			return
+		}
+		app.Stop()
+		loggedIn(ctx, *c)
+	}
+	myView := tview.NewTextView().SetText("Deleted by me")
+	myView.SetBorder(true).SetTitle("Mine")
+	theirView := tview.NewTextView().SetText("Deleted on other device")
+	theirView.SetBorder(true).SetTitle("Theirs")
+	form := tview.NewForm().
+		AddButton("Keep mine", func() { resolve(mine) }).
+		AddButton("Keep theirs", func() {
+			c.KeepTheirs(ctx, conflict)
+			app.Stop()
+			loggedIn(ctx, *c)
+		})
+	switch {
+	case mine == nil:
+		theirView.SetText(string(conflict.Theirs.GetData()))
+	case conflict.Theirs == nil:
+		myView.SetText(string(mine.GetData()))
+	default:
+		fields, err := client.CompareFields(mine, conflict.Theirs)
+		if err != nil {
+			log.Println(err)
+		}
+		my, their := "", ""
+		for _, field := range fields {
+			mark := "  "
+			if field.Mine != field.Theirs {
+				mark = "* "
+			}
+			my += mark + field.Name + ": " + field.Mine + "\n"
+			their += mark + field.Name + ": " + field.Theirs + "\n"
+		}
+		myView.SetText(my)
+		theirView.SetText(their)
+		form.AddButton("Merge", func() {
+			app.SetRoot(DrawMerge(mine, conflict, fields, resolve), true)
+		})
+	}
+	return tview.NewFlex().SetDirection(tview.FlexRow).
+		AddItem(tview.NewFlex().
+			AddItem(myView, 0, 1, false).
+			AddItem(theirView, 0, 1, false), 0, 1, false).
+		AddItem(form, 3, 0, true).
+		AddItem(info, 1, 0, false)
+}
+
+// DrawHistory - draw previous versions of record kept by server, newest first.
+// Selected version is shown and can be restored, Esc returns back.
+func DrawHistory(ctx context.Context, c *client.Client, app *tview.Application, back tview.Primitive, id string) *tview.Flex {
+	info := tview.NewTextView().SetText("Enter - restore version, Esc - back")
+	view := tview.NewTextView()
+	view.SetBorder(true).SetTitle("Version")
+	table := tview.NewTable().SetSelectable(true, false).SetFixed(1, 0)
+	for i, title := range []string{"Revision", "Replaced at"} {
+		table.SetCell(0, i, tview.NewTableCell(title).SetExpansion(1).SetAlign(tview.AlignCenter).SetBackgroundColor(tcell.Color100).SetSelectable(false))
+	}
+	revisions, err := c.ListRevisions(ctx, id)
 	if err != nil {
 		log.Println(err)
+		info.SetText(err.Error() + ", Esc - back")
+	}
+	if err == nil && len(revisions) == 0 {
+		info.SetText("No previous versions, Esc - back")
+	}
+	for i, revision := range revisions {
+		table.SetCellSimple(i+1, 0, strconv.FormatInt(revision.Revision, 10))
+		table.SetCellSimple(i+1, 1, revision.ReplacedAt.Format(time.RFC822))
 	}
+	flex := tview.NewFlex().SetDirection(tview.FlexRow).
+		AddItem(tview.NewFlex().
+			AddItem(table, 0, 1, true).
+			AddItem(view, 0, 1, false), 0, 1, true).
+		AddItem(info, 1, 0, false)
+	table.SetDoneFunc(func(key tcell.Key) {
+		if key == tcell.KeyEscape {
+			app.SetRoot(back, true)
+		}
+	})
+	table.SetSelectionChangedFunc(func(row int, column int) {
+		if row < 1 || row > len(revisions) {
+			return
+		}
+		view.SetText(string(revisions[row-1].Data.GetData()))
+	})
+	table.SetSelectedFunc(func(row int, column int) {
+		if row < 1 || row > len(revisions) {
+			return
+		}
+		revision := revisions[row-1]
+		modal := tview.NewModal().
+			SetText("Restore revision " + strconv.FormatInt(revision.Revision, 10) + "?").
+			AddButtons([]string{"Restore", "Cancel"}).
+			SetDoneFunc(func(buttonIndex int, buttonLabel string) {
+				if buttonLabel != "Restore" {
+					app.SetRoot(flex, true)
+					return
+				}
+				err := c.RestoreRevision(ctx, revision)
+				var conflict *client.ConflictError
+				if errors.As(err, &conflict) {
+					app.SetRoot(DrawConflict(ctx, c, app, conflict), true)
+					return
+				}
+				if err != nil {
+					log.Println(err)
+					info.SetText(err.Error())
+					app.SetRoot(flex, true)
+					return
+				}
+				app.Stop()
+				loggedIn(ctx, *c)
+			})
+		app.SetRoot(modal, true)
+	})
+	table.Select(1, 0)
+	return flex
+}
 
-	status := tview.NewTextView().SetText("Ctrl + (A)dd,  (E)xit")
-	table := tview.NewTable()
-	table = UpdateTable(ctx, client, table)
-	table.SetCell(0, 0, tview.NewTableCell(fmt.Sprintf("Passwords(%v)", len(client.LocalStorage.PasswordStorage))).SetExpansion(1).SetAlign(tview.AlignCenter).SetBackgroundColor(tcell.Color100))
-	table.SetCell(0, 1, tview.NewTableCell(fmt.Sprintf("Credit Cards(%v)", len(client.LocalStorage.CCStorage))).SetExpansion(1).SetAlign(tview.AlignCenter).SetBackgroundColor(tcell.Color100))
-	table.SetCell(0, 2, tview.NewTableCell(fmt.Sprintf("Texts(%v)", len(client.LocalStorage.TextStorage))).SetExpansion(1).SetAlign(tview.AlignCenter).SetBackgroundColor(tcell.Color100))
-	table.SetCell(0, 3, tview.NewTableCell(fmt.Sprintf("Files(%v)", len(client.LocalStorage.DataStorage))).SetExpansion(1).SetAlign(tview.AlignCenter).SetBackgroundColor(tcell.Color100))
+// DrawMerge - draw choice of version for each differing field of record, merged record is saved.
+func DrawMerge(mine models.Dater, conflict *client.ConflictError, fields []client.Field, save func(models.Dater)) *tview.Flex {
+	info := tview.NewTextView().SetText("Choose value of each changed field")
+	fromTheirs := map[string]bool{}
+	form := tview.NewForm()
+	for _, field := range fields {
+		if field.Mine == field.Theirs {
+			continue
+		}
+		name := field.Name
+		form.AddDropDown(name+": ", []string{"mine: " + field.Mine, "theirs: " + field.Theirs}, 0, func(option string, i int) {
+			fromTheirs[name] = i == 1
+		})
+	}
+	form.AddButton("Save", func() {
+		names := []string{}
+		for name, theirs := range fromTheirs {
+			if theirs {
+				names = append(names, name)
+			}
+		}
+		merged, err := client.MergeFields(mine, conflict.Theirs, names)
+		if err != nil {
+			info.SetText(err.Error())
+			return
+		}
+		save(merged)
+	})
+	return tview.NewFlex().SetDirection(tview.FlexRow).
+		AddItem(form, 0, 1, true).
+		AddItem(info, 1, 0, false)
+}
 
+func loggedIn(ctx context.Context, client client.Client) {
+
+	app := tview.NewApplication()
+	stopWatch()
+	conflicts := syncVault(ctx, &client)
+
+	status := tview.NewTextView().SetText("Ctrl + (A)dd,  (S)essions,  (T)wo-factor,  (P)assword,  (D)elete account,  (E)xit")
+	if at, ok := client.DeletionScheduled(); ok {
+		status.SetText(fmt.Sprintf("Account will be deleted at %s, Ctrl + D to undo.  ", at.Format("2006-01-02 15:04")) + status.GetText(false))
+	}
+	if client.Offline() {
+		status.SetText(fmt.Sprintf("Offline, %d changes are sent at next login.  ", client.Queued()) + status.GetText(false))
+	} else if client.Queued() > 0 {
+		status.SetText(fmt.Sprintf("Server is not reachable, %d changes not sent.  ", client.Queued()) + status.GetText(false))
+	}
+	table := tview.NewTable()
+	refreshTable(ctx, client, table)
 	table.SetSelectable(true, true)
+	watchCtx, cancel := context.WithCancel(ctx)
+	stopWatch = cancel
+	if !client.Offline() {
+		go watchChanges(watchCtx, &client, client.Revision(), app, table)
+	}
 
 	grid := tview.NewGrid().
 		AddItem(table, 0, 0, 1, 1, 0, 0, false).
@@ -335,6 +588,9 @@ func loggedIn(ctx context.Context, client client.Client) {
 				AddButtons([]string{"Quit", "Cancel"}).
 				SetDoneFunc(func(buttonIndex int, buttonLabel string) {
 					if buttonLabel == "Quit" {
+						if err := client.Logout(ctx); err != nil {
+							log.Println("Logout error: ", err)
+						}
 						app.SetRoot(grid, true)
 						app.Stop()
 					}
@@ -343,6 +599,14 @@ func loggedIn(ctx context.Context, client client.Client) {
 					}
 				})
 			app.SetRoot(modal, true)
+		case tcell.KeyCtrlS:
+			app.SetRoot(DrawSessions(ctx, client, app, grid), true)
+		case tcell.KeyCtrlT:
+			app.SetRoot(DrawTOTP(ctx, client, app, grid), true)
+		case tcell.KeyCtrlP:
+			app.SetRoot(DrawChangePassword(ctx, client, app, grid), true)
+		case tcell.KeyCtrlD:
+			app.SetRoot(DrawDeleteAccount(ctx, client, app, grid), true)
 		case tcell.KeyCtrlA:
 			formAdd := tview.NewForm().AddDropDown("Type: ", []string{"PASSWORD", "CREDIT CARD", "TEXT", "FILE"}, 0, func(option string, i int) {
 				switch option {
@@ -390,16 +654,13 @@ func loggedIn(ctx context.Context, client client.Client) {
 				case "FILE":
 					data := models.Data{}
 					path := Tree()
-					formAddText := tview.NewForm().AddInputField("Path to file: ", path, len(path), func(textToCheck string, lastChar rune) bool { return textToCheck != "" }, func(text string) { data.Data = []byte(text) }).
+					formAddText := tview.NewForm().AddInputField("Path to file: ", path, len(path), func(textToCheck string, lastChar rune) bool { return textToCheck != "" }, func(text string) { path = text }).
 						AddInputField("Tag: ", "", 10, func(textToCheck string, lastChar rune) bool { return textToCheck != "" }, func(text string) { data.Tag = text }).
 						AddButton("Add/Update", func() {
-							b, err := ioutil.ReadFile(path)
+							err := uploadFile(ctx, client, data, path)
 							if err != nil {
-								log.Fatal(err)
+								DrawError(err)
 							}
-							data.Data = b
-							data.ID = uuid.NewString()
-							client.AddData(ctx, data)
 							app.Stop()
 							loggedIn(ctx, client)
 						}).SetFocus(1)
@@ -413,12 +674,284 @@ func loggedIn(ctx context.Context, client client.Client) {
 		return event
 	})
 
-	err = app.SetRoot(grid, true).EnableMouse(true).SetFocus(table).Run()
+	app.SetRoot(grid, true)
+	// conflicts left are drawn again by next replay
+	if len(conflicts) > 0 {
+		app.SetRoot(DrawConflict(ctx, &client, app, conflicts[0]), true)
+	}
+	err := app.EnableMouse(true).SetFocus(table).Run()
 	if err != nil {
 		panic(err)
 	}
 }
 
+// syncVault - apply changes of vault made by other devices and send changes queued
+// while server was not reachable, returns conflicts of queued changes.
+func syncVault(ctx context.Context, c *client.Client) []*client.ConflictError {
+	if c.Offline() {
+		return nil
+	}
+	err := c.SyncChanges(ctx)
+	if err != nil {
+		log.Println(err)
+	}
+	conflicts, err := c.ReplayOutbox(ctx)
+	if err != nil {
+		log.Println(err)
+	}
+	return conflicts
+}
+
+// stopWatch - stops watching of vault by previous logged in screen.
+var stopWatch = func() {}
+
+// refreshTable - redraw table of vault data with headers.
+func refreshTable(ctx context.Context, client client.Client, table *tview.Table) {
+	table.Clear()
+	UpdateTable(ctx, client, table)
+	table.SetCell(0, 0, tview.NewTableCell(fmt.Sprintf("Passwords(%v)", len(client.LocalStorage.PasswordStorage))).SetExpansion(1).SetAlign(tview.AlignCenter).SetBackgroundColor(tcell.Color100))
+	table.SetCell(0, 1, tview.NewTableCell(fmt.Sprintf("Credit Cards(%v)", len(client.LocalStorage.CCStorage))).SetExpansion(1).SetAlign(tview.AlignCenter).SetBackgroundColor(tcell.Color100))
+	table.SetCell(0, 2, tview.NewTableCell(fmt.Sprintf("Texts(%v)", len(client.LocalStorage.TextStorage))).SetExpansion(1).SetAlign(tview.AlignCenter).SetBackgroundColor(tcell.Color100))
+	table.SetCell(0, 3, tview.NewTableCell(fmt.Sprintf("Files(%v)", len(client.LocalStorage.DataStorage))).SetExpansion(1).SetAlign(tview.AlignCenter).SetBackgroundColor(tcell.Color100))
+}
+
+// watchChanges - refresh table on changes of vault made by other devices.
+// Changes are applied in UI loop, so client is not used concurrently.
+func watchChanges(ctx context.Context, client *client.Client, since int64, app *tview.Application, table *tview.Table) {
+	err := client.Watch(ctx, since, func(event *pb.WatchEvent) {
+		// stopped screen does not run updates any more
+		if ctx.Err() != nil {
+			return
+		}
+		app.QueueUpdateDraw(func() {
+			if err := client.ApplyChanges(ctx, event.Changes, event.Revision); err != nil {
+				log.Println("watch error: ", err)
+			}
+			// server is reachable again, queued changes are sent
+			if client.Queued() > 0 {
+				conflicts, err := client.ReplayOutbox(ctx)
+				if err != nil {
+					log.Println("replay error: ", err)
+				}
+				if len(conflicts) > 0 {
+					app.SetRoot(DrawConflict(ctx, client, app, conflicts[0]), true)
+				}
+			}
+			refreshTable(ctx, *client, table)
+		})
+	})
+	if err != nil && ctx.Err() == nil {
+		log.Println("watch error: ", err)
+	}
+}
+
+// DrawSessions - draw table of user's active sessions.
+// Selected session can be terminated, Esc returns to main screen.
+func DrawSessions(ctx context.Context, client client.Client, app *tview.Application, grid *tview.Grid) *tview.Flex {
+	status := tview.NewTextView().SetText("Enter - terminate session, Esc - back")
+	table := tview.NewTable().SetSelectable(true, false).SetFixed(1, 0)
+	for i, title := range []string{"Device", "Version", "IP", "Logged in", "Last seen"} {
+		table.SetCell(0, i, tview.NewTableCell(title).SetExpansion(1).SetAlign(tview.AlignCenter).SetBackgroundColor(tcell.Color100).SetSelectable(false))
+	}
+	sessions, err := client.ListSessions(ctx)
+	if err != nil {
+		log.Println(err)
+		status.SetText(err.Error())
+	}
+	for i, session := range sessions {
+		device := session.Device
+		if session.Current {
+			device += " (this device)"
+		}
+		table.SetCellSimple(i+1, 0, device)
+		table.SetCellSimple(i+1, 1, session.BuildVersion)
+		table.SetCellSimple(i+1, 2, session.Ip)
+		table.SetCellSimple(i+1, 3, time.Unix(session.CreatedAt, 0).Format(time.RFC822))
+		table.SetCellSimple(i+1, 4, time.Unix(session.LastSeen, 0).Format(time.RFC822))
+	}
+	flex := tview.NewFlex().SetDirection(tview.FlexRow).
+		AddItem(table, 0, 1, true).
+		AddItem(status, 1, 0, false)
+	table.SetDoneFunc(func(key tcell.Key) {
+		if key == tcell.KeyEscape {
+			app.SetRoot(grid, true)
+		}
+	})
+	table.SetSelectedFunc(func(row int, column int) {
+		if row < 1 || row > len(sessions) {
+			return
+		}
+		session := sessions[row-1]
+		modal := tview.NewModal().
+			SetText("Terminate session of " + session.Device + "?").
+			AddButtons([]string{"Terminate", "Cancel"}).
+			SetDoneFunc(func(buttonIndex int, buttonLabel string) {
+				if buttonLabel != "Terminate" {
+					app.SetRoot(flex, true)
+					return
+				}
+				err := client.TerminateSession(ctx, session.Id)
+				if err != nil {
+					log.Println(err)
+					status.SetText(err.Error())
+					app.SetRoot(flex, true)
+					return
+				}
+				if session.Current {
+					app.Stop()
+					return
+				}
+				app.SetRoot(DrawSessions(ctx, client, app, grid), true)
+			})
+		app.SetRoot(modal, true)
+	})
+	return flex
+}
+
+// DrawTOTP - draw authenticator settings.
+// Enrollment shows QR code and recovery codes, enrollment is confirmed by code from app.
+func DrawTOTP(ctx context.Context, client client.Client, app *tview.Application, grid *tview.Grid) *tview.Flex {
+	code := ""
+	info := tview.NewTextView().SetText("Enroll authenticator app or disable it with code")
+	form := tview.NewForm().
+		AddInputField("Code: ", "", 20, nil, func(text string) {
+			code = text
+		})
+	flex := tview.NewFlex().SetDirection(tview.FlexRow).
+		AddItem(info, 0, 3, false).
+		AddItem(form, 0, 1, true)
+	form.AddButton("Enroll", func() {
+		uri, recoveryCodes, err := client.EnrollTOTP(ctx)
+		if err != nil {
+			info.SetText(err.Error())
+			return
+		}
+		qr, err := qrcode.New(uri, qrcode.Low)
+		if err != nil {
+			info.SetText(err.Error())
+			return
+		}
+		info.SetText(qr.ToSmallString(false) + uri +
+			"\n\nRecovery codes, keep them safe, each works once:\n" + strings.Join(recoveryCodes, "\n") +
+			"\n\nEnter code from app and press Confirm")
+	}).
+		AddButton("Confirm", func() {
+			err := client.ConfirmTOTP(ctx, code)
+			if err != nil {
+				info.SetText(err.Error())
+				return
+			}
+			info.SetText("Authenticator enabled, it will be asked on login")
+		}).
+		AddButton("Disable", func() {
+			err := client.DisableTOTP(ctx, code)
+			if err != nil {
+				info.SetText(err.Error())
+				return
+			}
+			info.SetText("Authenticator disabled")
+		}).
+		AddButton("Back", func() { app.SetRoot(grid, true) })
+	return flex
+}
+
+// DrawChangePassword - draw master password change form.
+func DrawChangePassword(ctx context.Context, client client.Client, app *tview.Application, grid *tview.Grid) *tview.Flex {
+	oldPassword, newPassword, repeat := "", "", ""
+	info := tview.NewTextView().SetText("Other sessions will be closed after change")
+	form := tview.NewForm().
+		AddPasswordField("Current password: ", "", 20, '*', func(text string) {
+			oldPassword = text
+		}).
+		AddPasswordField("New password: ", "", 20, '*', func(text string) {
+			newPassword = text
+		}).
+		AddPasswordField("Repeat new password: ", "", 20, '*', func(text string) {
+			repeat = text
+		})
+	form.AddButton("Change", func() {
+		if newPassword == "" || newPassword != repeat {
+			info.SetText("New passwords don't match")
+			return
+		}
+		err := client.ChangePassword(ctx, oldPassword, newPassword)
+		if err != nil {
+			info.SetText(err.Error())
+			return
+		}
+		info.SetText("Password changed")
+	}).
+		AddButton("Back", func() { app.SetRoot(grid, true) })
+	return tview.NewFlex().SetDirection(tview.FlexRow).
+		AddItem(form, 0, 1, true).
+		AddItem(info, 1, 0, false)
+}
+
+// DrawDeleteAccount - draw account deletion form.
+// Deletion is scheduled and can be undone till server purges account.
+func DrawDeleteAccount(ctx context.Context, client client.Client, app *tview.Application, grid *tview.Grid) *tview.Flex {
+	password, code := "", ""
+	info := tview.NewTextView().SetText("Account and all its data will be deleted after grace period")
+	if at, ok := client.DeletionScheduled(); ok {
+		info.SetText("Account will be deleted at " + at.Format("2006-01-02 15:04"))
+	}
+	form := tview.NewForm().
+		AddPasswordField("Password: ", "", 20, '*', func(text string) {
+			password = text
+		}).
+		AddInputField("Authenticator code: ", "", 20, nil, func(text string) {
+			code = text
+		})
+	form.AddButton("Delete", func() {
+		at, err := client.DeleteAccount(ctx, password, code)
+		if err != nil {
+			info.SetText(err.Error())
+			return
+		}
+		info.SetText("Account will be deleted at " + at.Format("2006-01-02 15:04") + ", till then it can be undone")
+	}).
+		AddButton("Undo", func() {
+			err := client.CancelAccountDeletion(ctx)
+			if err != nil {
+				info.SetText(err.Error())
+				return
+			}
+			info.SetText("Account deletion canceled")
+		}).
+		AddButton("Back", func() { app.SetRoot(grid, true) })
+	return tview.NewFlex().SetDirection(tview.FlexRow).
+		AddItem(form, 0, 1, true).
+		AddItem(info, 1, 0, false)
+}
+
+// uploadFile - upload file from disk by stream, content is never read into memory at once.
+func uploadFile(ctx context.Context, client client.Client, data models.Data, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = client.UploadFile(ctx, data, f)
+	return err
+}
+
+// writeFile - download file content by stream and write it to disk.
+// Partly written file is removed on error.
+func writeFile(ctx context.Context, client client.Client, data models.Data, path string) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	err = client.DownloadFile(ctx, data, f)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path)
+	}
+	return err
+}
+
 // Tree - draw file tree.
 func Tree() string {
 	app := tview.NewApplication()